@@ -0,0 +1,32 @@
+"""add_kdf_params_to_agents
+
+Per-agent KDF salt and parameters for deriving the agent master key
+
+Revision ID: c7e4a21b9d30
+Revises: b2d9e140fea9
+Create Date: 2026-10-16 15:00:00.000000
+
+"""
+from alembic import op
+import sqlalchemy as sa
+
+
+# revision identifiers, used by Alembic.
+revision = 'c7e4a21b9d30'
+down_revision = 'b2d9e140fea9'
+branch_labels = None
+depends_on = None
+
+
+def upgrade() -> None:
+    # Hex salt rendered into the agent; existing agents keep NULL, which
+    # means they were built with the legacy shared salt
+    op.add_column('agents', sa.Column('kdf_salt', sa.String(64), nullable=True))
+
+    # {"algorithm": "pbkdf2-sha256" | "argon2id", "iterations", "memory_kib", "threads"}
+    op.add_column('agents', sa.Column('kdf_params', sa.JSON(), nullable=True))
+
+
+def downgrade() -> None:
+    op.drop_column('agents', 'kdf_params')
+    op.drop_column('agents', 'kdf_salt')
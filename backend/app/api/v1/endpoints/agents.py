@@ -413,16 +413,19 @@ async def agent_websocket(
         # Verify auth token (should be in headers)
         # In real implementation, validate token from headers
         
+        # Go agents seal every frame, including the welcome below
+        channel = await AgentService.open_channel(db, agent, websocket)
+        
         # Update agent status
         await AgentService.update_agent_status(db, working_agent.id, AgentStatus.ONLINE)
-        connected_agents[str(working_agent.id)] = websocket
+        connected_agents[str(working_agent.id)] = channel
         
         # Create SOCKS proxy for this agent
         global next_socks_port
         socks_port = next_socks_port
         next_socks_port += 1
         
-        socks_proxy = AgentSOCKSProxy(working_agent.id, websocket, socks_port)
+        socks_proxy = AgentSOCKSProxy(working_agent.id, channel, socks_port)
         await socks_proxy.start()
         agent_socks_proxies[str(working_agent.id)] = socks_proxy
         
@@ -436,7 +439,7 @@ async def agent_websocket(
         logger.info(f"Agent {working_agent.name} connected with SOCKS proxy on port {socks_port}")
         
         # Send welcome message
-        await websocket.send_json({
+        await channel.send_json({
             "type": "welcome",
             "message": f"Connected to NOP as agent {working_agent.name}",
             "timestamp": datetime.utcnow().isoformat(),
@@ -446,8 +449,7 @@ async def agent_websocket(
         # Message loop
         while True:
             try:
-                data = await websocket.receive_text()
-                message = json.loads(data)
+                message = await channel.receive_json()
                 
                 # Handle different message types
                 msg_type = message.get("type")
@@ -466,6 +468,15 @@ async def agent_websocket(
                         await db.commit()
                     
                     print(f"Agent {working_agent.name} registered: {message}")
+                    channel.registered(message)
+                    
+                    # Go agents report how they derive their master key
+                    reported_kdf = (message.get("data") or {}).get("kdf")
+                    if not AgentService.kdf_matches(working_agent, reported_kdf):
+                        logger.warning(
+                            f"Agent {working_agent.name} derives its key with {reported_kdf}, "
+                            f"expected {AgentService.kdf_params_of(working_agent)}"
+                        )
                     
                     # Extract agent IP and auto-generate /24 network for discovery
                     # Prefer internal network IPs (10.x, 192.168.x) over Docker bridge IPs (172.x)
@@ -508,9 +519,11 @@ async def agent_websocket(
                         except Exception as e:
                             logger.warning(f"Could not parse agent IP {agent_ip}: {e}")
                     
-                    await websocket.send_json({
+                    # The agent disconnects unless encrypted framing is confirmed
+                    await channel.send_json({
                         "type": "registered",
-                        "status": "success"
+                        "status": "success",
+                        "encryption": channel.sealed
                     })
                     
                 elif msg_type == "heartbeat":
@@ -518,13 +531,20 @@ async def agent_websocket(
                     await AgentService.update_agent_status(
                         db, working_agent.id, AgentStatus.ONLINE, update_last_seen=True
                     )
+                    # Echo the heartbeat ID so the agent can measure RTT and loss
+                    heartbeat_id = (message.get("data") or {}).get("heartbeat_id")
+                    if heartbeat_id is not None:
+                        await channel.send_json({
+                            "type": "heartbeat_ack",
+                            "heartbeat_id": heartbeat_id
+                        })
                     
                 elif msg_type == "asset_data":
                     # Handle discovered assets
                     assets = message.get('assets', [])
                     count = await AgentDataService.ingest_asset_data(db, working_agent.id, assets)
                     print(f"Agent {working_agent.name} discovered {count} assets")
-                    await websocket.send_json({
+                    await channel.send_json({
                         "type": "asset_ack",
                         "count": count,
                         "status": "success"
@@ -593,6 +613,9 @@ async def agent_websocket(
                     
             except json.JSONDecodeError:
                 print(f"Invalid JSON from agent {working_agent.name}")
+            except ValueError as e:
+                # Cleartext or forged frames on a sealed connection
+                logger.warning(f"Dropping frame from agent {working_agent.name}: {e}")
                 
     except WebSocketDisconnect:
         print(f"Agent {working_agent.name if working_agent else agent.name} disconnected")
@@ -740,38 +763,53 @@ async def agent_websocket_endpoint(
     socks_proxy = None
     
     try:
-        # Wait for registration
-        data = await websocket.receive_text()
-        message = json.loads(data)
+        # Wait for registration. Go agents seal it and name themselves on
+        # the envelope, so the key that opens it can be looked up first
+        frame = json.loads(await websocket.receive_text())
+        agent_id_str = frame.get("agent_id")
+        if not agent_id_str:
+            await websocket.close(code=1008, reason="Missing credentials")
+            return
         
-        if message.get("type") != "register":
-            await websocket.close(code=1008, reason="Expected register message")
+        agent_id = UUID(agent_id_str)
+        agent = await AgentService.get_agent(db, agent_id)
+        if not agent:
+            await websocket.close(code=1008, reason="Invalid credentials")
             return
         
-        agent_id_str = message.get("agent_id")
-        auth_token = message.get("auth_token")
+        channel = await AgentService.open_channel(db, agent, websocket)
+        try:
+            message = channel.unwrap(frame)
+        except ValueError:
+            # A Go agent's registration must open under its key
+            await websocket.close(code=1008, reason="Invalid credentials")
+            return
         
-        if not agent_id_str or not auth_token:
-            await websocket.close(code=1008, reason="Missing credentials")
+        if message.get("type") != "register":
+            await websocket.close(code=1008, reason="Expected register message")
             return
         
-        agent_id = UUID(agent_id_str)
-        agent = await AgentService.get_agent(db, agent_id)
+        # Go agents send their token in the Authorization header
+        auth_token = message.get("auth_token")
+        if not auth_token:
+            auth_token = websocket.headers.get("authorization", "").removeprefix("Bearer ").strip()
         
-        if not agent or agent.auth_token != auth_token:
+        if message.get("agent_id") != agent_id_str or not auth_token or agent.auth_token != auth_token:
             await websocket.close(code=1008, reason="Invalid credentials")
             return
         
+        channel.registered(message)
+        
         # Update agent status
         await AgentService.update_agent_status(db, agent_id, AgentStatus.ONLINE)
-        connected_agents[agent_id_str] = websocket
+        connected_agents[agent_id_str] = channel
         
         # Create SOCKS proxy for this agent
         global next_socks_port
         socks_port = next_socks_port
         next_socks_port += 1
         
-        socks_proxy = AgentSOCKSProxy(agent_id, websocket, socks_port)
+        socks_proxy = AgentSOCKSProxy(agent_id, channel, socks_port)
         await socks_proxy.start()
         agent_socks_proxies[agent_id_str] = socks_proxy
         
@@ -814,17 +852,23 @@ async def agent_websocket_endpoint(
         
         logger.info(f"Agent {agent.name} connected with SOCKS proxy on port {socks_port}")
         
-        # Send registration confirmation
-        await websocket.send_json({
+        # Send registration confirmation; the agent disconnects unless
+        # encrypted framing is confirmed
+        await channel.send_json({
             "type": "registered",
             "status": "success",
-            "socks_port": socks_port
+            "socks_port": socks_port,
+            "encryption": channel.sealed
         })
         
         # Message loop
         while True:
-            data = await websocket.receive_text()
-            message = json.loads(data)
+            try:
+                message = await channel.receive_json()
+            except ValueError as e:
+                # Cleartext or forged frames on a sealed connection
+                logger.warning(f"Dropping frame from agent {agent.name}: {e}")
+                continue
             msg_type = message.get("type")
             logger.info(f"Agent {agent.name} received message type: {msg_type}")
             
@@ -832,6 +876,12 @@ async def agent_websocket_endpoint(
                 await AgentService.update_agent_status(
                     db, agent_id, AgentStatus.ONLINE, update_last_seen=True
                 )
+                heartbeat_id = (message.get("data") or {}).get("heartbeat_id")
+                if heartbeat_id is not None:
+                    await channel.send_json({
+                        "type": "heartbeat_ack",
+                        "heartbeat_id": heartbeat_id
+                    })
             
             elif msg_type == "asset_data":
                 assets = message.get('assets', [])
@@ -58,6 +58,13 @@ class Settings(BaseSettings):
     # Paths
     EVIDENCE_PATH: str = "/app/evidence"
     LOGS_PATH: str = "/app/logs"
+    # Copy of the nopagent Go module that generated Go agents are built
+    # against; empty picks /app/nopagent or the repository checkout
+    NOPAGENT_PATH: str = ""
+    # Ed25519 private key (base64 of the 32-byte seed) that signs destructive
+    # commands; Go agents generated while it is set embed its public key and
+    # refuse those commands unsigned. Empty leaves signing off.
+    AGENT_SIGNING_KEY: str = ""
     
     @property
     def monitor_subnets_list(self) -> List[str]:
@@ -56,6 +56,12 @@ class Agent(Base):
     encryption_key = Column(String(255), nullable=False)  # Encryption key for secure tunnel
     download_token = Column(String(255), nullable=False, unique=True)  # One-time use download token
     
+    # Master key derivation - the salt is random per agent (hex, NULL for agents
+    # built with the legacy shared salt) and rendered into the agent with the params
+    # Example: {"algorithm": "argon2id", "iterations": 3, "memory_kib": 65536, "threads": 4}
+    kdf_salt = Column(String(64), nullable=True)
+    kdf_params = Column(JSON, nullable=True)
+    
     # Capabilities - JSON field with module flags
     # Modules: asset, traffic, host, access - agent acts as proxy relaying data to C2
     # Example: {"asset": true, "traffic": true, "host": true, "access": false}
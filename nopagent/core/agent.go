@@ -0,0 +1,433 @@
+// Package core is the NOP agent itself: connection management, the cipher
+// and session keys, command dispatch, the monitoring modules' schedules and
+// everything reported to the C2. A generated agent is a thin main package
+// that passes its rendered Identity to Main.
+package core
+
+import (
+	"crypto/cipher"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"github.com/goranjovic55/NOP/nopagent/keystore"
+	"github.com/goranjovic55/NOP/nopagent/modules"
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+	"github.com/goranjovic55/NOP/nopagent/storage"
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// Version of the agent core, reported at registration
+const Version = "1.0.0"
+
+// Name used for persistence entries, firewall rules and the local state directory
+const ServiceName = "nop-agent"
+
+// Identity holds the values rendered into the agent template at generation
+// time. Fields keep the raw template text: an unrendered "{{...}}"
+// placeholder means the value was not provided.
+type Identity struct {
+	AgentID         string
+	AgentName       string
+	AuthToken       string
+	EncryptionKey   string
+	ServerURL       string // comma-separated list, tried in order
+	TokenExpiry     string // RFC3339, empty if AuthToken does not expire
+	KDFSalt         string // hex, random per agent
+	KDF             string // crypto.PBKDF2 or crypto.Argon2id
+	KDFMemory       string // KiB, Argon2id only
+	KDFIterations   string
+	DataPolicy      string
+	CommandPolicy   string
+	VisibleMode     string
+	ConsentNotice   string
+	ServerPublicKey string
+	BuildOptions    string
+	SealedConfig    string
+	ClientCert      string
+	ClientKey       string
+	ServerCA        string
+	UserAgent       string
+	HTTPHeaders     map[string]string
+	Capabilities    map[string]bool
+	Config          map[string]interface{}
+}
+
+type NOPAgent struct {
+	identity       Identity
+	transport      transport.Transport
+	wsFailures     int
+	agentID        string
+	agentName      string
+	authToken      string
+	tokenExpiry    time.Time
+	tokenMutex     sync.Mutex
+	encryptionKey  []byte
+	serverURL      string
+	endpoints      []*endpointHealth
+	link           linkStats
+	endpointIndex  int
+	capabilities   map[string]bool
+	config         map[string]interface{}
+	running        bool
+	cipher         cipher.AEAD
+	masterKey      []byte
+	kdf            crypto.KDFParams
+	messageKeys    bool
+	session        *crypto.WireKey // nil until a key exchange completes
+	exchange       *pendingExchange
+	suite          string
+	schedule       string // wire key schedule picked by the C2
+	staticWire     *crypto.WireKey
+	sealWire       bool // set at the first registration, never cleared
+	replayCheck    bool // set once the C2 agrees, never cleared
+	sendCounter    uint64
+	sendReserved   uint64               // counters below it may have been sent; persisted
+	staticWindow   *crypto.ReplayWindow // resumed from the sealed state
+	replayMutex    sync.Mutex
+	sessionWindow  *crypto.ReplayWindow
+	retired        *crypto.WireKey
+	retiredUntil   time.Time
+	keyMutex       sync.RWMutex
+	passiveHosts   []map[string]interface{}
+	hostsMutex     sync.Mutex
+	assetCache     map[string]map[string]interface{}
+	assetMutex     sync.Mutex
+	connMutex      sync.Mutex
+	networkState   *protocol.NetworkState
+	onBattery      bool
+	jobs           []*Job
+	jobMutex       sync.Mutex
+	jobSignal      chan struct{}
+	restoredJobs   []*Job // loaded at startup, not yet reported
+	lastTaskAt     time.Time
+	sampleCounters map[string]uint64
+	sampleMutex    sync.Mutex
+	eventBuckets   map[string]*eventBucket
+	eventMutex     sync.Mutex
+	modulesOnce    sync.Once
+	modules        map[string]*moduleHealth
+	moduleMutex    sync.Mutex
+	lastContact    time.Time
+	autonomous     *autonomousState
+	autoMutex      sync.Mutex
+	flow           flowControl
+	bandwidth      tokenBucket
+	compression    string
+	codec          transport.Codec
+	ouiTable       map[string]string
+	ouiVersion     string
+	ouiMutex       sync.RWMutex
+	fingerprint    string
+	previousPrint  string
+	sim            *simHost
+	audit          *auditReport
+	policy         *dataPolicy
+	cmdPolicy      *commandPolicy
+	commandKey     *commandKey
+	options        *buildOptions
+	sinks          []*fileSink
+	policyNotices  map[string]bool
+	policyMutex    sync.Mutex
+	recorder       *sessionRecorder
+	store          *storage.Store
+	keys           keystore.Store // nil without a platform keystore
+	recorderOnce   sync.Once
+	proxies        map[string]*reverseProxy
+	proxyMutex     sync.Mutex
+	shells         map[string]*shellSession
+	shellMutex     sync.Mutex
+	transfers      map[string]*fileTransfer // interrupted file_get transfers
+	transferMutex  sync.Mutex
+	uploads        map[string]*fileUpload // file_put transfers in progress
+	uploadMutex    sync.Mutex
+	schedules      map[string]*scheduledTask
+	scheduleMutex  sync.Mutex
+	scheduleSignal chan struct{}
+	moduleHashes   map[string]string
+	hashMutex      sync.Mutex
+	siteMap        []modules.SiteLabel
+	siteMutex      sync.RWMutex
+	acksEnabled    bool
+	dataChannel    bool
+	grants         map[string]chan map[string]interface{}
+	grantMutex     sync.Mutex
+	nextSeq        uint64
+	unacked        map[uint64]interface{}
+	ackMutex       sync.Mutex
+	spoolPending   bool
+	spoolSeq       int64
+	spoolMutex     sync.Mutex
+}
+
+type endpointHealth struct {
+	URL         string
+	Failures    int
+	LastSuccess time.Time
+	LastFailure time.Time
+}
+
+func NewNOPAgent(identity Identity) *NOPAgent {
+	// Main has already refused builds with unreadable options
+	options, _ := loadBuildOptions(identity.BuildOptions)
+	agent := &NOPAgent{
+		identity:       identity,
+		agentID:        identity.AgentID,
+		agentName:      identity.AgentName,
+		authToken:      identity.AuthToken,
+		encryptionKey:  []byte(identity.EncryptionKey),
+		capabilities:   identity.Capabilities,
+		config:         identity.Config,
+		running:        true,
+		passiveHosts:   make([]map[string]interface{}, 0),
+		assetCache:     make(map[string]map[string]interface{}),
+		jobs:           make([]*Job, 0),
+		jobSignal:      make(chan struct{}, 1),
+		sampleCounters: make(map[string]uint64),
+		eventBuckets:   make(map[string]*eventBucket),
+		modules:        make(map[string]*moduleHealth),
+		policy:         loadDataPolicy(identity.DataPolicy),
+		cmdPolicy:      loadCommandPolicy(identity.CommandPolicy),
+		commandKey:     loadCommandKey(identity.ServerPublicKey),
+		options:        options,
+		policyNotices:  make(map[string]bool),
+		unacked:        make(map[uint64]interface{}),
+		moduleHashes:   make(map[string]string),
+		proxies:        make(map[string]*reverseProxy),
+		shells:         make(map[string]*shellSession),
+		transfers:      make(map[string]*fileTransfer),
+		uploads:        make(map[string]*fileUpload),
+		schedules:      make(map[string]*scheduledTask),
+		scheduleSignal: make(chan struct{}, 1),
+		grants:         make(map[string]chan map[string]interface{}),
+	}
+	for _, u := range strings.Split(identity.ServerURL, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			agent.endpoints = append(agent.endpoints, &endpointHealth{URL: u})
+		}
+	}
+	if len(agent.endpoints) > 0 {
+		agent.serverURL = agent.endpoints[0].URL
+	}
+	// Main has already refused an unreadable expiry
+	agent.tokenExpiry, _ = parseTokenExpiry(identity.TokenExpiry)
+	agent.initCipher()
+	agent.sinks = options.fileSinks(agent.store)
+	agent.openKeystore()
+	agent.loadIdentity()
+	agent.loadOUI()
+	agent.loadSiteMap()
+	agent.loadSchedules()
+	agent.loadJobs()
+	agent.spoolPending = len(agent.spoolFiles()) > 0
+	return agent
+}
+
+// timeout reads a duration in seconds from the config; zero disables it
+func (a *NOPAgent) timeout(key string, def time.Duration) time.Duration {
+	if val, ok := a.config[key].(float64); ok && val >= 0 {
+		return time.Duration(val * float64(time.Second))
+	}
+	return def
+}
+
+// interval reads a positive period in seconds from the config
+func (a *NOPAgent) interval(key string, def time.Duration) time.Duration {
+	if val, ok := a.config[key].(float64); ok && val > 0 {
+		return time.Duration(val * float64(time.Second))
+	}
+	return def
+}
+
+// stateDir returns the directory where the agent keeps local state and logs
+func (a *NOPAgent) stateDir() string {
+	if dir, ok := a.config["state_dir"].(string); ok && dir != "" {
+		return dir
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, ServiceName)
+	}
+	return filepath.Join(os.TempDir(), ServiceName)
+}
+
+// ============================================================================
+// MAIN
+// ============================================================================
+func (a *NOPAgent) Run() {
+	log.Printf("[%s] NOP Agent '%s' starting...", time.Now().Format(time.RFC3339), a.agentName)
+
+	enabled := make([]string, 0)
+	for module, isEnabled := range a.capabilities {
+		if isEnabled {
+			enabled = append(enabled, module)
+		}
+	}
+	log.Printf("[%s] Enabled modules: %v", time.Now().Format(time.RFC3339), enabled)
+
+	// Jobs queue up across reconnects, so the scheduler outlives each connection
+	go a.JobScheduler()
+	go a.TaskScheduler()
+	go a.TokenRefresher()
+	go a.EventAggregator()
+
+	a.lastContact = time.Now()
+
+	if a.visibleMode() {
+		a.announce()
+		go a.StatusPage()
+	}
+
+	for a.running {
+		if err := a.Connect(); err != nil {
+			log.Printf("[%s] Connection error: %v", time.Now().Format(time.RFC3339), err)
+			a.recordEndpointFailure()
+			a.checkAutonomous()
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		if err := a.Register(); err != nil {
+			log.Printf("[%s] Registration error: %v", time.Now().Format(time.RFC3339), err)
+			a.closeConn()
+			a.recordEndpointFailure()
+			a.checkAutonomous()
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		a.lastContact = time.Now()
+		a.recordEndpointSuccess()
+		a.leaveAutonomous()
+		go func() {
+			// Unacknowledged reports predate anything still in the spool
+			a.retransmitUnacked()
+			a.replaySpool()
+			a.reportInterruptedTransfers()
+			a.reportResumedJobs()
+		}()
+
+		go a.Heartbeat()
+		a.startModules()
+
+		// Handle messages (blocking)
+		a.MessageHandler()
+
+		a.closeConn()
+		a.lastContact = time.Now()
+
+		if a.running {
+			log.Printf("[%s] Reconnecting in 5 seconds...", time.Now().Format(time.RFC3339))
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// Main is the entry point of a generated agent: it parses the command line
+// and runs the agent described by identity until it is stopped
+func Main(identity Identity) {
+	simulate := flag.String("simulate", "", "run simulated agents from a scenario file instead of the live system")
+	replay := flag.String("replay", "", "re-feed the inbound messages of a session recording through the handlers")
+	replayWait := flag.Duration("replay-wait", 5*time.Second, "time to let asynchronous handlers finish after a replay")
+	flag.Parse()
+
+	unsealed, err := unsealIdentity(identity)
+	if err != nil {
+		log.Fatalf("[%s] Cannot unseal configuration: %v", time.Now().Format(time.RFC3339), err)
+	}
+	identity = unsealed
+
+	if _, err := crypto.ParseKDF(identity.KDF, identity.KDFMemory, identity.KDFIterations); err != nil {
+		log.Fatalf("[%s] Invalid build: %v", time.Now().Format(time.RFC3339), err)
+	}
+	if err := crypto.CheckFIPS([]byte(identity.EncryptionKey), identity.KDFSalt); err != nil {
+		log.Fatalf("[%s] Invalid build: %v", time.Now().Format(time.RFC3339), err)
+	}
+	if crypto.FIPS && crypto.FIPSModule() == "" {
+		log.Printf("[%s] FIPS build without GOEXPERIMENT=boringcrypto: algorithms are restricted but not from a validated module", time.Now().Format(time.RFC3339))
+	}
+	if _, err := parseTokenExpiry(identity.TokenExpiry); err != nil {
+		log.Fatalf("[%s] Invalid build: %v", time.Now().Format(time.RFC3339), err)
+	}
+
+	options, err := loadBuildOptions(identity.BuildOptions)
+	if err == nil {
+		err = options.validate(identity)
+	}
+	if err != nil {
+		log.Fatalf("[%s] Invalid build: %v", time.Now().Format(time.RFC3339), err)
+	}
+
+	if *replay != "" {
+		if err := runReplay(identity, *replay, *replayWait); err != nil {
+			log.Fatalf("[%s] Replay failed: %v", time.Now().Format(time.RFC3339), err)
+		}
+		return
+	}
+
+	if *simulate != "" {
+		if err := runSimulation(identity, *simulate); err != nil {
+			log.Fatalf("[%s] Simulation failed: %v", time.Now().Format(time.RFC3339), err)
+		}
+		return
+	}
+
+	if err := options.checkGuardrails(); err != nil {
+		log.Fatalf("[%s] Refusing to run: %v", time.Now().Format(time.RFC3339), err)
+	}
+	options.applyObfuscation(identity.Config)
+
+	switch flag.Arg(0) {
+	case "audit":
+		// Collect once into a local report and never contact the C2
+		auditFlags := flag.NewFlagSet("audit", flag.ExitOnError)
+		output := auditFlags.String("output", "nop-audit-report.json", "path of the encrypted audit report")
+		auditFlags.Parse(flag.Args()[1:])
+		if err := runAudit(identity, *output); err != nil {
+			log.Fatalf("[%s] Audit failed: %v", time.Now().Format(time.RFC3339), err)
+		}
+		return
+	case "decrypt":
+		// Print a sealed log or state file written by this agent
+		if flag.NArg() != 2 {
+			log.Fatalf("[%s] Usage: decrypt <file>", time.Now().Format(time.RFC3339))
+		}
+		if err := runDecrypt(identity, flag.Arg(1)); err != nil {
+			log.Fatalf("[%s] Decrypt failed: %v", time.Now().Format(time.RFC3339), err)
+		}
+		return
+	case "import":
+		// Spool third-party scan results for the next connection
+		importFlags := flag.NewFlagSet("import", flag.ExitOnError)
+		format := importFlags.String("format", "auto", "nmap, masscan, arp-scan or auto")
+		importFlags.Parse(flag.Args()[1:])
+		if err := runImport(identity, *format, importFlags.Args()); err != nil {
+			log.Fatalf("[%s] Import failed: %v", time.Now().Format(time.RFC3339), err)
+		}
+		return
+	}
+
+	agent := NewNOPAgent(identity)
+	agent.openLogFile()
+
+	// Handle graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		log.Printf("[%s] Agent stopped by user", time.Now().Format(time.RFC3339))
+		agent.running = false
+		agent.stopJobs()
+		agent.closeConn()
+		os.Exit(0)
+	}()
+
+	agent.Run()
+}
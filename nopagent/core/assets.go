@@ -0,0 +1,288 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// ASSET MODULE - Network asset discovery and monitoring
+// ============================================================================
+func (a *NOPAgent) AssetModule(beat func() bool) {
+	if !a.capabilities["asset"] {
+		return
+	}
+	log.Printf("[%s] Asset module started", time.Now().Format(time.RFC3339))
+
+	interval := a.interval("discovery_interval", 300*time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Announcements are collected between rounds like passively seen hosts
+	if a.ssdpEnabled("listen") {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go a.listenSSDP(ctx)
+	}
+
+	// Initial discovery
+	a.discoverAssets()
+
+	for a.running && beat() {
+		select {
+		case <-ticker.C:
+			ticker.Reset(a.powerAdjustedInterval(interval))
+			if a.moduleSuspended("asset") {
+				continue
+			}
+			a.discoverAssets()
+		}
+	}
+}
+
+func (a *NOPAgent) discoverAssets() {
+	if a.sim != nil {
+		a.reportAssets(a.sim.assets())
+		return
+	}
+
+	assets := make([]map[string]interface{}, 0)
+
+	// Get all network interfaces
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("[%s] Asset discovery error: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+
+	// Collect local interface info as assets
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || !modules.UsableAddress(ipnet.IP) {
+				continue
+			}
+
+			asset := map[string]interface{}{
+				"ip":            ipnet.IP.String(),
+				"family":        modules.AddressFamily(ipnet.IP),
+				"mac":           iface.HardwareAddr.String(),
+				"status":        "online",
+				"discovered_at": time.Now().UTC().Format(time.RFC3339),
+				"interface":     iface.Name,
+			}
+			if ipnet.IP.IsLinkLocalUnicast() {
+				asset["scope"] = "link"
+			}
+			assets = append(assets, asset)
+		}
+	}
+
+	// Sweep first so the ARP table read below has the MACs of the hosts
+	// that answered
+	swept := a.sweepAssets()
+	upnp := a.searchUPnP()
+
+	// Try to discover local network hosts via ARP table
+	arpAssets := modules.ArpTable()
+	assets = append(assets, arpAssets...)
+
+	// IPv6 neighbors replace ARP on IPv6-only segments
+	assets = append(assets, modules.NeighborTable6()...)
+
+	// Add passively discovered hosts
+	a.hostsMutex.Lock()
+	assets = append(assets, a.passiveHosts...)
+	a.passiveHosts = make([]map[string]interface{}, 0)
+	a.hostsMutex.Unlock()
+
+	assets = mergeAssets(mergeAssets(assets, swept), upnp)
+	a.describeUPnP(assets)
+	a.resolveNetBIOS(assets)
+	a.scanPorts(assets)
+	a.reportAssets(assets)
+}
+
+// mergeAssets adds extra to assets: an asset already listed with the same IP
+// gains the fields it lacks, other assets are appended
+func mergeAssets(assets, extra []map[string]interface{}) []map[string]interface{} {
+	byIP := make(map[string]map[string]interface{}, len(assets))
+	for _, asset := range assets {
+		if ip, _ := asset["ip"].(string); ip != "" {
+			byIP[ip] = asset
+		}
+	}
+	for _, asset := range extra {
+		ip, _ := asset["ip"].(string)
+		existing, ok := byIP[ip]
+		if !ok {
+			assets = append(assets, asset)
+			byIP[ip] = asset
+			continue
+		}
+		for k, v := range asset {
+			if _, set := existing[k]; !set {
+				existing[k] = v
+			}
+		}
+	}
+	return assets
+}
+
+// reportAssets annotates and caches a discovery round and sends it to the C2
+func (a *NOPAgent) reportAssets(assets []map[string]interface{}) {
+	a.annotateVendors(assets)
+	a.annotateSites(assets)
+	a.cacheAssets(assets)
+
+	if len(assets) > 0 {
+		log.Printf("[%s] Discovered %d assets", time.Now().Format(time.RFC3339), len(assets))
+		a.relayToC2(protocol.AssetData{
+			Type:      "asset_data",
+			AgentID:   a.agentID,
+			Assets:    assets,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// cacheAssets merges discovered assets into the local cache keyed by IP
+func (a *NOPAgent) cacheAssets(assets []map[string]interface{}) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	a.assetMutex.Lock()
+	defer a.assetMutex.Unlock()
+	for _, asset := range assets {
+		ip, _ := asset["ip"].(string)
+		if ip == "" {
+			continue
+		}
+		cached, ok := a.assetCache[ip]
+		if !ok {
+			cached = make(map[string]interface{})
+			a.assetCache[ip] = cached
+		}
+		for k, v := range asset {
+			cached[k] = v
+		}
+		cached["last_seen"] = now
+	}
+}
+
+func (a *NOPAgent) handleExportAssets(msg map[string]interface{}) {
+	format, _ := msg["format"].(string)
+	if format == "" {
+		format = "json"
+	}
+	transferID, _ := msg["transfer_id"].(string)
+	if transferID == "" {
+		transferID = newID()
+	}
+
+	a.assetMutex.Lock()
+	ips := make([]string, 0, len(a.assetCache))
+	for ip := range a.assetCache {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	assets := make([]map[string]interface{}, 0, len(ips))
+	for _, ip := range ips {
+		asset := make(map[string]interface{}, len(a.assetCache[ip]))
+		for k, v := range a.assetCache[ip] {
+			asset[k] = v
+		}
+		assets = append(assets, asset)
+	}
+	a.assetMutex.Unlock()
+
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		if err := json.NewEncoder(&buf).Encode(assets); err != nil {
+			a.sendError("export_assets", msg, err)
+			return
+		}
+	case "ndjson":
+		enc := json.NewEncoder(&buf)
+		for _, asset := range assets {
+			if err := enc.Encode(asset); err != nil {
+				a.sendError("export_assets", msg, err)
+				return
+			}
+		}
+	case "csv":
+		writeAssetsCSV(&buf, assets)
+	default:
+		a.sendError("export_assets", msg, newAgentError(ErrInvalidRequest, "unsupported_format",
+			"unsupported export format %q", format))
+		return
+	}
+
+	name := fmt.Sprintf("assets-%s-%s.%s", a.agentID, time.Now().UTC().Format("20060102T150405Z"), format)
+	size := int64(buf.Len())
+	if err := a.streamFile(transferID, name, "assets", &buf, size); err != nil {
+		a.sendError("export_assets", msg, err)
+		return
+	}
+
+	log.Printf("[%s] Exported %d assets as %s (%d bytes)", time.Now().Format(time.RFC3339), len(assets), format, size)
+	a.relayToC2(map[string]interface{}{
+		"type":        "export_assets_result",
+		"agent_id":    a.agentID,
+		"transfer_id": transferID,
+		"name":        name,
+		"format":      format,
+		"count":       len(assets),
+		"size":        size,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// writeAssetsCSV writes one row per asset with ip and mac first, followed by
+// every other field seen across the assets
+func writeAssetsCSV(w io.Writer, assets []map[string]interface{}) {
+	seen := map[string]bool{"ip": true, "mac": true}
+	extra := make([]string, 0)
+	for _, asset := range assets {
+		for k := range asset {
+			if !seen[k] {
+				seen[k] = true
+				extra = append(extra, k)
+			}
+		}
+	}
+	sort.Strings(extra)
+	columns := append([]string{"ip", "mac"}, extra...)
+
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	for _, asset := range assets {
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			if v, ok := asset[col]; ok && v != nil {
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"github.com/goranjovic55/NOP/nopagent/modules"
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// AUDIT MODE - One-shot collection for air-gapped assessments
+// ============================================================================
+
+// auditReportFormat identifies audit reports for the NOP importer
+const auditReportFormat = "nop-audit-report"
+
+// auditReport collects what the enabled collectors would have relayed to the
+// C2 during an audit run
+type auditReport struct {
+	mutex   sync.Mutex
+	records []interface{}
+}
+
+func (r *auditReport) add(data interface{}) {
+	r.mutex.Lock()
+	r.records = append(r.records, data)
+	r.mutex.Unlock()
+}
+
+// auditFile is written to the --output path. Data is the JSON array of
+// reports sealed like the agent's other local files (storage purpose
+// "audit", under a key derived from the agent's encryption key), so only the
+// NOP instance that generated the agent can import it.
+type auditFile struct {
+	Format       string           `json:"format"`
+	AgentID      string           `json:"agent_id"`
+	AgentName    string           `json:"agent_name"`
+	AgentVersion string           `json:"agent_version"`
+	Hostname     string           `json:"hostname"`
+	StartedAt    string           `json:"started_at"`
+	FinishedAt   string           `json:"finished_at"`
+	Collectors   []string         `json:"collectors"`
+	Records      int              `json:"records"`
+	KDF          crypto.KDFParams `json:"kdf"`
+	Data         string           `json:"data"`
+}
+
+// runAudit runs every enabled collector once and writes an encrypted report
+// to output. It never dials the C2: reports stop at relayToC2, and nothing
+// else that writes to the connection is started.
+func runAudit(identity Identity, output string) error {
+	agent := NewNOPAgent(identity)
+	if agent.store == nil {
+		return fmt.Errorf("an audit report is encrypted with the agent's encryption key, which this build does not have")
+	}
+	agent.audit = &auditReport{}
+	started := time.Now().UTC()
+	log.Printf("[%s] Audit started, report will be written to %s", time.Now().Format(time.RFC3339), output)
+
+	collectors := make([]string, 0)
+	if agent.capabilities["host"] {
+		collectors = append(collectors, "host")
+		agent.sendHostInfo()
+	}
+	if agent.capabilities["asset"] {
+		collectors = append(collectors, "asset")
+		agent.discoverAssets()
+	}
+	if agent.capabilities["traffic"] {
+		collectors = append(collectors, "traffic")
+		agent.relayToC2(protocol.TrafficData{
+			Type:      "traffic_data",
+			AgentID:   agent.agentID,
+			Traffic:   agent.captureTrafficStats(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	collectors = append(collectors, "network")
+	agent.relayToC2(map[string]interface{}{
+		"type":      "network_state",
+		"agent_id":  agent.agentID,
+		"network":   modules.CollectNetworkState(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+
+	agent.audit.mutex.Lock()
+	records := agent.audit.records
+	agent.audit.mutex.Unlock()
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	sealed, err := agent.store.Seal("audit", payload)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt report: %v", err)
+	}
+
+	hostname, _ := os.Hostname()
+	report := auditFile{
+		Format:       auditReportFormat,
+		AgentID:      agent.agentID,
+		AgentName:    agent.agentName,
+		AgentVersion: Version,
+		Hostname:     hostname,
+		StartedAt:    started.Format(time.RFC3339),
+		FinishedAt:   time.Now().UTC().Format(time.RFC3339),
+		Collectors:   collectors,
+		Records:      len(records),
+		KDF:          agent.kdf,
+		Data:         base64.StdEncoding.EncodeToString(sealed),
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(output, data, 0600); err != nil {
+		return err
+	}
+	log.Printf("[%s] Audit finished: %d reports from %v written to %s",
+		time.Now().Format(time.RFC3339), len(records), collectors, output)
+	return nil
+}
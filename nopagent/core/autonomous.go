@@ -0,0 +1,84 @@
+package core
+
+import (
+	"log"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// AUTONOMOUS MODE - Keep collecting while the C2 is unreachable
+// ============================================================================
+type autonomousState struct {
+	since  time.Time
+	counts map[string]int
+	latest map[string]interface{}
+}
+
+// checkAutonomous enters autonomous mode once the C2 has been unreachable for
+// longer than "autonomous_after" seconds (default 300)
+func (a *NOPAgent) checkAutonomous() {
+	threshold := 300 * time.Second
+	if val, ok := a.config["autonomous_after"].(float64); ok && val > 0 {
+		threshold = time.Duration(val) * time.Second
+	}
+	if time.Since(a.lastContact) < threshold {
+		return
+	}
+
+	a.autoMutex.Lock()
+	entered := a.autonomous == nil
+	if entered {
+		a.autonomous = &autonomousState{
+			since:  a.lastContact,
+			counts: make(map[string]int),
+			latest: make(map[string]interface{}),
+		}
+	}
+	a.autoMutex.Unlock()
+
+	if entered {
+		log.Printf("[%s] C2 unreachable for %s - entering autonomous mode", time.Now().Format(time.RFC3339),
+			time.Since(a.lastContact).Round(time.Second))
+		a.startModules()
+	}
+}
+
+// recordAutonomous keeps a compact record of outbound data while in
+// autonomous mode: a count per message type and the latest of each
+func (a *NOPAgent) recordAutonomous(data interface{}) bool {
+	a.autoMutex.Lock()
+	defer a.autoMutex.Unlock()
+	if a.autonomous == nil {
+		return false
+	}
+	msgType := protocol.Type(data)
+	a.autonomous.counts[msgType]++
+	a.autonomous.latest[msgType] = data
+	return true
+}
+
+// leaveAutonomous sends a catch-up summary instead of replaying every event
+func (a *NOPAgent) leaveAutonomous() {
+	a.autoMutex.Lock()
+	state := a.autonomous
+	a.autonomous = nil
+	a.autoMutex.Unlock()
+
+	if state == nil {
+		return
+	}
+
+	log.Printf("[%s] C2 reachable again - leaving autonomous mode after %s", time.Now().Format(time.RFC3339),
+		time.Since(state.since).Round(time.Second))
+	a.relayToC2(map[string]interface{}{
+		"type":            "autonomous_summary",
+		"agent_id":        a.agentID,
+		"offline_since":   state.since.UTC().Format(time.RFC3339),
+		"offline_seconds": int(time.Since(state.since).Seconds()),
+		"event_counts":    state.counts,
+		"latest":          state.latest,
+		"timestamp":       time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// COMMAND BATCHES - Several commands in one message, one job, one result
+// ============================================================================
+
+const maxBatchCommands = 64
+
+// batchOptions are the fields of a batch message its commands inherit
+var batchOptions = []string{"shell", "cwd", "env", "run_as", "run_as_group", "password", "timeout_seconds"}
+
+// parseBatch reads the "commands" of a batch message. Each is a command
+// line or an object with the fields of a command message, defaulting to
+// the batch message's own shell, cwd, env, run_as and timeout.
+func (a *NOPAgent) parseBatch(msg map[string]interface{}) ([]map[string]interface{}, []*execSpec, error) {
+	raw, _ := msg["commands"].([]interface{})
+	if len(raw) == 0 || len(raw) > maxBatchCommands {
+		return nil, nil, newAgentError(ErrInvalidRequest, "invalid_batch", "commands must hold 1 to %d commands", maxBatchCommands)
+	}
+	switch mode, _ := msg["mode"].(string); mode {
+	case "", "sequential", "parallel":
+	default:
+		return nil, nil, newAgentError(ErrInvalidRequest, "invalid_mode", "mode must be sequential or parallel")
+	}
+	msgs := make([]map[string]interface{}, len(raw))
+	specs := make([]*execSpec, len(raw))
+	for i, item := range raw {
+		sub := map[string]interface{}{"type": "command"}
+		for _, key := range batchOptions {
+			if val, ok := msg[key]; ok {
+				sub[key] = val
+			}
+		}
+		switch item := item.(type) {
+		case string:
+			sub["command"] = item
+		case map[string]interface{}:
+			for k, v := range item {
+				sub[k] = v
+			}
+			sub["type"] = "command"
+		default:
+			return nil, nil, newAgentError(ErrInvalidRequest, "invalid_batch", "command %d must be a string or an object", i)
+		}
+		spec, err := a.parseExec(sub)
+		if err != nil {
+			failure := *classifyError(err)
+			failure.Message = fmt.Sprintf("command %d: %s", i, failure.Message)
+			return nil, nil, &failure
+		}
+		msgs[i], specs[i] = sub, spec
+	}
+	return msgs, specs, nil
+}
+
+// runBatch runs the commands of a batch one after another or, with "mode"
+// parallel, all at once, and reports them in a single command_result with
+// one "results" entry per command. A sequential batch with "stop_on_error"
+// skips the rest after a command fails or exits non-zero.
+func (a *NOPAgent) runBatch(ctx context.Context, qc *Job) error {
+	msgs, specs, err := a.parseBatch(qc.msg)
+	if err != nil {
+		return err
+	}
+	mode, _ := qc.msg["mode"].(string)
+	if mode == "" {
+		mode = "sequential"
+	}
+	stopOnError, _ := qc.msg["stop_on_error"].(bool)
+
+	started := time.Now()
+	results := make([]map[string]interface{}, len(specs))
+	errs := make([]error, len(specs))
+	run := func(i int) {
+		results[i], errs[i] = a.execute(ctx, msgs[i], specs[i])
+		results[i]["index"] = i
+	}
+	if mode == "parallel" {
+		var wg sync.WaitGroup
+		for i := range specs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				run(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		stopped := false
+		for i := range specs {
+			if stopped || ctx.Err() != nil {
+				results[i] = map[string]interface{}{"index": i, "command": specs[i].line, "status": "skipped"}
+				continue
+			}
+			run(i)
+			exitCode, _ := results[i]["exit_code"].(int)
+			stopped = stopOnError && (errs[i] != nil || exitCode != 0)
+		}
+	}
+
+	// Like a single command, the batch completed if every command it
+	// started ran to its end; failed also counts non-zero exits
+	status := "completed"
+	failed, skipped := 0, 0
+	for i, result := range results {
+		exitCode, _ := result["exit_code"].(int)
+		switch {
+		case result["status"] == "skipped":
+			skipped++
+		case result["status"] != "completed":
+			status = "failed"
+			failed++
+		case exitCode != 0:
+			failed++
+		}
+		if err == nil && errs[i] != nil {
+			err = errs[i]
+		}
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		status, err = "cancelled", ctx.Err()
+	}
+
+	result := map[string]interface{}{
+		"type":        "command_result",
+		"agent_id":    a.agentID,
+		"command_id":  qc.ID,
+		"command":     qc.Command,
+		"batch":       true,
+		"mode":        mode,
+		"status":      status,
+		"failed":      failed,
+		"skipped":     skipped,
+		"results":     results,
+		"started_at":  started.UTC().Format(time.RFC3339),
+		"duration_ms": time.Since(started).Milliseconds(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	}
+	if qc.ScheduleID != "" {
+		result["schedule_id"] = qc.ScheduleID
+	}
+	log.Printf("[%s] Batch %s finished: %d of %d commands failed, %d skipped", time.Now().Format(time.RFC3339), qc.ID, failed, len(results), skipped)
+	a.relayToC2(result)
+	return err
+}
+
+// commandSpec parses a command, script or batch message for queueing and
+// returns the line shown for it
+func (a *NOPAgent) commandSpec(msg map[string]interface{}) (string, error) {
+	if _, batch := msg["commands"]; batch {
+		_, specs, err := a.parseBatch(msg)
+		if err != nil {
+			return "", err
+		}
+		// Approvers see every command of the batch
+		lines := make([]string, len(specs))
+		for i, spec := range specs {
+			lines[i] = spec.line
+		}
+		separator := "; "
+		if mode, _ := msg["mode"].(string); mode == "parallel" {
+			separator = " & "
+		}
+		return strings.Join(lines, separator), nil
+	}
+	spec, err := a.parseExec(msg)
+	if err != nil {
+		return "", err
+	}
+	return spec.line, nil
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// ============================================================================
+// INTROSPECTION - Self-description of the commands in this build
+// ============================================================================
+type CommandSpec struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Params      []ParamSpec `json:"params,omitempty"`
+	Privilege   string      `json:"privilege"`            // "none", "user" or "elevated" on the host
+	Capability  string      `json:"capability,omitempty"` // capability flag that must be enabled
+	Signed      bool        `json:"signed,omitempty"`     // needs a C2 signature when ServerPublicKey is embedded
+	Approval    string      `json:"approval,omitempty"`   // approval class of the job it starts
+}
+
+type ParamSpec struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+// commandCatalog describes every C2 message type handled by dispatchMessage
+var commandCatalog = []CommandSpec{
+	{Name: "terminate", Description: "Stop the agent process", Privilege: "none", Signed: true,
+		Params: []ParamSpec{{Name: "message", Type: "string", Description: "reason logged by the agent"}}},
+	{Name: "kill", Description: "Stop the agent and delete its binary", Privilege: "user", Signed: true,
+		Params: []ParamSpec{{Name: "message", Type: "string", Description: "reason logged by the agent"}}},
+	{Name: "uninstall", Description: "Remove persistence, state, logs, firewall rules and the binary, then report", Privilege: "elevated",
+		Signed: true},
+	{Name: "broadcast", Description: "Run a wrapped message after a random offset in the stagger window", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "message", Type: "object", Required: true, Description: "message to dispatch"},
+			{Name: "stagger_seconds", Type: "number", Description: "window for the random offset"},
+			{Name: "broadcast_id", Type: "string"},
+		}},
+	{Name: "command", Description: "Queue a shell command; its output is returned as command_result", Privilege: "user", Capability: "access", Signed: true, Approval: "command",
+		Params: []ParamSpec{
+			{Name: "command", Type: "string", Description: "command line, required unless shell is raw or commands is set"},
+			{Name: "command_id", Type: "string"},
+			{Name: "operator", Type: "string"},
+			{Name: "timeout_seconds", Type: "number", Description: "kill the command after this long (default command_timeout, 3600)"},
+			{Name: "shell", Type: "string", Description: "sh, bash, cmd, powershell or pwsh (default the platform shell), or raw to run argv directly"},
+			{Name: "argv", Type: "string[]", Description: "program and arguments when shell is raw"},
+			{Name: "cwd", Type: "string", Description: "working directory"},
+			{Name: "env", Type: "object", Description: "variables added to the agent's environment"},
+			{Name: "run_as", Type: "string", Description: "user (name or uid) to run the command as; the agent needs root, or SeAssignPrimaryTokenPrivilege on Windows"},
+			{Name: "run_as_group", Type: "string", Description: "primary group instead of the user's (Unix)"},
+			{Name: "password", Type: "string", Description: "password of the run_as account (Windows)"},
+			{Name: "commands", Type: "array", Description: "batch of command lines or command objects run as one job"},
+			{Name: "mode", Type: "string", Description: "sequential (default) or parallel, for batches"},
+			{Name: "stop_on_error", Type: "boolean", Description: "skip the rest of a sequential batch after a failure or non-zero exit"},
+		}},
+	{Name: "script", Description: "Run a script body with a detected interpreter; its output is returned as script_result", Privilege: "user", Capability: "access", Signed: true, Approval: "script",
+		Params: []ParamSpec{
+			{Name: "script", Type: "string", Required: true},
+			{Name: "language", Type: "string", Required: true, Description: "bash, sh, powershell or python"},
+			{Name: "args", Type: "string[]", Description: "arguments passed to the script"},
+			{Name: "command_id", Type: "string"},
+			{Name: "operator", Type: "string"},
+			{Name: "timeout_seconds", Type: "number", Description: "kill the script after this long (default command_timeout, 3600)"},
+			{Name: "cwd", Type: "string", Description: "working directory"},
+			{Name: "env", Type: "object", Description: "variables added to the agent's environment"},
+			{Name: "run_as", Type: "string", Description: "user to run the script as, as for command"},
+			{Name: "run_as_group", Type: "string", Description: "primary group instead of the user's (Unix)"},
+			{Name: "password", Type: "string", Description: "password of the run_as account (Windows)"},
+		}},
+	{Name: "queue_list", Description: "List queued and running jobs", Privilege: "none"},
+	{Name: "queue_cancel", Description: "Cancel queued jobs that have not started", Privilege: "none",
+		Params: []ParamSpec{{Name: "command_id", Type: "string"}, {Name: "command_ids", Type: "string[]"}}},
+	{Name: "job_list", Description: "List recent jobs with their status, optionally filtered", Privilege: "none",
+		Params: []ParamSpec{{Name: "status", Type: "string", Description: "queued, running, completed, failed, ..."}}},
+	{Name: "job_status", Description: "Report one job by ID", Privilege: "none",
+		Params: []ParamSpec{{Name: "job_id", Type: "string", Required: true}}},
+	{Name: "job_cancel", Description: "Cancel a queued job, or kill a running command and its process group", Privilege: "none",
+		Params: []ParamSpec{{Name: "job_id", Type: "string", Required: true}}},
+	{Name: "schedule_add", Description: "Install a recurring command or module run on a cron schedule", Privilege: "user", Signed: true,
+		Params: []ParamSpec{
+			{Name: "cron", Type: "string", Required: true, Description: "5-field cron expression, @daily style macro or \"@every 30m\", in agent local time"},
+			{Name: "action", Type: "object", Required: true, Description: "{\"type\": \"command\", \"command\": ...} or {\"type\": \"module\", \"module\": \"host\"|\"asset\"|\"network\"}"},
+			{Name: "schedule_id", Type: "string", Description: "replaces the schedule with this ID"},
+		}},
+	{Name: "schedule_remove", Description: "Remove a scheduled task", Privilege: "none",
+		Params: []ParamSpec{{Name: "schedule_id", Type: "string", Required: true}}},
+	{Name: "schedule_list", Description: "List scheduled tasks with their last and next runs", Privilege: "none"},
+	{Name: "command_approve", Description: "Approve a command held for approval", Privilege: "none", Signed: true,
+		Params: []ParamSpec{{Name: "command_id", Type: "string", Required: true}, {Name: "operator", Type: "string", Required: true}, {Name: "role", Type: "string"}}},
+	{Name: "command_reject", Description: "Reject and drop a command held for approval", Privilege: "none", Signed: true,
+		Params: []ParamSpec{{Name: "command_id", Type: "string", Required: true}, {Name: "operator", Type: "string", Required: true}}},
+	{Name: "ping", Description: "Reply with pong", Privilege: "none"},
+	{Name: "data_channel_grant", Description: "One-time token for a separate file transfer connection", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "transfer_id", Type: "string", Required: true},
+			{Name: "token", Type: "string", Required: true},
+			{Name: "url", Type: "string", Description: "data channel endpoint (default the C2 URL)"},
+		}},
+	{Name: "rekey", Description: "Rotate the connection key via ECDH (X25519, P-256 in FIPS builds), authenticated by the current key", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "rekey_id", Type: "string", Required: true},
+			{Name: "public_key", Type: "string", Required: true, Description: "base64 public key on the key exchange curve"},
+			{Name: "nonce", Type: "string", Required: true, Description: "base64, at least 16 bytes"},
+			{Name: "mac", Type: "string", Required: true, Description: "base64 HMAC-SHA256 under the current key"},
+		}},
+	{Name: "ack", Description: "Acknowledge sequenced reports so they are not retransmitted", Privilege: "none",
+		Params: []ParamSpec{{Name: "seq", Type: "number"}, {Name: "seqs", Type: "number[]"}, {Name: "through", Type: "number"}}},
+	{Name: "settings_update", Description: "Merge settings into the agent config", Privilege: "none", Signed: true,
+		Params: []ParamSpec{{Name: "settings", Type: "object", Required: true}}},
+	{Name: "pause_telemetry", Description: "Hold telemetry until resumed", Privilege: "none",
+		Params: []ParamSpec{{Name: "duration_seconds", Type: "number"}, {Name: "max_rate", Type: "number"}}},
+	{Name: "resume_telemetry", Description: "Resume telemetry and deliver held reports", Privilege: "none",
+		Params: []ParamSpec{{Name: "max_rate", Type: "number"}}},
+	{Name: "introspect", Description: "Describe the commands supported by this build", Privilege: "none"},
+	{Name: "export_assets", Description: "Stream the local asset cache as a file", Privilege: "none", Capability: "asset",
+		Params: []ParamSpec{
+			{Name: "format", Type: "string", Description: "csv, json or ndjson (default json)"},
+			{Name: "transfer_id", Type: "string"},
+		}},
+	{Name: "oui_update", Description: "Replace the MAC vendor table used for asset records", Privilege: "none", Capability: "asset",
+		Params: []ParamSpec{{Name: "data", Type: "string", Required: true, Description: "base64 gzip of PREFIX<TAB>Vendor lines"}}},
+	{Name: "site_map_update", Description: "Replace the subnet labels applied to discovered assets", Privilege: "none", Capability: "asset",
+		Params: []ParamSpec{{Name: "entries", Type: "object[]", Required: true, Description: "cidr, site, zone and criticality per subnet"}}},
+	{Name: "import_scan", Description: "Import Nmap XML, Masscan JSON or arp-scan output from this host as assets", Privilege: "user", Capability: "asset",
+		Params: []ParamSpec{
+			{Name: "path", Type: "string", Required: true},
+			{Name: "format", Type: "string", Description: "nmap, masscan, arp-scan or auto (default)"},
+		}},
+	{Name: "identity_conflict", Description: "Another host holds this agent ID; request a sub-identity", Privilege: "none",
+		Params: []ParamSpec{{Name: "fingerprint", Type: "string", Description: "fingerprint of the conflicting host"}}},
+	{Name: "identity_assigned", Description: "Adopt a sub-identity issued by the C2 and reconnect", Privilege: "none",
+		Params: []ParamSpec{{Name: "agent_id", Type: "string", Required: true}, {Name: "auth_token", Type: "string"}}},
+	{Name: "token_refresh", Description: "Replace the bearer token used on reconnect", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "auth_token", Type: "string", Required: true},
+			{Name: "expires_at", Type: "string", Description: "RFC3339; omit for a token that does not expire"},
+			{Name: "expires_in", Type: "number", Description: "seconds, if expires_at is not given"},
+		}},
+	{Name: "update_offer", Description: "Check that an update artifact matches this platform", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "artifact", Type: "object", Required: true, Description: "goos, goarch, goarm, goamd64, libc, static"},
+			{Name: "update_id", Type: "string"},
+		}},
+	{Name: "proxy_start", Description: "Serve an internal HTTP service over HTTPS from this agent", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "target", Type: "string", Required: true, Description: "http(s) URL of the internal service"},
+			{Name: "cert_pem", Type: "string", Required: true},
+			{Name: "key_pem", Type: "string", Required: true},
+			{Name: "listen", Type: "string", Description: "listen address (default 127.0.0.1:0)"},
+			{Name: "access_token", Type: "string", Description: "required as basic auth password or X-NOP-Proxy-Token"},
+			{Name: "proxy_id", Type: "string"},
+		}},
+	{Name: "proxy_stop", Description: "Stop a reverse proxy", Privilege: "none", Capability: "access",
+		Params: []ParamSpec{{Name: "proxy_id", Type: "string", Required: true}}},
+	{Name: "proxy_list", Description: "List running reverse proxies", Privilege: "none", Capability: "access"},
+	{Name: "file_get", Description: "Send a file as file_chunk messages, resumable from an offset", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "path", Type: "string", Required: true},
+			{Name: "transfer_id", Type: "string", Description: "reuse an interrupted transfer's ID to resume it"},
+			{Name: "offset", Type: "number", Description: "bytes already received"},
+		}},
+	{Name: "proc_list", Description: "List processes with owner, command line, CPU and memory use", Privilege: "none", Capability: "host",
+		Params: []ParamSpec{
+			{Name: "filter", Type: "string", Description: "substring of the name or command line"},
+			{Name: "user", Type: "string"},
+			{Name: "sort", Type: "string", Description: "pid (default), cpu or rss"},
+			{Name: "max_entries", Type: "number", Description: "default 5000"},
+		}},
+	{Name: "proc_inspect", Description: "Describe one process with its ancestry, children, sockets and modules", Privilege: "none", Capability: "host",
+		Params: []ParamSpec{{Name: "pid", Type: "number", Required: true}}},
+	{Name: "proc_kill", Description: "Signal a process, optionally with its descendants", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "pid", Type: "number", Required: true},
+			{Name: "signal", Type: "string", Description: "TERM (default), KILL, INT, HUP, STOP or CONT"},
+			{Name: "tree", Type: "boolean", Description: "signal descendants too"},
+		}},
+	{Name: "proc_start", Description: "Start a detached process and report its PID", Privilege: "user", Capability: "access", Signed: true, Approval: "command",
+		Params: []ParamSpec{
+			{Name: "argv", Type: "string[]", Required: true, Description: "program and arguments"},
+			{Name: "cwd", Type: "string"},
+			{Name: "env", Type: "object"},
+			{Name: "run_as", Type: "string"},
+			{Name: "run_as_group", Type: "string"},
+			{Name: "password", Type: "string", Description: "password of the run_as account (Windows)"},
+		}},
+	{Name: "collect", Description: "Archive a directory as zip or tar.gz on the fly and send it as file_chunk messages", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "path", Type: "string", Required: true},
+			{Name: "format", Type: "string", Description: "tar.gz (default) or zip"},
+			{Name: "include", Type: "string[]", Description: "globs a file's relative path or name must match"},
+			{Name: "exclude", Type: "string[]", Description: "globs for files and directories to leave out"},
+			{Name: "max_file_size", Type: "number", Description: "skip files larger than this many bytes"},
+			{Name: "max_total_size", Type: "number", Description: "stop after this many bytes of content (default 1 GiB)"},
+			{Name: "transfer_id", Type: "string"},
+		}},
+	{Name: "file_tail", Description: "Follow a file across rotation and stream new lines as file_tail_data until cancelled with job_cancel", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "path", Type: "string", Required: true},
+			{Name: "lines", Type: "number", Description: "lines of existing content to send first (default 10)"},
+			{Name: "job_id", Type: "string"},
+		}},
+	{Name: "hash_files", Description: "Hash the files matching a list of globs and report their metadata", Privilege: "user", Capability: "access",
+		Params: []ParamSpec{
+			{Name: "patterns", Type: "string[]", Required: true, Description: "globs such as /etc/*.conf"},
+			{Name: "algorithms", Type: "string[]", Description: "sha256, sha1 and/or md5 (default sha256)"},
+			{Name: "recursive", Type: "boolean", Description: "walk directories that match"},
+			{Name: "max_files", Type: "number", Description: "stop after this many files (default 10000)"},
+		}},
+	{Name: "file_put", Description: "Write a file sent in file_put_chunk messages, verified against its SHA-256", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "path", Type: "string", Required: true},
+			{Name: "size", Type: "number", Required: true},
+			{Name: "sha256", Type: "string", Required: true, Description: "hex SHA-256 of the whole file"},
+			{Name: "transfer_id", Type: "string"},
+			{Name: "mode", Type: "string", Description: "octal permission bits, e.g. \"0755\""},
+			{Name: "overwrite", Type: "boolean", Description: "replace an existing file"},
+		}},
+	{Name: "file_put_chunk", Description: "Append data to a file_put upload", Privilege: "user", Capability: "access",
+		Params: []ParamSpec{
+			{Name: "transfer_id", Type: "string", Required: true},
+			{Name: "offset", Type: "number", Required: true},
+			{Name: "data", Type: "string", Required: true, Description: "base64"},
+			{Name: "eof", Type: "boolean"},
+		}},
+	{Name: "fs_list", Description: "List a directory as structured entries (name, type, size, mode, modified, owner)", Privilege: "none", Capability: "access",
+		Params: []ParamSpec{
+			{Name: "path", Type: "string", Required: true},
+			{Name: "max_entries", Type: "number", Description: "default 5000"},
+			{Name: "request_id", Type: "string"},
+		}},
+	{Name: "fs_stat", Description: "Describe one file, directory or symlink", Privilege: "none", Capability: "access",
+		Params: []ParamSpec{{Name: "path", Type: "string", Required: true}, {Name: "request_id", Type: "string"}}},
+	{Name: "fs_remove", Description: "Delete a file or directory", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "path", Type: "string", Required: true},
+			{Name: "recursive", Type: "boolean", Description: "delete a directory with its contents"},
+			{Name: "request_id", Type: "string"},
+		}},
+	{Name: "fs_mkdir", Description: "Create a directory", Privilege: "user", Capability: "access",
+		Params: []ParamSpec{
+			{Name: "path", Type: "string", Required: true},
+			{Name: "parents", Type: "boolean", Description: "create missing parent directories"},
+			{Name: "mode", Type: "string", Description: "octal permission bits, default \"0755\""},
+			{Name: "request_id", Type: "string"},
+		}},
+	{Name: "fs_move", Description: "Rename or move a file or directory on the same filesystem", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "source", Type: "string", Required: true},
+			{Name: "destination", Type: "string", Required: true},
+			{Name: "overwrite", Type: "boolean", Description: "replace an existing destination"},
+			{Name: "request_id", Type: "string"},
+		}},
+	{Name: "shell_open", Description: "Open an interactive shell on a PTY, streamed as shell_output", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "session_id", Type: "string"},
+			{Name: "shell", Type: "string", Description: "bash, zsh or sh; cmd, powershell or pwsh on Windows"},
+			{Name: "cols", Type: "number"},
+			{Name: "rows", Type: "number"},
+		}},
+	{Name: "shell_input", Description: "Send keystrokes to a shell session", Privilege: "none", Capability: "access", Signed: true,
+		Params: []ParamSpec{{Name: "session_id", Type: "string", Required: true}, {Name: "data", Type: "string", Required: true, Description: "base64"}}},
+	{Name: "shell_resize", Description: "Resize a shell session's terminal", Privilege: "none", Capability: "access",
+		Params: []ParamSpec{{Name: "session_id", Type: "string", Required: true}, {Name: "cols", Type: "number", Required: true}, {Name: "rows", Type: "number", Required: true}}},
+	{Name: "shell_close", Description: "End a shell session", Privilege: "none", Capability: "access",
+		Params: []ParamSpec{{Name: "session_id", Type: "string", Required: true}}},
+	{Name: "http_request", Description: "Perform an HTTP request from the agent and return the response", Privilege: "user", Capability: "access", Signed: true,
+		Params: []ParamSpec{
+			{Name: "url", Type: "string", Required: true},
+			{Name: "method", Type: "string", Description: "default GET"},
+			{Name: "headers", Type: "object"},
+			{Name: "body", Type: "string"},
+			{Name: "body_base64", Type: "string"},
+			{Name: "timeout", Type: "number", Description: "seconds, default 15, max 120"},
+			{Name: "max_body", Type: "number", Description: "bytes of body returned, default 65536"},
+			{Name: "follow_redirects", Type: "boolean", Description: "default true"},
+			{Name: "insecure", Type: "boolean", Description: "skip TLS verification"},
+			{Name: "request_id", Type: "string"},
+		}},
+	{Name: "dns_lookup", Description: "Resolve a name from the agent's network", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "name", Type: "string", Required: true, Description: "name, or IP address for PTR"},
+			{Name: "record_type", Type: "string", Description: "A, AAAA, PTR, SRV, TXT, MX, CNAME or NS (default A)"},
+			{Name: "server", Type: "string", Description: "DNS server host[:port] (default host resolvers)"},
+			{Name: "request_id", Type: "string"},
+		}},
+	{Name: "mail_probe", Description: "Check a mail server for STARTTLS, auth mechanisms and open relaying", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "host", Type: "string", Required: true},
+			{Name: "protocol", Type: "string", Description: "smtp or imap (default smtp)"},
+			{Name: "port", Type: "number", Description: "default 25 for smtp, 143 for imap"},
+			{Name: "relay_test", Type: "boolean", Description: "smtp only: test MAIL FROM/RCPT TO acceptance, no message is sent"},
+			{Name: "mail_from", Type: "string"},
+			{Name: "rcpt_to", Type: "string", Description: "external recipient used for the relay test"},
+			{Name: "request_id", Type: "string"},
+		}},
+	{Name: "db_probe", Description: "Fingerprint database services from their pre-authentication handshake", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "host", Type: "string", Required: true},
+			{Name: "services", Type: "string[]", Description: "mysql, postgres, mssql, redis, mongodb (default all)"},
+			{Name: "ports", Type: "object", Description: "port override per service"},
+			{Name: "request_id", Type: "string"},
+		}},
+	{Name: "k8s_probe", Description: "Check kubelet, API server and etcd for anonymous access", Privilege: "none",
+		Params: []ParamSpec{{Name: "host", Type: "string", Required: true}, {Name: "request_id", Type: "string"}}},
+	{Name: "cloud_exposure", Description: "Check reachability of cloud metadata services and storage endpoints", Privilege: "none",
+		Params: []ParamSpec{
+			{Name: "endpoints", Type: "string[]", Description: "extra storage hostnames to test"},
+			{Name: "timeout", Type: "number", Description: "per-check timeout in seconds (default 3)"},
+			{Name: "request_id", Type: "string"},
+		}},
+}
+
+func (a *NOPAgent) handleIntrospect() {
+	available := make([]map[string]interface{}, 0, len(commandCatalog))
+	for _, spec := range commandCatalog {
+		available = append(available, map[string]interface{}{
+			"spec":    spec,
+			"enabled": spec.Capability == "" || a.capabilities[spec.Capability],
+		})
+	}
+
+	transportNames := make([]string, 0, len(transport.Schemes))
+	for name := range transport.Schemes {
+		if a.options.allowsTransport(name) {
+			transportNames = append(transportNames, name)
+		}
+	}
+	sort.Strings(transportNames)
+
+	a.relayToC2(map[string]interface{}{
+		"type":         "introspect_result",
+		"agent_id":     a.agentID,
+		"commands":     available,
+		"capabilities": a.capabilities,
+		"profile":      a.options.Profile,
+		"transports":   transportNames,
+		"codecs":       a.supportedCodecs(),
+		"compression":  a.supportedCompression(),
+		"platform":     runtime.GOOS,
+		"arch":         runtime.GOARCH,
+		"go_version":   runtime.Version(),
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,452 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+	"github.com/goranjovic55/NOP/nopagent/storage"
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// initCipher derives the master key with the generated KDF (PBKDF2 or
+// Argon2id) over the per-agent salt.
+// With "per_message_keys" enabled, every message is additionally sealed
+// under its own HKDF-derived key (see seal).
+func (a *NOPAgent) initCipher() {
+	// Main has already refused builds with unusable KDF parameters
+	a.kdf, _ = crypto.ParseKDF(a.identity.KDF, a.identity.KDFMemory, a.identity.KDFIterations)
+	a.masterKey = crypto.MasterKey(a.encryptionKey, kdfSalt(a.identity.KDFSalt), a.kdf)
+	a.store, _ = storage.New(a.masterKey)
+	a.messageKeys, _ = a.config["per_message_keys"].(bool)
+
+	// Counters resume past the block the previous run reserved, or at the
+	// clock if that is later, so a static-key nonce is not reused even when
+	// the clock went back. They are only sent once the C2 agrees in
+	// "registered".
+	a.sendCounter = uint64(time.Now().UnixNano())
+	a.staticWindow = &crypto.ReplayWindow{}
+	a.loadReplayState()
+	a.reserveCounters(a.sendCounter)
+
+	gcm, err := crypto.NewGCM(a.masterKey)
+	if err != nil {
+		log.Printf("[%s] Cipher init error: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+
+	a.cipher = gcm
+	a.suite = crypto.DefaultSuite
+	a.schedule = protocol.KeyScheduleShared
+	a.staticWire, _ = crypto.NewWireKey(a.suite, a.masterKey, false)
+}
+
+// kdfSalt returns the embedded per-agent salt, falling back to the legacy
+// shared salt for templates rendered without one
+func kdfSalt(encoded string) []byte {
+	salt, ok := crypto.Salt(encoded)
+	if !ok {
+		log.Printf("[%s] No per-agent KDF salt embedded, using legacy shared salt", time.Now().Format(time.RFC3339))
+	}
+	return salt
+}
+
+// supportedCiphers lists the suites offered at registration, in preference
+// order, according to the "cipher" config key ("aes-256-gcm",
+// "chacha20-poly1305" or "auto"). Auto prefers ChaCha20-Poly1305 on CPUs
+// without AES instructions, e.g. low-end ARM boards. FIPS builds only offer
+// AES-256-GCM.
+func (a *NOPAgent) supportedCiphers() []string {
+	if crypto.FIPS {
+		return []string{crypto.DefaultSuite}
+	}
+	switch setting, _ := a.config["cipher"].(string); setting {
+	case "aes-256-gcm":
+		return []string{"aes-256-gcm"}
+	case "chacha20-poly1305":
+		return []string{"chacha20-poly1305", "aes-256-gcm"}
+	}
+	if crypto.HasAESHardware() {
+		return []string{"aes-256-gcm", "chacha20-poly1305"}
+	}
+	return []string{"chacha20-poly1305", "aes-256-gcm"}
+}
+
+// supportedKeySchedules lists the wire key schedules offered at
+// registration; "key_schedule": "shared" in the config drops the
+// directional one for C2s that cannot derive it
+func (a *NOPAgent) supportedKeySchedules() []string {
+	if setting, _ := a.config["key_schedule"].(string); setting == protocol.KeyScheduleShared {
+		return []string{protocol.KeyScheduleShared}
+	}
+	return []string{protocol.KeyScheduleDirectional, protocol.KeyScheduleShared}
+}
+
+// useCipherSuite switches wire encryption to suite and schedule, keeping
+// the current keys
+func (a *NOPAgent) useCipherSuite(suite, schedule string) error {
+	directional := schedule == protocol.KeyScheduleDirectional
+	static, err := crypto.NewWireKey(suite, a.masterKey, directional)
+	if err != nil {
+		return err
+	}
+	a.keyMutex.Lock()
+	defer a.keyMutex.Unlock()
+	if a.session != nil {
+		session, err := crypto.NewWireKey(suite, a.session.Secret, directional)
+		if err != nil {
+			return err
+		}
+		a.session = session
+	}
+	a.suite, a.schedule, a.staticWire = suite, schedule, static
+	a.retired = nil
+	return nil
+}
+
+// newWireKey builds a wire key for secret in the negotiated suite and
+// schedule
+func (a *NOPAgent) newWireKey(secret []byte) (*crypto.WireKey, error) {
+	a.keyMutex.RLock()
+	suite, schedule := a.suite, a.schedule
+	a.keyMutex.RUnlock()
+	return crypto.NewWireKey(suite, secret, schedule == protocol.KeyScheduleDirectional)
+}
+
+func (a *NOPAgent) encryptMessage(data string) (string, error) {
+	sealed, err := a.seal([]byte(data))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (a *NOPAgent) decryptMessage(encryptedData string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encryptedData)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := a.open(data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// wireKeys returns the key for traffic on the current connection (the
+// session key once a key exchange has completed, otherwise the static key)
+// and the replay window for inbound counters under it, nil when replay
+// protection is off
+func (a *NOPAgent) wireKeys() (*crypto.WireKey, *crypto.ReplayWindow) {
+	a.keyMutex.RLock()
+	defer a.keyMutex.RUnlock()
+	window := a.sessionWindow
+	if window == nil {
+		window = a.staticWindow
+	}
+	if !a.replayCheck {
+		window = nil
+	}
+	if a.session != nil {
+		return a.session, window
+	}
+	return a.staticWire, window
+}
+
+// seal encrypts for the wire; local state uses a.store so it stays
+// readable across sessions. With replay protection the frame starts with an
+// 8-byte big-endian counter that is bound into the AAD and, under the
+// directional key schedule, into the nonce.
+func (a *NOPAgent) seal(plaintext []byte) ([]byte, error) {
+	key, window := a.wireKeys()
+	if window == nil {
+		return key.Seal(plaintext, nil, 0, a.messageKeys)
+	}
+	counter := atomic.AddUint64(&a.sendCounter, 1)
+	if counter >= atomic.LoadUint64(&a.sendReserved) {
+		a.reserveCounters(counter)
+	}
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint64(header, counter)
+	sealed, err := key.Seal(plaintext, append([]byte(crypto.AADAgent), header...), counter, a.messageKeys)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, sealed...), nil
+}
+
+// open decrypts wire data and rejects counters already seen or older than
+// the replay window. After a rekey the retired key is still accepted for a
+// grace period, covering messages the C2 sealed before switching.
+func (a *NOPAgent) open(data []byte) ([]byte, error) {
+	key, window := a.wireKeys()
+	var aad []byte
+	var counter uint64
+	if window != nil {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("ciphertext too short")
+		}
+		counter = binary.BigEndian.Uint64(data[:8])
+		aad = append([]byte(crypto.AADC2), data[:8]...)
+		data = data[8:]
+	}
+
+	plaintext, err := key.Open(data, aad, a.messageKeys)
+	if err != nil {
+		a.keyMutex.RLock()
+		retired, until := a.retired, a.retiredUntil
+		a.keyMutex.RUnlock()
+		if retired != nil && time.Now().Before(until) {
+			if p, retiredErr := retired.Open(data, aad, a.messageKeys); retiredErr == nil {
+				plaintext, err = p, nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Only authenticated counters may advance the window
+	if window != nil && !window.Accept(counter) {
+		return nil, fmt.Errorf("replayed or out-of-window message (counter %d)", counter)
+	}
+	// Session keys die with the connection, the static key does not
+	if window != nil && window == a.staticWindow {
+		a.saveReplayState()
+	}
+	return plaintext, nil
+}
+
+// errCleartextFrame rejects unsealed frames once sealing is on
+var errCleartextFrame = errors.New("cleartext message on an encrypted connection")
+
+// sealing reports whether frames are encrypted, which they are from the
+// first registration of a build with an encryption key on
+func (a *NOPAgent) sealing() bool {
+	a.keyMutex.RLock()
+	defer a.keyMutex.RUnlock()
+	return a.sealWire && a.cipher != nil
+}
+
+// enableSealing turns on encrypted framing. It is one-way: nothing the C2
+// or the network sends can turn it off again.
+func (a *NOPAgent) enableSealing() {
+	a.keyMutex.Lock()
+	a.sealWire = true
+	a.keyMutex.Unlock()
+}
+
+// replayOffered reports whether registration offers message counters;
+// "replay_protection": false in the config withholds the offer unless the
+// C2 already agreed to them
+func (a *NOPAgent) replayOffered() bool {
+	enabled, ok := a.config["replay_protection"].(bool)
+	return !ok || enabled || a.replayChecking()
+}
+
+func (a *NOPAgent) replayChecking() bool {
+	a.keyMutex.RLock()
+	defer a.keyMutex.RUnlock()
+	return a.replayCheck
+}
+
+// enableReplayCheck turns on message counters. Like sealing it is one-way,
+// and it is kept in the sealed state so a restart does not undo it.
+func (a *NOPAgent) enableReplayCheck() {
+	a.keyMutex.Lock()
+	a.replayCheck = true
+	a.keyMutex.Unlock()
+	a.saveReplayState()
+}
+
+// replayState is the replay protection kept across restarts: whether the
+// C2 has agreed to counters, and the highest counter accepted under the
+// static key, so frames captured before a restart are refused after it
+type replayState struct {
+	Enabled      bool   `json:"enabled"`
+	Highest      uint64 `json:"highest"`
+	SendReserved uint64 `json:"send_reserved,omitempty"`
+}
+
+// counterBlock is how many send counters are reserved per write of the
+// replay state
+const counterBlock = 1 << 20
+
+func (a *NOPAgent) replayPath() string {
+	return filepath.Join(a.stateDir(), "replay.json")
+}
+
+func (a *NOPAgent) loadReplayState() {
+	var stored replayState
+	if err := a.readState(a.replayPath(), "replay", &stored); err != nil {
+		return
+	}
+	a.replayCheck = stored.Enabled
+	a.staticWindow = crypto.ResumeReplayWindow(stored.Highest)
+	if stored.SendReserved > a.sendCounter {
+		a.sendCounter = stored.SendReserved
+	}
+}
+
+func (a *NOPAgent) saveReplayState() {
+	a.replayMutex.Lock()
+	defer a.replayMutex.Unlock()
+	a.writeReplayState()
+}
+
+// reserveCounters persists a block of send counters past counter before
+// any of them goes out, so the next run starts beyond everything this one
+// may have sent. Without a writable state directory only the clock is left.
+func (a *NOPAgent) reserveCounters(counter uint64) {
+	a.replayMutex.Lock()
+	defer a.replayMutex.Unlock()
+	if counter < atomic.LoadUint64(&a.sendReserved) {
+		return
+	}
+	atomic.StoreUint64(&a.sendReserved, counter+counterBlock)
+	a.writeReplayState()
+}
+
+// writeReplayState saves the replay state; callers hold replayMutex
+func (a *NOPAgent) writeReplayState() {
+	state := replayState{
+		Enabled:      a.replayChecking(),
+		Highest:      a.staticWindow.Highest(),
+		SendReserved: atomic.LoadUint64(&a.sendReserved),
+	}
+	if err := a.writeState(a.replayPath(), "replay", state); err != nil {
+		log.Printf("[%s] Could not save replay state: %v", time.Now().Format(time.RFC3339), err)
+	}
+}
+
+// sendEncrypted seals message when the C2 agreed to encryption and sends it
+// as is otherwise
+func (a *NOPAgent) sendEncrypted(message interface{}) error {
+	if !a.sealing() {
+		return a.writeJSON(message)
+	}
+	envelope, err := a.sealEnvelope(message)
+	if err != nil {
+		return err
+	}
+	return a.writeJSON(envelope)
+}
+
+// sealEnvelope encodes, compresses and encrypts a message into the
+// {"encrypted": true, "data": ...} envelope understood by the C2
+func (a *NOPAgent) sealEnvelope(message interface{}) (map[string]interface{}, error) {
+	if !a.outboundAllowed(message) {
+		return nil, errBlockedByPolicy
+	}
+	message = a.tagConsent(message)
+	a.record("out", message)
+	codec := a.activeCodec()
+	payload, err := codec.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compress before encrypting - ciphertext does not compress
+	algorithm := a.compression
+	if algorithm != "" {
+		payload, err = transport.Compress(algorithm, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sealed, err := a.seal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// []byte data is base64 in JSON frames and raw bytes in binary frames
+	encryptedMsg := map[string]interface{}{
+		"encrypted": true,
+		"data":      sealed,
+	}
+	if algorithm != "" {
+		encryptedMsg["compression"] = algorithm
+	}
+	if codec.Name() != "json" {
+		encryptedMsg["codec"] = codec.Name()
+	}
+	return encryptedMsg, nil
+}
+
+// openEnvelope decrypts an {"encrypted": true, "data": ...} message from the
+// C2 using the same codec and compression pipeline as sendEncrypted
+func (a *NOPAgent) openEnvelope(msg map[string]interface{}) (map[string]interface{}, error) {
+	encoded, ok := msg["data"].(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted message has no data")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := a.open(data)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm, _ := msg["compression"].(string); algorithm != "" {
+		payload, err = transport.Decompress(algorithm, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	name, _ := msg["codec"].(string)
+	codec, ok := transport.Codecs[name]
+	if !ok {
+		codec = transport.Codecs["json"]
+	}
+	var inner map[string]interface{}
+	if err := transport.DecodeFrame(codec, payload, &inner); err != nil {
+		return nil, err
+	}
+	return inner, nil
+}
+
+// supportedCompression lists the algorithms offered at registration, in
+// preference order, according to the "compression" config key
+// ("none", "gzip", "zstd" or "auto")
+func (a *NOPAgent) supportedCompression() []string {
+	switch setting, _ := a.config["compression"].(string); setting {
+	case "gzip":
+		return []string{"gzip"}
+	case "zstd", "auto":
+		return []string{"zstd", "gzip"}
+	}
+	return []string{}
+}
+
+// supportedCodecs lists the codecs offered at registration according to the
+// "codec" config key ("json", "cbor", "msgpack" or "auto")
+func (a *NOPAgent) supportedCodecs() []string {
+	switch setting, _ := a.config["codec"].(string); setting {
+	case "cbor":
+		return []string{"cbor", "json"}
+	case "msgpack":
+		return []string{"msgpack", "json"}
+	case "auto":
+		return []string{"cbor", "msgpack", "json"}
+	}
+	return []string{"json"}
+}
+
+func (a *NOPAgent) activeCodec() transport.Codec {
+	if a.codec == nil {
+		return transport.Codecs["json"]
+	}
+	return a.codec
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// metadataService describes one provider's instance metadata endpoint. The
+// header is what the provider requires; the probe also tries without it,
+// since a service that answers bare GETs is reachable through simple SSRF.
+type metadataService struct {
+	Provider string
+	URL      string
+	Header   string
+	Value    string
+}
+
+var metadataServices = []metadataService{
+	{"aws", "http://169.254.169.254/latest/meta-data/", "", ""},
+	{"aws_ipv6", "http://[fd00:ec2::254]/latest/meta-data/", "", ""},
+	{"gcp", "http://metadata.google.internal/computeMetadata/v1/instance/", "Metadata-Flavor", "Google"},
+	{"azure", "http://169.254.169.254/metadata/instance?api-version=2021-02-01", "Metadata", "true"},
+	{"oracle", "http://169.254.169.254/opc/v2/instance/", "Authorization", "Bearer Oracle"},
+	{"digitalocean", "http://169.254.169.254/metadata/v1.json", "", ""},
+	{"openstack", "http://169.254.169.254/openstack/latest/meta_data.json", "", ""},
+	{"alibaba", "http://100.100.100.200/latest/meta-data/", "", ""},
+	{"tencent", "http://metadata.tencentyun.com/latest/meta-data/", "", ""},
+}
+
+// cloudStorageEndpoints are object storage hosts checked for DNS and egress
+var cloudStorageEndpoints = []string{
+	"s3.amazonaws.com",
+	"storage.googleapis.com",
+	"blob.core.windows.net",
+	"objectstorage.us-ashburn-1.oraclecloud.com",
+	"nyc3.digitaloceanspaces.com",
+	"oss-cn-hangzhou.aliyuncs.com",
+}
+
+// handleCloudExposure maps which cloud metadata services and storage
+// endpoints this network segment can reach. Only metadata index pages are
+// requested; credentials paths are never read.
+func (a *NOPAgent) handleCloudExposure(msg map[string]interface{}) {
+	timeout := 3 * time.Second
+	if t, ok := msg["timeout"].(float64); ok && t > 0 {
+		timeout = time.Duration(t * float64(time.Second))
+	}
+	endpoints := append([]string(nil), cloudStorageEndpoints...)
+	if extra, ok := msg["endpoints"].([]interface{}); ok {
+		for _, e := range extra {
+			if host, ok := e.(string); ok && host != "" {
+				endpoints = append(endpoints, host)
+			}
+		}
+	}
+
+	// Metadata services must be reached directly, never through a proxy
+	direct := &http.Client{
+		Timeout:       timeout,
+		Transport:     &http.Transport{Proxy: nil},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	get := func(rawURL, header, value string) (int, error) {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return 0, err
+		}
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := direct.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	findings := make([]map[string]interface{}, 0)
+	finding := func(component, severity, detail string) {
+		findings = append(findings, map[string]interface{}{"component": component, "severity": severity, "detail": detail})
+	}
+
+	metadata := make([]map[string]interface{}, 0, len(metadataServices))
+	for _, svc := range metadataServices {
+		entry := map[string]interface{}{"provider": svc.Provider, "url": svc.URL}
+		status, err := get(svc.URL, svc.Header, svc.Value)
+		if err != nil {
+			entry["reachable"] = false
+			metadata = append(metadata, entry)
+			continue
+		}
+		entry["reachable"] = true
+		entry["status"] = status
+		if svc.Header != "" {
+			bare, err := get(svc.URL, "", "")
+			if err == nil {
+				entry["status_without_header"] = bare
+				if status == http.StatusOK && bare == http.StatusOK {
+					finding(svc.Provider, "high", "metadata service answers without its required header")
+				}
+			}
+		}
+
+		// IMDSv2 requires a PUT for a session token; a bare GET working means v1 is still on
+		if svc.Provider == "aws" && status == http.StatusOK {
+			entry["imdsv1"] = true
+			finding("aws", "high", "IMDSv1 is enabled: metadata is readable with a single unauthenticated GET")
+			req, _ := http.NewRequest(http.MethodPut, "http://169.254.169.254/latest/api/token", nil)
+			req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+			if resp, err := direct.Do(req); err == nil {
+				resp.Body.Close()
+				entry["imdsv2"] = resp.StatusCode == http.StatusOK
+			}
+		} else if status == http.StatusOK {
+			finding(svc.Provider, "medium", "instance metadata service is reachable from this network")
+		}
+		metadata = append(metadata, entry)
+	}
+
+	// Storage endpoints: private answers indicate VPC/private endpoints
+	storage := make([]map[string]interface{}, 0, len(endpoints))
+	for _, host := range endpoints {
+		entry := map[string]interface{}{"host": host}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		cancel()
+		if err != nil {
+			entry["resolved"] = false
+			storage = append(storage, entry)
+			continue
+		}
+		ips := make([]string, 0, len(addrs))
+		private := false
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP.String())
+			private = private || addr.IP.IsPrivate()
+		}
+		entry["resolved"] = true
+		entry["addresses"] = ips
+		entry["private_endpoint"] = private
+
+		start := time.Now()
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", net.JoinHostPort(host, "443"), &tls.Config{ServerName: host})
+		if err == nil {
+			entry["direct_egress"] = true
+			entry["rtt_ms"] = time.Since(start).Milliseconds()
+			conn.Close()
+			finding("storage", "info", "direct HTTPS egress to "+host)
+		} else {
+			entry["direct_egress"] = false
+			entry["error"] = err.Error()
+		}
+
+		// Egress through the environment's proxy is a separate exfiltration path
+		req, _ := http.NewRequest(http.MethodHead, "https://"+host+"/", nil)
+		if proxyURL, err := http.ProxyFromEnvironment(req); err == nil && proxyURL != nil {
+			entry["proxy"] = proxyURL.Host
+			viaProxy := &http.Client{Timeout: timeout, Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			if resp, err := viaProxy.Do(req); err == nil {
+				resp.Body.Close()
+				entry["proxy_egress"] = true
+			} else {
+				entry["proxy_egress"] = false
+			}
+		}
+		storage = append(storage, entry)
+	}
+
+	a.writeJSON(map[string]interface{}{
+		"type":       "cloud_exposure_result",
+		"agent_id":   a.agentID,
+		"request_id": msg["request_id"],
+		"metadata":   metadata,
+		"storage":    storage,
+		"findings":   findings,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
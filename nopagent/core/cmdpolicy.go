@@ -0,0 +1,178 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// COMMAND POLICY - Embedded limits on what the C2 may run on this host
+// ============================================================================
+
+// commandPolicy is baked in at generation time like the data policy, so a
+// compromised C2 session cannot widen it. A nil policy allows everything.
+type commandPolicy struct {
+	AllowedCommands []string `json:"allowed_commands"` // regular expressions matching the whole command line
+	ForbiddenPaths  []string `json:"forbidden_paths"`  // no command or file operation may touch these trees
+	MaxOutputBytes  int      `json:"max_output_bytes"` // per stream, 0 for no limit
+	allowed         []*regexp.Regexp
+	forbidden       []string // ForbiddenPaths and their symlink targets
+	denyAll         bool
+}
+
+// loadCommandPolicy parses the embedded command policy. An unrendered
+// placeholder allows everything; a rendered but unreadable policy refuses
+// every command and file operation.
+func loadCommandPolicy(raw string) *commandPolicy {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasPrefix(raw, "{{") {
+		return nil
+	}
+	policy := &commandPolicy{}
+	if err := json.Unmarshal([]byte(raw), policy); err != nil {
+		log.Printf("[%s] Invalid command policy, commands disabled: %v", time.Now().Format(time.RFC3339), err)
+		return &commandPolicy{denyAll: true}
+	}
+	for _, pattern := range policy.AllowedCommands {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			log.Printf("[%s] Invalid command policy pattern %q, commands disabled: %v", time.Now().Format(time.RFC3339), pattern, err)
+			return &commandPolicy{denyAll: true}
+		}
+		policy.allowed = append(policy.allowed, re)
+	}
+	for i, path := range policy.ForbiddenPaths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		policy.ForbiddenPaths[i] = filepath.Clean(path)
+		policy.forbidden = append(policy.forbidden, policy.ForbiddenPaths[i])
+		// /etc is /private/etc on macOS, for example
+		if resolved, err := filepath.EvalSymlinks(path); err == nil && resolved != policy.ForbiddenPaths[i] {
+			policy.forbidden = append(policy.forbidden, resolved)
+		}
+	}
+	return policy
+}
+
+// checkCommand refuses command lines outside the allowlist or naming a
+// forbidden path. The path check is a plain substring match, so the
+// allowlist is what actually bounds a shell command.
+func (p *commandPolicy) checkCommand(line string) error {
+	if p == nil {
+		return nil
+	}
+	if p.denyAll {
+		return newAgentError(ErrPermission, "command_not_allowed", "the command policy of this build is invalid")
+	}
+	if len(p.allowed) > 0 {
+		allowed := false
+		for _, re := range p.allowed {
+			if re.MatchString(strings.TrimSpace(line)) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return newAgentError(ErrPermission, "command_not_allowed", "command is not in the allowlist of this build")
+		}
+	}
+	for _, forbidden := range p.ForbiddenPaths {
+		if strings.Contains(line, forbidden) {
+			return newAgentError(ErrPermission, "forbidden_path", "command names forbidden path %s", forbidden)
+		}
+	}
+	return nil
+}
+
+// checkPath refuses file operations on or below a forbidden path. Relative
+// paths are made absolute against the agent's working directory, where they
+// will be opened, and symlinks are resolved so a link cannot point around
+// the policy.
+func (p *commandPolicy) checkPath(path string) error {
+	if p == nil {
+		return nil
+	}
+	if p.denyAll {
+		return newAgentError(ErrPermission, "forbidden_path", "the command policy of this build is invalid")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return newAgentError(ErrPermission, "forbidden_path", "%s cannot be resolved: %v", path, err)
+	}
+	candidates := []string{abs}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		candidates = append(candidates, resolved)
+	} else if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		// Files about to be created resolve through their directory
+		candidates = append(candidates, filepath.Join(dir, filepath.Base(abs)))
+	}
+	for _, candidate := range candidates {
+		for _, forbidden := range p.forbidden {
+			if !strings.EqualFold(filepath.VolumeName(forbidden), filepath.VolumeName(candidate)) {
+				continue
+			}
+			// Both are absolute on one volume, so Rel failing means the
+			// relation is unknown and the path is refused
+			rel, err := filepath.Rel(forbidden, candidate)
+			if err != nil || rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return newAgentError(ErrPermission, "forbidden_path", "%s is under forbidden path %s", path, forbidden)
+			}
+		}
+	}
+	return nil
+}
+
+// loaderEnv are variables that change which program runs or what it loads
+// before the checked command line is even read
+var loaderEnv = []string{"PATH", "PATHEXT", "IFS", "ENV", "BASH_ENV"}
+
+// checkEnv refuses, under a restrictive policy, variables that would run
+// something other than the allowed command line, and values naming a
+// forbidden path
+func (p *commandPolicy) checkEnv(name, value string) error {
+	if !p.restrictsShells() {
+		return nil
+	}
+	upper := strings.ToUpper(name)
+	for _, loader := range loaderEnv {
+		if upper == loader {
+			return newAgentError(ErrPermission, "env_not_allowed", "the command policy of this build does not allow setting %s", name)
+		}
+	}
+	for _, prefix := range []string{"LD_", "DYLD_", "BASH_FUNC_"} {
+		if strings.HasPrefix(upper, prefix) {
+			return newAgentError(ErrPermission, "env_not_allowed", "the command policy of this build does not allow setting %s", name)
+		}
+	}
+	for _, forbidden := range p.ForbiddenPaths {
+		if strings.Contains(value, forbidden) {
+			return newAgentError(ErrPermission, "forbidden_path", "env %s names forbidden path %s", name, forbidden)
+		}
+	}
+	return nil
+}
+
+// restrictsShells reports whether interactive shells must be refused: they
+// would bypass both the allowlist and the path check
+func (p *commandPolicy) restrictsShells() bool {
+	return p != nil && (p.denyAll || len(p.allowed) > 0 || len(p.ForbiddenPaths) > 0)
+}
+
+// summary is reported at registration so the C2 can tell operators what
+// this agent will refuse
+func (p *commandPolicy) summary() map[string]interface{} {
+	if p == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"allowed_commands": p.AllowedCommands,
+		"forbidden_paths":  p.ForbiddenPaths,
+		"max_output_bytes": p.MaxOutputBytes,
+		"invalid":          p.denyAll,
+	}
+}
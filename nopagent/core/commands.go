@@ -0,0 +1,181 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// ============================================================================
+// COMMAND QUEUE - Pending command inspection and cancellation
+// ============================================================================
+
+// handleQueueList reports jobs that are waiting or running
+func (a *NOPAgent) handleQueueList() {
+	a.jobMutex.Lock()
+	queue := make([]Job, 0, len(a.jobs))
+	for _, job := range a.jobs {
+		if job.active() {
+			queue = append(queue, *job)
+		}
+	}
+	a.jobMutex.Unlock()
+
+	a.relayToC2(map[string]interface{}{
+		"type":      "queue_list_result",
+		"agent_id":  a.agentID,
+		"queue":     queue,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (a *NOPAgent) handleQueueCancel(msg map[string]interface{}) {
+	ids := make([]string, 0)
+	if id, ok := msg["command_id"].(string); ok {
+		ids = append(ids, id)
+	}
+	if list, ok := msg["command_ids"].([]interface{}); ok {
+		for _, v := range list {
+			if id, ok := v.(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	cancelled := make([]string, 0)
+	running := make([]string, 0)
+	notFound := make([]string, 0)
+
+	a.jobMutex.Lock()
+	for _, id := range ids {
+		found := false
+		for _, job := range a.jobs {
+			if job.ID != id || !job.active() {
+				continue
+			}
+			found = true
+			if job.Status == "queued" || job.Status == "pending_approval" {
+				job.Status = "cancelled"
+				job.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+				cancelled = append(cancelled, id)
+			} else {
+				running = append(running, id)
+			}
+			break
+		}
+		if !found {
+			notFound = append(notFound, id)
+		}
+	}
+	a.pruneJobs()
+	a.jobMutex.Unlock()
+
+	if len(cancelled) > 0 {
+		log.Printf("[%s] Cancelled queued commands: %v", time.Now().Format(time.RFC3339), cancelled)
+	}
+
+	a.relayToC2(map[string]interface{}{
+		"type":            "queue_cancel_result",
+		"agent_id":        a.agentID,
+		"cancelled":       cancelled,
+		"already_started": running,
+		"not_found":       notFound,
+		"timestamp":       time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// approvalClass is the approval class of a request, taken from the catalog
+// rather than the message so a sender cannot pick an unheld class
+func approvalClass(msgType string) string {
+	if spec, ok := catalogSpec(msgType); ok && spec.Approval != "" {
+		return spec.Approval
+	}
+	return msgType
+}
+
+// approvalRole reports whether a command class is held for approval, and which
+// operator role may approve it ("" or "*" means any role). Configured as
+// "approval_required": {"command": "admin", "script": "*"}; once configured,
+// classes it does not list are held for any role.
+func (a *NOPAgent) approvalRole(class string) (string, bool) {
+	required, ok := a.config["approval_required"].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	val, ok := required[class]
+	if !ok {
+		val = required["*"]
+	}
+	role, _ := val.(string)
+	return role, true
+}
+
+// handleCommandApproval releases or drops a held command. Approvals are
+// signed, so operator and role come from the verified payload; without a C2
+// signing key nothing vouches for them and approvals are refused.
+func (a *NOPAgent) handleCommandApproval(msg map[string]interface{}, approve bool) {
+	id, _ := msg["command_id"].(string)
+	operator, _ := msg["operator"].(string)
+	role, _ := msg["role"].(string)
+
+	result := map[string]interface{}{
+		"type":       "command_approval_result",
+		"agent_id":   a.agentID,
+		"command_id": id,
+		"approved":   false,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	a.jobMutex.Lock()
+	var target *Job
+	for _, job := range a.jobs {
+		if job.ID == id && job.Status == "pending_approval" {
+			target = job
+			break
+		}
+	}
+
+	var approvalErr *AgentError
+	distinct, _ := a.config["approval_distinct_operator"].(bool)
+	if operator == "" {
+		approvalErr = newAgentError(ErrInvalidRequest, "approver_unknown", "approval decisions must name the operator")
+	} else if approve && a.commandKey == nil {
+		approvalErr = newAgentError(ErrPermission, "approval_unsigned", "approvals need a C2 signing key to verify the approver")
+	} else if target == nil {
+		approvalErr = newAgentError(ErrNotFound, "no_pending_command", "no command pending approval with id %q", id)
+	} else if requiredRole, _ := a.approvalRole(target.Class); approve && requiredRole != "" && requiredRole != "*" && role != requiredRole {
+		approvalErr = newAgentError(ErrPermission, "approver_role_mismatch", "approval for class %q requires role %q", target.Class, requiredRole)
+	} else if approve && distinct && target.Operator == "" {
+		approvalErr = newAgentError(ErrPermission, "issuer_unknown", "command %q names no issuing operator to tell the approver from", id)
+	} else if approve && distinct && operator == target.Operator {
+		approvalErr = newAgentError(ErrPermission, "approver_is_issuer", "approver must differ from the issuing operator")
+	} else if approve {
+		target.Status = "queued"
+		result["approved"] = true
+	} else {
+		target.Status = "rejected"
+		target.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		a.pruneJobs()
+		result["rejected"] = true
+	}
+	a.jobMutex.Unlock()
+
+	if approvalErr != nil {
+		result["error"] = approvalErr
+		log.Printf("[%s] Approval for command %s refused: %v", time.Now().Format(time.RFC3339), id, approvalErr)
+	} else if approve {
+		log.Printf("[%s] Command %s approved by %s", time.Now().Format(time.RFC3339), id, operator)
+		a.wakeJobs()
+	} else {
+		log.Printf("[%s] Command %s rejected by %s", time.Now().Format(time.RFC3339), id, operator)
+	}
+
+	a.relayToC2(result)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,557 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"github.com/goranjovic55/NOP/nopagent/modules"
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// writeJSON sends one message over the active transport, as a binary frame
+// when a binary codec was negotiated and the transport supports it. Once the
+// C2 has agreed to encryption every message is sealed first, so heartbeats,
+// results and the key exchange never cross the wire in cleartext.
+func (a *NOPAgent) writeJSON(v interface{}) error {
+	// Envelopes were checked and recorded in plaintext by sealEnvelope
+	if m, ok := v.(map[string]interface{}); !ok || m["encrypted"] != true {
+		if a.sealing() {
+			return a.sendEncrypted(v)
+		}
+		if !a.outboundAllowed(v) {
+			return errBlockedByPolicy
+		}
+		v = a.tagConsent(v)
+		a.record("out", v)
+	}
+	a.throttle(v)
+	a.connMutex.Lock()
+	defer a.connMutex.Unlock()
+	if a.transport == nil {
+		return fmt.Errorf("not connected")
+	}
+	// A stalled peer must not hold connMutex forever
+	if d, ok := a.transport.(transport.Deadliner); ok {
+		if wt := a.timeout("write_timeout", 10*time.Second); wt > 0 {
+			d.SetWriteDeadline(time.Now().Add(wt))
+		}
+	}
+	if codec := a.activeCodec(); codec.Name() != "json" {
+		if bt, ok := a.transport.(transport.BinarySender); ok {
+			data, err := codec.Marshal(v)
+			if err != nil {
+				return err
+			}
+			return bt.SendBinary(data)
+		}
+	}
+	return a.transport.Send(v)
+}
+
+func (a *NOPAgent) readJSON(v *map[string]interface{}) error {
+	if a.transport == nil {
+		return fmt.Errorf("not connected")
+	}
+	if d, ok := a.transport.(transport.Deadliner); ok {
+		if rt := a.timeout("read_timeout", 0); rt > 0 {
+			d.SetReadDeadline(time.Now().Add(rt))
+		}
+	}
+	return a.transport.Receive(v)
+}
+
+func (a *NOPAgent) closeConn() {
+	a.connMutex.Lock()
+	defer a.connMutex.Unlock()
+	if a.transport != nil {
+		a.transport.Close()
+		a.transport = nil
+	}
+	a.setSession(nil)
+}
+
+func (a *NOPAgent) Connect() error {
+	log.Printf("[%s] Connecting to C2 server: %s", time.Now().Format(time.RFC3339), a.serverURL)
+
+	u, err := url.Parse(a.serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %v", err)
+	}
+
+	header := a.connectionHeaders()
+	header["Authorization"] = []string{fmt.Sprintf("Bearer %s", a.bearerToken())}
+
+	// "transport" in the generated config overrides the URL scheme, so the
+	// same endpoint list can be reused for e.g. QUIC on lossy links
+	if scheme, ok := a.config["transport"].(string); ok && scheme != "" {
+		u.Scheme = scheme
+	}
+	if !a.options.allowsTransport(u.Scheme) {
+		return fmt.Errorf("transport %s is not in this build", u.Scheme)
+	}
+
+	newTransport, ok := transport.Schemes[u.Scheme]
+	if !ok {
+		return fmt.Errorf("unsupported transport scheme: %s", u.Scheme)
+	}
+
+	// Egress filtering may block WebSocket upgrades - fall back to HTTPS polling
+	isWebSocket := u.Scheme == "ws" || u.Scheme == "wss"
+	fallback := strings.Replace(u.Scheme, "ws", "http", 1)
+	threshold := 3
+	if val, ok := a.config["http_fallback_after"].(float64); ok && val > 0 {
+		threshold = int(val)
+	}
+	if enabled, ok := a.config["http_fallback"].(bool); isWebSocket && (!ok || enabled) && a.options.allowsTransport(fallback) && a.wsFailures >= threshold {
+		log.Printf("[%s] WebSocket unavailable after %d attempts, falling back to HTTP polling",
+			time.Now().Format(time.RFC3339), a.wsFailures)
+		newTransport = transport.NewHTTP
+		// Give WebSocket another chance on the next reconnect cycle
+		a.wsFailures = 0
+	}
+
+	channel := newTransport(transportHost{a})
+	if err := channel.Dial(u, header); err != nil {
+		if isWebSocket {
+			a.wsFailures++
+		}
+		return fmt.Errorf("connection failed: %v", err)
+	}
+	if transport.IsWebSocket(channel) {
+		a.wsFailures = 0
+	}
+
+	a.connMutex.Lock()
+	a.transport = channel
+	a.connMutex.Unlock()
+	log.Printf("[%s] Connected! Establishing encrypted tunnel...", time.Now().Format(time.RFC3339))
+
+	return nil
+}
+
+// transportHost is the view of the agent handed to its transports
+type transportHost struct {
+	agent *NOPAgent
+}
+
+func (h transportHost) AgentID() string                { return h.agent.agentID }
+func (h transportHost) Config() map[string]interface{} { return h.agent.config }
+func (h transportHost) Codec() transport.Codec         { return h.agent.activeCodec() }
+
+func (h transportHost) Timeout(key string, def time.Duration) time.Duration {
+	return h.agent.timeout(key, def)
+}
+
+func (h transportHost) Credentials() transport.Credentials {
+	id := h.agent.identity
+	return transport.Credentials{ClientCert: id.ClientCert, ClientKey: id.ClientKey, ServerCA: id.ServerCA}
+}
+
+func (h transportHost) Seal(plaintext []byte) ([]byte, error) { return h.agent.seal(plaintext) }
+func (h transportHost) Open(data []byte) ([]byte, error)      { return h.agent.open(data) }
+
+func (h transportHost) SealEnvelope(message interface{}) (map[string]interface{}, error) {
+	return h.agent.sealEnvelope(message)
+}
+
+// connectionHeaders builds the headers sent when dialing the C2
+func (a *NOPAgent) connectionHeaders() http.Header {
+	header := make(http.Header)
+	for k, v := range a.identity.HTTPHeaders {
+		header.Set(k, v)
+	}
+	if custom, ok := a.config["http_headers"].(map[string]interface{}); ok {
+		for k, v := range custom {
+			if s, ok := v.(string); ok {
+				header.Set(k, s)
+			}
+		}
+	}
+
+	userAgent := a.identity.UserAgent
+	if strings.HasPrefix(userAgent, "{{") {
+		userAgent = ""
+	}
+	if ua, ok := a.config["user_agent"].(string); ok && ua != "" {
+		userAgent = ua
+	}
+	if userAgent != "" {
+		header.Set("User-Agent", userAgent)
+	}
+	if origin, ok := a.config["ws_origin"].(string); ok && origin != "" {
+		header.Set("Origin", origin)
+	}
+	return header
+}
+
+func (a *NOPAgent) recordEndpointSuccess() {
+	if len(a.endpoints) == 0 {
+		return
+	}
+	ep := a.endpoints[a.endpointIndex]
+	ep.Failures = 0
+	ep.LastSuccess = time.Now()
+}
+
+// recordEndpointFailure rotates to the next C2 endpoint after "failover_after"
+// consecutive failures (default 4, leaving room for the HTTP fallback attempt)
+func (a *NOPAgent) recordEndpointFailure() {
+	if len(a.endpoints) == 0 {
+		return
+	}
+	ep := a.endpoints[a.endpointIndex]
+	ep.Failures++
+	ep.LastFailure = time.Now()
+
+	threshold := 4
+	if val, ok := a.config["failover_after"].(float64); ok && val > 0 {
+		threshold = int(val)
+	}
+	if len(a.endpoints) < 2 || ep.Failures < threshold {
+		return
+	}
+
+	ep.Failures = 0
+	a.endpointIndex = (a.endpointIndex + 1) % len(a.endpoints)
+	a.serverURL = a.endpoints[a.endpointIndex].URL
+	a.wsFailures = 0
+	log.Printf("[%s] C2 endpoint %s failed %d times, failing over to %s", time.Now().Format(time.RFC3339),
+		ep.URL, threshold, a.serverURL)
+}
+
+func (a *NOPAgent) Register() error {
+	hostname, _ := os.Hostname()
+
+	primaryIP := modules.PrimaryAddress(a.serverURL)
+	var ipv4, ipv6 string
+	for _, ip := range modules.GlobalAddresses() {
+		if ip.To4() != nil && ipv4 == "" {
+			ipv4 = ip.String()
+		} else if ip.To4() == nil && ipv6 == "" {
+			ipv6 = ip.String()
+		}
+	}
+
+	reg := protocol.Message{
+		Type:      "register",
+		AgentID:   a.agentID,
+		AgentName: a.agentName,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Data: map[string]interface{}{
+			"capabilities": a.capabilities,
+			"compression":  a.supportedCompression(),
+			"codecs":       a.supportedCodecs(),
+			"ciphers":      a.supportedCiphers(),
+			// See protocol.KeyScheduleDirectional
+			"key_schedules": a.supportedKeySchedules(),
+			// Every frame is sealed from this one on; the C2 confirms with
+			// "encryption": true
+			"encryption": a.cipher != nil,
+			// Counters in the AAD, once the C2 answers "replay_protection": true
+			"replay_protection": a.replayOffered(),
+			// An X25519 exchange once the C2 answers "session_keys": true
+			"session_keys": a.sessionKeysOffered(),
+			// Lets the C2 derive the same master key
+			"kdf":         a.kdf,
+			"fingerprint": a.fingerprint,
+			"acks":        true,
+			// Release of the nopagent module this binary was built from
+			"agent_version": Version,
+			"build":         a.options.summary(),
+			// The C2 must wrap the catalog entries marked Signed in a
+			// signed payload for this agent
+			"signed_commands": a.commandKey != nil,
+			// Commands and paths this build refuses, null if unrestricted
+			"command_policy": a.cmdPolicy.summary(),
+			// Restricted to FIPS approved algorithms, and the validated
+			// module providing them if any
+			"fips":        crypto.FIPS,
+			"fips_module": crypto.FIPSModule(),
+			// Platform keystore holding issued tokens, empty if none
+			"keystore": a.keystoreName(),
+		},
+		SystemInfo: map[string]interface{}{
+			"hostname":   hostname,
+			"platform":   runtime.GOOS,
+			"version":    runtime.Version(),
+			"ip_address": primaryIP,
+			"ipv4":       ipv4,
+			"ipv6":       ipv6,
+			"arch":       runtime.GOARCH,
+			// Operators read network visibility differently for VMs and containers
+			"virtualization": modules.DetectVirtualization(),
+			// Lets fleet updaters pick the right artifact for ARM and musl hosts
+			"platform_detail": modules.PlatformDetail(),
+		},
+	}
+
+	if a.visibleMode() {
+		data := reg.Data.(map[string]interface{})
+		data["monitoring"] = consentTag
+		data["visible_mode"] = true
+		data["status_page"] = a.statusPageAddr()
+	}
+
+	if a.sim != nil {
+		a.sim.overlaySystemInfo(reg.SystemInfo)
+		reg.Data.(map[string]interface{})["simulated"] = true
+	}
+
+	// State copied from another host, e.g. a cloned VM: let the C2 resolve it
+	if a.previousPrint != "" {
+		data := reg.Data.(map[string]interface{})
+		data["fingerprint_changed"] = true
+		data["previous_fingerprint"] = a.previousPrint
+	}
+
+	// Compression, binary codecs, the cipher suite and the key schedule stay
+	// at their defaults until the C2 picks them in "registered". A build with
+	// an encryption key seals the registration itself, so the auth token and
+	// host details never cross the wire in cleartext, and there is no
+	// cleartext mode to fall back to afterwards.
+	a.compression = ""
+	a.codec = nil
+	a.useCipherSuite(crypto.DefaultSuite, protocol.KeyScheduleShared)
+	var frame interface{} = reg
+	if a.cipher != nil {
+		a.enableSealing()
+		envelope, err := a.sealEnvelope(reg)
+		if err != nil {
+			return fmt.Errorf("registration failed: %v", err)
+		}
+		// The C2 needs the agent ID to find the key that opens the frame,
+		// and to know whether it starts with a counter: once agreed,
+		// counters frame every message, the registration included
+		envelope["agent_id"] = a.agentID
+		if a.replayChecking() {
+			envelope["replay_protection"] = true
+		}
+		frame = envelope
+	}
+	err := a.writeJSON(frame)
+	if err != nil {
+		return fmt.Errorf("registration failed: %v", err)
+	}
+
+	log.Printf("[%s] Registered with C2 server", time.Now().Format(time.RFC3339))
+	return nil
+}
+
+func (a *NOPAgent) Heartbeat() {
+	timer := time.NewTimer(a.heartbeatInterval())
+	defer timer.Stop()
+
+	for a.running {
+		select {
+		case <-timer.C:
+			timer.Reset(a.heartbeatInterval())
+			ackTimeout, lossThreshold := a.linkSettings()
+			a.link.expire(time.Now(), ackTimeout)
+			hb := protocol.Message{
+				Type:      "heartbeat",
+				AgentID:   a.agentID,
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				Data: map[string]interface{}{
+					"heartbeat_id": a.link.sent(time.Now()),
+					"link":         a.link.summary(),
+				},
+			}
+			err := a.writeJSON(hb)
+			if err != nil {
+				log.Printf("[%s] Heartbeat error: %v", time.Now().Format(time.RFC3339), err)
+				return
+			}
+			a.checkLink(lossThreshold)
+		}
+	}
+}
+
+func (a *NOPAgent) MessageHandler() {
+	for a.running {
+		var msg map[string]interface{}
+		err := a.readJSON(&msg)
+		var frameErr *transport.MalformedError
+		if errors.As(err, &frameErr) {
+			a.rejectMessage(nil, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("[%s] Read error: %v", time.Now().Format(time.RFC3339), err)
+			return
+		}
+
+		if encrypted, _ := msg["encrypted"].(bool); encrypted {
+			msg, err = a.openEnvelope(msg)
+			if err != nil {
+				log.Printf("[%s] Dropping undecryptable message: %v", time.Now().Format(time.RFC3339), err)
+				a.rejectMessage(nil, err)
+				continue
+			}
+		} else if a.sealing() {
+			// Sealing is never turned off, so a cleartext frame did not come
+			// from the C2
+			log.Printf("[%s] Dropping cleartext message on an encrypted connection", time.Now().Format(time.RFC3339))
+			a.rejectMessage(nil, errCleartextFrame)
+			continue
+		}
+
+		a.record("in", msg)
+		a.dispatchMessage(msg)
+	}
+}
+
+// heartbeatInterval returns the delay until the next heartbeat. The optional
+// "heartbeat" config block adds "jitter" (fraction of the interval, e.g. 0.2
+// for +/-20%) and an "adaptive" mode that uses "active_interval" while
+// commands are queued or running and "idle_interval" once no command has
+// arrived for "idle_after" seconds.
+func (a *NOPAgent) heartbeatInterval() time.Duration {
+	interval := 30 * time.Second
+	if val, ok := a.config["heartbeat_interval"]; ok {
+		if i, ok := val.(float64); ok {
+			interval = time.Duration(i) * time.Second
+		}
+	}
+
+	policy, _ := a.config["heartbeat"].(map[string]interface{})
+	seconds := func(key string) time.Duration {
+		if val, ok := policy[key].(float64); ok && val > 0 {
+			return time.Duration(val * float64(time.Second))
+		}
+		return 0
+	}
+
+	if adaptive, _ := policy["adaptive"].(bool); adaptive {
+		busy := a.activeJobs() > 0
+		a.jobMutex.Lock()
+		lastTask := a.lastTaskAt
+		a.jobMutex.Unlock()
+
+		idleAfter := seconds("idle_after")
+		if idleAfter == 0 {
+			idleAfter = 5 * time.Minute
+		}
+		if active := seconds("active_interval"); busy && active > 0 {
+			interval = active
+		} else if idle := seconds("idle_interval"); !busy && idle > 0 && time.Since(lastTask) > idleAfter {
+			interval = idle
+		}
+	}
+
+	if jitter, ok := policy["jitter"].(float64); ok && jitter > 0 && jitter < 1 {
+		offset := (mathrand.Float64()*2 - 1) * jitter * float64(interval)
+		interval += time.Duration(offset)
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+func (a *NOPAgent) sendPong() {
+	pong := protocol.Message{
+		Type:      "pong",
+		AgentID:   a.agentID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	a.writeJSON(pong)
+}
+
+func (a *NOPAgent) relayToC2(data interface{}) {
+	if !a.outboundAllowed(data) || !a.sampled(protocol.Type(data)) {
+		return
+	}
+	data = a.tagConsent(data)
+	a.writeSinks(data)
+	if a.audit != nil {
+		a.audit.add(data)
+		return
+	}
+	spoolable := telemetryTypes[protocol.Type(data)] || scheduledResult(data)
+	if a.recordAutonomous(data) {
+		if spoolable {
+			a.spool(data)
+		}
+		return
+	}
+	// Queue behind spooled reports until the replay has drained them
+	if spoolable && a.spoolBacklog() {
+		a.spool(data)
+		return
+	}
+	if !a.admitTelemetry(data) {
+		return
+	}
+
+	if err := a.deliver(data); err != nil {
+		if spoolable {
+			a.spool(data)
+			return
+		}
+		log.Printf("[%s] Relay error: %v", time.Now().Format(time.RFC3339), err)
+	}
+}
+
+// deliver sends a report, numbering it for acknowledgement when the C2 has
+// enabled acks
+func (a *NOPAgent) deliver(data interface{}) error {
+	seq, data := a.sequence(data)
+	if err := a.transmit(data); err != nil {
+		return err
+	}
+	if seq > 0 {
+		a.trackUnacked(seq, data)
+	}
+	return nil
+}
+
+func (a *NOPAgent) transmit(data interface{}) error {
+	return a.sendEncrypted(data)
+}
+
+// sampled applies the per-type "sampling" config (e.g. {"conn_event": 10}
+// sends 1 in 10 conn_events). Types without a rate are always sent, and the
+// rates can be changed at runtime through settings_update.
+func (a *NOPAgent) sampled(msgType string) bool {
+	sampling, ok := a.config["sampling"].(map[string]interface{})
+	if !ok {
+		return true
+	}
+	rate, ok := sampling[msgType].(float64)
+	if !ok || rate <= 1 {
+		return true
+	}
+
+	a.sampleMutex.Lock()
+	defer a.sampleMutex.Unlock()
+	count := a.sampleCounters[msgType]
+	a.sampleCounters[msgType] = count + 1
+	return count%uint64(rate) == 0
+}
+
+// openStream opens a dedicated stream when the transport supports it
+func (a *NOPAgent) openStream(kind string, meta map[string]interface{}) (net.Conn, bool) {
+	a.connMutex.Lock()
+	st, ok := a.transport.(transport.StreamOpener)
+	a.connMutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+	stream, err := st.OpenStream(kind, meta)
+	if err != nil {
+		log.Printf("[%s] Could not open %s stream, using control channel: %v", time.Now().Format(time.RFC3339), kind, err)
+		return nil, false
+	}
+	return stream, true
+}
@@ -0,0 +1,275 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+)
+
+// fakeTransport plays back inbound frames and keeps what the agent sends,
+// both as they look after a trip through JSON
+type fakeTransport struct {
+	mutex  sync.Mutex
+	in     []map[string]interface{}
+	sent   []map[string]interface{}
+	closed bool
+}
+
+func jsonFrame(t *testing.T, v interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame map[string]interface{}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func (f *fakeTransport) Dial(u *url.URL, header http.Header) error { return nil }
+
+func (f *fakeTransport) Send(v interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var frame map[string]interface{}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return err
+	}
+	f.sent = append(f.sent, frame)
+	return nil
+}
+
+func (f *fakeTransport) Receive(v *map[string]interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.in) == 0 {
+		return io.EOF
+	}
+	*v, f.in = f.in[0], f.in[1:]
+	return nil
+}
+
+func (f *fakeTransport) Close() error {
+	f.mutex.Lock()
+	f.closed = true
+	f.mutex.Unlock()
+	return nil
+}
+
+// connectTestAgent attaches a fake transport to a test agent
+func connectTestAgent(t *testing.T) (*NOPAgent, *fakeTransport) {
+	t.Helper()
+	a := newTestAgent(t)
+	a.authToken = "secret-auth-token"
+	conn := &fakeTransport{}
+	a.transport = conn
+	a.running = true
+	return a, conn
+}
+
+// sealFor seals msg the way the C2 does for a
+func sealFor(t *testing.T, a *NOPAgent, msg map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	envelope, err := a.sealEnvelope(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jsonFrame(t, envelope)
+}
+
+// sealCounted seals msg the way the C2 does once counters are agreed
+func sealCounted(t *testing.T, a *NOPAgent, counter uint64, msg map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := binary.BigEndian.AppendUint64(nil, counter)
+	key, _ := a.wireKeys()
+	sealed, err := key.Seal(payload, append([]byte(crypto.AADC2), header...), counter, a.messageKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jsonFrame(t, map[string]interface{}{"encrypted": true, "data": append(header, sealed...)})
+}
+
+func TestSealedRegistration(t *testing.T) {
+	a, conn := connectTestAgent(t)
+	if err := a.Register(); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if len(conn.sent) != 1 {
+		t.Fatalf("sent %d frames, want 1", len(conn.sent))
+	}
+	frame := conn.sent[0]
+	if frame["encrypted"] != true || frame["agent_id"] != "test-agent" {
+		t.Fatalf("registration frame = %v, want an envelope naming the agent", frame)
+	}
+	wire, _ := json.Marshal(frame)
+	for _, secret := range []string{"register", "secret-auth-token", "capabilities"} {
+		if strings.Contains(string(wire), secret) {
+			t.Errorf("registration leaks %q in cleartext", secret)
+		}
+	}
+	reg, err := a.openEnvelope(frame)
+	if err != nil || reg["type"] != "register" {
+		t.Errorf("registration opens to %v, %v", reg, err)
+	}
+}
+
+func TestSealedFraming(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered map[string]interface{}
+		sealed     bool
+		wantClosed bool
+	}{
+		{name: "confirmed", registered: map[string]interface{}{"type": "registered", "encryption": true}, sealed: true},
+		{name: "not confirmed", registered: map[string]interface{}{"type": "registered"}, sealed: true, wantClosed: true},
+		{name: "downgrade", registered: map[string]interface{}{"type": "registered", "encryption": false}, sealed: true, wantClosed: true},
+		// Dropped before dispatch, so it cannot close the connection either
+		{name: "cleartext", registered: map[string]interface{}{"type": "registered", "encryption": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, conn := connectTestAgent(t)
+			if err := a.Register(); err != nil {
+				t.Fatal(err)
+			}
+			frame := tt.registered
+			if tt.sealed {
+				frame = sealFor(t, a, tt.registered)
+			}
+			conn.in = append(conn.in, frame)
+			a.MessageHandler()
+
+			if conn.closed != tt.wantClosed {
+				t.Errorf("connection closed = %v, want %v", conn.closed, tt.wantClosed)
+			}
+			if !a.sealing() {
+				t.Error("sealing was turned off")
+			}
+			if !tt.sealed {
+				if report := lastReport(t, a); report["type"] != "error" {
+					t.Errorf("cleartext frame answered with %v, want an error", report["type"])
+				}
+			}
+		})
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	a, conn := connectTestAgent(t)
+	if err := a.Register(); err != nil {
+		t.Fatal(err)
+	}
+	conn.in = append(conn.in, sealFor(t, a, map[string]interface{}{"type": "registered", "encryption": true, "replay_protection": true}))
+	a.MessageHandler()
+	if !a.replayChecking() {
+		t.Fatal("replay protection not enabled")
+	}
+
+	// pongs counts the frames the agent answered since the last call
+	answered := len(conn.sent)
+	pongs := func(conn *fakeTransport) int {
+		n := len(conn.sent) - answered
+		answered = len(conn.sent)
+		return n
+	}
+	ping := map[string]interface{}{"type": "ping"}
+	captured := sealCounted(t, a, 1000, ping)
+	conn.in = append(conn.in, captured, captured, sealFor(t, a, ping), sealCounted(t, a, 1001, ping))
+	a.MessageHandler()
+	if n := pongs(conn); n != 2 {
+		t.Errorf("answered %d pings, want the first and the last", n)
+	}
+
+	// A restart resumes the check and the window from the sealed state
+	restarted := NewNOPAgent(a.identity)
+	restarted.audit = &auditReport{}
+	conn = &fakeTransport{}
+	restarted.transport, restarted.running = conn, true
+	answered = 0
+	if err := restarted.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.replayChecking() || conn.sent[0]["replay_protection"] != true {
+		t.Errorf("restarted agent registered without counters (replay_protection %v)", conn.sent[0]["replay_protection"])
+	}
+	pongs(conn)
+
+	registered := map[string]interface{}{"type": "registered", "encryption": true, "replay_protection": true}
+	conn.in = append(conn.in,
+		// Frames without counters, even the C2's own, no longer open
+		sealFor(t, restarted, registered),
+		sealCounted(t, restarted, 1002, registered),
+		captured,
+		sealCounted(t, restarted, 1001, ping),
+		sealCounted(t, restarted, 1003, ping))
+	restarted.MessageHandler()
+	if n := pongs(conn); n != 1 {
+		t.Errorf("answered %d pings after a restart, want only the new one", n)
+	}
+	if conn.closed {
+		t.Error("connection closed")
+	}
+
+	// Once agreed, a registered without counters is a downgrade
+	conn.in = append(conn.in, sealCounted(t, restarted, 1004, map[string]interface{}{"type": "registered", "encryption": true}))
+	restarted.MessageHandler()
+	if !conn.closed || !restarted.replayChecking() {
+		t.Errorf("downgrade accepted: closed %v, replay check %v", conn.closed, restarted.replayChecking())
+	}
+}
+
+func TestSendCounterResumes(t *testing.T) {
+	a := newTestAgent(t)
+	a.enableReplayCheck()
+	if _, err := a.seal([]byte("first run")); err != nil {
+		t.Fatal(err)
+	}
+	sent := atomic.LoadUint64(&a.sendCounter)
+
+	// A restart starts past the reservation, not at the clock
+	reserved := uint64(time.Now().Add(24 * time.Hour).UnixNano())
+	a.replayMutex.Lock()
+	atomic.StoreUint64(&a.sendReserved, reserved)
+	a.writeReplayState()
+	a.replayMutex.Unlock()
+
+	restarted := NewNOPAgent(a.identity)
+	if restarted.sendCounter < reserved || restarted.sendCounter <= sent {
+		t.Errorf("restarted at counter %d, want at least the reserved %d", restarted.sendCounter, reserved)
+	}
+	if restarted.sendReserved <= restarted.sendCounter {
+		t.Errorf("restarted agent reserved nothing beyond %d", restarted.sendCounter)
+	}
+
+	// Crossing the reservation persists the next block before the counter is sent
+	atomic.StoreUint64(&restarted.sendCounter, restarted.sendReserved-1)
+	if _, err := restarted.seal([]byte("crossing")); err != nil {
+		t.Fatal(err)
+	}
+	var stored replayState
+	if err := restarted.readState(restarted.replayPath(), "replay", &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.SendReserved <= restarted.sendCounter {
+		t.Errorf("persisted reservation %d does not cover sent counter %d", stored.SendReserved, restarted.sendCounter)
+	}
+}
@@ -0,0 +1,484 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dbProbes fingerprint database services from their pre-authentication
+// handshake; none of them sends credentials
+var dbProbes = map[string]struct {
+	port  int
+	probe func(conn net.Conn, address string) (map[string]interface{}, error)
+}{
+	"mysql":    {3306, probeMySQL},
+	"postgres": {5432, probePostgres},
+	"mssql":    {1433, probeMSSQL},
+	"redis":    {6379, probeRedis},
+	"mongodb":  {27017, probeMongoDB},
+}
+
+// handleDBProbe probes the requested database services on a host and tags
+// the cached asset with the roles and versions found
+func (a *NOPAgent) handleDBProbe(msg map[string]interface{}) {
+	host, _ := msg["host"].(string)
+	if host == "" {
+		a.sendError("db_probe", msg, newAgentError(ErrInvalidRequest, "missing_host", "host is required"))
+		return
+	}
+	services := make([]string, 0)
+	if list, ok := msg["services"].([]interface{}); ok {
+		for _, s := range list {
+			if name, ok := s.(string); ok {
+				services = append(services, name)
+			}
+		}
+	}
+	if len(services) == 0 {
+		for name := range dbProbes {
+			services = append(services, name)
+		}
+		sort.Strings(services)
+	}
+	ports, _ := msg["ports"].(map[string]interface{})
+
+	results := make(map[string]interface{})
+	roles := make([]string, 0)
+	for _, name := range services {
+		spec, ok := dbProbes[name]
+		if !ok {
+			results[name] = map[string]interface{}{"error": "unsupported service"}
+			continue
+		}
+		port := spec.port
+		if val, ok := ports[name].(float64); ok && val > 0 {
+			port = int(val)
+		}
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+
+		result := map[string]interface{}{"port": port}
+		if conn, err := net.DialTimeout("tcp", address, 5*time.Second); err != nil {
+			result["reachable"] = false
+		} else {
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			details, err := spec.probe(conn, address)
+			conn.Close()
+			result["reachable"] = true
+			for k, v := range details {
+				result[k] = v
+			}
+			if err != nil {
+				result["error"] = err.Error()
+			} else {
+				roles = append(roles, name)
+			}
+		}
+		results[name] = result
+	}
+
+	if net.ParseIP(host) != nil && len(roles) > 0 {
+		asset := map[string]interface{}{"ip": host, "db_roles": roles}
+		for _, name := range roles {
+			asset["db_"+name] = results[name]
+		}
+		a.cacheAssets([]map[string]interface{}{asset})
+	}
+
+	a.writeJSON(map[string]interface{}{
+		"type":       "db_probe_result",
+		"agent_id":   a.agentID,
+		"request_id": msg["request_id"],
+		"host":       host,
+		"roles":      roles,
+		"services":   results,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// probeMySQL parses the initial handshake packet the server sends on connect
+func probeMySQL(conn net.Conn, address string) (map[string]interface{}, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if size > 1<<16 {
+		return nil, fmt.Errorf("unexpected mysql packet size %d", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(conn, packet); err != nil {
+		return nil, err
+	}
+	if len(packet) > 3 && packet[0] == 0xff {
+		// e.g. "Host is not allowed to connect"
+		return map[string]interface{}{"error_message": string(packet[3:])}, nil
+	}
+	if len(packet) < 2 || packet[0] != 10 {
+		return nil, fmt.Errorf("not a mysql handshake")
+	}
+
+	result := map[string]interface{}{"protocol_version": int(packet[0])}
+	rest := packet[1:]
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return nil, fmt.Errorf("truncated mysql handshake")
+	}
+	result["version"] = string(rest[:end])
+	rest = rest[end+1:]
+	// connection id (4), auth data part 1 (8), filler (1)
+	if len(rest) >= 15 {
+		capabilities := uint32(binary.LittleEndian.Uint16(rest[13:15]))
+		if len(rest) >= 20 {
+			capabilities |= uint32(binary.LittleEndian.Uint16(rest[18:20])) << 16
+		}
+		result["ssl"] = capabilities&0x800 != 0
+		// charset (1), status (2), upper caps (2), auth data length (1), reserved (10), auth data part 2
+		if len(rest) > 31 {
+			tail := rest[31:]
+			if i := bytes.IndexByte(tail, 0); i >= 0 && i+1 < len(tail) {
+				plugin := tail[i+1:]
+				if j := bytes.IndexByte(plugin, 0); j >= 0 {
+					plugin = plugin[:j]
+				}
+				result["auth_plugin"] = string(plugin)
+			}
+		}
+	}
+	result["auth_required"] = true
+	return result, nil
+}
+
+// probePostgres checks SSL support, then sends a startup message for a
+// non-existent role to learn which authentication method the server demands
+func probePostgres(conn net.Conn, address string) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	sslRequest := []byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}
+	if _, err := conn.Write(sslRequest); err != nil {
+		return nil, err
+	}
+	var answer [1]byte
+	if _, err := io.ReadFull(conn, answer[:]); err != nil {
+		return nil, err
+	}
+	if answer[0] != 'S' && answer[0] != 'N' {
+		return nil, fmt.Errorf("not a postgres server")
+	}
+	result["ssl"] = answer[0] == 'S'
+
+	// The SSL negotiation consumed the connection; start over in plaintext
+	plain, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return result, nil
+	}
+	defer plain.Close()
+	plain.SetDeadline(time.Now().Add(10 * time.Second))
+
+	params := []byte("user\x00nop_probe\x00database\x00postgres\x00\x00")
+	startup := make([]byte, 8+len(params))
+	binary.BigEndian.PutUint32(startup[0:4], uint32(len(startup)))
+	binary.BigEndian.PutUint32(startup[4:8], 196608) // protocol 3.0
+	copy(startup[8:], params)
+	if _, err := plain.Write(startup); err != nil {
+		return result, nil
+	}
+
+	var header [5]byte
+	if _, err := io.ReadFull(plain, header[:]); err != nil {
+		return result, nil
+	}
+	size := int(binary.BigEndian.Uint32(header[1:5])) - 4
+	if size < 0 || size > 1<<16 {
+		return result, nil
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(plain, body); err != nil {
+		return result, nil
+	}
+
+	switch header[0] {
+	case 'R':
+		methods := map[uint32]string{0: "trust", 2: "kerberos", 3: "password", 5: "md5", 7: "gss", 9: "sspi", 10: "sasl"}
+		if len(body) < 4 {
+			break
+		}
+		code := binary.BigEndian.Uint32(body[0:4])
+		result["auth_method"] = methods[code]
+		result["auth_required"] = code != 0
+		if code == 10 {
+			result["sasl_mechanisms"] = strings.FieldsFunc(string(body[4:]), func(r rune) bool { return r == 0 })
+		}
+	case 'E':
+		// Rejections such as "no pg_hba.conf entry" reveal the access policy
+		for _, field := range bytes.Split(body, []byte{0}) {
+			if len(field) > 1 && field[0] == 'M' {
+				result["error_message"] = string(field[1:])
+			}
+		}
+		result["auth_required"] = true
+	}
+	return result, nil
+}
+
+// probeMSSQL sends a TDS PRELOGIN packet, which the server answers with its
+// version and encryption requirement
+func probeMSSQL(conn net.Conn, address string) (map[string]interface{}, error) {
+	payload := []byte{
+		0x00, 0x00, 0x15, 0x00, 0x06, // VERSION at 21, length 6
+		0x01, 0x00, 0x1b, 0x00, 0x01, // ENCRYPTION at 27, length 1
+		0x02, 0x00, 0x1c, 0x00, 0x01, // INSTOPT at 28, length 1
+		0x03, 0x00, 0x1d, 0x00, 0x04, // THREADID at 29, length 4
+		0xff,
+		0, 0, 0, 0, 0, 0, // version
+		0x00,       // encryption off
+		0x00,       // instance
+		0, 0, 0, 0, // thread id
+	}
+	packet := append([]byte{0x12, 0x01, 0x00, byte(8 + len(payload)), 0, 0, 0, 0}, payload...)
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0x04 {
+		return nil, fmt.Errorf("not a tds response")
+	}
+	size := int(binary.BigEndian.Uint16(header[2:4])) - 8
+	if size <= 0 || size > 4096 {
+		return nil, fmt.Errorf("unexpected tds packet size")
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{"auth_required": true}
+	encryption := map[byte]string{0: "off", 1: "on", 2: "not_supported", 3: "required"}
+	for i := 0; i+5 <= len(body) && body[i] != 0xff; i += 5 {
+		offset := int(binary.BigEndian.Uint16(body[i+1 : i+3]))
+		length := int(binary.BigEndian.Uint16(body[i+3 : i+5]))
+		if offset+length > len(body) {
+			break
+		}
+		value := body[offset : offset+length]
+		switch body[i] {
+		case 0x00:
+			if len(value) >= 4 {
+				result["version"] = fmt.Sprintf("%d.%d.%d", value[0], value[1], binary.BigEndian.Uint16(value[2:4]))
+			}
+		case 0x01:
+			if len(value) >= 1 {
+				result["encryption"] = encryption[value[0]]
+			}
+		}
+	}
+	return result, nil
+}
+
+// probeRedis asks for server info; a NOAUTH error means a password is set
+func probeRedis(conn net.Conn, address string) (map[string]interface{}, error) {
+	if _, err := conn.Write([]byte("INFO server\r\n")); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSpace(line)
+
+	result := map[string]interface{}{}
+	switch {
+	case strings.HasPrefix(line, "-NOAUTH"), strings.HasPrefix(line, "-ERR operation not permitted"):
+		result["auth_required"] = true
+	case strings.HasPrefix(line, "-DENIED"):
+		// Protected mode: no password, but only loopback clients allowed
+		result["auth_required"] = false
+		result["protected_mode"] = true
+	case strings.HasPrefix(line, "$"):
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > 1<<16 {
+			return nil, fmt.Errorf("unexpected redis reply")
+		}
+		info := make([]byte, size)
+		if _, err := io.ReadFull(reader, info); err != nil {
+			return nil, err
+		}
+		result["auth_required"] = false
+		for _, field := range strings.Split(string(info), "\r\n") {
+			key, value, ok := strings.Cut(field, ":")
+			if ok && (key == "redis_version" || key == "redis_mode" || key == "os") {
+				result[strings.TrimPrefix(key, "redis_")] = value
+			}
+		}
+	default:
+		return nil, fmt.Errorf("not a redis server")
+	}
+	return result, nil
+}
+
+// probeMongoDB runs hello and buildInfo, which servers answer without
+// authentication, then listDatabases only to see whether it is refused
+func probeMongoDB(conn net.Conn, address string) (map[string]interface{}, error) {
+	hello, err := mongoCommand(conn, 1, "hello")
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	for _, key := range []string{"maxWireVersion", "isWritablePrimary", "setName", "msg"} {
+		if v, ok := hello[key]; ok {
+			result[key] = v
+		}
+	}
+	if build, err := mongoCommand(conn, 2, "buildInfo"); err == nil {
+		result["version"] = build["version"]
+	}
+	if list, err := mongoCommand(conn, 3, "listDatabases"); err == nil {
+		ok, _ := list["ok"].(float64)
+		result["auth_required"] = ok != 1
+	}
+	return result, nil
+}
+
+// mongoCommand sends {<name>: 1, $db: "admin"} as an OP_MSG and returns the
+// scalar fields of the reply
+func mongoCommand(conn net.Conn, requestID int32, name string) (map[string]interface{}, error) {
+	doc := bsonDocument(name, "admin")
+	message := make([]byte, 21+len(doc))
+	binary.LittleEndian.PutUint32(message[0:4], uint32(len(message)))
+	binary.LittleEndian.PutUint32(message[4:8], uint32(requestID))
+	binary.LittleEndian.PutUint32(message[12:16], 2013) // OP_MSG
+	// flag bits 0, section kind 0
+	copy(message[21:], doc)
+	if _, err := conn.Write(message); err != nil {
+		return nil, err
+	}
+
+	var header [16]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint32(header[0:4])) - 16
+	if size < 5 || size > 1<<20 || binary.LittleEndian.Uint32(header[12:16]) != 2013 {
+		return nil, fmt.Errorf("not a mongodb reply")
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	return bsonScalars(body[5:])
+}
+
+// bsonDocument encodes {<command>: int32(1), "$db": db}
+func bsonDocument(command, db string) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0})
+	buf.WriteByte(0x10)
+	buf.WriteString(command)
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.LittleEndian, int32(1))
+	buf.WriteByte(0x02)
+	buf.WriteString("$db")
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.LittleEndian, int32(len(db)+1))
+	buf.WriteString(db)
+	buf.WriteByte(0)
+	buf.WriteByte(0)
+	out := buf.Bytes()
+	binary.LittleEndian.PutUint32(out[0:4], uint32(len(out)))
+	return out
+}
+
+// bsonScalars decodes the top-level string, number and bool fields of a BSON
+// document, skipping everything else
+func bsonScalars(doc []byte) (map[string]interface{}, error) {
+	if len(doc) < 5 {
+		return nil, fmt.Errorf("short bson document")
+	}
+	end := int(binary.LittleEndian.Uint32(doc[0:4]))
+	if end > len(doc) {
+		return nil, fmt.Errorf("truncated bson document")
+	}
+	fields := make(map[string]interface{})
+	for i := 4; i < end-1; {
+		kind := doc[i]
+		i++
+		nameEnd := bytes.IndexByte(doc[i:end], 0)
+		if nameEnd < 0 {
+			break
+		}
+		name := string(doc[i : i+nameEnd])
+		i += nameEnd + 1
+
+		var size int
+		switch kind {
+		case 0x01: // double
+			if i+8 > end {
+				return fields, nil
+			}
+			fields[name] = math.Float64frombits(binary.LittleEndian.Uint64(doc[i:]))
+			size = 8
+		case 0x02: // string
+			if i+4 > end {
+				return fields, nil
+			}
+			n := int(binary.LittleEndian.Uint32(doc[i:]))
+			if n < 1 || i+4+n > end {
+				return fields, nil
+			}
+			fields[name] = string(doc[i+4 : i+4+n-1])
+			size = 4 + n
+		case 0x03, 0x04: // document, array
+			if i+4 > end {
+				return fields, nil
+			}
+			size = int(binary.LittleEndian.Uint32(doc[i:]))
+		case 0x05: // binary
+			if i+4 > end {
+				return fields, nil
+			}
+			size = 5 + int(binary.LittleEndian.Uint32(doc[i:]))
+		case 0x07:
+			size = 12
+		case 0x08:
+			if i < end {
+				fields[name] = doc[i] == 1
+			}
+			size = 1
+		case 0x09, 0x11:
+			size = 8
+		case 0x0a:
+			size = 0
+		case 0x10: // int32
+			if i+4 > end {
+				return fields, nil
+			}
+			fields[name] = float64(int32(binary.LittleEndian.Uint32(doc[i:])))
+			size = 4
+		case 0x12: // int64
+			if i+8 > end {
+				return fields, nil
+			}
+			fields[name] = float64(int64(binary.LittleEndian.Uint64(doc[i:])))
+			size = 8
+		default:
+			return fields, nil
+		}
+		if size < 0 {
+			break
+		}
+		i += size
+	}
+	return fields, nil
+}
@@ -0,0 +1,573 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// dispatchMessage routes one decoded C2 message to its handler
+func (a *NOPAgent) dispatchMessage(msg map[string]interface{}) {
+	if verified, ok := a.verifyMessage(msg); ok {
+		a.dispatchVerified(verified)
+	}
+}
+
+// verifyMessage returns the signed payload of a message whose type must be
+// signed, or the message itself. Failures are reported to the C2.
+func (a *NOPAgent) verifyMessage(msg map[string]interface{}) (map[string]interface{}, bool) {
+	msgType, _ := msg["type"].(string)
+	if !a.requiresSignature(msgType) {
+		return msg, true
+	}
+	verified, err := a.verifySigned(msg)
+	if err != nil {
+		log.Printf("[%s] Rejected unsigned %s: %v", time.Now().Format(time.RFC3339), msgType, err)
+		a.sendError(msgType, msg, newAgentError(ErrAuth, "invalid_signature", "%v", err))
+		return nil, false
+	}
+	return verified, true
+}
+
+// dispatchVerified routes a message that passed verifyMessage
+func (a *NOPAgent) dispatchVerified(msg map[string]interface{}) {
+	if err := validateInbound(msg); err != nil {
+		a.rejectMessage(msg, err)
+		return
+	}
+	// A handler bug triggered by odd input must not take the agent down
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[%s] Handler panic: %v\n%s", time.Now().Format(time.RFC3339), r, debug.Stack())
+			a.rejectMessage(msg, fmt.Errorf("handler failed: %v", r))
+		}
+	}()
+	msgType, _ := msg["type"].(string)
+	if a.options.Profile == "passive" && probesNetwork(msgType, msg) {
+		a.sendError(msgType, msg, newAgentError(ErrNotSupported, "passive_profile", "%s probes the network, which this passive build never does", msgType))
+		return
+	}
+
+	switch msgType {
+	case "terminate":
+		log.Printf("[%s] Terminate command received from C2", time.Now().Format(time.RFC3339))
+		if message, ok := msg["message"].(string); ok {
+			log.Printf("[%s] Message: %s", time.Now().Format(time.RFC3339), message)
+		}
+		a.running = false
+		a.stopJobs()
+
+	case "kill":
+		log.Printf("[%s] KILL command received - Self-destructing...", time.Now().Format(time.RFC3339))
+		if message, ok := msg["message"].(string); ok {
+			log.Printf("[%s] Message: %s", time.Now().Format(time.RFC3339), message)
+		}
+		a.running = false
+		a.stopJobs()
+		// Attempt to delete self
+		executable, err := os.Executable()
+		if err == nil {
+			log.Printf("[%s] Deleting agent file: %s", time.Now().Format(time.RFC3339), executable)
+			os.Remove(executable)
+		}
+
+	case "uninstall":
+		log.Printf("[%s] UNINSTALL command received - Removing all agent artifacts...", time.Now().Format(time.RFC3339))
+		a.handleUninstall()
+		a.running = false
+
+	case "broadcast":
+		a.handleBroadcast(msg)
+
+	case "command", "script":
+		a.handleCommand(msg)
+
+	case "collect", "export_assets", "file_get", "file_tail", "hash_files", "http_request", "dns_lookup", "mail_probe", "db_probe", "k8s_probe", "cloud_exposure",
+		"proc_list", "proc_inspect", "proc_kill", "proc_start":
+		a.submitTask(msg)
+
+	case "queue_list":
+		a.handleQueueList()
+
+	case "queue_cancel":
+		a.handleQueueCancel(msg)
+
+	case "job_list":
+		a.handleJobList(msg)
+
+	case "job_status":
+		a.handleJobStatus(msg)
+
+	case "job_cancel":
+		a.handleJobCancel(msg)
+
+	case "schedule_add":
+		a.handleScheduleAdd(msg)
+
+	case "schedule_remove":
+		a.handleScheduleRemove(msg)
+
+	case "schedule_list":
+		a.handleScheduleList()
+
+	case "command_approve":
+		a.handleCommandApproval(msg, true)
+
+	case "command_reject":
+		a.handleCommandApproval(msg, false)
+
+	case "registered":
+		a.handleRegistered(msg)
+
+	case "ack":
+		a.handleAck(msg)
+
+	case "rekey":
+		a.handleRekey(msg)
+
+	case "key_exchange":
+		a.handleKeyExchange(msg)
+
+	case "data_channel_grant":
+		a.handleDataChannelGrant(msg)
+
+	case "welcome", "asset_ack":
+		// Informational acknowledgements from the C2
+
+	case "ping":
+		a.sendPong()
+
+	case "heartbeat_ack":
+		a.handleHeartbeatAck(msg)
+
+	case "settings_update":
+		a.handleSettingsUpdate(msg)
+
+	case "pause_telemetry", "resume_telemetry":
+		a.handleFlowControl(msgType, msg)
+
+	case "introspect":
+		a.handleIntrospect()
+
+	case "oui_update":
+		a.handleOUIUpdate(msg)
+
+	case "site_map_update":
+		a.handleSiteMapUpdate(msg)
+
+	case "import_scan":
+		a.handleImportScan(msg)
+
+	case "identity_conflict":
+		a.handleIdentityConflict(msg)
+
+	case "identity_assigned":
+		a.handleIdentityAssigned(msg)
+
+	case "token_refresh":
+		a.handleTokenRefresh(msg)
+
+	case "update_offer":
+		a.handleUpdateOffer(msg)
+
+	case "proxy_start":
+		a.handleProxyStart(msg)
+
+	case "proxy_stop":
+		a.handleProxyStop(msg)
+
+	case "proxy_list":
+		a.handleProxyList()
+
+	case "file_put":
+		a.handleFilePut(msg)
+
+	case "file_put_chunk":
+		a.handleFilePutChunk(msg)
+
+	case "fs_list":
+		a.handleFSList(msg)
+
+	case "fs_stat":
+		a.handleFSStat(msg)
+
+	case "fs_remove":
+		a.handleFSRemove(msg)
+
+	case "fs_mkdir":
+		a.handleFSMkdir(msg)
+
+	case "fs_move":
+		a.handleFSMove(msg)
+
+	case "shell_open":
+		a.handleShellOpen(msg)
+
+	case "shell_input":
+		a.handleShellInput(msg)
+
+	case "shell_resize":
+		a.handleShellResize(msg)
+
+	case "shell_close":
+		a.handleShellClose(msg)
+
+	default:
+		a.sendError(msgType, msg, newAgentError(ErrNotSupported, "unknown_message_type",
+			"message type %q is not supported by this agent", msgType))
+	}
+}
+
+func (a *NOPAgent) handleRegistered(msg map[string]interface{}) {
+	// Registration was already sealed; a C2 that does not confirm encrypted
+	// framing does not speak this agent's protocol
+	if encryption, _ := msg["encryption"].(bool); !encryption && a.cipher != nil {
+		log.Printf("[%s] C2 did not confirm encrypted framing, disconnecting", time.Now().Format(time.RFC3339))
+		a.closeConn()
+		return
+	}
+	replay, _ := msg["replay_protection"].(bool)
+	if !replay && a.replayChecking() {
+		log.Printf("[%s] C2 dropped replay protection, disconnecting", time.Now().Format(time.RFC3339))
+		a.closeConn()
+		return
+	}
+
+	acks, _ := msg["acks"].(bool)
+	a.ackMutex.Lock()
+	a.acksEnabled = acks
+	a.ackMutex.Unlock()
+
+	dataChannel, _ := msg["data_channel"].(bool)
+	a.grantMutex.Lock()
+	a.dataChannel = dataChannel
+	a.grantMutex.Unlock()
+
+	// The C2 accepted the changed fingerprint without raising a conflict
+	if a.previousPrint != "" {
+		a.previousPrint = ""
+		token, expiry := a.tokenState()
+		a.saveIdentity(agentIdentity{AgentID: a.agentID, ParentID: a.identity.AgentID, AuthToken: token, TokenExpiry: formatExpiry(expiry), Fingerprint: a.fingerprint})
+	}
+
+	algorithm, _ := msg["compression"].(string)
+	for _, offered := range a.supportedCompression() {
+		if offered == algorithm {
+			a.compression = algorithm
+			log.Printf("[%s] Payload compression negotiated: %s", time.Now().Format(time.RFC3339), algorithm)
+			break
+		}
+	}
+
+	name, _ := msg["codec"].(string)
+	for _, offered := range a.supportedCodecs() {
+		if offered == name {
+			a.connMutex.Lock()
+			a.codec = transport.Codecs[name]
+			a.connMutex.Unlock()
+			log.Printf("[%s] Message codec negotiated: %s", time.Now().Format(time.RFC3339), name)
+			break
+		}
+	}
+
+	suite, _ := msg["cipher"].(string)
+	if !slices.Contains(a.supportedCiphers(), suite) {
+		suite = crypto.DefaultSuite
+	}
+	schedule, _ := msg["key_schedule"].(string)
+	if !slices.Contains(a.supportedKeySchedules(), schedule) {
+		schedule = protocol.KeyScheduleShared
+	}
+	if suite != crypto.DefaultSuite || schedule != protocol.KeyScheduleShared {
+		if err := a.useCipherSuite(suite, schedule); err != nil {
+			log.Printf("[%s] Cipher switch failed: %v", time.Now().Format(time.RFC3339), err)
+			return
+		}
+		log.Printf("[%s] Cipher suite negotiated: %s (%s keys)", time.Now().Format(time.RFC3339), suite, schedule)
+	}
+
+	// Counters are only framed when both sides number their messages
+	if replay && a.replayOffered() && !a.replayChecking() {
+		a.enableReplayCheck()
+		log.Printf("[%s] Replay protection enabled", time.Now().Format(time.RFC3339))
+	}
+
+	if sessionKeys, _ := msg["session_keys"].(bool); sessionKeys && a.sealing() && a.sessionKeysOffered() {
+		if err := a.startKeyExchange(); err != nil {
+			log.Printf("[%s] Key exchange error: %v", time.Now().Format(time.RFC3339), err)
+			a.closeConn()
+		}
+	}
+}
+
+// handleBroadcast runs a fleet-wide message after a random offset inside its
+// "stagger_seconds" window, so agents don't all scan or report at once. A
+// signed message is verified on arrival, so the window may be longer than
+// signatureMaxAge.
+func (a *NOPAgent) handleBroadcast(msg map[string]interface{}) {
+	inner, ok := msg["message"].(map[string]interface{})
+	if !ok {
+		a.sendError("broadcast", msg, newAgentError(ErrInvalidRequest, "missing_message",
+			"broadcast envelope has no message"))
+		return
+	}
+
+	// Check the signature now: a stagger can outlast the signature window
+	inner, ok = a.verifyMessage(inner)
+	if !ok {
+		return
+	}
+
+	var offset time.Duration
+	if window, ok := msg["stagger_seconds"].(float64); ok && window > 0 {
+		offset = time.Duration(mathrand.Int63n(int64(window * float64(time.Second))))
+	}
+	broadcastID, _ := msg["broadcast_id"].(string)
+	innerType, _ := inner["type"].(string)
+
+	log.Printf("[%s] Broadcast %s (%s) scheduled in %s", time.Now().Format(time.RFC3339), broadcastID, innerType,
+		offset.Round(time.Second))
+	a.relayToC2(map[string]interface{}{
+		"type":           "broadcast_ack",
+		"agent_id":       a.agentID,
+		"broadcast_id":   broadcastID,
+		"offset_seconds": offset.Seconds(),
+		"timestamp":      time.Now().UTC().Format(time.RFC3339),
+	})
+
+	go func() {
+		time.Sleep(offset)
+		if !a.running {
+			return
+		}
+		a.dispatchVerified(inner)
+		// Unblock the read loop if the broadcast stopped the agent
+		if !a.running {
+			a.closeConn()
+		}
+	}()
+}
+
+// handleCommand queues a command, or a script, which is a command whose
+// body is staged to a file; both go through approval and the command policy
+func (a *NOPAgent) handleCommand(msg map[string]interface{}) {
+	reqType, _ := msg["type"].(string)
+	if !a.capabilities["access"] {
+		a.sendError(reqType, msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+	cmd, err := a.commandSpec(msg)
+	if err != nil {
+		a.sendError(reqType, msg, err)
+		return
+	}
+	log.Printf("[%s] Received command: %s", time.Now().Format(time.RFC3339), cmd)
+
+	id, _ := msg["command_id"].(string)
+	if id == "" {
+		id = newID()
+	}
+	class := approvalClass(reqType)
+	operator, _ := msg["operator"].(string)
+
+	job := &Job{
+		ID:       id,
+		Type:     reqType,
+		Command:  cmd,
+		Class:    class,
+		Operator: operator,
+		Status:   "queued",
+		msg:      msg,
+	}
+	a.bindJob(job)
+	a.holdForApproval(job)
+	a.enqueueJob(job)
+}
+
+// holdForApproval parks a job as pending_approval when its class needs
+// approval and tells the C2 what it would run
+func (a *NOPAgent) holdForApproval(job *Job) {
+	if _, held := a.approvalRole(job.Class); !held {
+		return
+	}
+	job.Status = "pending_approval"
+	log.Printf("[%s] Command %s (%s) held for approval", time.Now().Format(time.RFC3339), job.ID, job.Class)
+	a.relayToC2(map[string]interface{}{
+		"type":       "command_pending_approval",
+		"agent_id":   a.agentID,
+		"command_id": job.ID,
+		"job_type":   job.Type,
+		"class":      job.Class,
+		"command":    job.Command,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (a *NOPAgent) handleUninstall() {
+	removed := make([]string, 0)
+	failed := make([]map[string]interface{}, 0)
+
+	record := func(item string, err error) {
+		if err == nil {
+			removed = append(removed, item)
+			log.Printf("[%s] Removed: %s", time.Now().Format(time.RFC3339), item)
+		} else if !os.IsNotExist(err) {
+			failed = append(failed, map[string]interface{}{"item": item, "error": classifyError(err)})
+			log.Printf("[%s] Could not remove %s: %v", time.Now().Format(time.RFC3339), item, err)
+		}
+	}
+
+	executable, _ := os.Executable()
+	home, _ := os.UserHomeDir()
+	// Stopping the service signals this process, so it runs only once the
+	// report is out
+	stops := make([]*exec.Cmd, 0)
+
+	// Persistence entries
+	switch runtime.GOOS {
+	case "linux":
+		exec.Command("systemctl", "disable", ServiceName).Run()
+		exec.Command("systemctl", "--user", "disable", ServiceName).Run()
+		stops = append(stops, exec.Command("systemctl", "stop", ServiceName), exec.Command("systemctl", "--user", "stop", ServiceName))
+		for _, unit := range []string{
+			motdNotice,
+			"/etc/systemd/system/" + ServiceName + ".service",
+			filepath.Join(home, ".config/systemd/user", ServiceName+".service"),
+			"/etc/init.d/" + ServiceName,
+		} {
+			record(unit, os.Remove(unit))
+		}
+		if executable != "" {
+			record("crontab entries", a.removeCrontabEntries(executable))
+		}
+	case "darwin":
+		for _, plist := range []string{
+			"/Library/LaunchDaemons/com." + ServiceName + ".plist",
+			filepath.Join(home, "Library/LaunchAgents", "com."+ServiceName+".plist"),
+		} {
+			record(plist, os.Remove(plist))
+		}
+		stops = append(stops, exec.Command("launchctl", "remove", "com."+ServiceName))
+	case "windows":
+		record("scheduled task "+ServiceName, a.runCleanup("schtasks", "/Delete", "/TN", ServiceName, "/F"))
+		record("service "+ServiceName, a.runCleanup("sc", "delete", ServiceName))
+		record("run key "+ServiceName, a.runCleanup("reg", "delete",
+			`HKCU\Software\Microsoft\Windows\CurrentVersion\Run`, "/v", ServiceName, "/f"))
+	}
+
+	// Firewall exceptions
+	switch runtime.GOOS {
+	case "windows":
+		record("firewall rule "+ServiceName, a.runCleanup("netsh", "advfirewall", "firewall", "delete", "rule", "name="+ServiceName))
+	case "darwin":
+		if executable != "" {
+			record("application firewall entry", a.runCleanup("/usr/libexec/ApplicationFirewall/socketfilterfw", "--remove", executable))
+		}
+	}
+
+	// Issued token held by the platform keystore
+	if a.keys != nil {
+		if _, err := a.keys.Get(keystoreTokenName); err == nil {
+			record("keystore entry "+keystoreTokenName, a.keys.Delete(keystoreTokenName))
+		}
+	}
+
+	// Local state directory (includes logs)
+	stateDir := a.stateDir()
+	if _, err := os.Stat(stateDir); err == nil {
+		record(stateDir, os.RemoveAll(stateDir))
+	}
+	if logFile, ok := a.config["log_file"].(string); ok && logFile != "" {
+		record(logFile, os.Remove(logFile))
+	}
+
+	// The binary itself - Windows cannot delete a running executable, so defer it
+	if executable != "" {
+		if runtime.GOOS == "windows" {
+			cmd := exec.Command("cmd", "/C", "ping -n 3 127.0.0.1 >NUL & del /F /Q \""+executable+"\"")
+			record(executable+" (scheduled)", cmd.Start())
+		} else {
+			record(executable, os.Remove(executable))
+		}
+	}
+
+	a.relayToC2(map[string]interface{}{
+		"type":      "uninstall_result",
+		"agent_id":  a.agentID,
+		"removed":   removed,
+		"failed":    failed,
+		"complete":  len(failed) == 0,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+
+	for _, stop := range stops {
+		detachProcess(stop)
+		stop.Start()
+	}
+}
+
+// runCleanup runs a cleanup command, mapping "nothing to remove" failures to os.ErrNotExist
+func (a *NOPAgent) runCleanup(name string, args ...string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return os.ErrNotExist
+	}
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		text := strings.ToLower(string(output))
+		if strings.Contains(text, "cannot find") || strings.Contains(text, "does not exist") ||
+			strings.Contains(text, "no rules match") || strings.Contains(text, "unable to find") {
+			return os.ErrNotExist
+		}
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (a *NOPAgent) removeCrontabEntries(executable string) error {
+	output, err := exec.Command("crontab", "-l").Output()
+	if err != nil {
+		return os.ErrNotExist
+	}
+	lines := strings.Split(string(output), "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !strings.Contains(line, executable) {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return os.ErrNotExist
+	}
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(kept, "\n"))
+	return cmd.Run()
+}
+
+// handleSettingsUpdate merges settings into the config. settings_update is
+// signed; without a C2 signing key the approval settings are kept as built,
+// since an unverified update could otherwise lift the approval hold.
+func (a *NOPAgent) handleSettingsUpdate(msg map[string]interface{}) {
+	if settings, ok := msg["settings"].(map[string]interface{}); ok {
+		log.Printf("[%s] Settings update received from C2", time.Now().Format(time.RFC3339))
+		// Update config with new settings
+		for k, v := range settings {
+			if a.commandKey == nil && strings.HasPrefix(k, "approval_") {
+				log.Printf("[%s] Ignoring unsigned change to %s", time.Now().Format(time.RFC3339), k)
+				continue
+			}
+			a.config[k] = v
+		}
+	}
+}
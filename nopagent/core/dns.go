@@ -0,0 +1,133 @@
+package core
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsTypes are the record types accepted by dns_lookup
+var dnsTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"PTR":   dns.TypePTR,
+	"SRV":   dns.TypeSRV,
+	"TXT":   dns.TypeTXT,
+	"MX":    dns.TypeMX,
+	"CNAME": dns.TypeCNAME,
+	"NS":    dns.TypeNS,
+}
+
+// handleDNSLookup resolves a name through the host's resolvers or a given
+// server and returns the answers with their TTLs
+func (a *NOPAgent) handleDNSLookup(msg map[string]interface{}) {
+	name, _ := msg["name"].(string)
+	if name == "" {
+		a.sendError("dns_lookup", msg, newAgentError(ErrInvalidRequest, "missing_name", "name is required"))
+		return
+	}
+	recordType, _ := msg["record_type"].(string)
+	if recordType == "" {
+		recordType = "A"
+	}
+	recordType = strings.ToUpper(recordType)
+	qtype, ok := dnsTypes[recordType]
+	if !ok {
+		a.sendError("dns_lookup", msg, newAgentError(ErrInvalidRequest, "unsupported_type",
+			"record type %q is not supported", recordType))
+		return
+	}
+
+	// PTR lookups accept a plain IP address
+	if qtype == dns.TypePTR && net.ParseIP(name) != nil {
+		name, _ = dns.ReverseAddr(name)
+	}
+
+	servers := make([]string, 0)
+	if server, ok := msg["server"].(string); ok && server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		servers = append(servers, server)
+	} else if conf, err := dns.ClientConfigFromFile("/etc/resolv.conf"); err == nil {
+		for _, s := range conf.Servers {
+			servers = append(servers, net.JoinHostPort(s, conf.Port))
+		}
+	}
+	if len(servers) == 0 {
+		a.sendError("dns_lookup", msg, newAgentError(ErrNotSupported, "no_resolver",
+			"no DNS server given and none found in /etc/resolv.conf"))
+		return
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	query.RecursionDesired = true
+
+	client := &dns.Client{Timeout: 5 * time.Second}
+	var response *dns.Msg
+	var server string
+	var err error
+	for _, server = range servers {
+		response, _, err = client.Exchange(query, server)
+		if err == nil && response.Truncated {
+			response, _, err = (&dns.Client{Net: "tcp", Timeout: 5 * time.Second}).Exchange(query, server)
+		}
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		a.sendError("dns_lookup", msg, err)
+		return
+	}
+
+	answers := make([]map[string]interface{}, 0, len(response.Answer))
+	for _, rr := range response.Answer {
+		header := rr.Header()
+		answer := map[string]interface{}{
+			"name": header.Name,
+			"type": dns.TypeToString[header.Rrtype],
+			"ttl":  header.Ttl,
+		}
+		switch record := rr.(type) {
+		case *dns.A:
+			answer["address"] = record.A.String()
+		case *dns.AAAA:
+			answer["address"] = record.AAAA.String()
+		case *dns.PTR:
+			answer["target"] = record.Ptr
+		case *dns.CNAME:
+			answer["target"] = record.Target
+		case *dns.NS:
+			answer["target"] = record.Ns
+		case *dns.SRV:
+			answer["target"] = record.Target
+			answer["port"] = record.Port
+			answer["priority"] = record.Priority
+			answer["weight"] = record.Weight
+		case *dns.MX:
+			answer["host"] = record.Mx
+			answer["preference"] = record.Preference
+		case *dns.TXT:
+			answer["text"] = record.Txt
+		default:
+			answer["value"] = rr.String()
+		}
+		answers = append(answers, answer)
+	}
+
+	a.writeJSON(map[string]interface{}{
+		"type":        "dns_lookup_result",
+		"agent_id":    a.agentID,
+		"request_id":  msg["request_id"],
+		"name":        name,
+		"record_type": recordType,
+		"server":      server,
+		"rcode":       dns.RcodeToString[response.Rcode],
+		"answers":     answers,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ERRORS - Structured error taxonomy shared by all module responses
+// ============================================================================
+
+// Error categories let the C2 aggregate failures across the fleet; Code
+// carries the specific reason within a category.
+const (
+	ErrAuth           = "auth"
+	ErrPermission     = "permission"
+	ErrTimeout        = "timeout"
+	ErrNotSupported   = "not_supported"
+	ErrScopeViolation = "scope_violation"
+	ErrResourceLimit  = "resource_limit"
+	ErrInvalidRequest = "invalid_request"
+	ErrNotFound       = "not_found"
+	ErrInternal       = "internal"
+)
+
+type AgentError struct {
+	Category string `json:"category"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func (e *AgentError) Error() string {
+	return fmt.Sprintf("%s/%s: %s", e.Category, e.Code, e.Message)
+}
+
+func newAgentError(category, code, format string, args ...interface{}) *AgentError {
+	return &AgentError{Category: category, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// classifyError maps an arbitrary Go error onto the taxonomy
+func classifyError(err error) *AgentError {
+	if err == nil {
+		return nil
+	}
+	var agentErr *AgentError
+	if errors.As(err, &agentErr) {
+		return agentErr
+	}
+
+	var netErr net.Error
+	text := strings.ToLower(err.Error())
+	switch {
+	case errors.Is(err, os.ErrPermission), strings.Contains(text, "permission denied"),
+		strings.Contains(text, "access is denied"), strings.Contains(text, "operation not permitted"):
+		return &AgentError{Category: ErrPermission, Code: "access_denied", Message: err.Error()}
+	case errors.Is(err, os.ErrNotExist):
+		return &AgentError{Category: ErrNotFound, Code: "not_found", Message: err.Error()}
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &AgentError{Category: ErrTimeout, Code: "deadline_exceeded", Message: err.Error()}
+	case errors.Is(err, exec.ErrNotFound):
+		return &AgentError{Category: ErrNotSupported, Code: "executable_not_found", Message: err.Error()}
+	case strings.Contains(text, "401") || strings.Contains(text, "403") || strings.Contains(text, "unauthorized"):
+		return &AgentError{Category: ErrAuth, Code: "rejected_by_server", Message: err.Error()}
+	}
+	return &AgentError{Category: ErrInternal, Code: "unexpected", Message: err.Error()}
+}
+
+// sendError reports a failed request back to the C2 using the shared taxonomy
+func (a *NOPAgent) sendError(requestType string, msg map[string]interface{}, err error) {
+	agentErr := classifyError(err)
+	log.Printf("[%s] %s failed: %v", time.Now().Format(time.RFC3339), requestType, agentErr)
+
+	response := map[string]interface{}{
+		"type":         "error",
+		"agent_id":     a.agentID,
+		"request_type": requestType,
+		"error":        agentErr,
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, key := range []string{"command_id", "job_id", "transfer_id", "request_id"} {
+		if id, ok := msg[key].(string); ok {
+			response[key] = id
+		}
+	}
+	a.relayToC2(response)
+}
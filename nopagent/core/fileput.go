@@ -0,0 +1,234 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// FILE UPLOAD - file_put transfers from the C2, written atomically
+// ============================================================================
+
+// fileUpload is a file_put in progress. Chunks go to a temp file next to
+// the target, which only replaces it once size and SHA-256 match.
+type fileUpload struct {
+	id        string
+	path      string
+	size      int64
+	sha256    string
+	mode      os.FileMode
+	overwrite bool
+	tmp       *os.File
+	hash      hash.Hash
+	written   int64
+	started   time.Time
+	idle      *time.Timer
+	done      sync.Once
+}
+
+// handleFilePut starts an upload: it checks the target and opens the temp
+// file, then acknowledges with file_put_ready so the C2 starts sending
+// file_put_chunk messages
+func (a *NOPAgent) handleFilePut(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("file_put", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+	path, _ := msg["path"].(string)
+	if path == "" {
+		a.sendError("file_put", msg, newAgentError(ErrInvalidRequest, "missing_path", "path is required"))
+		return
+	}
+	if err := a.cmdPolicy.checkPath(path); err != nil {
+		a.sendError("file_put", msg, err)
+		return
+	}
+	digest, _ := msg["sha256"].(string)
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*sha256.Size {
+		a.sendError("file_put", msg, newAgentError(ErrInvalidRequest, "invalid_sha256", "sha256 must be the hex SHA-256 of the file"))
+		return
+	}
+	sizeVal, ok := msg["size"].(float64)
+	if !ok || sizeVal < 0 {
+		a.sendError("file_put", msg, newAgentError(ErrInvalidRequest, "invalid_size", "size is required"))
+		return
+	}
+	transferID, _ := msg["transfer_id"].(string)
+	if transferID == "" {
+		transferID = newID()
+	}
+	overwrite, _ := msg["overwrite"].(bool)
+
+	// New files default to 0644; replaced files keep their mode
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			a.sendError("file_put", msg, newAgentError(ErrInvalidRequest, "is_directory", "%s is a directory", path))
+			return
+		}
+		if !overwrite {
+			a.sendError("file_put", msg, newAgentError(ErrInvalidRequest, "file_exists", "%s exists and overwrite is not set", path))
+			return
+		}
+		mode = info.Mode().Perm()
+	}
+	if raw, ok := msg["mode"].(string); ok && raw != "" {
+		var err error
+		if mode, err = parseMode(raw); err != nil {
+			a.sendError("file_put", msg, err)
+			return
+		}
+	}
+
+	a.uploadMutex.Lock()
+	_, duplicate := a.uploads[transferID]
+	a.uploadMutex.Unlock()
+	if duplicate {
+		a.sendError("file_put", msg, newAgentError(ErrInvalidRequest, "duplicate_transfer", "transfer %s is already in progress", transferID))
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		a.sendError("file_put", msg, err)
+		return
+	}
+	upload := &fileUpload{
+		id:        transferID,
+		path:      path,
+		size:      int64(sizeVal),
+		sha256:    strings.ToLower(digest),
+		mode:      mode,
+		overwrite: overwrite,
+		tmp:       tmp,
+		hash:      sha256.New(),
+		started:   time.Now(),
+	}
+	// Abandoned uploads, e.g. from a C2 that lost its connection, are
+	// discarded after "file_put_timeout" seconds (default 300) without data
+	upload.idle = time.AfterFunc(a.timeout("file_put_timeout", 5*time.Minute), func() {
+		a.finishUpload(upload, newAgentError(ErrTimeout, "upload_idle", "no data for transfer %s", transferID))
+	})
+	a.uploadMutex.Lock()
+	a.uploads[transferID] = upload
+	a.uploadMutex.Unlock()
+
+	log.Printf("[%s] Receiving %s (%d bytes) as transfer %s", time.Now().Format(time.RFC3339), path, upload.size, transferID)
+	a.relayToC2(map[string]interface{}{
+		"type":        "file_put_ready",
+		"agent_id":    a.agentID,
+		"transfer_id": transferID,
+		"path":        path,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleFilePutChunk appends base64 "data" at "offset", which must follow
+// the bytes already written. The chunk flagged eof completes the upload.
+func (a *NOPAgent) handleFilePutChunk(msg map[string]interface{}) {
+	transferID, _ := msg["transfer_id"].(string)
+	a.uploadMutex.Lock()
+	upload := a.uploads[transferID]
+	a.uploadMutex.Unlock()
+	if upload == nil {
+		a.sendError("file_put_chunk", msg, newAgentError(ErrNotFound, "unknown_transfer", "no upload with id %q", transferID))
+		return
+	}
+
+	encoded, _ := msg["data"].(string)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		a.finishUpload(upload, newAgentError(ErrInvalidRequest, "invalid_data", "data must be base64"))
+		return
+	}
+	offset, _ := msg["offset"].(float64)
+	if int64(offset) != upload.written {
+		a.finishUpload(upload, newAgentError(ErrInvalidRequest, "invalid_offset", "chunk at offset %d, expected %d", int64(offset), upload.written))
+		return
+	}
+	if upload.written+int64(len(data)) > upload.size {
+		a.finishUpload(upload, newAgentError(ErrInvalidRequest, "size_mismatch", "more data than the announced %d bytes", upload.size))
+		return
+	}
+	if _, err := upload.tmp.Write(data); err != nil {
+		a.finishUpload(upload, err)
+		return
+	}
+	upload.hash.Write(data)
+	upload.written += int64(len(data))
+	upload.idle.Reset(a.timeout("file_put_timeout", 5*time.Minute))
+
+	if eof, _ := msg["eof"].(bool); eof {
+		a.finishUpload(upload, a.commitUpload(upload))
+	}
+}
+
+// commitUpload checks the received file against the announced size and
+// hash, applies the mode and renames it over the target
+func (a *NOPAgent) commitUpload(upload *fileUpload) error {
+	if upload.written != upload.size {
+		return newAgentError(ErrInvalidRequest, "size_mismatch", "received %d of %d bytes", upload.written, upload.size)
+	}
+	if got := hex.EncodeToString(upload.hash.Sum(nil)); got != upload.sha256 {
+		return newAgentError(ErrInvalidRequest, "hash_mismatch", "received data has SHA-256 %s, expected %s", got, upload.sha256)
+	}
+	if err := upload.tmp.Sync(); err != nil {
+		return err
+	}
+	if err := upload.tmp.Chmod(upload.mode); err != nil {
+		return err
+	}
+	if err := upload.tmp.Close(); err != nil {
+		return err
+	}
+	// The target may have appeared while the upload was running
+	if _, err := os.Stat(upload.path); err == nil && !upload.overwrite {
+		return newAgentError(ErrInvalidRequest, "file_exists", "%s exists and overwrite is not set", upload.path)
+	}
+	return os.Rename(upload.tmp.Name(), upload.path)
+}
+
+// finishUpload ends an upload once, removing the temp file unless it was
+// renamed into place, and reports the outcome in file_put_result
+func (a *NOPAgent) finishUpload(upload *fileUpload, err error) {
+	upload.done.Do(func() {
+		upload.idle.Stop()
+		upload.tmp.Close()
+		if err != nil {
+			os.Remove(upload.tmp.Name())
+		}
+		a.uploadMutex.Lock()
+		delete(a.uploads, upload.id)
+		a.uploadMutex.Unlock()
+
+		result := map[string]interface{}{
+			"type":        "file_put_result",
+			"agent_id":    a.agentID,
+			"transfer_id": upload.id,
+			"path":        upload.path,
+			"status":      "completed",
+			"size":        upload.written,
+			"sha256":      upload.sha256,
+			"mode":        "0" + strconv.FormatUint(uint64(upload.mode), 8),
+			"duration_ms": time.Since(upload.started).Milliseconds(),
+			"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		}
+		if err != nil {
+			log.Printf("[%s] Upload %s to %s failed: %v", time.Now().Format(time.RFC3339), upload.id, upload.path, err)
+			result["status"] = "failed"
+			result["error"] = classifyError(err)
+		} else {
+			log.Printf("[%s] Wrote %s (%d bytes) from transfer %s", time.Now().Format(time.RFC3339), upload.path, upload.written, upload.id)
+		}
+		a.relayToC2(result)
+	})
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// newTestAgent returns an agent keeping its state in a temp directory whose
+// reports are collected as in an audit run rather than sent
+func newTestAgent(t *testing.T, capabilities ...string) *NOPAgent {
+	t.Helper()
+	identity := Identity{
+		AgentID:       "test-agent",
+		EncryptionKey: "0123456789abcdef0123456789abcdef",
+		Capabilities:  map[string]bool{},
+		Config:        map[string]interface{}{"state_dir": t.TempDir()},
+	}
+	for _, capability := range capabilities {
+		identity.Capabilities[capability] = true
+	}
+	agent := NewNOPAgent(identity)
+	agent.audit = &auditReport{}
+	return agent
+}
+
+// lastReport returns the last report the agent relayed
+func lastReport(t *testing.T, a *NOPAgent) map[string]interface{} {
+	t.Helper()
+	a.audit.mutex.Lock()
+	defer a.audit.mutex.Unlock()
+	if len(a.audit.records) == 0 {
+		t.Fatal("agent relayed nothing")
+	}
+	report, ok := a.audit.records[len(a.audit.records)-1].(map[string]interface{})
+	if !ok {
+		t.Fatalf("last report is a %T", a.audit.records[len(a.audit.records)-1])
+	}
+	return report
+}
+
+func TestFilePut(t *testing.T) {
+	content := []byte("uploaded file contents")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	chunk := func(offset int, data []byte, eof bool) map[string]interface{} {
+		return map[string]interface{}{
+			"type":        "file_put_chunk",
+			"transfer_id": "t1",
+			"offset":      float64(offset),
+			"data":        base64.StdEncoding.EncodeToString(data),
+			"eof":         eof,
+		}
+	}
+	whole := []map[string]interface{}{chunk(0, content[:8], false), chunk(8, content[8:], true)}
+
+	tests := []struct {
+		name      string
+		existing  []byte
+		overwrite bool
+		digest    string
+		size      int
+		chunks    []map[string]interface{}
+		during    func(path string)
+		wantCode  string
+		wantFile  []byte
+	}{
+		{name: "new file", digest: digest, size: len(content), chunks: whole, wantFile: content},
+		{name: "single chunk", digest: digest, size: len(content), chunks: []map[string]interface{}{chunk(0, content, true)}, wantFile: content},
+		{name: "replace", existing: []byte("old"), overwrite: true, digest: digest, size: len(content), chunks: whole, wantFile: content},
+		{name: "exists without overwrite", existing: []byte("old"), digest: digest, size: len(content), wantCode: "file_exists", wantFile: []byte("old")},
+		{name: "created during upload", digest: digest, size: len(content), chunks: whole,
+			during: func(path string) { os.WriteFile(path, []byte("raced"), 0600) }, wantCode: "file_exists", wantFile: []byte("raced")},
+		{name: "hash mismatch", existing: []byte("old"), overwrite: true, digest: hex.EncodeToString(make([]byte, 32)), size: len(content), chunks: whole, wantCode: "hash_mismatch", wantFile: []byte("old")},
+		{name: "short", digest: digest, size: len(content) + 1, chunks: whole, wantCode: "size_mismatch"},
+		{name: "too long", digest: digest, size: 8, chunks: whole, wantCode: "size_mismatch"},
+		{name: "gap", digest: digest, size: len(content), chunks: []map[string]interface{}{chunk(0, content[:8], false), chunk(9, content[9:], true)}, wantCode: "invalid_offset"},
+		{name: "invalid digest", digest: "abc", size: len(content), wantCode: "invalid_sha256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, "access")
+			dir := t.TempDir()
+			path := filepath.Join(dir, "upload.bin")
+			if tt.existing != nil {
+				if err := os.WriteFile(path, tt.existing, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			a.handleFilePut(map[string]interface{}{
+				"type":        "file_put",
+				"transfer_id": "t1",
+				"path":        path,
+				"sha256":      tt.digest,
+				"size":        float64(tt.size),
+				"overwrite":   tt.overwrite,
+			})
+			if lastReport(t, a)["type"] == "file_put_ready" {
+				for i, msg := range tt.chunks {
+					if i == len(tt.chunks)-1 && tt.during != nil {
+						tt.during(path)
+					}
+					a.handleFilePutChunk(msg)
+				}
+			}
+
+			report := lastReport(t, a)
+			code := ""
+			if agentErr, ok := report["error"].(*AgentError); ok {
+				code = agentErr.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("%s reported error %q, want %q", report["type"], code, tt.wantCode)
+			}
+			if tt.wantCode == "" && report["status"] != "completed" {
+				t.Errorf("file_put_result status = %v", report["status"])
+			}
+
+			got, err := os.ReadFile(path)
+			if tt.wantFile == nil && !os.IsNotExist(err) {
+				t.Errorf("target exists after a failed upload: %q, %v", got, err)
+			}
+			if tt.wantFile != nil && string(got) != string(tt.wantFile) {
+				t.Errorf("target holds %q, want %q", got, tt.wantFile)
+			}
+			// Only the target remains; the temp file is renamed or removed
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				if entry.Name() != "upload.bin" {
+					t.Errorf("left behind %s", entry.Name())
+				}
+			}
+			if len(a.uploads) != 0 {
+				t.Errorf("%d uploads still registered", len(a.uploads))
+			}
+
+			// A replaced file keeps its mode
+			if info, err := os.Stat(path); err == nil && tt.overwrite && tt.existing != nil && runtime.GOOS != "windows" {
+				if info.Mode().Perm() != 0600 {
+					t.Errorf("replaced file mode = %v, want 0600", info.Mode().Perm())
+				}
+			}
+		})
+	}
+}
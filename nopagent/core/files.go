@@ -0,0 +1,228 @@
+package core
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// FILE BROWSER - Structured filesystem commands (fs_list, fs_stat, ...)
+// ============================================================================
+
+// fileEntry describes one filesystem object for the C2 file browser
+func fileEntry(path string, info fs.FileInfo) map[string]interface{} {
+	kind := "file"
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		kind = "symlink"
+	case info.IsDir():
+		kind = "dir"
+	case !info.Mode().IsRegular():
+		kind = "other"
+	}
+	entry := map[string]interface{}{
+		"name":        info.Name(),
+		"path":        path,
+		"type":        kind,
+		"size":        info.Size(),
+		"mode":        "0" + strconv.FormatUint(uint64(info.Mode().Perm()), 8),
+		"permissions": info.Mode().String(),
+		"modified":    info.ModTime().UTC().Format(time.RFC3339),
+	}
+	if owner, group := fileOwner(info); owner != "" {
+		entry["owner"] = owner
+		entry["group"] = group
+	}
+	if kind == "symlink" {
+		if target, err := os.Readlink(path); err == nil {
+			entry["target"] = target
+		}
+	}
+	return entry
+}
+
+// parseMode reads octal permission bits such as "0755"
+func parseMode(raw string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(strings.TrimPrefix(raw, "0o"), 8, 32)
+	if err != nil || bits > 0777 {
+		return 0, newAgentError(ErrInvalidRequest, "invalid_mode", "mode %q is not octal permission bits", raw)
+	}
+	return os.FileMode(bits), nil
+}
+
+// fsPath reads the required "path" of an fs_* request as an absolute path
+func (a *NOPAgent) fsPath(reqType string, msg map[string]interface{}, key string) (string, bool) {
+	if !a.capabilities["access"] {
+		a.sendError(reqType, msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return "", false
+	}
+	path, _ := msg[key].(string)
+	if path == "" {
+		a.sendError(reqType, msg, newAgentError(ErrInvalidRequest, "missing_"+key, "%s is required", key))
+		return "", false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		a.sendError(reqType, msg, err)
+		return "", false
+	}
+	if err := a.cmdPolicy.checkPath(abs); err != nil {
+		a.sendError(reqType, msg, err)
+		return "", false
+	}
+	return abs, true
+}
+
+func (a *NOPAgent) sendFSResult(reqType string, msg map[string]interface{}, fields map[string]interface{}) {
+	result := map[string]interface{}{
+		"type":       reqType + "_result",
+		"agent_id":   a.agentID,
+		"request_id": msg["request_id"],
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	}
+	for k, v := range fields {
+		result[k] = v
+	}
+	a.writeJSON(result)
+}
+
+// handleFSList lists a directory, sorted by name. Entries that cannot be
+// stat'ed are skipped; at most "max_entries" (default 5000) are returned.
+func (a *NOPAgent) handleFSList(msg map[string]interface{}) {
+	path, ok := a.fsPath("fs_list", msg, "path")
+	if !ok {
+		return
+	}
+	limit := 5000
+	if val, ok := msg["max_entries"].(float64); ok && val > 0 {
+		limit = int(val)
+	}
+
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		a.sendError("fs_list", msg, err)
+		return
+	}
+	entries := make([]map[string]interface{}, 0, min(len(dirEntries), limit))
+	for _, dirEntry := range dirEntries {
+		if len(entries) == limit {
+			break
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fileEntry(filepath.Join(path, dirEntry.Name()), info))
+	}
+	a.sendFSResult("fs_list", msg, map[string]interface{}{
+		"path":      path,
+		"entries":   entries,
+		"total":     len(dirEntries),
+		"truncated": len(dirEntries) > limit,
+	})
+}
+
+// handleFSStat describes one path without following a final symlink
+func (a *NOPAgent) handleFSStat(msg map[string]interface{}) {
+	path, ok := a.fsPath("fs_stat", msg, "path")
+	if !ok {
+		return
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		a.sendError("fs_stat", msg, err)
+		return
+	}
+	a.sendFSResult("fs_stat", msg, map[string]interface{}{"entry": fileEntry(path, info)})
+}
+
+// handleFSRemove deletes a file or empty directory, or a whole tree with
+// "recursive". Filesystem roots are refused.
+func (a *NOPAgent) handleFSRemove(msg map[string]interface{}) {
+	path, ok := a.fsPath("fs_remove", msg, "path")
+	if !ok {
+		return
+	}
+	if filepath.Dir(path) == path {
+		a.sendError("fs_remove", msg, newAgentError(ErrInvalidRequest, "refused_root", "refusing to remove %s", path))
+		return
+	}
+	if _, err := os.Lstat(path); err != nil {
+		a.sendError("fs_remove", msg, err)
+		return
+	}
+	remove := os.Remove
+	if recursive, _ := msg["recursive"].(bool); recursive {
+		remove = os.RemoveAll
+	}
+	if err := remove(path); err != nil {
+		a.sendError("fs_remove", msg, err)
+		return
+	}
+	a.sendFSResult("fs_remove", msg, map[string]interface{}{"path": path, "removed": true})
+}
+
+// handleFSMkdir creates a directory, with missing parents if "parents" is
+// set, using "mode" (octal, default 0755)
+func (a *NOPAgent) handleFSMkdir(msg map[string]interface{}) {
+	path, ok := a.fsPath("fs_mkdir", msg, "path")
+	if !ok {
+		return
+	}
+	mode := os.FileMode(0755)
+	if raw, ok := msg["mode"].(string); ok && raw != "" {
+		var err error
+		if mode, err = parseMode(raw); err != nil {
+			a.sendError("fs_mkdir", msg, err)
+			return
+		}
+	}
+	mkdir := os.Mkdir
+	if parents, _ := msg["parents"].(bool); parents {
+		mkdir = os.MkdirAll
+	}
+	if err := mkdir(path, mode); err != nil {
+		a.sendError("fs_mkdir", msg, err)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		a.sendError("fs_mkdir", msg, err)
+		return
+	}
+	a.sendFSResult("fs_mkdir", msg, map[string]interface{}{"entry": fileEntry(path, info)})
+}
+
+// handleFSMove renames source to destination. An existing destination is
+// only replaced with "overwrite"; moves across filesystems are not
+// supported.
+func (a *NOPAgent) handleFSMove(msg map[string]interface{}) {
+	source, ok := a.fsPath("fs_move", msg, "source")
+	if !ok {
+		return
+	}
+	destination, ok := a.fsPath("fs_move", msg, "destination")
+	if !ok {
+		return
+	}
+	if _, err := os.Lstat(destination); err == nil {
+		if overwrite, _ := msg["overwrite"].(bool); !overwrite {
+			a.sendError("fs_move", msg, newAgentError(ErrInvalidRequest, "file_exists", "%s exists and overwrite is not set", destination))
+			return
+		}
+	}
+	if err := os.Rename(source, destination); err != nil {
+		a.sendError("fs_move", msg, err)
+		return
+	}
+	info, err := os.Lstat(destination)
+	if err != nil {
+		a.sendError("fs_move", msg, err)
+		return
+	}
+	a.sendFSResult("fs_move", msg, map[string]interface{}{"source": source, "entry": fileEntry(destination, info)})
+}
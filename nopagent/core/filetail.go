@@ -0,0 +1,171 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// ============================================================================
+// FILE TAIL - Follow a log file and stream new lines until cancelled
+// ============================================================================
+
+const (
+	tailPoll     = 500 * time.Millisecond
+	tailMaxLine  = 64 << 10 // longer lines are sent in pieces
+	tailMaxBatch = 1000     // lines per file_tail_data message
+)
+
+// runFileTail sends the last "lines" (default 10) lines of "path", then
+// every line appended to it as file_tail_data, until the job is cancelled.
+// A file replaced by rotation is finished and the new one followed from its
+// start (rotated); one truncated in place is reread from the start
+// (truncated). A missing file is waited for.
+func (a *NOPAgent) runFileTail(ctx context.Context, job *Job) error {
+	if !a.capabilities["access"] {
+		return newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled")
+	}
+	path, _ := job.msg["path"].(string)
+	if path == "" {
+		return newAgentError(ErrInvalidRequest, "missing_path", "path is required")
+	}
+	if err := a.cmdPolicy.checkPath(path); err != nil {
+		return err
+	}
+	backlog := 10
+	if val, ok := job.msg["lines"].(float64); ok && val >= 0 {
+		backlog = int(val)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return newAgentError(ErrInvalidRequest, "is_directory", "%s is a directory", path)
+	}
+	offset, err := tailStart(f, info.Size(), backlog)
+	if err != nil {
+		return err
+	}
+
+	var partial []byte
+	flags := map[string]interface{}{}
+	buf := make([]byte, 32<<10)
+	ticker := time.NewTicker(tailPoll)
+	defer ticker.Stop()
+	for {
+		lines := make([]string, 0)
+		for {
+			n, err := f.ReadAt(buf, offset)
+			offset += int64(n)
+			partial = append(partial, buf[:n]...)
+			for {
+				i := bytes.IndexByte(partial, '\n')
+				if i < 0 {
+					break
+				}
+				lines = append(lines, string(bytes.TrimSuffix(partial[:i], []byte("\r"))))
+				partial = partial[i+1:]
+			}
+			if len(partial) > tailMaxLine {
+				lines = append(lines, string(partial))
+				partial = nil
+			}
+			if len(lines) >= tailMaxBatch {
+				a.sendTail(job, path, lines, flags)
+				lines, flags = lines[:0], map[string]interface{}{}
+			}
+			if err == io.EOF || n == 0 {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if len(lines) > 0 || len(flags) > 0 {
+			a.sendTail(job, path, lines, flags)
+			flags = map[string]interface{}{}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		// Rotation replaces the file at path; copytruncate shrinks it
+		current, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		opened, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		switch {
+		case !os.SameFile(opened, current):
+			if offset < opened.Size() {
+				continue // finish the rotated file first
+			}
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f, offset, partial = next, 0, nil
+			flags["rotated"] = true
+		case current.Size() < offset:
+			offset, partial = 0, nil
+			flags["truncated"] = true
+		}
+	}
+}
+
+// tailStart returns the offset of the last n lines, looking at most 1 MiB
+// back from the end
+func tailStart(f *os.File, size int64, n int) (int64, error) {
+	if n == 0 || size == 0 {
+		return size, nil
+	}
+	start := max(size-1<<20, 0)
+	data := make([]byte, size-start)
+	if _, err := f.ReadAt(data, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	// A final newline ends the last line rather than starting a new one
+	end := len(data)
+	if data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			if n--; n == 0 {
+				return start + int64(i) + 1, nil
+			}
+		}
+	}
+	return start, nil
+}
+
+func (a *NOPAgent) sendTail(job *Job, path string, lines []string, flags map[string]interface{}) {
+	data := map[string]interface{}{
+		"type":      "file_tail_data",
+		"agent_id":  a.agentID,
+		"job_id":    job.ID,
+		"path":      path,
+		"lines":     lines,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+	for k, v := range flags {
+		data[k] = v
+	}
+	a.relayToC2(data)
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// FLOW CONTROL - Server-driven telemetry pacing
+// ============================================================================
+
+// Telemetry message types are subject to flow control; heartbeats and
+// command responses always go through
+var telemetryTypes = map[string]bool{
+	"asset_data":      true,
+	"traffic_data":    true,
+	"host_data":       true,
+	"network_change":  true,
+	"event_aggregate": true,
+}
+
+type flowControl struct {
+	mutex       sync.Mutex
+	pausedUntil time.Time
+	held        map[string]interface{}
+	maxRate     float64
+	lastSent    time.Time
+}
+
+// tokenBucket paces outbound bytes for the "max_kbps" limit
+type tokenBucket struct {
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// throttle blocks until the message fits within "max_kbps" (kilobits per
+// second, unlimited when unset); bursts of up to one second are allowed
+func (a *NOPAgent) throttle(v interface{}) {
+	kbps, ok := a.config["max_kbps"].(float64)
+	if !ok || kbps <= 0 {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	rate := kbps * 1000 / 8
+
+	b := &a.bandwidth
+	b.mutex.Lock()
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = rate
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > rate {
+			b.tokens = rate
+		}
+	}
+	b.last = now
+	b.tokens -= float64(len(data))
+	wait := time.Duration(0)
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / rate * float64(time.Second))
+	}
+	b.mutex.Unlock()
+
+	time.Sleep(wait)
+}
+
+// handleFlowControl honors pause_telemetry/resume_telemetry from an overloaded
+// C2. A pause may carry "duration_seconds" (auto-resume), and either message
+// may carry "max_rate" (telemetry messages per second, 0 = unlimited).
+func (a *NOPAgent) handleFlowControl(msgType string, msg map[string]interface{}) {
+	a.flow.mutex.Lock()
+	if rate, ok := msg["max_rate"].(float64); ok && rate >= 0 {
+		a.flow.maxRate = rate
+	}
+
+	var held map[string]interface{}
+	if msgType == "pause_telemetry" {
+		a.flow.pausedUntil = time.Now().Add(100 * 365 * 24 * time.Hour)
+		if d, ok := msg["duration_seconds"].(float64); ok && d > 0 {
+			a.flow.pausedUntil = time.Now().Add(time.Duration(d) * time.Second)
+		}
+		if a.flow.held == nil {
+			a.flow.held = make(map[string]interface{})
+		}
+	} else {
+		a.flow.pausedUntil = time.Time{}
+		held = a.flow.held
+		a.flow.held = nil
+	}
+	a.flow.mutex.Unlock()
+
+	log.Printf("[%s] Flow control: %s (max_rate=%v)", time.Now().Format(time.RFC3339), msgType, msg["max_rate"])
+
+	// Deliver the latest report of each type that was held during the pause
+	for _, data := range held {
+		a.relayToC2(data)
+	}
+}
+
+// admitTelemetry drops telemetry while paused (keeping the latest of each
+// type for delivery on resume) and spaces sends to honor max_rate
+func (a *NOPAgent) admitTelemetry(data interface{}) bool {
+	msgType := protocol.Type(data)
+	if !telemetryTypes[msgType] {
+		return true
+	}
+
+	a.flow.mutex.Lock()
+	if time.Now().Before(a.flow.pausedUntil) {
+		a.flow.held[msgType] = data
+		a.flow.mutex.Unlock()
+		return false
+	}
+
+	var wait time.Duration
+	if a.flow.maxRate > 0 {
+		next := a.flow.lastSent.Add(time.Duration(float64(time.Second) / a.flow.maxRate))
+		wait = time.Until(next)
+		if wait < 0 {
+			wait = 0
+		}
+	}
+	a.flow.lastSent = time.Now().Add(wait)
+	a.flow.mutex.Unlock()
+
+	time.Sleep(wait)
+	return true
+}
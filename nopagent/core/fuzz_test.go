@@ -0,0 +1,54 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// FuzzInboundMessage feeds arbitrary frames through the decode, decrypt and
+// validation steps MessageHandler applies before dispatching. Run it with:
+//
+//	go test ./core -run '^$' -fuzz FuzzInboundMessage
+func FuzzInboundMessage(f *testing.F) {
+	seeds := []string{
+		`{"type":"ping"}`,
+		`{"type":"command","command":"whoami","args":["-a"]}`,
+		`{"type":"command","command":42}`,
+		`{"type":"ack","seqs":[1,2,"x"]}`,
+		`{"type":"broadcast","message":{"type":"broadcast","message":{"type":"ping"}}}`,
+		`{"encrypted":true,"data":"AAAA","compression":"zstd","codec":"msgpack"}`,
+		`{"encrypted":true,"data":"not base64"}`,
+		`[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]`,
+		`{"type":""}`,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	agent := &NOPAgent{encryptionKey: []byte("fuzz"), config: map[string]interface{}{}}
+	agent.initCipher()
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg map[string]interface{}
+		if json.Unmarshal(data, &msg) == nil {
+			if encrypted, _ := msg["encrypted"].(bool); encrypted {
+				if inner, err := agent.openEnvelope(msg); err == nil {
+					validateInbound(inner)
+				}
+			}
+			validateInbound(msg)
+		}
+
+		// Binary codecs take the same bytes straight off the wire
+		for _, codec := range transport.Codecs {
+			var decoded map[string]interface{}
+			if transport.DecodeFrame(codec, data, &decoded) == nil {
+				validateInbound(decoded)
+			}
+		}
+		transport.Decompress("gzip", data)
+		transport.Decompress("zstd", data)
+	})
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ============================================================================
+// FILE HASHING - hash_files integrity sweeps done natively
+// ============================================================================
+
+// hashAlgorithms are the digests hash_files can compute
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+// handleHashFiles hashes every regular file matching "patterns" (globs in
+// filepath.Match syntax; directories are walked when "recursive" is set)
+// with each of "algorithms" (default sha256), reading each file once. At
+// most "max_files" (default 10000) are hashed.
+func (a *NOPAgent) handleHashFiles(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("hash_files", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+	patterns, _ := msg["patterns"].([]interface{})
+	if len(patterns) == 0 {
+		a.sendError("hash_files", msg, newAgentError(ErrInvalidRequest, "missing_patterns", "patterns is required"))
+		return
+	}
+	algorithms := []string{"sha256"}
+	if raw, ok := msg["algorithms"].([]interface{}); ok && len(raw) > 0 {
+		algorithms = algorithms[:0]
+		for _, val := range raw {
+			name, _ := val.(string)
+			if _, ok := hashAlgorithms[name]; !ok {
+				a.sendError("hash_files", msg, newAgentError(ErrInvalidRequest, "unsupported_algorithm", "algorithm %q is not one of sha256, sha1, md5", name))
+				return
+			}
+			algorithms = append(algorithms, name)
+		}
+	}
+	recursive, _ := msg["recursive"].(bool)
+	limit := 10000
+	if val, ok := msg["max_files"].(float64); ok && val > 0 {
+		limit = int(val)
+	}
+
+	started := time.Now()
+	files := make([]map[string]interface{}, 0)
+	seen := make(map[string]bool)
+	truncated := false
+	add := func(path string) {
+		if seen[path] {
+			return
+		}
+		if len(files) == limit {
+			truncated = true
+			return
+		}
+		seen[path] = true
+		files = append(files, a.hashFile(path, algorithms))
+	}
+	for _, val := range patterns {
+		pattern, _ := val.(string)
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			a.sendError("hash_files", msg, newAgentError(ErrInvalidRequest, "invalid_pattern", "%q: %v", pattern, err))
+			return
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				continue
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			if !recursive {
+				continue
+			}
+			filepath.WalkDir(match, func(path string, entry fs.DirEntry, err error) error {
+				if err == nil && entry.Type().IsRegular() {
+					add(path)
+				}
+				if truncated {
+					return filepath.SkipAll
+				}
+				return nil
+			})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i]["path"].(string) < files[j]["path"].(string) })
+
+	a.relayToC2(map[string]interface{}{
+		"type":        "hash_files_result",
+		"agent_id":    a.agentID,
+		"request_id":  msg["request_id"],
+		"algorithms":  algorithms,
+		"files":       files,
+		"truncated":   truncated,
+		"duration_ms": time.Since(started).Milliseconds(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// hashFile returns the metadata and digests of one file, or its error
+func (a *NOPAgent) hashFile(path string, algorithms []string) map[string]interface{} {
+	entry := map[string]interface{}{"path": path}
+	abs, err := filepath.Abs(path)
+	if err == nil {
+		err = a.cmdPolicy.checkPath(abs)
+	}
+	if err != nil {
+		entry["error"] = classifyError(err)
+		return entry
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		entry["error"] = classifyError(err)
+		return entry
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil {
+		for k, v := range fileEntry(path, info) {
+			entry[k] = v
+		}
+	}
+
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, name := range algorithms {
+		hashes[i] = hashAlgorithms[name]()
+		writers[i] = hashes[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		entry["error"] = classifyError(err)
+		return entry
+	}
+	for i, name := range algorithms {
+		entry[name] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return entry
+}
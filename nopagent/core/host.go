@@ -0,0 +1,55 @@
+package core
+
+import (
+	"log"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// HOST MODULE - Host system information and monitoring
+// ============================================================================
+func (a *NOPAgent) HostModule(beat func() bool) {
+	if !a.capabilities["host"] {
+		return
+	}
+	log.Printf("[%s] Host module started", time.Now().Format(time.RFC3339))
+
+	interval := 120 * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Send initial host info
+	a.sendHostInfo()
+
+	for a.running && beat() {
+		select {
+		case <-ticker.C:
+			ticker.Reset(a.powerAdjustedInterval(interval))
+			if a.moduleSuspended("host") {
+				continue
+			}
+			a.sendHostInfo()
+		}
+	}
+}
+
+func (a *NOPAgent) sendHostInfo() {
+	hostInfo := a.collectHostInfo()
+	a.relayToC2(protocol.HostData{
+		Type:      "host_data",
+		AgentID:   a.agentID,
+		Host:      hostInfo,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// collectHostInfo reports the simulated host when one is attached
+func (a *NOPAgent) collectHostInfo() map[string]interface{} {
+	if a.sim != nil {
+		return a.sim.hostInfo()
+	}
+	return modules.CollectHostInfo()
+}
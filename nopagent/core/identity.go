@@ -0,0 +1,101 @@
+package core
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// IDENTITY - Duplicate agent detection for cloned or re-imaged hosts
+// ============================================================================
+
+// agentIdentity is persisted in the state directory and binds the agent ID in
+// use to the host it was issued for
+type agentIdentity struct {
+	AgentID     string `json:"agent_id"`
+	ParentID    string `json:"parent_agent_id"`
+	AuthToken   string `json:"auth_token,omitempty"`
+	TokenExpiry string `json:"token_expires_at,omitempty"` // RFC3339, empty if the token does not expire
+	// The token is held by the platform keystore rather than this file
+	TokenInKeystore bool   `json:"token_in_keystore,omitempty"`
+	Fingerprint     string `json:"fingerprint"`
+}
+
+func (a *NOPAgent) identityPath() string {
+	return filepath.Join(a.stateDir(), "identity.json")
+}
+
+// loadIdentity adopts a sub-identity previously assigned to this host; state
+// recorded under a different fingerprint is reported at registration instead
+func (a *NOPAgent) loadIdentity() {
+	a.fingerprint = modules.HostFingerprint()
+
+	var stored agentIdentity
+	err := a.readState(a.identityPath(), "identity", &stored)
+	if err != nil || stored.ParentID != a.identity.AgentID {
+		a.saveIdentity(agentIdentity{AgentID: a.agentID, ParentID: a.identity.AgentID, Fingerprint: a.fingerprint})
+		return
+	}
+
+	if stored.Fingerprint != a.fingerprint {
+		a.previousPrint = stored.Fingerprint
+		log.Printf("[%s] Host fingerprint changed since last run - possible cloned agent", time.Now().Format(time.RFC3339))
+		return
+	}
+
+	a.agentID = stored.AgentID
+	if token := a.loadToken(stored); token != "" {
+		// A refreshed token supersedes the embedded one, expiry included
+		expiry, _ := parseTokenExpiry(stored.TokenExpiry)
+		a.setToken(token, expiry)
+	}
+}
+
+func (a *NOPAgent) saveIdentity(identity agentIdentity) error {
+	a.storeToken(&identity)
+	return a.writeState(a.identityPath(), "identity", identity)
+}
+
+// handleIdentityConflict asks the C2 for a sub-identity when another host is
+// already connected under this agent ID
+func (a *NOPAgent) handleIdentityConflict(msg map[string]interface{}) {
+	log.Printf("[%s] C2 reports identity conflict for %s - requesting sub-identity",
+		time.Now().Format(time.RFC3339), a.agentID)
+	a.writeJSON(map[string]interface{}{
+		"type":            "identity_request",
+		"agent_id":        a.agentID,
+		"parent_agent_id": a.identity.AgentID,
+		"fingerprint":     a.fingerprint,
+		"conflict_with":   msg["fingerprint"],
+		"timestamp":       time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleIdentityAssigned switches to the sub-identity issued by the C2 and
+// reconnects so the session is registered under it
+func (a *NOPAgent) handleIdentityAssigned(msg map[string]interface{}) {
+	agentID, _ := msg["agent_id"].(string)
+	if agentID == "" {
+		a.sendError("identity_assigned", msg, newAgentError(ErrInvalidRequest, "missing_agent_id", "agent_id is required"))
+		return
+	}
+	token, _ := msg["auth_token"].(string)
+	rawExpiry, _ := msg["expires_at"].(string)
+	expiry, _ := parseTokenExpiry(rawExpiry)
+
+	identity := agentIdentity{AgentID: agentID, ParentID: a.identity.AgentID, AuthToken: token, TokenExpiry: formatExpiry(expiry), Fingerprint: a.fingerprint}
+	if err := a.saveIdentity(identity); err != nil {
+		log.Printf("[%s] Could not persist identity: %v", time.Now().Format(time.RFC3339), err)
+	}
+
+	log.Printf("[%s] Assigned sub-identity %s (parent %s)", time.Now().Format(time.RFC3339), agentID, a.identity.AgentID)
+	a.agentID = agentID
+	if token != "" {
+		a.setToken(token, expiry)
+	}
+	a.previousPrint = ""
+	a.closeConn()
+}
@@ -0,0 +1,458 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ============================================================================
+// JOBS - Tasks from the C2 run off the read loop in a bounded worker pool
+// ============================================================================
+
+// Job is one task from the C2. Commands use their command_id as job ID;
+// other tasks take "job_id" or get a random one.
+type Job struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Command    string                 `json:"command,omitempty"`
+	Class      string                 `json:"class,omitempty"`
+	Operator   string                 `json:"operator,omitempty"`
+	Module     string                 `json:"module,omitempty"`
+	ScheduleID string                 `json:"schedule_id,omitempty"`
+	Status     string                 `json:"status"` // pending_approval, queued, running, completed, failed, cancelled, rejected, interrupted
+	QueuedAt   string                 `json:"queued_at"`
+	StartedAt  string                 `json:"started_at,omitempty"`
+	FinishedAt string                 `json:"finished_at,omitempty"`
+	Error      *AgentError            `json:"error,omitempty"`
+	msg        map[string]interface{} `json:"-"`
+	run        func(ctx context.Context) error
+	cancel     context.CancelFunc // set while running if run honours ctx
+}
+
+// active reports whether the job still waits or runs
+func (j *Job) active() bool {
+	return j.Status == "pending_approval" || j.Status == "queued" || j.Status == "running"
+}
+
+// taskHandlers are the requests that run as jobs instead of in the read
+// loop. Their handlers take no context, so these jobs cannot be cancelled
+// once running.
+var taskHandlers = map[string]func(*NOPAgent, map[string]interface{}){
+	"collect":        (*NOPAgent).handleCollect,
+	"export_assets":  (*NOPAgent).handleExportAssets,
+	"file_get":       (*NOPAgent).handleFileGet,
+	"hash_files":     (*NOPAgent).handleHashFiles,
+	"http_request":   (*NOPAgent).handleHTTPRequest,
+	"dns_lookup":     (*NOPAgent).handleDNSLookup,
+	"mail_probe":     (*NOPAgent).handleMailProbe,
+	"db_probe":       (*NOPAgent).handleDBProbe,
+	"k8s_probe":      (*NOPAgent).handleK8sProbe,
+	"cloud_exposure": (*NOPAgent).handleCloudExposure,
+	"proc_list":      (*NOPAgent).handleProcList,
+	"proc_inspect":   (*NOPAgent).handleProcInspect,
+	"proc_kill":      (*NOPAgent).handleProcKill,
+	"proc_start":     (*NOPAgent).handleProcStart,
+}
+
+// cancellableJobs are the job types whose run stops when its context is
+// cancelled
+var cancellableJobs = map[string]bool{"command": true, "script": true, "file_tail": true}
+
+// followJobs run until cancelled, so they start at once and do not hold
+// one of the maxJobs worker slots
+var followJobs = map[string]bool{"file_tail": true}
+
+// submitTask queues a task request as a job and tells the C2 its ID
+func (a *NOPAgent) submitTask(msg map[string]interface{}) {
+	reqType, _ := msg["type"].(string)
+	id, _ := msg["job_id"].(string)
+	if id == "" {
+		id = newID()
+	}
+	job := &Job{ID: id, Type: reqType, Status: "queued", msg: msg}
+	if reqType == "proc_start" {
+		// Starting a process is a raw command and is held like one
+		spec, err := a.procStartSpec(msg)
+		if err != nil {
+			a.sendError(reqType, msg, err)
+			return
+		}
+		job.Command = spec.line
+		job.Class = approvalClass(reqType)
+		job.Operator, _ = msg["operator"].(string)
+	}
+	a.bindJob(job)
+	if job.Class != "" {
+		a.holdForApproval(job)
+	}
+	a.enqueueJob(job)
+}
+
+// bindJob sets what a job runs from its type, so jobs restored from disk
+// can run too
+func (a *NOPAgent) bindJob(job *Job) bool {
+	switch job.Type {
+	case "command", "script":
+		// Jobs and schedules stored by a build with a looser policy
+		if _, err := a.commandSpec(job.msg); err != nil {
+			return false
+		}
+		job.run = func(ctx context.Context) error { return a.runCommand(ctx, job) }
+	case "file_tail":
+		job.run = func(ctx context.Context) error { return a.runFileTail(ctx, job) }
+	case "module":
+		module, ok := scheduledModules[job.Module]
+		if !ok {
+			return false
+		}
+		job.run = func(context.Context) error {
+			module.run(a)
+			return nil
+		}
+	default:
+		handle, ok := taskHandlers[job.Type]
+		if !ok {
+			return false
+		}
+		job.run = func(context.Context) error {
+			handle(a, job.msg)
+			return nil
+		}
+	}
+	return true
+}
+
+func (a *NOPAgent) enqueueJob(job *Job) {
+	job.QueuedAt = time.Now().UTC().Format(time.RFC3339)
+	a.jobMutex.Lock()
+	a.jobs = append(a.jobs, job)
+	a.lastTaskAt = time.Now()
+	a.jobMutex.Unlock()
+
+	a.relayToC2(map[string]interface{}{
+		"type":      "job_queued",
+		"agent_id":  a.agentID,
+		"job_id":    job.ID,
+		"job_type":  job.Type,
+		"status":    job.Status,
+		"timestamp": job.QueuedAt,
+	})
+	a.wakeJobs()
+}
+
+func (a *NOPAgent) wakeJobs() {
+	select {
+	case a.jobSignal <- struct{}{}:
+	default:
+	}
+}
+
+// maxJobs is the worker pool size, "max_concurrent_jobs" (default 4). It is
+// read on every scheduling pass, so settings updates apply to the next job.
+func (a *NOPAgent) maxJobs() int {
+	if val, ok := a.config["max_concurrent_jobs"].(float64); ok && val >= 1 {
+		return int(val)
+	}
+	return 4
+}
+
+// JobScheduler starts queued jobs in arrival order while fewer than
+// maxJobs are running; followJobs start right away
+func (a *NOPAgent) JobScheduler() {
+	for a.running {
+		a.jobMutex.Lock()
+		running := 0
+		for _, job := range a.jobs {
+			if job.Status == "running" && !followJobs[job.Type] {
+				running++
+			}
+		}
+		for _, job := range a.jobs {
+			if job.Status != "queued" || running >= a.maxJobs() && !followJobs[job.Type] {
+				continue
+			}
+			job.Status = "running"
+			job.StartedAt = time.Now().UTC().Format(time.RFC3339)
+			if !followJobs[job.Type] {
+				running++
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			if cancellableJobs[job.Type] {
+				job.cancel = cancel
+			}
+			go a.runJob(ctx, cancel, job)
+		}
+		a.jobMutex.Unlock()
+
+		<-a.jobSignal
+	}
+}
+
+// runJob runs one job, records the outcome and reports it as job_completed
+func (a *NOPAgent) runJob(ctx context.Context, cancel context.CancelFunc, job *Job) {
+	started := time.Now()
+	err := job.run(ctx)
+	cancel()
+
+	a.jobMutex.Lock()
+	job.cancel = nil
+	job.Status = "completed"
+	if errors.Is(err, context.Canceled) {
+		job.Status = "cancelled"
+	} else if err != nil {
+		job.Status = "failed"
+		job.Error = classifyError(err)
+	}
+	job.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	a.pruneJobs()
+	a.jobMutex.Unlock()
+	a.wakeJobs()
+
+	if job.Error != nil {
+		log.Printf("[%s] Job %s (%s) failed: %v", time.Now().Format(time.RFC3339), job.ID, job.Type, err)
+	}
+	event := map[string]interface{}{
+		"type":        "job_completed",
+		"agent_id":    a.agentID,
+		"job_id":      job.ID,
+		"job_type":    job.Type,
+		"status":      job.Status,
+		"started_at":  job.StartedAt,
+		"duration_ms": time.Since(started).Milliseconds(),
+		"timestamp":   job.FinishedAt,
+	}
+	if job.Error != nil {
+		event["error"] = job.Error
+	}
+	a.relayToC2(event)
+}
+
+// pruneJobs keeps the last "job_history" (default 100) finished jobs for
+// job_status; the caller holds jobMutex
+func (a *NOPAgent) pruneJobs() {
+	keep := 100
+	if val, ok := a.config["job_history"].(float64); ok && val >= 0 {
+		keep = int(val)
+	}
+	finished := 0
+	for _, job := range a.jobs {
+		if !job.active() {
+			finished++
+		}
+	}
+	pruned := a.jobs[:0]
+	for _, job := range a.jobs {
+		if !job.active() && finished > keep {
+			finished--
+			continue
+		}
+		pruned = append(pruned, job)
+	}
+	a.jobs = pruned
+}
+
+// activeJobs counts jobs waiting or running
+func (a *NOPAgent) activeJobs() int {
+	a.jobMutex.Lock()
+	defer a.jobMutex.Unlock()
+	count := 0
+	for _, job := range a.jobs {
+		if job.active() {
+			count++
+		}
+	}
+	return count
+}
+
+// handleJobList reports jobs, optionally only those with "status"
+func (a *NOPAgent) handleJobList(msg map[string]interface{}) {
+	status, _ := msg["status"].(string)
+	a.jobMutex.Lock()
+	jobs := make([]Job, 0, len(a.jobs))
+	running := 0
+	for _, job := range a.jobs {
+		if job.Status == "running" {
+			running++
+		}
+		if status == "" || job.Status == status {
+			jobs = append(jobs, *job)
+		}
+	}
+	a.jobMutex.Unlock()
+
+	a.relayToC2(map[string]interface{}{
+		"type":      "job_list_result",
+		"agent_id":  a.agentID,
+		"jobs":      jobs,
+		"running":   running,
+		"limit":     a.maxJobs(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (a *NOPAgent) handleJobStatus(msg map[string]interface{}) {
+	id, _ := msg["job_id"].(string)
+	a.jobMutex.Lock()
+	var found *Job
+	// A reused ID refers to its latest job
+	for i := len(a.jobs) - 1; i >= 0; i-- {
+		if a.jobs[i].ID == id {
+			copied := *a.jobs[i]
+			found = &copied
+			break
+		}
+	}
+	a.jobMutex.Unlock()
+	if found == nil {
+		a.sendError("job_status", msg, newAgentError(ErrNotFound, "unknown_job", "no job with id %q", id))
+		return
+	}
+
+	a.relayToC2(map[string]interface{}{
+		"type":      "job_status_result",
+		"agent_id":  a.agentID,
+		"job":       found,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleJobCancel stops a job: queued ones never start, running commands
+// are killed with their whole process group and end as cancelled
+func (a *NOPAgent) handleJobCancel(msg map[string]interface{}) {
+	id, _ := msg["job_id"].(string)
+	a.jobMutex.Lock()
+	var target *Job
+	for _, job := range a.jobs {
+		if job.ID == id && job.active() {
+			target = job
+			break
+		}
+	}
+	var cancelErr *AgentError
+	switch {
+	case target == nil:
+		cancelErr = newAgentError(ErrNotFound, "unknown_job", "no waiting or running job with id %q", id)
+	case target.Status != "running":
+		target.Status = "cancelled"
+		target.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		a.pruneJobs()
+	case target.cancel == nil:
+		cancelErr = newAgentError(ErrNotSupported, "not_cancellable", "running %s jobs cannot be cancelled", target.Type)
+	default:
+		// runJob records the outcome once the process has exited
+		target.cancel()
+	}
+	a.jobMutex.Unlock()
+
+	if cancelErr != nil {
+		a.sendError("job_cancel", msg, cancelErr)
+		return
+	}
+	log.Printf("[%s] Cancelled job %s", time.Now().Format(time.RFC3339), id)
+	a.relayToC2(map[string]interface{}{
+		"type":      "job_cancel_result",
+		"agent_id":  a.agentID,
+		"job_id":    id,
+		"cancelled": true,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// storedJob is a job as written to the state directory at shutdown
+type storedJob struct {
+	Job
+	Msg map[string]interface{} `json:"msg"`
+}
+
+func (a *NOPAgent) jobsPath() string {
+	return filepath.Join(a.stateDir(), "jobs.json")
+}
+
+// stopJobs saves waiting and running jobs for the next start, then kills
+// running commands so they do not outlive the agent
+func (a *NOPAgent) stopJobs() {
+	a.jobMutex.Lock()
+	stored := make([]storedJob, 0)
+	for _, job := range a.jobs {
+		if job.active() {
+			stored = append(stored, storedJob{Job: *job, Msg: job.msg})
+		}
+		if job.cancel != nil {
+			job.cancel()
+		}
+	}
+	a.jobMutex.Unlock()
+	if len(stored) == 0 {
+		return
+	}
+	if err := a.writeState(a.jobsPath(), "jobs", stored); err != nil {
+		log.Printf("[%s] Saving job queue failed: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+	log.Printf("[%s] Saved %d jobs for the next start", time.Now().Format(time.RFC3339), len(stored))
+}
+
+// loadJobs requeues the jobs saved at the last shutdown. Jobs that were
+// already running are not started again, since they may have had effects;
+// they are recorded as interrupted.
+func (a *NOPAgent) loadJobs() {
+	var stored []storedJob
+	err := a.readState(a.jobsPath(), "jobs", &stored)
+	if os.IsNotExist(err) {
+		return
+	}
+	os.Remove(a.jobsPath())
+	if err != nil {
+		log.Printf("[%s] Saved job queue unreadable: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+
+	a.jobMutex.Lock()
+	defer a.jobMutex.Unlock()
+	for i := range stored {
+		job := stored[i].Job
+		job.msg = stored[i].Msg
+		if job.Status == "running" || !a.bindJob(&job) {
+			job.Status = "interrupted"
+			job.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		}
+		a.jobs = append(a.jobs, &job)
+		a.restoredJobs = append(a.restoredJobs, &job)
+	}
+	log.Printf("[%s] Restored %d saved jobs", time.Now().Format(time.RFC3339), len(stored))
+}
+
+// reportResumedJobs tells the C2, once, which jobs were restored after a
+// restart and which were lost mid-run
+func (a *NOPAgent) reportResumedJobs() {
+	a.jobMutex.Lock()
+	restored := len(a.restoredJobs)
+	resumed, interrupted := make([]string, 0), make([]string, 0)
+	for _, job := range a.restoredJobs {
+		if job.Status == "interrupted" {
+			interrupted = append(interrupted, job.ID)
+		} else {
+			resumed = append(resumed, job.ID)
+		}
+	}
+	a.jobMutex.Unlock()
+	if restored == 0 {
+		return
+	}
+	err := a.writeJSON(map[string]interface{}{
+		"type":        "jobs_resumed",
+		"agent_id":    a.agentID,
+		"resumed":     resumed,
+		"interrupted": interrupted,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+	if err == nil {
+		a.jobMutex.Lock()
+		a.restoredJobs = nil
+		a.jobMutex.Unlock()
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleK8sProbe checks a host for Kubernetes components that answer without
+// credentials: the kubelet read-only and authenticated ports, the API server
+// and etcd. Only counts and versions are reported, never object contents.
+func (a *NOPAgent) handleK8sProbe(msg map[string]interface{}) {
+	host, _ := msg["host"].(string)
+	if host == "" {
+		a.sendError("k8s_probe", msg, newAgentError(ErrInvalidRequest, "missing_host", "host is required"))
+		return
+	}
+
+	client := &http.Client{
+		Timeout:   8 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	get := func(rawURL string) (int, []byte, error) {
+		resp, err := client.Get(rawURL)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+		return resp.StatusCode, body, err
+	}
+	base := func(scheme string, port int) string {
+		return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	checks := make(map[string]interface{})
+	findings := make([]map[string]interface{}, 0)
+	finding := func(component, severity, detail string) {
+		findings = append(findings, map[string]interface{}{"component": component, "severity": severity, "detail": detail})
+	}
+	podSummary := func(body []byte) map[string]interface{} {
+		var pods struct {
+			Items []struct {
+				Metadata struct {
+					Namespace string `json:"namespace"`
+				} `json:"metadata"`
+			} `json:"items"`
+		}
+		if json.Unmarshal(body, &pods) != nil {
+			return nil
+		}
+		namespaces := make(map[string]bool)
+		for _, p := range pods.Items {
+			namespaces[p.Metadata.Namespace] = true
+		}
+		names := make([]string, 0, len(namespaces))
+		for ns := range namespaces {
+			names = append(names, ns)
+		}
+		sort.Strings(names)
+		return map[string]interface{}{"pods": len(pods.Items), "namespaces": names}
+	}
+
+	// Kubelet read-only port: unauthenticated by design when enabled
+	if status, body, err := get(base("http", 10255) + "/pods"); err == nil {
+		check := map[string]interface{}{"status": status}
+		if status == http.StatusOK {
+			check["anonymous"] = true
+			check["summary"] = podSummary(body)
+			finding("kubelet_readonly", "high", "kubelet read-only port 10255 lists pods without authentication")
+		}
+		checks["kubelet_readonly"] = check
+	}
+
+	// Kubelet API: anonymous-auth with AlwaysAllow exposes pods and exec
+	if status, body, err := get(base("https", 10250) + "/pods"); err == nil {
+		check := map[string]interface{}{"status": status, "anonymous": status == http.StatusOK}
+		if status == http.StatusOK {
+			check["summary"] = podSummary(body)
+			finding("kubelet", "critical", "kubelet API on 10250 accepts anonymous requests")
+		}
+		checks["kubelet"] = check
+	}
+
+	// API server: /version is commonly public; listing namespaces must not be
+	if status, body, err := get(base("https", 6443) + "/version"); err == nil {
+		check := map[string]interface{}{"status": status}
+		var version map[string]interface{}
+		if status == http.StatusOK && json.Unmarshal(body, &version) == nil {
+			check["version"] = version["gitVersion"]
+		}
+		if nsStatus, _, err := get(base("https", 6443) + "/api/v1/namespaces"); err == nil {
+			check["namespaces_status"] = nsStatus
+			switch nsStatus {
+			case http.StatusOK:
+				check["anonymous"] = "full"
+				finding("apiserver", "critical", "API server lets anonymous users list namespaces")
+			case http.StatusForbidden:
+				check["anonymous"] = "authenticated_as_anonymous"
+				finding("apiserver", "low", "API server accepts anonymous authentication but RBAC denies access")
+			default:
+				check["anonymous"] = "disabled"
+			}
+		}
+		checks["apiserver"] = check
+	}
+
+	// etcd: client-cert auth rejects the TLS handshake outright
+	for _, scheme := range []string{"http", "https"} {
+		status, body, err := get(base(scheme, 2379) + "/version")
+		if err != nil {
+			if scheme == "https" && strings.Contains(err.Error(), "certificate") {
+				checks["etcd"] = map[string]interface{}{"tls": true, "client_cert_required": true}
+			}
+			continue
+		}
+		check := map[string]interface{}{"status": status, "tls": scheme == "https"}
+		var version map[string]interface{}
+		if status == http.StatusOK && json.Unmarshal(body, &version) == nil {
+			check["version"] = version["etcdserver"]
+			// count_only returns the number of keys without their values
+			req := strings.NewReader(`{"key":"AA==","range_end":"AA==","count_only":true}`)
+			if resp, err := client.Post(base(scheme, 2379)+"/v3/kv/range", "application/json", req); err == nil {
+				var count map[string]interface{}
+				json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&count)
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					check["anonymous"] = true
+					check["keys"] = count["count"]
+					finding("etcd", "critical", "etcd on 2379 answers key range queries without client authentication")
+				}
+			}
+		}
+		checks["etcd"] = check
+		break
+	}
+
+	if net.ParseIP(host) != nil && len(checks) > 0 {
+		a.cacheAssets([]map[string]interface{}{{"ip": host, "kubernetes": checks, "kubernetes_findings": findings}})
+	}
+
+	a.writeJSON(map[string]interface{}{
+		"type":       "k8s_probe_result",
+		"agent_id":   a.agentID,
+		"request_id": msg["request_id"],
+		"host":       host,
+		"checks":     checks,
+		"findings":   findings,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/keystore"
+)
+
+// ============================================================================
+// KEYSTORE - Refreshed tokens in the platform keystore (-tags keystore)
+// ============================================================================
+
+// keystoreTokenName is the keystore entry holding the current auth token
+const keystoreTokenName = "auth_token"
+
+// openKeystore uses the platform keystore when the build includes one,
+// unless "keystore" is false in the config. Keys derived from the embedded
+// key are never persisted, so only issued tokens are stored there.
+func (a *NOPAgent) openKeystore() {
+	if enabled, ok := a.config["keystore"].(bool); ok && !enabled {
+		return
+	}
+	keys, err := keystore.Open(ServiceName, filepath.Join(a.stateDir(), "keys"))
+	if err != nil {
+		if err != keystore.ErrUnavailable {
+			log.Printf("[%s] Platform keystore unavailable: %v", time.Now().Format(time.RFC3339), err)
+		}
+		return
+	}
+	a.keys = keys
+	log.Printf("[%s] Using platform keystore: %s", time.Now().Format(time.RFC3339), keys.Name())
+}
+
+// keystoreName is reported at registration, empty without a keystore
+func (a *NOPAgent) keystoreName() string {
+	if a.keys == nil {
+		return ""
+	}
+	return a.keys.Name()
+}
+
+// storeToken moves the token of identity into the keystore. Backends that
+// survive a reboot replace the copy in the state file; the Linux kernel
+// keyring does not, so the sealed file keeps its copy as well.
+func (a *NOPAgent) storeToken(identity *agentIdentity) {
+	if a.keys == nil || identity.AuthToken == "" {
+		return
+	}
+	if err := a.keys.Put(keystoreTokenName, []byte(identity.AuthToken)); err != nil {
+		log.Printf("[%s] Could not store token in keystore: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+	if a.keys.Persistent() {
+		identity.AuthToken = ""
+		identity.TokenInKeystore = true
+	}
+}
+
+// loadToken returns the token for a stored identity, preferring the
+// keystore's copy
+func (a *NOPAgent) loadToken(identity agentIdentity) string {
+	if a.keys != nil && (identity.TokenInKeystore || identity.AuthToken != "") {
+		if token, err := a.keys.Get(keystoreTokenName); err == nil {
+			return string(token)
+		} else if identity.TokenInKeystore {
+			log.Printf("[%s] Stored token missing from keystore: %v", time.Now().Format(time.RFC3339), err)
+		}
+	}
+	return identity.AuthToken
+}
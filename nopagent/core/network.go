@@ -0,0 +1,72 @@
+package core
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// NETWORK MODULE - Roaming detection for mobile endpoints
+// ============================================================================
+func (a *NOPAgent) NetworkModule(beat func() bool) {
+	if a.sim != nil {
+		return
+	}
+	log.Printf("[%s] Network module started", time.Now().Format(time.RFC3339))
+
+	ticker := time.NewTicker(a.interval("network_check_interval", 15*time.Second))
+	defer ticker.Stop()
+
+	if a.networkState == nil {
+		a.networkState = modules.CollectNetworkState()
+	}
+
+	for a.running && beat() {
+		select {
+		case <-ticker.C:
+			a.checkNetworkChange()
+		}
+	}
+}
+
+func (a *NOPAgent) checkNetworkChange() {
+	current := modules.CollectNetworkState()
+	previous := a.networkState
+
+	changes := make([]string, 0)
+	if current.Gateway != previous.Gateway {
+		changes = append(changes, "gateway")
+	}
+	if current.SSID != previous.SSID {
+		changes = append(changes, "ssid")
+	}
+	if strings.Join(current.Addresses, ",") != strings.Join(previous.Addresses, ",") {
+		changes = append(changes, "addresses")
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	a.networkState = current
+	log.Printf("[%s] Network change detected (%s): gateway=%s ssid=%s", time.Now().Format(time.RFC3339),
+		strings.Join(changes, ", "), current.Gateway, current.SSID)
+
+	// Flapping between the same networks is coalesced into one aggregate
+	a.emitEvent("network_change|"+current.Gateway+"|"+current.SSID, protocol.NetworkChangeData{
+		Type:      "network_change",
+		AgentID:   a.agentID,
+		Previous:  previous,
+		Current:   current,
+		Changes:   changes,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+
+	// Report the newly visited network right away instead of waiting for the next interval
+	if a.capabilities["asset"] {
+		a.discoverAssets()
+	}
+}
@@ -0,0 +1,363 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+	"github.com/goranjovic55/NOP/nopagent/storage"
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// ============================================================================
+// BUILD OPTIONS - Per-build choices made by the generator
+// ============================================================================
+
+// buildModules are the collectors a build can include, by capability name
+var buildModules = map[string]bool{"asset": true, "traffic": true, "host": true, "access": true}
+
+// subCapabilities enable optional stages of a module and need that module
+var subCapabilities = map[string]string{"port_scan": "asset"}
+
+// Profiles constrain the other options: "monitoring" is for consented
+// deployments and needs visible mode, "passive" never probes the network
+var buildProfiles = map[string]bool{"standard": true, "monitoring": true, "passive": true}
+
+// activeProbes are the requests that contact other hosts on the C2's
+// behalf; "passive" builds refuse them whatever their capabilities
+var activeProbes = map[string]bool{"mail_probe": true, "db_probe": true, "k8s_probe": true, "cloud_exposure": true, "http_request": true}
+
+// probesNetwork reports whether a request is an active probe. dns_lookup is
+// one only when it names its own server; the host resolvers are not probed.
+func probesNetwork(msgType string, msg map[string]interface{}) bool {
+	if msgType == "dns_lookup" {
+		server, _ := msg["server"].(string)
+		return server != ""
+	}
+	return activeProbes[msgType]
+}
+
+// buildOptions are rendered as JSON into the template, e.g.
+// {"transports": ["wss", "https"], "modules": ["asset", "host"],
+// "profile": "monitoring", "sinks": [{"type": "c2"}]}. Unlike Config they
+// are fixed for the life of the binary; empty fields mean no restriction.
+type buildOptions struct {
+	Transports  []string   `json:"transports,omitempty"`
+	Obfuscation bool       `json:"obfuscation,omitempty"`
+	Modules     []string   `json:"modules,omitempty"`
+	Guardrails  guardrails `json:"guardrails,omitempty"`
+	Profile     string     `json:"profile,omitempty"`
+	Sinks       []sinkSpec `json:"sinks,omitempty"`
+}
+
+// guardrails keep the agent from running outside its intended environment
+type guardrails struct {
+	Hostnames []string `json:"hostnames,omitempty"` // glob patterns
+	Domains   []string `json:"domains,omitempty"`   // DNS suffixes of the host's name
+	Networks  []string `json:"networks,omitempty"`  // CIDRs, one must hold a local address
+	NotAfter  string   `json:"not_after,omitempty"` // RFC3339
+}
+
+// sinkSpec is a destination for reports: "c2" (required) or "file", which
+// appends each report the data policy allows to Path as a sealed record
+// ("nop-agent decrypt" prints them), or as a JSON line with Plaintext
+type sinkSpec struct {
+	Type      string `json:"type"`
+	Path      string `json:"path,omitempty"`
+	Plaintext bool   `json:"plaintext,omitempty"`
+}
+
+// fileSink appends reports to a local file
+type fileSink struct {
+	path   string
+	sealed *storage.Log
+	mutex  sync.Mutex
+}
+
+// loadBuildOptions parses the embedded options. An unrendered placeholder
+// means an unrestricted build; unreadable options are an error, returned
+// with the unrestricted defaults.
+func loadBuildOptions(raw string) (*buildOptions, error) {
+	defaults := &buildOptions{Profile: "standard"}
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasPrefix(raw, "{{") {
+		return defaults, nil
+	}
+	options := &buildOptions{}
+	if err := json.Unmarshal([]byte(raw), options); err != nil {
+		return defaults, fmt.Errorf("invalid build options: %v", err)
+	}
+	if options.Profile == "" {
+		options.Profile = "standard"
+	}
+	return options, nil
+}
+
+// validate rejects option combinations the agent cannot honor, so a bad
+// build fails at startup instead of misbehaving in the field
+func (o *buildOptions) validate(identity Identity) error {
+	for _, scheme := range o.Transports {
+		if _, ok := transport.Schemes[scheme]; !ok {
+			return fmt.Errorf("unknown transport %q", scheme)
+		}
+	}
+	override, _ := identity.Config["transport"].(string)
+	for _, endpoint := range strings.Split(identity.ServerURL, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid server URL %q: %v", endpoint, err)
+		}
+		scheme := u.Scheme
+		if override != "" {
+			scheme = override
+		}
+		if !o.allowsTransport(scheme) {
+			return fmt.Errorf("server URL %s needs transport %q, which is not in this build", endpoint, scheme)
+		}
+	}
+
+	included := make(map[string]bool)
+	for _, module := range o.Modules {
+		if !buildModules[module] {
+			return fmt.Errorf("unknown module %q", module)
+		}
+		included[module] = true
+	}
+	for capability, enabled := range identity.Capabilities {
+		module := capability
+		if parent, ok := subCapabilities[capability]; ok {
+			module = parent
+		}
+		if enabled && len(o.Modules) > 0 && !included[module] {
+			return fmt.Errorf("capability %q is enabled but its module is not in this build", capability)
+		}
+	}
+
+	visible := identity.VisibleMode == "true"
+	if o.Obfuscation && visible {
+		return fmt.Errorf("obfuscation cannot be combined with visible mode")
+	}
+	if !buildProfiles[o.Profile] {
+		return fmt.Errorf("unknown profile %q", o.Profile)
+	}
+	switch o.Profile {
+	case "monitoring":
+		if !visible {
+			return fmt.Errorf("profile \"monitoring\" requires visible mode")
+		}
+		if o.Obfuscation {
+			return fmt.Errorf("profile \"monitoring\" cannot be obfuscated")
+		}
+	case "passive":
+		if identity.Capabilities["access"] || included["access"] {
+			return fmt.Errorf("profile \"passive\" cannot include the access module")
+		}
+		if identity.Capabilities["port_scan"] {
+			return fmt.Errorf("profile \"passive\" cannot enable port_scan")
+		}
+	}
+
+	for _, pattern := range o.Guardrails.Hostnames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid hostname guardrail %q: %v", pattern, err)
+		}
+	}
+	for _, cidr := range o.Guardrails.Networks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid network guardrail %q: %v", cidr, err)
+		}
+	}
+	if o.Guardrails.NotAfter != "" {
+		if _, err := time.Parse(time.RFC3339, o.Guardrails.NotAfter); err != nil {
+			return fmt.Errorf("invalid not_after guardrail: %v", err)
+		}
+	}
+
+	c2Sinks := 0
+	for _, sink := range o.Sinks {
+		switch sink.Type {
+		case "c2":
+			c2Sinks++
+		case "file":
+			if sink.Path == "" {
+				return fmt.Errorf("file sink needs a path")
+			}
+		default:
+			return fmt.Errorf("unknown sink type %q", sink.Type)
+		}
+	}
+	if len(o.Sinks) > 0 && c2Sinks == 0 {
+		return fmt.Errorf("sinks must include the c2; this agent does not run offline")
+	}
+	if strings.TrimSpace(identity.ServerURL) == "" {
+		return fmt.Errorf("the c2 sink needs at least one server URL")
+	}
+	return nil
+}
+
+// checkGuardrails reports why the agent must not run on this host, if it
+// must not
+func (o *buildOptions) checkGuardrails() error {
+	g := o.Guardrails
+	if g.NotAfter != "" {
+		if notAfter, _ := time.Parse(time.RFC3339, g.NotAfter); time.Now().After(notAfter) {
+			return fmt.Errorf("build expired at %s", g.NotAfter)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	hostname = strings.ToLower(hostname)
+	if len(g.Hostnames) > 0 {
+		matched := false
+		for _, pattern := range g.Hostnames {
+			if ok, _ := path.Match(strings.ToLower(pattern), hostname); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("hostname %q is not in the build's guardrails", hostname)
+		}
+	}
+
+	if len(g.Domains) > 0 {
+		names := []string{hostname}
+		if cname, err := net.LookupCNAME(hostname); err == nil {
+			names = append(names, strings.ToLower(strings.TrimSuffix(cname, ".")))
+		}
+		matched := false
+		for _, domain := range g.Domains {
+			domain = strings.ToLower(strings.Trim(domain, "."))
+			for _, name := range names {
+				if strings.HasSuffix(name, "."+domain) {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return fmt.Errorf("host is not in any of the build's domains %v", g.Domains)
+		}
+	}
+
+	if len(g.Networks) > 0 {
+		matched := false
+		for _, cidr := range g.Networks {
+			_, network, _ := net.ParseCIDR(cidr)
+			for _, ip := range modules.GlobalAddresses() {
+				if network.Contains(ip) {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return fmt.Errorf("no local address is in the build's networks %v", g.Networks)
+		}
+	}
+	return nil
+}
+
+// allowsTransport reports whether scheme was compiled into this build
+func (o *buildOptions) allowsTransport(scheme string) bool {
+	if len(o.Transports) == 0 {
+		return true
+	}
+	for _, allowed := range o.Transports {
+		if allowed == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// fileSinks opens the file sinks, creating their directories
+func (o *buildOptions) fileSinks(store *storage.Store) []*fileSink {
+	sinks := make([]*fileSink, 0)
+	for _, spec := range o.Sinks {
+		if spec.Type != "file" {
+			continue
+		}
+		sink := &fileSink{path: spec.Path}
+		if !spec.Plaintext {
+			sealed, err := store.OpenLog(spec.Path, "sink", 0)
+			if err != nil {
+				log.Printf("[%s] Sink %s: %v", time.Now().Format(time.RFC3339), spec.Path, err)
+				continue
+			}
+			sink.sealed = sealed
+		} else {
+			os.MkdirAll(filepath.Dir(spec.Path), 0700)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+func (s *fileSink) write(data interface{}) error {
+	line, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if s.sealed != nil {
+		return s.sealed.Append(line)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// writeSinks copies a report to every file sink
+func (a *NOPAgent) writeSinks(data interface{}) {
+	for _, sink := range a.sinks {
+		if err := sink.write(data); err != nil {
+			log.Printf("[%s] Sink %s: %v", time.Now().Format(time.RFC3339), sink.path, err)
+		}
+	}
+}
+
+// applyObfuscation silences the agent's own log output in obfuscated builds,
+// unless "debug_log" is set in the config
+func (o *buildOptions) applyObfuscation(config map[string]interface{}) {
+	if debug, _ := config["debug_log"].(bool); o.Obfuscation && !debug {
+		log.SetOutput(io.Discard)
+	}
+}
+
+// summary is reported at registration so the C2 knows what this build can do
+func (o *buildOptions) summary() map[string]interface{} {
+	transports := o.Transports
+	if len(transports) == 0 {
+		transports = make([]string, 0, len(transport.Schemes))
+		for scheme := range transport.Schemes {
+			transports = append(transports, scheme)
+		}
+		sort.Strings(transports)
+	}
+	return map[string]interface{}{
+		"profile":     o.Profile,
+		"transports":  transports,
+		"modules":     o.Modules,
+		"obfuscation": o.Obfuscation,
+		"guardrails":  len(o.Guardrails.Hostnames)+len(o.Guardrails.Domains)+len(o.Guardrails.Networks) > 0 || o.Guardrails.NotAfter != "",
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"encoding/base64"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+func (a *NOPAgent) ouiPath() string {
+	return filepath.Join(a.stateDir(), "oui.txt.gz")
+}
+
+// readOUIUpdate returns the stored OUI update, accepting a plaintext file
+// from earlier versions
+func (a *NOPAgent) readOUIUpdate() ([]byte, error) {
+	data, err := a.store.ReadFile(a.ouiPath(), "oui")
+	if err == nil || os.IsNotExist(err) {
+		return data, err
+	}
+	return os.ReadFile(a.ouiPath())
+}
+
+// loadOUI loads the embedded table, then any update previously pushed by the C2
+func (a *NOPAgent) loadOUI() {
+	table, version, err := modules.ParseOUI(modules.EmbeddedOUI)
+	if err != nil {
+		log.Printf("[%s] Embedded OUI table error: %v", time.Now().Format(time.RFC3339), err)
+		table = make(map[string]string)
+	}
+
+	if data, err := a.readOUIUpdate(); err == nil {
+		if updated, updatedVersion, err := modules.ParseOUI(data); err == nil {
+			for prefix, vendor := range updated {
+				table[prefix] = vendor
+			}
+			version = updatedVersion
+		} else {
+			log.Printf("[%s] Stored OUI update unreadable: %v", time.Now().Format(time.RFC3339), err)
+		}
+	}
+
+	a.ouiMutex.Lock()
+	a.ouiTable, a.ouiVersion = table, version
+	a.ouiMutex.Unlock()
+}
+
+// lookupVendor resolves a MAC address using the longest matching prefix
+func (a *NOPAgent) lookupVendor(mac string) string {
+	mac = modules.NormalizeMAC(mac)
+	a.ouiMutex.RLock()
+	defer a.ouiMutex.RUnlock()
+	for _, n := range []int{9, 7, 6} {
+		if len(mac) >= n {
+			if vendor, ok := a.ouiTable[mac[:n]]; ok {
+				return vendor
+			}
+		}
+	}
+	return ""
+}
+
+func (a *NOPAgent) annotateVendors(assets []map[string]interface{}) {
+	for _, asset := range assets {
+		if _, ok := asset["vendor"]; ok {
+			continue
+		}
+		mac, _ := asset["mac"].(string)
+		if vendor := a.lookupVendor(mac); vendor != "" {
+			asset["vendor"] = vendor
+		}
+	}
+}
+
+// handleOUIUpdate applies an OUI table pushed by the C2 and stores it so the
+// update survives restarts
+func (a *NOPAgent) handleOUIUpdate(msg map[string]interface{}) {
+	encoded, _ := msg["data"].(string)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || encoded == "" {
+		a.sendError("oui_update", msg, newAgentError(ErrInvalidRequest, "invalid_data",
+			"data must be a base64 encoded gzip OUI table"))
+		return
+	}
+	if _, _, err := modules.ParseOUI(data); err != nil {
+		a.sendError("oui_update", msg, newAgentError(ErrInvalidRequest, "invalid_data",
+			"OUI table could not be parsed: %v", err))
+		return
+	}
+
+	if err := a.store.WriteFile(a.ouiPath(), "oui", data); err != nil {
+		a.sendError("oui_update", msg, err)
+		return
+	}
+	a.loadOUI()
+
+	a.ouiMutex.RLock()
+	entries, version := len(a.ouiTable), a.ouiVersion
+	a.ouiMutex.RUnlock()
+	log.Printf("[%s] OUI table updated to version %q (%d entries)", time.Now().Format(time.RFC3339), version, entries)
+
+	a.writeJSON(map[string]interface{}{
+		"type":      "oui_update_result",
+		"agent_id":  a.agentID,
+		"version":   version,
+		"entries":   entries,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/storage"
+)
+
+// ============================================================================
+// OUTPUT SPOOL - Command output too large to send inline
+// ============================================================================
+
+// outputCapture collects one output stream of a command. The first inline
+// bytes are kept for the result message; once a stream outgrows them it is
+// written in full to a sealed spool file the C2 can fetch with file_get.
+// Output beyond the command policy's max_output_bytes is dropped altogether.
+type outputCapture struct {
+	inline  int
+	limit   int
+	spool   func() (*storage.Log, string, error)
+	buf     bytes.Buffer
+	file    *storage.Log
+	path    string
+	size    int64
+	dropped bool
+	err     error
+}
+
+func (c *outputCapture) Write(data []byte) (int, error) {
+	n := len(data)
+	if c.limit > 0 && c.size+int64(len(data)) > int64(c.limit) {
+		c.dropped = true
+		data = data[:max(int64(c.limit)-c.size, 0)]
+	}
+	c.size += int64(len(data))
+	if c.file == nil && c.err == nil && c.buf.Len()+len(data) > c.inline {
+		if c.file, c.path, c.err = c.spool(); c.err == nil {
+			_, c.err = c.file.Write(c.buf.Bytes())
+		}
+	}
+	if c.file != nil && c.err == nil {
+		_, c.err = c.file.Write(data)
+	}
+	if room := c.inline - c.buf.Len(); room > 0 {
+		c.buf.Write(data[:min(room, len(data))])
+	}
+	return n, nil
+}
+
+// report closes the spool file and adds the stream to result as name, with
+// name_truncated when part of it is not inline, name_size, and name_file
+// when the full output was spooled
+func (c *outputCapture) report(result map[string]interface{}, name string) {
+	outputField(result, name, c.buf.Bytes())
+	if c.dropped || c.size > int64(c.buf.Len()) {
+		result[name+"_truncated"] = true
+		result[name+"_size"] = c.size
+	}
+	if c.file == nil {
+		return
+	}
+	c.file.Close()
+	if c.err != nil {
+		log.Printf("[%s] Spooling %s failed: %v", time.Now().Format(time.RFC3339), name, c.err)
+		os.Remove(c.path)
+		return
+	}
+	result[name+"_file"] = c.path
+}
+
+// newCapture returns the capture for one output stream of a command,
+// spooling to the output directory of the state directory
+func (a *NOPAgent) newCapture(stream string) *outputCapture {
+	capture := &outputCapture{inline: a.maxInlineOutput()}
+	if a.cmdPolicy != nil {
+		capture.limit = a.cmdPolicy.MaxOutputBytes
+	}
+	capture.spool = func() (*storage.Log, string, error) {
+		a.pruneOutputSpool()
+		path := filepath.Join(a.outputDir(), stream+"-"+newID()+".log")
+		file, err := a.store.OpenLog(path, "output", 0)
+		return file, path, err
+	}
+	return capture
+}
+
+// maxInlineOutput is "max_inline_output" bytes per stream (default 256 KiB)
+func (a *NOPAgent) maxInlineOutput() int {
+	if val, ok := a.config["max_inline_output"].(float64); ok && val >= 0 {
+		return int(val)
+	}
+	return 256 << 10
+}
+
+func (a *NOPAgent) outputDir() string {
+	return filepath.Join(a.stateDir(), "output")
+}
+
+// isSpooledOutput reports whether path is a spooled output file, which
+// file_get sends as command output rather than file contents
+func (a *NOPAgent) isSpooledOutput(path string) bool {
+	abs, err := filepath.Abs(path)
+	return err == nil && filepath.Dir(abs) == a.outputDir()
+}
+
+// openSpooledOutput decrypts a spooled output file for file_get, returning
+// a reader of the plain output and its size
+func (a *NOPAgent) openSpooledOutput(path string) (io.ReadCloser, int64, error) {
+	var size int64
+	err := a.store.ReadLog(path, "output", func(record []byte) error {
+		size += int64(len(record))
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(a.store.ReadLog(path, "output", func(record []byte) error {
+			_, err := writer.Write(record)
+			return err
+		}))
+	}()
+	return reader, size, nil
+}
+
+// pruneOutputSpool removes spooled output older than "output_spool_ttl"
+// seconds (default one day, zero keeps it)
+func (a *NOPAgent) pruneOutputSpool() {
+	ttl := a.timeout("output_spool_ttl", 24*time.Hour)
+	if ttl == 0 {
+		return
+	}
+	entries, err := os.ReadDir(a.outputDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > ttl {
+			os.Remove(filepath.Join(a.outputDir(), entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package core
+
+import "io/fs"
+
+// fileOwner is not reported on this platform
+func fileOwner(info fs.FileInfo) (string, string) {
+	return "", ""
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package core
+
+import (
+	"io/fs"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ownerNames caches ID to name lookups, which read the user database
+var ownerNames sync.Map
+
+func lookupName(key string, lookup func() (string, error)) string {
+	if name, ok := ownerNames.Load(key); ok {
+		return name.(string)
+	}
+	name, err := lookup()
+	if err != nil {
+		name = key[2:]
+	}
+	ownerNames.Store(key, name)
+	return name
+}
+
+// fileOwner returns the owning user and group names, or their numeric IDs
+// when they are not in the user database
+func fileOwner(info fs.FileInfo) (string, string) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	uid := strconv.FormatUint(uint64(stat.Uid), 10)
+	gid := strconv.FormatUint(uint64(stat.Gid), 10)
+	owner := lookupName("u:"+uid, func() (string, error) {
+		u, err := user.LookupId(uid)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+	group := lookupName("g:"+gid, func() (string, error) {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+	return owner, group
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// DATA POLICY - Hard limit on the categories of data the agent sends
+// ============================================================================
+
+// policyCategories are the categories a data policy can name
+var policyCategories = []string{
+	"assets", "traffic", "host", "network_probes",
+	"processes", "file_contents", "screenshots", "command_output",
+}
+
+// dataCategories maps outbound message types to policy categories. Types not
+// listed are protocol traffic ("control"), which is always allowed.
+var dataCategories = map[string]string{
+	"asset_data":            "assets",
+	"export_assets_result":  "assets",
+	"traffic_data":          "traffic",
+	"traffic_anomaly":       "traffic",
+	"beacon_candidate":      "traffic",
+	"network_change":        "traffic",
+	"host_data":             "host",
+	"proc_list_result":      "processes",
+	"proc_inspect_result":   "processes",
+	"hash_files_result":     "host",
+	"file_tail_data":        "file_contents",
+	"http_request_result":   "network_probes",
+	"dns_lookup_result":     "network_probes",
+	"mail_probe_result":     "network_probes",
+	"db_probe_result":       "network_probes",
+	"k8s_probe_result":      "network_probes",
+	"cloud_exposure_result": "network_probes",
+	"command_result":        "command_output",
+	"script_result":         "command_output",
+	"shell_output":          "command_output",
+}
+
+var errBlockedByPolicy = errors.New("blocked by data policy")
+
+type dataPolicy struct {
+	allowed  map[string]bool
+	fallback bool
+}
+
+// loadDataPolicy parses the embedded data policy. An unrendered placeholder allows
+// everything; a rendered but unreadable policy allows only control traffic.
+func loadDataPolicy(raw string) *dataPolicy {
+	policy := &dataPolicy{allowed: make(map[string]bool), fallback: true}
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasPrefix(raw, "{{") {
+		return policy
+	}
+	var categories map[string]bool
+	if err := json.Unmarshal([]byte(raw), &categories); err != nil {
+		log.Printf("[%s] Invalid data policy, only control traffic allowed: %v", time.Now().Format(time.RFC3339), err)
+		policy.fallback = false
+		return policy
+	}
+	for category, allowed := range categories {
+		if category == "default" {
+			policy.fallback = allowed
+			continue
+		}
+		policy.allowed[category] = allowed
+	}
+	return policy
+}
+
+func (p *dataPolicy) allows(category string) bool {
+	if p == nil || category == "control" {
+		return true
+	}
+	if allowed, ok := p.allowed[category]; ok {
+		return allowed
+	}
+	return p.fallback
+}
+
+// messageCategory returns the policy category of an outbound message;
+// aggregates take the category of the event they wrap
+func messageCategory(v interface{}) string {
+	if aggregate, ok := v.(protocol.AggregatedEvent); ok {
+		return messageCategory(aggregate.Event)
+	}
+	if category, ok := dataCategories[protocol.Type(v)]; ok {
+		return category
+	}
+	return "control"
+}
+
+// outboundAllowed enforces the data policy on one outbound message
+func (a *NOPAgent) outboundAllowed(v interface{}) bool {
+	category := messageCategory(v)
+	if a.policy.allows(category) {
+		return true
+	}
+	a.notifyBlocked(protocol.Type(v), category)
+	return false
+}
+
+// notifyBlocked logs and reports the first blocked message of each type, so
+// the C2 learns why data is missing without a notice per dropped message
+func (a *NOPAgent) notifyBlocked(msgType, category string) {
+	a.policyMutex.Lock()
+	seen := a.policyNotices[msgType]
+	a.policyNotices[msgType] = true
+	a.policyMutex.Unlock()
+	if seen {
+		return
+	}
+
+	log.Printf("[%s] Data policy blocks %s (%s)", time.Now().Format(time.RFC3339), msgType, category)
+	go a.writeJSON(map[string]interface{}{
+		"type":         "policy_blocked",
+		"agent_id":     a.agentID,
+		"message_type": msgType,
+		"category":     category,
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	})
+}
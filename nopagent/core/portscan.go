@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// PORT SCAN - Open ports of discovered hosts for the asset module
+// ============================================================================
+
+// portScanPolicy returns the "port_scan" config block, e.g. {"ports": [22,
+// "8000-8100"], "workers": 100, "rate": 500, "timeout": 1, "max_hosts": 256,
+// "interval": 3600}. Scans need the port_scan capability; "enabled": false
+// pauses them.
+func (a *NOPAgent) portScanPolicy() map[string]interface{} {
+	if policy, ok := a.config["port_scan"].(map[string]interface{}); ok {
+		return policy
+	}
+	return map[string]interface{}{}
+}
+
+// scanPorts adds open_ports to the assets of a discovery round. A host is
+// rescanned once "interval" seconds (default one hour) have passed since
+// its last scan; in between the cached open_ports stand.
+func (a *NOPAgent) scanPorts(assets []map[string]interface{}) {
+	policy := a.portScanPolicy()
+	if enabled, ok := policy["enabled"].(bool); !a.capabilities["port_scan"] || ok && !enabled {
+		return
+	}
+	exclusions, ok := a.scanExclusions()
+	if !ok {
+		return
+	}
+	number := func(key string, def int) int {
+		if val, ok := policy[key].(float64); ok && val >= 0 {
+			return int(val)
+		}
+		return def
+	}
+
+	opts := modules.ScanOptions{
+		Ports:   modules.DefaultScanPorts,
+		Workers: number("workers", 100),
+		Rate:    number("rate", 500),
+		Timeout: time.Second,
+	}
+	if val, ok := policy["timeout"].(float64); ok && val > 0 {
+		opts.Timeout = time.Duration(val * float64(time.Second))
+	}
+	if spec, ok := policy["ports"].([]interface{}); ok {
+		opts.Ports = parsePorts(spec)
+	}
+	interval := time.Hour
+	if val, ok := policy["interval"].(float64); ok && val >= 0 {
+		interval = time.Duration(val * float64(time.Second))
+	}
+
+	hosts, byIP := a.dueHosts(assets, "ports_scanned_at", interval, number("max_hosts", 256), exclusions)
+	if len(hosts) == 0 || len(opts.Ports) == 0 {
+		return
+	}
+
+	started := time.Now()
+	open := modules.PortScan(context.Background(), hosts, opts)
+	scannedAt := time.Now().UTC().Format(time.RFC3339)
+	for ip, records := range byIP {
+		ports, ok := open[ip]
+		if !ok {
+			ports = make([]map[string]interface{}, 0)
+		}
+		for _, asset := range records {
+			asset["open_ports"] = ports
+			asset["ports_scanned_at"] = scannedAt
+		}
+	}
+	log.Printf("[%s] Port scan of %d hosts (%d ports each) found %d with open ports in %s", time.Now().Format(time.RFC3339),
+		len(hosts), len(opts.Ports), len(open), time.Since(started).Round(time.Millisecond))
+}
+
+// parsePorts reads a port list of numbers and "first-last" ranges, dropping
+// anything outside 1-65535
+func parsePorts(spec []interface{}) []int {
+	ports := make([]int, 0, len(spec))
+	seen := make(map[int]bool)
+	add := func(port int) {
+		if port > 0 && port < 65536 && !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	for _, entry := range spec {
+		switch v := entry.(type) {
+		case float64:
+			add(int(v))
+		case string:
+			first, last, isRange := strings.Cut(v, "-")
+			lo, err := strconv.Atoi(strings.TrimSpace(first))
+			if err != nil {
+				continue
+			}
+			hi := lo
+			if isRange {
+				if hi, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+					continue
+				}
+			}
+			for port := max(lo, 1); port <= min(hi, 65535); port++ {
+				add(port)
+			}
+		}
+	}
+	return ports
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		name string
+		spec []interface{}
+		want []int
+	}{
+		{"mixed", []interface{}{22.0, "80-83", "x", 70000.0, "65534-70000", 22.0}, []int{22, 80, 81, 82, 83, 65534, 65535}},
+		{"empty", nil, []int{}},
+		{"single string", []interface{}{" 443 "}, []int{443}},
+		{"overlapping ranges", []interface{}{"1-3", "2-4"}, []int{1, 2, 3, 4}},
+		{"zero and negatives", []interface{}{0.0, -1.0, "0-2"}, []int{1, 2}},
+		{"backwards range", []interface{}{"90-80"}, []int{}},
+		{"bad bound", []interface{}{"80-x", "y-90"}, []int{}},
+		{"other types", []interface{}{true, map[string]interface{}{}, []interface{}{80.0}}, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePorts(tt.spec); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePorts(%v) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
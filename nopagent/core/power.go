@@ -0,0 +1,82 @@
+package core
+
+import (
+	"log"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// POWER MODULE - Battery-aware collection policy
+// ============================================================================
+func (a *NOPAgent) PowerModule(beat func() bool) {
+	policy := a.powerPolicy()
+	if enabled, ok := policy["enabled"].(bool); ok && !enabled {
+		return
+	}
+	log.Printf("[%s] Power module started", time.Now().Format(time.RFC3339))
+
+	ticker := time.NewTicker(60 * time.Second)
+	defer ticker.Stop()
+
+	a.checkPowerSource()
+
+	for a.running && beat() {
+		select {
+		case <-ticker.C:
+			a.checkPowerSource()
+		}
+	}
+}
+
+func (a *NOPAgent) checkPowerSource() {
+	onBattery := modules.OnBattery()
+	if onBattery == a.onBattery {
+		return
+	}
+	a.onBattery = onBattery
+
+	if onBattery {
+		log.Printf("[%s] Running on battery - stretching intervals (x%.0f), suspended modules: %v",
+			time.Now().Format(time.RFC3339), a.powerMultiplier(), a.powerPolicy()["disabled_modules"])
+	} else {
+		log.Printf("[%s] Running on AC power - restoring normal intervals", time.Now().Format(time.RFC3339))
+	}
+}
+
+// powerPolicy returns the "power_policy" config block, e.g.
+// {"enabled": true, "battery_multiplier": 4, "disabled_modules": ["traffic"]}
+func (a *NOPAgent) powerPolicy() map[string]interface{} {
+	if policy, ok := a.config["power_policy"].(map[string]interface{}); ok {
+		return policy
+	}
+	return map[string]interface{}{}
+}
+
+func (a *NOPAgent) powerMultiplier() float64 {
+	if m, ok := a.powerPolicy()["battery_multiplier"].(float64); ok && m >= 1 {
+		return m
+	}
+	return 3
+}
+
+func (a *NOPAgent) powerAdjustedInterval(interval time.Duration) time.Duration {
+	if !a.onBattery {
+		return interval
+	}
+	return time.Duration(float64(interval) * a.powerMultiplier())
+}
+
+func (a *NOPAgent) moduleSuspended(module string) bool {
+	if !a.onBattery {
+		return false
+	}
+	disabled, _ := a.powerPolicy()["disabled_modules"].([]interface{})
+	for _, m := range disabled {
+		if name, ok := m.(string); ok && name == module {
+			return true
+		}
+	}
+	return false
+}
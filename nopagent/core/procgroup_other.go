@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package core
+
+import "os/exec"
+
+// setProcessGroup leaves cancellation to kill only the shell here
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func detachProcess(cmd *exec.Cmd) {}
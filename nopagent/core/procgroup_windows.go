@@ -0,0 +1,26 @@
+package core
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// setProcessGroup makes cancelling cmd kill its whole process tree;
+// taskkill walks the children that cmd /C started
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
+
+// detachProcess starts cmd without the agent's console, in its own process
+// group, so it outlives the agent
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP}
+}
@@ -0,0 +1,283 @@
+package core
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ============================================================================
+// PROCESSES - Structured process listing and control (proc_list, ...)
+// ============================================================================
+
+// processEntry describes one process; fields the agent may not read (other
+// users' processes when unprivileged) are left out
+func processEntry(p *process.Process) map[string]interface{} {
+	entry := map[string]interface{}{"pid": p.Pid}
+	if ppid, err := p.Ppid(); err == nil {
+		entry["ppid"] = ppid
+	}
+	if name, err := p.Name(); err == nil {
+		entry["name"] = name
+	}
+	if user, err := p.Username(); err == nil {
+		entry["user"] = user
+	}
+	if cmdline, err := p.Cmdline(); err == nil {
+		entry["cmdline"] = cmdline
+	}
+	if exe, err := p.Exe(); err == nil {
+		entry["exe"] = exe
+	}
+	if status, err := p.Status(); err == nil && len(status) > 0 {
+		entry["status"] = status[0]
+	}
+	// Average over the process lifetime; sampling would stall the listing
+	if cpu, err := p.CPUPercent(); err == nil {
+		entry["cpu_percent"] = cpu
+	}
+	if mem, err := p.MemoryInfo(); err == nil {
+		entry["rss"] = mem.RSS
+	}
+	if created, err := p.CreateTime(); err == nil {
+		entry["started"] = time.UnixMilli(created).UTC().Format(time.RFC3339)
+	}
+	return entry
+}
+
+// handleProcList lists processes sorted by "sort" (pid, cpu or rss;
+// default pid), optionally only those whose name or command line contains
+// "filter" or that run as "user". At most "max_entries" (default 5000) are
+// returned.
+func (a *NOPAgent) handleProcList(msg map[string]interface{}) {
+	if !a.capabilities["host"] {
+		a.sendError("proc_list", msg, newAgentError(ErrNotSupported, "capability_disabled", "host capability is not enabled"))
+		return
+	}
+	procs, err := process.Processes()
+	if err != nil {
+		a.sendError("proc_list", msg, err)
+		return
+	}
+	filter, _ := msg["filter"].(string)
+	user, _ := msg["user"].(string)
+	limit := 5000
+	if val, ok := msg["max_entries"].(float64); ok && val > 0 {
+		limit = int(val)
+	}
+
+	entries := make([]map[string]interface{}, 0, len(procs))
+	for _, p := range procs {
+		entry := processEntry(p)
+		if _, ok := entry["name"]; !ok {
+			continue // exited while listing
+		}
+		if filter != "" {
+			name, _ := entry["name"].(string)
+			cmdline, _ := entry["cmdline"].(string)
+			if !strings.Contains(name, filter) && !strings.Contains(cmdline, filter) {
+				continue
+			}
+		}
+		if owner, _ := entry["user"].(string); user != "" && owner != user {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	key, _ := msg["sort"].(string)
+	sort.SliceStable(entries, func(i, j int) bool {
+		switch key {
+		case "cpu":
+			ci, _ := entries[i]["cpu_percent"].(float64)
+			cj, _ := entries[j]["cpu_percent"].(float64)
+			return ci > cj
+		case "rss":
+			ri, _ := entries[i]["rss"].(uint64)
+			rj, _ := entries[j]["rss"].(uint64)
+			return ri > rj
+		}
+		return entries[i]["pid"].(int32) < entries[j]["pid"].(int32)
+	})
+	total := len(entries)
+	if total > limit {
+		entries = entries[:limit]
+	}
+
+	a.relayToC2(map[string]interface{}{
+		"type":       "proc_list_result",
+		"agent_id":   a.agentID,
+		"request_id": msg["request_id"],
+		"processes":  entries,
+		"total":      total,
+		"truncated":  total > limit,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleProcInspect describes one process in depth: the proc_list fields
+// plus its ancestry, sockets and loaded modules
+func (a *NOPAgent) handleProcInspect(msg map[string]interface{}) {
+	if !a.capabilities["host"] {
+		a.sendError("proc_inspect", msg, newAgentError(ErrNotSupported, "capability_disabled", "host capability is not enabled"))
+		return
+	}
+	pidVal, _ := msg["pid"].(float64)
+	p, err := process.NewProcess(int32(pidVal))
+	if err != nil {
+		a.sendError("proc_inspect", msg, newAgentError(ErrNotFound, "unknown_process", "no process %d", int32(pidVal)))
+		return
+	}
+	result := processEntry(p)
+	for k, v := range a.snapshotProcess(p.Pid) {
+		result[k] = v
+	}
+	if cwd, err := p.Cwd(); err == nil {
+		result["cwd"] = cwd
+	}
+	if threads, err := p.NumThreads(); err == nil {
+		result["threads"] = threads
+	}
+	if children, err := p.Children(); err == nil {
+		pids := make([]int32, 0, len(children))
+		for _, child := range children {
+			pids = append(pids, child.Pid)
+		}
+		result["children"] = pids
+	}
+	result["type"] = "proc_inspect_result"
+	result["agent_id"] = a.agentID
+	result["request_id"] = msg["request_id"]
+	result["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	a.relayToC2(result)
+}
+
+// handleProcKill sends "signal" (TERM by default; KILL, INT, HUP, STOP or
+// CONT) to a process, and to its descendants first when "tree" is set. PID
+// 1 and the agent itself are refused.
+func (a *NOPAgent) handleProcKill(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("proc_kill", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+	pidVal, _ := msg["pid"].(float64)
+	pid := int32(pidVal)
+	if pid <= 1 || int(pid) == os.Getpid() {
+		a.sendError("proc_kill", msg, newAgentError(ErrPermission, "protected_process", "process %d cannot be signalled", pid))
+		return
+	}
+	signal, _ := msg["signal"].(string)
+	signal = strings.TrimPrefix(strings.ToUpper(signal), "SIG")
+	if signal == "" {
+		signal = "TERM"
+	}
+	send, ok := map[string]func(*process.Process) error{
+		"TERM": (*process.Process).Terminate,
+		"KILL": (*process.Process).Kill,
+		"STOP": (*process.Process).Suspend,
+		"CONT": (*process.Process).Resume,
+		"INT":  func(p *process.Process) error { return p.SendSignal(syscall.SIGINT) },
+		"HUP":  func(p *process.Process) error { return p.SendSignal(syscall.SIGHUP) },
+	}[signal]
+	if !ok {
+		a.sendError("proc_kill", msg, newAgentError(ErrInvalidRequest, "unsupported_signal", "signal %q is not one of TERM, KILL, INT, HUP, STOP, CONT", signal))
+		return
+	}
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		a.sendError("proc_kill", msg, newAgentError(ErrNotFound, "unknown_process", "no process %d", pid))
+		return
+	}
+
+	// Children before parents, so a parent cannot respawn them
+	targets := []*process.Process{p}
+	if tree, _ := msg["tree"].(bool); tree {
+		for i := 0; i < len(targets); i++ {
+			if children, err := targets[i].Children(); err == nil {
+				targets = append(targets, children...)
+			}
+		}
+	}
+	signalled := make([]int32, 0, len(targets))
+	failed := make([]map[string]interface{}, 0)
+	for i := len(targets) - 1; i >= 0; i-- {
+		if err := send(targets[i]); err != nil {
+			failed = append(failed, map[string]interface{}{"pid": targets[i].Pid, "error": classifyError(err)})
+			continue
+		}
+		signalled = append(signalled, targets[i].Pid)
+	}
+
+	log.Printf("[%s] Sent SIG%s to %v", time.Now().Format(time.RFC3339), signal, signalled)
+	a.relayToC2(map[string]interface{}{
+		"type":       "proc_kill_result",
+		"agent_id":   a.agentID,
+		"request_id": msg["request_id"],
+		"pid":        pid,
+		"signal":     signal,
+		"signalled":  signalled,
+		"failed":     failed,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// procStartSpec parses a proc_start request as a raw command
+func (a *NOPAgent) procStartSpec(msg map[string]interface{}) (*execSpec, error) {
+	options := make(map[string]interface{}, len(msg)+1)
+	for k, v := range msg {
+		options[k] = v
+	}
+	options["shell"] = "raw"
+	return a.parseExec(options)
+}
+
+// handleProcStart starts "argv" detached from the agent, with the cwd, env
+// and run_as options of a raw command, and reports its PID. Its output is
+// discarded; it keeps running if the agent stops.
+func (a *NOPAgent) handleProcStart(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("proc_start", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+	spec, err := a.procStartSpec(msg)
+	if err != nil {
+		a.sendError("proc_start", msg, err)
+		return
+	}
+
+	cmd := exec.Command(spec.argv[0], spec.argv[1:]...)
+	cmd.Dir, cmd.Env = spec.dir, spec.env
+	detachProcess(cmd)
+	if spec.runAs != nil {
+		release, err := applyRunAs(cmd, spec.runAs)
+		if err != nil {
+			a.sendError("proc_start", msg, err)
+			return
+		}
+		defer release()
+	}
+	if err := cmd.Start(); err != nil {
+		if spec.runAs != nil && privilegeError(err) {
+			err = newAgentError(ErrPermission, "insufficient_privileges", "the agent may not start processes as %s: %v", spec.runAs.user, err)
+		}
+		a.sendError("proc_start", msg, err)
+		return
+	}
+	// Reap it when it exits
+	go cmd.Wait()
+
+	log.Printf("[%s] Started %s as pid %d", time.Now().Format(time.RFC3339), spec.line, cmd.Process.Pid)
+	a.relayToC2(map[string]interface{}{
+		"type":       "proc_start_result",
+		"agent_id":   a.agentID,
+		"request_id": msg["request_id"],
+		"pid":        cmd.Process.Pid,
+		"argv":       spec.argv,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// ============================================================================
+// REVERSE PROXY - TLS front-end for internal web services
+// ============================================================================
+
+// reverseProxy serves HTTPS with a C2-provisioned certificate and forwards
+// requests to a plain-HTTP service reachable from the agent
+type reverseProxy struct {
+	ID        string `json:"proxy_id"`
+	Listen    string `json:"listen"`
+	Target    string `json:"target"`
+	StartedAt string `json:"started_at"`
+	server    *http.Server
+}
+
+func (a *NOPAgent) handleProxyStart(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("proxy_start", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+
+	target, _ := msg["target"].(string)
+	targetURL, err := url.Parse(target)
+	if err != nil || targetURL.Host == "" || (targetURL.Scheme != "http" && targetURL.Scheme != "https") {
+		a.sendError("proxy_start", msg, newAgentError(ErrInvalidRequest, "invalid_target", "target must be an http(s) URL"))
+		return
+	}
+	certPEM, _ := msg["cert_pem"].(string)
+	keyPEM, _ := msg["key_pem"].(string)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		a.sendError("proxy_start", msg, newAgentError(ErrInvalidRequest, "invalid_certificate", "%v", err))
+		return
+	}
+	listen, _ := msg["listen"].(string)
+	if listen == "" {
+		listen = "127.0.0.1:0"
+	}
+	// Optional shared secret so the listener is not an open relay
+	token, _ := msg["access_token"].(string)
+
+	id, _ := msg["proxy_id"].(string)
+	if id == "" {
+		id = newID()
+	}
+	a.proxyMutex.Lock()
+	_, exists := a.proxies[id]
+	a.proxyMutex.Unlock()
+	if exists {
+		a.sendError("proxy_start", msg, newAgentError(ErrInvalidRequest, "duplicate_proxy", "proxy %s is already running", id))
+		return
+	}
+
+	listener, err := tls.Listen("tcp", listen, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		a.sendError("proxy_start", msg, err)
+		return
+	}
+
+	upstream := httputil.NewSingleHostReverseProxy(targetURL)
+	// Internal services often use self-signed certificates
+	upstream.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	handler := http.Handler(upstream)
+	if token != "" {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, password, _ := r.BasicAuth()
+			if password != token && r.Header.Get("X-NOP-Proxy-Token") != token {
+				w.Header().Set("WWW-Authenticate", `Basic realm="nop-proxy"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			r.Header.Del("Authorization")
+			r.Header.Del("X-NOP-Proxy-Token")
+			upstream.ServeHTTP(w, r)
+		})
+	}
+
+	proxy := &reverseProxy{
+		ID:        id,
+		Listen:    listener.Addr().String(),
+		Target:    targetURL.String(),
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+		server:    &http.Server{Handler: handler, ReadHeaderTimeout: 30 * time.Second},
+	}
+	a.proxyMutex.Lock()
+	a.proxies[id] = proxy
+	a.proxyMutex.Unlock()
+
+	go func() {
+		if err := proxy.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[%s] Reverse proxy %s stopped: %v", time.Now().Format(time.RFC3339), id, err)
+		}
+		a.proxyMutex.Lock()
+		delete(a.proxies, id)
+		a.proxyMutex.Unlock()
+	}()
+
+	log.Printf("[%s] Reverse proxy %s: https://%s -> %s", time.Now().Format(time.RFC3339), id, proxy.Listen, proxy.Target)
+	a.sendProxyResult(proxy, "started")
+}
+
+func (a *NOPAgent) handleProxyStop(msg map[string]interface{}) {
+	id, _ := msg["proxy_id"].(string)
+	a.proxyMutex.Lock()
+	proxy, ok := a.proxies[id]
+	delete(a.proxies, id)
+	a.proxyMutex.Unlock()
+	if !ok {
+		a.sendError("proxy_stop", msg, newAgentError(ErrNotFound, "unknown_proxy", "no proxy with id %q", id))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	proxy.server.Shutdown(ctx)
+	log.Printf("[%s] Reverse proxy %s stopped", time.Now().Format(time.RFC3339), id)
+	a.sendProxyResult(proxy, "stopped")
+}
+
+func (a *NOPAgent) handleProxyList() {
+	a.proxyMutex.Lock()
+	proxies := make([]*reverseProxy, 0, len(a.proxies))
+	for _, p := range a.proxies {
+		proxies = append(proxies, p)
+	}
+	a.proxyMutex.Unlock()
+
+	a.writeJSON(map[string]interface{}{
+		"type":      "proxy_list_result",
+		"agent_id":  a.agentID,
+		"proxies":   proxies,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (a *NOPAgent) sendProxyResult(proxy *reverseProxy, status string) {
+	a.writeJSON(map[string]interface{}{
+		"type":      "proxy_result",
+		"agent_id":  a.agentID,
+		"proxy_id":  proxy.ID,
+		"status":    status,
+		"listen":    proxy.Listen,
+		"target":    proxy.Target,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
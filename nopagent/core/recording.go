@@ -0,0 +1,190 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/storage"
+)
+
+// ============================================================================
+// SESSION RECORDING - Decrypted C2 traffic for offline debugging
+// ============================================================================
+
+// sessionRecorder appends every decrypted message of a run to
+// <state_dir>/recordings/session-<time>.rec when "record_sessions" is true.
+// The file is a sealed storage log, so only this agent build can read it
+// back (see --replay). Recording stops once the file reaches
+// "record_max_bytes" (default 64 MiB).
+type sessionRecorder struct {
+	log *storage.Log
+}
+
+type sessionRecord struct {
+	Direction string      `json:"dir"`
+	Time      string      `json:"t"`
+	Message   interface{} `json:"msg"`
+}
+
+// redactedFields are field-name fragments whose values never reach a recording
+var redactedFields = []string{"password", "passwd", "secret", "token", "authorization", "private_key", "client_key", "api_key", "credential", "cookie"}
+
+func (a *NOPAgent) openRecorder() *sessionRecorder {
+	if enabled, _ := a.config["record_sessions"].(bool); !enabled {
+		return nil
+	}
+	dir := filepath.Join(a.stateDir(), "recordings")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("[%s] Session recording disabled: %v", time.Now().Format(time.RFC3339), err)
+		return nil
+	}
+	limit := int64(64 << 20)
+	if val, ok := a.config["record_max_bytes"].(float64); ok && val > 0 {
+		limit = int64(val)
+	}
+	name := filepath.Join(dir, "session-"+time.Now().UTC().Format("20060102T150405Z")+".rec")
+	file, err := a.store.OpenLog(name, "recording", limit)
+	if err != nil {
+		log.Printf("[%s] Session recording disabled: %v", time.Now().Format(time.RFC3339), err)
+		return nil
+	}
+	log.Printf("[%s] Recording session to %s", time.Now().Format(time.RFC3339), name)
+	return &sessionRecorder{log: file}
+}
+
+// record appends one message in direction "in" or "out"
+func (a *NOPAgent) record(direction string, message interface{}) {
+	a.recorderOnce.Do(func() { a.recorder = a.openRecorder() })
+	r := a.recorder
+	if r == nil {
+		return
+	}
+
+	// Round-trip through JSON so structs are redacted like maps
+	var generic interface{}
+	if data, err := json.Marshal(message); err != nil || json.Unmarshal(data, &generic) != nil {
+		return
+	}
+	payload, err := json.Marshal(sessionRecord{
+		Direction: direction,
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Message:   redact(generic),
+	})
+	if err != nil {
+		return
+	}
+	if err := r.log.Append(payload); err == storage.ErrLogFull {
+		log.Printf("[%s] Session recording reached its size limit, stopping", time.Now().Format(time.RFC3339))
+		r.log.Close()
+	}
+}
+
+// redact replaces the values of sensitive fields at any depth
+func redact(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			lower := strings.ToLower(k)
+			sensitive := false
+			for _, fragment := range redactedFields {
+				if strings.Contains(lower, fragment) {
+					sensitive = true
+					break
+				}
+			}
+			if sensitive {
+				value[k] = "[redacted]"
+			} else {
+				value[k] = redact(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redact(item)
+		}
+	}
+	return v
+}
+
+// readRecording returns the records of a session file in order
+func (a *NOPAgent) readRecording(path string) ([]sessionRecord, error) {
+	records := make([]sessionRecord, 0)
+	err := a.store.ReadLog(path, "recording", func(payload []byte) error {
+		var record sessionRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// replayTransport stands in for the C2 during --replay: whatever the
+// handlers send is printed as one JSON line per message on stdout
+type replayTransport struct {
+	mutex sync.Mutex
+	enc   *json.Encoder
+}
+
+func (t *replayTransport) Dial(u *url.URL, header http.Header) error { return nil }
+
+func (t *replayTransport) Send(v interface{}) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.enc.Encode(map[string]interface{}{"dir": "out", "msg": v})
+}
+
+func (t *replayTransport) Receive(v *map[string]interface{}) error {
+	select {}
+}
+
+func (t *replayTransport) Close() error { return nil }
+
+// replaySkipped are message types never re-run from a recording because
+// they act on the local installation
+var replaySkipped = map[string]bool{"kill": true, "uninstall": true, "terminate": true, "rekey": true}
+
+// runReplay re-feeds the inbound messages of a recording through
+// dispatchMessage with the live handlers, and prints the agent's responses.
+// Outbound messages are sent unencrypted so they can be compared with the
+// "out" records of the original session.
+func runReplay(identity Identity, path string, wait time.Duration) error {
+	agent := NewNOPAgent(identity)
+	agent.config["record_sessions"] = false
+	agent.config["session_keys"] = false
+	records, err := agent.readRecording(path)
+	if err != nil && len(records) == 0 {
+		return err
+	}
+	if err != nil {
+		log.Printf("[%s] Replaying %d records: %v", time.Now().Format(time.RFC3339), len(records), err)
+	}
+
+	agent.transport = &replayTransport{enc: json.NewEncoder(os.Stdout)}
+	inbound := 0
+	for _, record := range records {
+		msg, ok := record.Message.(map[string]interface{})
+		if record.Direction != "in" || !ok {
+			continue
+		}
+		if msgType, _ := msg["type"].(string); replaySkipped[msgType] {
+			log.Printf("[%s] Skipping %s from recording", time.Now().Format(time.RFC3339), msgType)
+			continue
+		}
+		inbound++
+		agent.dispatchMessage(msg)
+	}
+	log.Printf("[%s] Replayed %d of %d records from %s", time.Now().Format(time.RFC3339), inbound, len(records), path)
+
+	time.Sleep(wait)
+	agent.running = false
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !unix && !windows
+
+package core
+
+import "os/exec"
+
+func lookupRunAs(r *runAs) error {
+	return newAgentError(ErrNotSupported, "unsupported_option", "run_as is not supported on this platform")
+}
+
+func applyRunAs(cmd *exec.Cmd, r *runAs) (func(), error) {
+	return nil, newAgentError(ErrNotSupported, "unsupported_option", "run_as is not supported on this platform")
+}
+
+func privilegeError(err error) bool {
+	return false
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package core
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// lookupRunAs resolves a user name or numeric uid, its primary group (or
+// run_as_group) and supplementary groups. Only root may switch to another
+// user.
+func lookupRunAs(r *runAs) error {
+	u, err := user.Lookup(r.user)
+	if err != nil {
+		if u, err = user.LookupId(r.user); err != nil {
+			return newAgentError(ErrNotFound, "unknown_user", "no user %q", r.user)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	if r.group != "" {
+		g, err := user.LookupGroup(r.group)
+		if err != nil {
+			if g, err = user.LookupGroupId(r.group); err != nil {
+				return newAgentError(ErrNotFound, "unknown_group", "no group %q", r.group)
+			}
+		}
+		if gid, err = strconv.ParseUint(g.Gid, 10, 32); err != nil {
+			return err
+		}
+	}
+	r.uid, r.gid = uint32(uid), uint32(gid)
+	r.groups = nil
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+				r.groups = append(r.groups, uint32(n))
+			}
+		}
+	}
+	r.env = []string{"HOME=" + u.HomeDir, "USER=" + u.Username, "LOGNAME=" + u.Username}
+
+	if euid := os.Geteuid(); euid != 0 && uint32(euid) != r.uid {
+		return newAgentError(ErrPermission, "insufficient_privileges", "running as %s needs root, the agent runs as uid %d", r.user, euid)
+	}
+	return nil
+}
+
+// applyRunAs makes cmd setgid, setgroups and setuid before exec
+func applyRunAs(cmd *exec.Cmd, r *runAs) (func(), error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: r.uid, Gid: r.gid, Groups: r.groups}
+	return func() {}, nil
+}
+
+func privilegeError(err error) bool {
+	return errors.Is(err, syscall.EPERM)
+}
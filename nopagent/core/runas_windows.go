@@ -0,0 +1,84 @@
+package core
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procLogonUser = windows.NewLazySystemDLL("advapi32.dll").NewProc("LogonUserW")
+
+const (
+	logon32LogonInteractive = 2
+	logon32ProviderDefault  = 0
+)
+
+// lookupRunAs only checks the request: Windows needs the account's
+// password to log it on, which happens when the command starts
+func lookupRunAs(r *runAs) error {
+	if r.password == "" {
+		return newAgentError(ErrInvalidRequest, "missing_password", "run_as needs the password of %s on Windows", r.user)
+	}
+	if r.group != "" {
+		return newAgentError(ErrNotSupported, "unsupported_option", "run_as_group is not supported on Windows")
+	}
+	return nil
+}
+
+// applyRunAs logs the user on ("DOMAIN\user", "user@domain" or a local
+// "user") and starts cmd with the token through CreateProcessAsUser, which
+// needs the agent to hold SeAssignPrimaryTokenPrivilege, e.g. as a service
+func applyRunAs(cmd *exec.Cmd, r *runAs) (func(), error) {
+	name, domain := r.user, "."
+	if i := strings.IndexByte(name, '\\'); i >= 0 {
+		domain, name = name[:i], name[i+1:]
+	} else if strings.Contains(name, "@") {
+		domain = ""
+	}
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	passwordPtr, err := windows.UTF16PtrFromString(r.password)
+	if err != nil {
+		return nil, err
+	}
+	var domainPtr *uint16
+	if domain != "" {
+		if domainPtr, err = windows.UTF16PtrFromString(domain); err != nil {
+			return nil, err
+		}
+	}
+
+	var token windows.Token
+	ok, _, callErr := procLogonUser.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(unsafe.Pointer(domainPtr)),
+		uintptr(unsafe.Pointer(passwordPtr)),
+		logon32LogonInteractive,
+		logon32ProviderDefault,
+		uintptr(unsafe.Pointer(&token)),
+	)
+	if ok == 0 {
+		if errors.Is(callErr, windows.ERROR_LOGON_FAILURE) {
+			return nil, newAgentError(ErrPermission, "logon_failed", "logon as %s failed: unknown user or wrong password", r.user)
+		}
+		if privilegeError(callErr) {
+			return nil, newAgentError(ErrPermission, "insufficient_privileges", "the agent may not log on %s: %v", r.user, callErr)
+		}
+		return nil, callErr
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Token = syscall.Token(token)
+	return func() { token.Close() }, nil
+}
+
+func privilegeError(err error) bool {
+	return errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD) || errors.Is(err, windows.ERROR_ACCESS_DENIED)
+}
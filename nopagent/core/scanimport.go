@@ -0,0 +1,104 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// SCAN IMPORT - Fold existing Nmap, Masscan and arp-scan results into assets
+// ============================================================================
+
+// importBatchSize bounds the assets per asset_data message so a large scan
+// does not exceed the transport's message limits
+const importBatchSize = 256
+
+// importMaxBytes is the largest scan file read: "import_max_bytes" from the
+// config, default 64 MiB
+func (a *NOPAgent) importMaxBytes() int64 {
+	if val, ok := a.config["import_max_bytes"].(float64); ok && val > 0 {
+		return int64(val)
+	}
+	return 64 << 20
+}
+
+// importScan parses a scan file on this host and relays its hosts as asset
+// records, annotated like discovered assets. It returns the format used and
+// the number of assets.
+func (a *NOPAgent) importScan(path, format string) (string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return format, 0, err
+	}
+	defer f.Close()
+	limit := a.importMaxBytes()
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return format, 0, err
+	}
+	if int64(len(data)) > limit {
+		return format, 0, fmt.Errorf("%s exceeds the %d byte import limit", path, limit)
+	}
+
+	assets, format, err := modules.ParseScan(format, data)
+	if err != nil {
+		return format, 0, err
+	}
+	for start := 0; start < len(assets); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(assets) {
+			end = len(assets)
+		}
+		a.reportAssets(assets[start:end])
+	}
+	log.Printf("[%s] Imported %d assets from %s (%s)", time.Now().Format(time.RFC3339), len(assets), path, format)
+	return format, len(assets), nil
+}
+
+// handleImportScan imports a scan file named by the C2
+func (a *NOPAgent) handleImportScan(msg map[string]interface{}) {
+	path, _ := msg["path"].(string)
+	if path == "" {
+		a.sendError("import_scan", msg, newAgentError(ErrInvalidRequest, "missing_path", "path is required"))
+		return
+	}
+	format, _ := msg["format"].(string)
+
+	format, count, err := a.importScan(path, format)
+	if err != nil {
+		a.sendError("import_scan", msg, err)
+		return
+	}
+	a.writeJSON(map[string]interface{}{
+		"type":      "import_scan_result",
+		"agent_id":  a.agentID,
+		"path":      path,
+		"format":    format,
+		"assets":    count,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// runImport is the local "import" path: the assets are spooled and delivered
+// the next time the agent connects, so the file can be imported without a
+// C2 connection from this process
+func runImport(identity Identity, format string, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no scan files given")
+	}
+	agent := NewNOPAgent(identity)
+	if !agent.capabilities["asset"] {
+		return fmt.Errorf("the asset capability is not enabled for this agent")
+	}
+	for _, path := range paths {
+		if _, _, err := agent.importScan(path, format); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+func TestImportScan(t *testing.T) {
+	var lines []string
+	for i := 0; i < importBatchSize+10; i++ {
+		lines = append(lines, fmt.Sprintf("10.0.%d.%d\t00:1a:2b:3c:%02x:%02x\tAcme", i/250, i%250+1, i/256, i%256))
+	}
+	arpScan := strings.Join(lines, "\n")
+
+	tests := []struct {
+		name        string
+		data        string
+		maxBytes    float64
+		wantAssets  int
+		wantBatches []int
+		wantErr     bool
+	}{
+		{name: "one batch", data: strings.Join(lines[:3], "\n"), wantAssets: 3, wantBatches: []int{3}},
+		{name: "split into batches", data: arpScan, wantAssets: importBatchSize + 10, wantBatches: []int{importBatchSize, 10}},
+		{name: "at the size limit", data: lines[0], maxBytes: float64(len(lines[0])), wantAssets: 1, wantBatches: []int{1}},
+		{name: "over the size limit", data: arpScan, maxBytes: 100, wantErr: true},
+		{name: "nothing found", data: "Starting arp-scan", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, "asset")
+			if tt.maxBytes > 0 {
+				a.config["import_max_bytes"] = tt.maxBytes
+			}
+			path := filepath.Join(t.TempDir(), "scan.txt")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			format, count, err := a.importScan(path, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("importScan = %v, want error %v", err, tt.wantErr)
+			}
+			if count != tt.wantAssets {
+				t.Errorf("imported %d assets, want %d", count, tt.wantAssets)
+			}
+			if err == nil && format != "arp-scan" {
+				t.Errorf("format = %q", format)
+			}
+			batches := make([]int, 0)
+			for _, record := range a.audit.records {
+				if report, ok := record.(protocol.AssetData); ok {
+					batches = append(batches, len(report.Assets))
+				}
+			}
+			if fmt.Sprint(batches) != fmt.Sprint(tt.wantBatches) {
+				t.Errorf("asset_data batches = %v, want %v", batches, tt.wantBatches)
+			}
+		})
+	}
+}
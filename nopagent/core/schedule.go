@@ -0,0 +1,291 @@
+package core
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/cron"
+)
+
+// ============================================================================
+// SCHEDULED TASKS - Recurring commands and module runs installed by the C2
+// ============================================================================
+
+// scheduledTask is a recurring action kept in the sealed state directory so
+// it survives restarts and keeps running while the C2 is unreachable
+type scheduledTask struct {
+	ID        string                 `json:"schedule_id"`
+	Cron      string                 `json:"cron"`
+	Action    map[string]interface{} `json:"action"`
+	CreatedAt string                 `json:"created_at"`
+	LastRun   string                 `json:"last_run,omitempty"`
+	NextRun   string                 `json:"next_run,omitempty"`
+	schedule  *cron.Schedule
+	next      time.Time
+}
+
+// scheduledModules are the module runs a scheduled task may trigger, with
+// the capability each needs
+var scheduledModules = map[string]struct {
+	capability string
+	run        func(a *NOPAgent)
+}{
+	"host":    {"host", (*NOPAgent).sendHostInfo},
+	"asset":   {"asset", (*NOPAgent).discoverAssets},
+	"network": {"asset", (*NOPAgent).checkNetworkChange},
+}
+
+func (a *NOPAgent) schedulesPath() string {
+	return filepath.Join(a.stateDir(), "schedules.json")
+}
+
+// loadSchedules restores installed tasks; runs missed while the agent was
+// down are skipped, not caught up
+func (a *NOPAgent) loadSchedules() {
+	var tasks []*scheduledTask
+	err := a.readState(a.schedulesPath(), "schedules", &tasks)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Stored schedules unreadable: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+	a.scheduleMutex.Lock()
+	defer a.scheduleMutex.Unlock()
+	for _, task := range tasks {
+		schedule, err := cron.Parse(task.Cron)
+		if err != nil {
+			log.Printf("[%s] Dropping schedule %s: %v", time.Now().Format(time.RFC3339), task.ID, err)
+			continue
+		}
+		task.schedule = schedule
+		a.planTask(task, time.Now())
+		a.schedules[task.ID] = task
+	}
+}
+
+// saveSchedules seals the installed tasks; the caller holds scheduleMutex
+func (a *NOPAgent) saveSchedules() error {
+	tasks := make([]*scheduledTask, 0, len(a.schedules))
+	for _, task := range a.schedules {
+		tasks = append(tasks, task)
+	}
+	return a.writeState(a.schedulesPath(), "schedules", tasks)
+}
+
+func (a *NOPAgent) planTask(task *scheduledTask, after time.Time) {
+	task.next = task.schedule.Next(after)
+	task.NextRun = ""
+	if !task.next.IsZero() {
+		task.NextRun = task.next.UTC().Format(time.RFC3339)
+	}
+}
+
+// checkAction validates a scheduled action: {"type": "command", "command":
+// ..., "timeout_seconds": ...}, with the execution options of a command
+// message, the same as a script message, or {"type": "module", "module":
+// "host"}
+func (a *NOPAgent) checkAction(action map[string]interface{}) error {
+	switch actionType, _ := action["type"].(string); actionType {
+	case "command", "script":
+		if _, err := a.commandSpec(action); err != nil {
+			return err
+		}
+		if !a.capabilities["access"] {
+			return newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled")
+		}
+		// Nobody would be asked to approve each run
+		if _, held := a.approvalRole(approvalClass(actionType)); held {
+			return newAgentError(ErrPermission, "approval_required", "commands need approval and cannot be scheduled")
+		}
+	case "module":
+		name, _ := action["module"].(string)
+		module, ok := scheduledModules[name]
+		if !ok {
+			return newAgentError(ErrInvalidRequest, "unknown_module", "module %q cannot be scheduled", name)
+		}
+		if !a.capabilities[module.capability] {
+			return newAgentError(ErrNotSupported, "capability_disabled", "%s capability is not enabled", module.capability)
+		}
+	default:
+		return newAgentError(ErrInvalidRequest, "invalid_action", "action type must be command, script or module")
+	}
+	return nil
+}
+
+// TaskScheduler starts scheduled tasks when they fall due, connected or not
+func (a *NOPAgent) TaskScheduler() {
+	for a.running {
+		now := time.Now()
+		wait := time.Minute
+		due := make([]*scheduledTask, 0)
+		a.scheduleMutex.Lock()
+		for _, task := range a.schedules {
+			if task.next.IsZero() {
+				continue
+			}
+			if !task.next.After(now) {
+				due = append(due, task)
+				task.LastRun = now.UTC().Format(time.RFC3339)
+				a.planTask(task, now)
+			}
+			if until := time.Until(task.next); !task.next.IsZero() && until < wait {
+				wait = until
+			}
+		}
+		if len(due) > 0 {
+			if err := a.saveSchedules(); err != nil {
+				log.Printf("[%s] Saving schedules failed: %v", time.Now().Format(time.RFC3339), err)
+			}
+		}
+		a.scheduleMutex.Unlock()
+
+		for _, task := range due {
+			a.runScheduled(task)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-a.scheduleSignal:
+		}
+	}
+}
+
+func (a *NOPAgent) wakeScheduler() {
+	select {
+	case a.scheduleSignal <- struct{}{}:
+	default:
+	}
+}
+
+// runScheduled queues one run of a task as a job. Results carry the
+// schedule_id and are spooled while the C2 is unreachable.
+func (a *NOPAgent) runScheduled(task *scheduledTask) {
+	log.Printf("[%s] Running scheduled task %s", time.Now().Format(time.RFC3339), task.ID)
+	msg := make(map[string]interface{}, len(task.Action)+1)
+	for k, v := range task.Action {
+		msg[k] = v
+	}
+	msg["schedule_id"] = task.ID
+
+	job := &Job{ID: newID(), ScheduleID: task.ID, Status: "queued", msg: msg}
+	job.Type, _ = msg["type"].(string)
+	job.Module, _ = msg["module"].(string)
+	if job.Type == "command" || job.Type == "script" {
+		job.Class = approvalClass(job.Type)
+		job.Command, _ = a.commandSpec(msg)
+	}
+	if !a.bindJob(job) {
+		return
+	}
+	a.enqueueJob(job)
+}
+
+// scheduledResult reports whether data came from a scheduled task
+func scheduledResult(data interface{}) bool {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = m["schedule_id"]
+	return ok
+}
+
+// handleScheduleAdd installs or replaces a recurring task
+func (a *NOPAgent) handleScheduleAdd(msg map[string]interface{}) {
+	expr, _ := msg["cron"].(string)
+	schedule, err := cron.Parse(expr)
+	if err != nil {
+		a.sendError("schedule_add", msg, newAgentError(ErrInvalidRequest, "invalid_cron", "%v", err))
+		return
+	}
+	action, _ := msg["action"].(map[string]interface{})
+	if err := a.checkAction(action); err != nil {
+		a.sendError("schedule_add", msg, err)
+		return
+	}
+	id, _ := msg["schedule_id"].(string)
+	if id == "" {
+		id = newID()
+	}
+
+	task := &scheduledTask{
+		ID:        id,
+		Cron:      expr,
+		Action:    action,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		schedule:  schedule,
+	}
+	a.planTask(task, time.Now())
+	if task.next.IsZero() {
+		a.sendError("schedule_add", msg, newAgentError(ErrInvalidRequest, "never_runs", "%q never fires", expr))
+		return
+	}
+
+	a.scheduleMutex.Lock()
+	a.schedules[id] = task
+	err = a.saveSchedules()
+	a.scheduleMutex.Unlock()
+	if err != nil {
+		a.sendError("schedule_add", msg, err)
+		return
+	}
+	a.wakeScheduler()
+
+	log.Printf("[%s] Installed schedule %s (%s), next run %s", time.Now().Format(time.RFC3339), id, expr, task.NextRun)
+	a.relayToC2(map[string]interface{}{
+		"type":        "schedule_add_result",
+		"agent_id":    a.agentID,
+		"schedule_id": id,
+		"next_run":    task.NextRun,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (a *NOPAgent) handleScheduleRemove(msg map[string]interface{}) {
+	id, _ := msg["schedule_id"].(string)
+	a.scheduleMutex.Lock()
+	_, ok := a.schedules[id]
+	var err error
+	if ok {
+		delete(a.schedules, id)
+		err = a.saveSchedules()
+	}
+	a.scheduleMutex.Unlock()
+	if !ok {
+		a.sendError("schedule_remove", msg, newAgentError(ErrNotFound, "unknown_schedule", "no schedule with id %q", id))
+		return
+	}
+	if err != nil {
+		a.sendError("schedule_remove", msg, err)
+		return
+	}
+
+	log.Printf("[%s] Removed schedule %s", time.Now().Format(time.RFC3339), id)
+	a.relayToC2(map[string]interface{}{
+		"type":        "schedule_remove_result",
+		"agent_id":    a.agentID,
+		"schedule_id": id,
+		"removed":     true,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (a *NOPAgent) handleScheduleList() {
+	a.scheduleMutex.Lock()
+	tasks := make([]scheduledTask, 0, len(a.schedules))
+	for _, task := range a.schedules {
+		tasks = append(tasks, *task)
+	}
+	a.scheduleMutex.Unlock()
+
+	a.relayToC2(map[string]interface{}{
+		"type":      "schedule_list_result",
+		"agent_id":  a.agentID,
+		"schedules": tasks,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// ============================================================================
+// SCRIPTS - Script bodies staged to a private temp file and run
+// ============================================================================
+
+// scriptLanguages lists, per language, the interpreters to try in order and
+// the file extension they expect
+var scriptLanguages = map[string]struct {
+	interpreters [][]string
+	ext          string
+}{
+	"bash": {[][]string{{"bash"}}, ".sh"},
+	"sh":   {[][]string{{"sh"}, {"bash"}}, ".sh"},
+	"powershell": {[][]string{
+		{"pwsh", "-NoLogo", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File"},
+		{"powershell.exe", "-NoLogo", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File"},
+	}, ".ps1"},
+	"python": {[][]string{{"python3"}, {"python"}, {"py", "-3"}}, ".py"},
+}
+
+// parseScript fills spec from a script message: "language", the "script"
+// body and its "args". Scripts are refused under a restrictive command
+// policy, which cannot see what a script does.
+func (a *NOPAgent) parseScript(msg map[string]interface{}, spec *execSpec) error {
+	if a.cmdPolicy.restrictsShells() {
+		return newAgentError(ErrPermission, "command_not_allowed", "scripts are disabled by the command policy")
+	}
+	body, _ := msg["script"].(string)
+	if body == "" {
+		return newAgentError(ErrInvalidRequest, "missing_script", "script is required")
+	}
+	language, _ := msg["language"].(string)
+	lang, ok := scriptLanguages[language]
+	if !ok {
+		return newAgentError(ErrInvalidRequest, "unsupported_language", "language must be bash, sh, powershell or python")
+	}
+	for _, candidate := range lang.interpreters {
+		if path, err := exec.LookPath(candidate[0]); err == nil {
+			spec.argv = append([]string{path}, candidate[1:]...)
+			break
+		}
+	}
+	if spec.argv == nil {
+		return newAgentError(ErrNotFound, "interpreter_not_found", "no %s interpreter on this host", language)
+	}
+	raw, _ := msg["args"].([]interface{})
+	for _, arg := range raw {
+		s, ok := arg.(string)
+		if !ok {
+			return newAgentError(ErrInvalidRequest, "invalid_args", "args must be a list of strings")
+		}
+		spec.args = append(spec.args, s)
+	}
+	spec.script, spec.ext = body, lang.ext
+	spec.line = fmt.Sprintf("%s script (%d bytes)", language, len(body))
+	return nil
+}
+
+// stageScript writes the script to a file only the agent (or the run_as
+// user) can read, in a fresh temp directory, and appends it and the script
+// arguments to argv. The returned func removes the directory.
+func stageScript(spec *execSpec) (func(), error) {
+	dir, err := os.MkdirTemp("", "nop-script-")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	path := filepath.Join(dir, "script"+spec.ext)
+	if err := os.WriteFile(path, []byte(spec.script), 0600); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if spec.runAs != nil && runtime.GOOS != "windows" {
+		for _, p := range []string{dir, path} {
+			if err := os.Chown(p, int(spec.runAs.uid), int(spec.runAs.gid)); err != nil {
+				cleanup()
+				return nil, err
+			}
+		}
+	}
+	spec.argv = append(append(spec.argv, path), spec.args...)
+	return cleanup, nil
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// SEALED CONFIG - Secrets encrypted to the machine they were generated for
+// ============================================================================
+
+// sealedConfigAAD binds the ciphertext to its purpose
+const sealedConfigAAD = "nop-agent sealed config"
+
+// sealedEnvelope is the rendered sealed configuration:
+// {"bind": ["machine_id"], "salt": "<hex>", "data": "<base64>"}. Data is
+// nonce || AES-256-GCM ciphertext of sealedSecrets under
+// crypto.ConfigKey(values of bind, salt), with sealedConfigAAD as AAD.
+type sealedEnvelope struct {
+	Bind []string `json:"bind"`
+	Salt string   `json:"salt"`
+	Data string   `json:"data"`
+}
+
+// sealedSecrets is what the envelope decrypts to
+type sealedSecrets struct {
+	AuthToken     string `json:"auth_token"`
+	EncryptionKey string `json:"encryption_key"`
+	ServerURL     string `json:"server_url,omitempty"`
+}
+
+// unsealIdentity replaces the secrets in identity with those from its sealed
+// configuration. An unrendered placeholder leaves the plaintext constants in
+// use; a sealed configuration that does not open is an error, so a binary
+// copied to another machine does not start.
+func unsealIdentity(identity Identity) (Identity, error) {
+	raw := strings.TrimSpace(identity.SealedConfig)
+	if raw == "" || strings.HasPrefix(raw, "{{") {
+		return identity, nil
+	}
+
+	var envelope sealedEnvelope
+	if err := json.Unmarshal([]byte(raw), &envelope); err != nil {
+		return identity, fmt.Errorf("unreadable sealed configuration: %v", err)
+	}
+	salt, err := hex.DecodeString(envelope.Salt)
+	if err != nil || len(salt) < 16 {
+		return identity, fmt.Errorf("sealed configuration has no usable salt")
+	}
+	data, err := base64.StdEncoding.DecodeString(envelope.Data)
+	if err != nil {
+		return identity, fmt.Errorf("sealed configuration data is not base64: %v", err)
+	}
+
+	machine := modules.MachineIdentifiers()
+	values := make([]string, 0, len(envelope.Bind))
+	for _, name := range envelope.Bind {
+		value, ok := machine[name]
+		if !ok {
+			return identity, fmt.Errorf("machine identifier %q is not available on this host", name)
+		}
+		values = append(values, value)
+	}
+	key, err := crypto.ConfigKey(values, salt)
+	if err != nil {
+		return identity, err
+	}
+	aead, err := crypto.NewGCM(key)
+	if err != nil {
+		return identity, err
+	}
+	plaintext, err := crypto.Open(crypto.DefaultSuite, aead, nil, data, []byte(sealedConfigAAD), false)
+	if err != nil {
+		return identity, fmt.Errorf("sealed configuration does not open on this machine")
+	}
+
+	var secrets sealedSecrets
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return identity, fmt.Errorf("unreadable sealed secrets: %v", err)
+	}
+	identity.AuthToken, identity.EncryptionKey = secrets.AuthToken, secrets.EncryptionKey
+	if secrets.ServerURL != "" {
+		identity.ServerURL = secrets.ServerURL
+	}
+	return identity, nil
+}
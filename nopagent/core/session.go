@@ -0,0 +1,227 @@
+package core
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+)
+
+// pendingExchange is the agent half of a key exchange awaiting the C2 reply
+type pendingExchange struct {
+	private *ecdh.PrivateKey
+	nonce   []byte
+}
+
+// sessionKeysOffered reports whether registration offers a key exchange;
+// "session_keys": false in the config withholds the offer
+func (a *NOPAgent) sessionKeysOffered() bool {
+	enabled, ok := a.config["session_keys"].(bool)
+	return !ok || enabled
+}
+
+// startKeyExchange runs once the C2 has accepted "session_keys" in
+// "registered". Both sides send an ephemeral X25519 public key (P-256 in
+// FIPS builds, named in "curve") with an HMAC under the static key, and the
+// connection is then encrypted with HKDF-SHA256(shared secret, agent nonce).
+// Recovering EncryptionKey from a binary allows impersonation but not
+// decryption of recorded sessions. Until the reply arrives both sides keep
+// the static key; the C2 switches right after sending its reply and must
+// accept static-key frames from the agent until the first session-key one.
+// Without a reply within "handshake_timeout" (default 10s) the connection is
+// dropped.
+func (a *NOPAgent) startKeyExchange() error {
+	curveName, curve := crypto.KeyAgreement()
+	private, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	public := private.PublicKey().Bytes()
+
+	exchange := &pendingExchange{private: private, nonce: nonce}
+	a.keyMutex.Lock()
+	a.exchange = exchange
+	a.keyMutex.Unlock()
+	if err := a.writeJSON(map[string]interface{}{
+		"type":       "key_exchange",
+		"agent_id":   a.agentID,
+		"public_key": base64.StdEncoding.EncodeToString(public),
+		"curve":      curveName,
+		"nonce":      base64.StdEncoding.EncodeToString(nonce),
+		"mac":        base64.StdEncoding.EncodeToString(crypto.HandshakeMAC(a.masterKey, "agent", public, nonce)),
+	}); err != nil {
+		return err
+	}
+
+	if ht := a.timeout("handshake_timeout", 10*time.Second); ht > 0 {
+		time.AfterFunc(ht, func() {
+			a.keyMutex.RLock()
+			waiting := a.exchange == exchange
+			a.keyMutex.RUnlock()
+			if waiting {
+				log.Printf("[%s] Key exchange unanswered, dropping connection", time.Now().Format(time.RFC3339))
+				a.closeConn()
+			}
+		})
+	}
+	return nil
+}
+
+// handleKeyExchange completes the exchange started by startKeyExchange. A
+// reply that is unsolicited or not authenticated by the C2 drops the
+// connection, since the C2 advertised session keys.
+func (a *NOPAgent) handleKeyExchange(msg map[string]interface{}) {
+	a.keyMutex.Lock()
+	exchange := a.exchange
+	a.exchange = nil
+	a.keyMutex.Unlock()
+	if exchange == nil {
+		log.Printf("[%s] Ignoring unsolicited key_exchange", time.Now().Format(time.RFC3339))
+		return
+	}
+	if err := a.completeExchange(exchange, msg); err != nil {
+		log.Printf("[%s] Key exchange error: %v", time.Now().Format(time.RFC3339), err)
+		a.closeConn()
+		return
+	}
+	log.Printf("[%s] Session key established", time.Now().Format(time.RFC3339))
+}
+
+func (a *NOPAgent) completeExchange(exchange *pendingExchange, reply map[string]interface{}) error {
+	public := exchange.private.PublicKey().Bytes()
+	encodedKey, _ := reply["public_key"].(string)
+	encodedMAC, _ := reply["mac"].(string)
+	peerKey, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return fmt.Errorf("invalid C2 public key")
+	}
+	mac, err := base64.StdEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, crypto.HandshakeMAC(a.masterKey, "server", peerKey, public, exchange.nonce)) {
+		return fmt.Errorf("key exchange reply is not authenticated by the C2")
+	}
+
+	_, curve := crypto.KeyAgreement()
+	peer, err := curve.NewPublicKey(peerKey)
+	if err != nil {
+		return fmt.Errorf("invalid C2 public key: %v", err)
+	}
+	shared, err := exchange.private.ECDH(peer)
+	if err != nil {
+		return err
+	}
+	key, err := crypto.DeriveKey(shared, exchange.nonce, crypto.SessionKeyInfo)
+	if err != nil {
+		return err
+	}
+	return a.setSession(key)
+}
+
+// setSession switches wire encryption to key, or back to the static key
+// when key is nil
+func (a *NOPAgent) setSession(key []byte) error {
+	var session *crypto.WireKey
+	if key != nil {
+		var err error
+		if session, err = a.newWireKey(key); err != nil {
+			return err
+		}
+	}
+	var window *crypto.ReplayWindow
+	if key != nil {
+		window = &crypto.ReplayWindow{}
+	}
+	a.keyMutex.Lock()
+	a.session = session
+	a.sessionWindow = window
+	a.retired = nil
+	if key == nil {
+		a.exchange = nil
+	}
+	a.keyMutex.Unlock()
+	return nil
+}
+
+// handleRekey rotates the wire key on request of the C2. The request carries
+// a fresh public key on the key exchange curve and an HMAC under the current key; the new key is
+// HKDF(shared secret || current key, nonce), so it chains from the old one.
+// The agent confirms with rekey_ack under the old key, then switches. The
+// old key keeps opening inbound messages for "rekey_grace" seconds (default
+// 30). A rekey lasts for the current connection; reconnecting runs a new
+// key exchange.
+func (a *NOPAgent) handleRekey(msg map[string]interface{}) {
+	rekeyID, _ := msg["rekey_id"].(string)
+	decode := func(field string) []byte {
+		s, _ := msg[field].(string)
+		b, _ := base64.StdEncoding.DecodeString(s)
+		return b
+	}
+	peerKey, nonce, mac := decode("public_key"), decode("nonce"), decode("mac")
+
+	current, _ := a.wireKeys()
+	base := current.Secret
+	if rekeyID == "" || len(nonce) < 16 || !hmac.Equal(mac, crypto.HandshakeMAC(base, "rekey", []byte(rekeyID), peerKey, nonce)) {
+		log.Printf("[%s] Rejecting unauthenticated rekey request", time.Now().Format(time.RFC3339))
+		a.sendError("rekey", msg, newAgentError(ErrAuth, "rekey_unauthenticated", "rekey request is not authenticated by the current key"))
+		return
+	}
+
+	_, curve := crypto.KeyAgreement()
+	peer, err := curve.NewPublicKey(peerKey)
+	if err != nil {
+		a.sendError("rekey", msg, newAgentError(ErrInvalidRequest, "invalid_public_key", "invalid public key: %v", err))
+		return
+	}
+	private, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		a.sendError("rekey", msg, err)
+		return
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		a.sendError("rekey", msg, newAgentError(ErrInvalidRequest, "invalid_public_key", "key agreement failed: %v", err))
+		return
+	}
+	key, err := crypto.DeriveKey(append(shared, base...), nonce, crypto.RekeyInfo)
+	if err != nil {
+		a.sendError("rekey", msg, err)
+		return
+	}
+	session, err := a.newWireKey(key)
+	if err != nil {
+		a.sendError("rekey", msg, err)
+		return
+	}
+
+	public := private.PublicKey().Bytes()
+	if err := a.sendEncrypted(map[string]interface{}{
+		"type":       "rekey_ack",
+		"agent_id":   a.agentID,
+		"rekey_id":   rekeyID,
+		"public_key": base64.StdEncoding.EncodeToString(public),
+		"mac":        base64.StdEncoding.EncodeToString(crypto.HandshakeMAC(base, "rekey_ack", []byte(rekeyID), public, nonce)),
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		log.Printf("[%s] Rekey %s not confirmed, keeping current key: %v", time.Now().Format(time.RFC3339), rekeyID, err)
+		return
+	}
+
+	a.keyMutex.Lock()
+	a.retired = a.session
+	if a.retired == nil {
+		a.retired = a.staticWire
+	}
+	a.retiredUntil = time.Now().Add(a.timeout("rekey_grace", 30*time.Second))
+	a.session = session
+	a.keyMutex.Unlock()
+	log.Printf("[%s] Rekey %s complete", time.Now().Format(time.RFC3339), rekeyID)
+}
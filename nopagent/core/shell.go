@@ -0,0 +1,224 @@
+package core
+
+import (
+	"encoding/base64"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/pty"
+)
+
+// ============================================================================
+// INTERACTIVE SHELLS - PTY sessions streamed over the C2 connection
+// ============================================================================
+
+// shellReadSize bounds the output carried by one shell_output message
+const shellReadSize = 16 << 10
+
+// shellSession is an open interactive shell
+type shellSession struct {
+	ID        string `json:"session_id"`
+	Shell     string `json:"shell"`
+	PID       int    `json:"pid"`
+	PTY       bool   `json:"pty"`
+	StartedAt string `json:"started_at"`
+	term      pty.Terminal
+	started   time.Time
+}
+
+// shellArgv resolves the shell requested by the C2. Unix agents accept
+// bash, zsh and sh, defaulting to $SHELL; Windows agents accept cmd,
+// powershell and pwsh, defaulting to cmd.
+func shellArgv(name string) ([]string, error) {
+	allowed := map[string][]string{"bash": {"bash", "-i"}, "zsh": {"zsh", "-i"}, "sh": {"sh", "-i"}}
+	if runtime.GOOS == "windows" {
+		allowed = map[string][]string{"cmd": {"cmd.exe"}, "powershell": {"powershell.exe", "-NoLogo"}, "pwsh": {"pwsh.exe", "-NoLogo"}}
+	}
+	if name == "" {
+		name = "cmd"
+		if runtime.GOOS != "windows" {
+			name = "sh"
+			if path, err := exec.LookPath(os.Getenv("SHELL")); err == nil {
+				return []string{path, "-i"}, nil
+			}
+		}
+	}
+	argv, ok := allowed[name]
+	if !ok {
+		return nil, newAgentError(ErrInvalidRequest, "unsupported_shell", "shell %q is not supported on %s", name, runtime.GOOS)
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{path}, argv[1:]...), nil
+}
+
+// handleShellOpen starts a shell on a PTY, or on pipes where the platform
+// has none, and streams its output as shell_output
+func (a *NOPAgent) handleShellOpen(msg map[string]interface{}) {
+	if !a.capabilities["access"] {
+		a.sendError("shell_open", msg, newAgentError(ErrNotSupported, "capability_disabled", "access capability is not enabled"))
+		return
+	}
+	// An interactive shell would bypass the command policy
+	if a.cmdPolicy.restrictsShells() {
+		a.sendError("shell_open", msg, newAgentError(ErrPermission, "command_not_allowed", "interactive shells are disabled by the command policy"))
+		return
+	}
+	name, _ := msg["shell"].(string)
+	argv, err := shellArgv(name)
+	if err != nil {
+		a.sendError("shell_open", msg, err)
+		return
+	}
+	cols, _ := msg["cols"].(float64)
+	rows, _ := msg["rows"].(float64)
+
+	id, _ := msg["session_id"].(string)
+	if id == "" {
+		id = newID()
+	}
+	a.shellMutex.Lock()
+	_, exists := a.shells[id]
+	a.shellMutex.Unlock()
+	if exists {
+		a.sendError("shell_open", msg, newAgentError(ErrInvalidRequest, "duplicate_session", "shell session %s is already open", id))
+		return
+	}
+
+	env := append(os.Environ(), "TERM=xterm-256color")
+	usePTY := true
+	term, err := pty.Start(argv, env, "", int(cols), int(rows))
+	if err == pty.ErrUnsupported {
+		usePTY = false
+		term, err = pty.Pipe(argv, env, "")
+	}
+	if err != nil {
+		a.sendError("shell_open", msg, err)
+		return
+	}
+
+	session := &shellSession{
+		ID:        id,
+		Shell:     argv[0],
+		PID:       term.Pid(),
+		PTY:       usePTY,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+		term:      term,
+		started:   time.Now(),
+	}
+	a.shellMutex.Lock()
+	a.shells[id] = session
+	a.shellMutex.Unlock()
+
+	log.Printf("[%s] Shell session %s opened: %s (pid %d, pty %v)", time.Now().Format(time.RFC3339), id, argv[0], session.PID, usePTY)
+	a.writeJSON(map[string]interface{}{
+		"type":       "shell_opened",
+		"agent_id":   a.agentID,
+		"session_id": id,
+		"shell":      session.Shell,
+		"pid":        session.PID,
+		"pty":        usePTY,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+	go a.pumpShell(session)
+}
+
+// pumpShell relays a session's output in order until the shell exits, then
+// reports shell_closed
+func (a *NOPAgent) pumpShell(session *shellSession) {
+	buf := make([]byte, shellReadSize)
+	var seq uint64
+	for {
+		n, err := session.term.Read(buf)
+		if n > 0 {
+			seq++
+			a.writeJSON(map[string]interface{}{
+				"type":       "shell_output",
+				"agent_id":   a.agentID,
+				"session_id": session.ID,
+				"seq":        seq,
+				"data":       base64.StdEncoding.EncodeToString(buf[:n]),
+			})
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	code, err := session.term.Wait()
+	session.term.Close()
+	a.shellMutex.Lock()
+	delete(a.shells, session.ID)
+	a.shellMutex.Unlock()
+
+	result := map[string]interface{}{
+		"type":        "shell_closed",
+		"agent_id":    a.agentID,
+		"session_id":  session.ID,
+		"exit_code":   code,
+		"duration_ms": time.Since(session.started).Milliseconds(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		result["error"] = classifyError(err)
+	}
+	log.Printf("[%s] Shell session %s closed: exit %d", time.Now().Format(time.RFC3339), session.ID, code)
+	a.writeJSON(result)
+}
+
+// shellSessionFor looks up the session named by msg, reporting unknown IDs
+func (a *NOPAgent) shellSessionFor(reqType string, msg map[string]interface{}) *shellSession {
+	id, _ := msg["session_id"].(string)
+	a.shellMutex.Lock()
+	session := a.shells[id]
+	a.shellMutex.Unlock()
+	if session == nil {
+		a.sendError(reqType, msg, newAgentError(ErrNotFound, "unknown_session", "no shell session with id %q", id))
+	}
+	return session
+}
+
+// handleShellInput writes keystrokes, base64 in "data", to a session
+func (a *NOPAgent) handleShellInput(msg map[string]interface{}) {
+	session := a.shellSessionFor("shell_input", msg)
+	if session == nil {
+		return
+	}
+	encoded, _ := msg["data"].(string)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		a.sendError("shell_input", msg, newAgentError(ErrInvalidRequest, "invalid_data", "data must be base64"))
+		return
+	}
+	if _, err := session.term.Write(data); err != nil {
+		a.sendError("shell_input", msg, err)
+	}
+}
+
+func (a *NOPAgent) handleShellResize(msg map[string]interface{}) {
+	session := a.shellSessionFor("shell_resize", msg)
+	if session == nil {
+		return
+	}
+	cols, _ := msg["cols"].(float64)
+	rows, _ := msg["rows"].(float64)
+	if err := session.term.Resize(int(cols), int(rows)); err != nil {
+		a.sendError("shell_resize", msg, err)
+	}
+}
+
+// handleShellClose ends a session; shell_closed follows once its output has
+// drained
+func (a *NOPAgent) handleShellClose(msg map[string]interface{}) {
+	session := a.shellSessionFor("shell_close", msg)
+	if session == nil {
+		return
+	}
+	log.Printf("[%s] Closing shell session %s", time.Now().Format(time.RFC3339), session.ID)
+	session.term.Close()
+}
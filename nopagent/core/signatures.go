@@ -0,0 +1,125 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// COMMAND SIGNATURES - Ed25519 verification of destructive C2 commands
+// ============================================================================
+
+// A signed command arrives as {"type": "kill", "signed_payload": <base64>,
+// "signature": <base64>}. The payload is the real message as JSON and must
+// repeat the type and carry agent_id (or "*" for the whole fleet), a random
+// nonce and issued_at. The agent verifies the exact payload bytes, so the C2
+// never has to reproduce a canonical encoding, and dispatches the payload
+// instead of the outer message.
+
+// signatureMaxAge bounds clock skew and how long nonces are remembered. It is
+// a constant so a hijacked transport cannot widen the replay window.
+const signatureMaxAge = 5 * time.Minute
+
+type commandKey struct {
+	key    ed25519.PublicKey // nil when the embedded key is unusable
+	nonces map[string]time.Time
+	mutex  sync.Mutex
+}
+
+// loadCommandKey parses the embedded C2 signing key. An unrendered placeholder leaves
+// signing off; a rendered but invalid key fails closed and rejects every
+// signed command.
+func loadCommandKey(raw string) *commandKey {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasPrefix(raw, "{{") {
+		log.Printf("[%s] No C2 signing key embedded, destructive commands are not verified", time.Now().Format(time.RFC3339))
+		return nil
+	}
+	ck := &commandKey{nonces: make(map[string]time.Time)}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		log.Printf("[%s] Invalid C2 signing key, signed commands will be rejected", time.Now().Format(time.RFC3339))
+		return ck
+	}
+	ck.key = ed25519.PublicKey(key)
+	return ck
+}
+
+func (a *NOPAgent) requiresSignature(msgType string) bool {
+	if a.commandKey == nil {
+		return false
+	}
+	spec, ok := catalogSpec(msgType)
+	return ok && spec.Signed
+}
+
+// verifySigned checks a signed command and returns its payload
+func (a *NOPAgent) verifySigned(msg map[string]interface{}) (map[string]interface{}, error) {
+	encodedPayload, _ := msg["signed_payload"].(string)
+	encodedSignature, _ := msg["signature"].(string)
+	if encodedPayload == "" || encodedSignature == "" {
+		return nil, fmt.Errorf("message is not signed")
+	}
+	payload, err := base64.StdEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, fmt.Errorf("signed_payload is not base64")
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, fmt.Errorf("signature is not base64")
+	}
+	if a.commandKey.key == nil || !ed25519.Verify(a.commandKey.key, payload, signature) {
+		return nil, fmt.Errorf("signature does not verify")
+	}
+
+	var inner map[string]interface{}
+	if err := json.Unmarshal(payload, &inner); err != nil {
+		return nil, fmt.Errorf("signed payload is not a JSON object")
+	}
+	if inner["type"] != msg["type"] {
+		return nil, fmt.Errorf("signed payload is a %v, not a %v", inner["type"], msg["type"])
+	}
+	if target, _ := inner["agent_id"].(string); target != a.agentID && target != "*" {
+		return nil, fmt.Errorf("signed payload is for agent %q", target)
+	}
+	nonce, _ := inner["nonce"].(string)
+	if len(nonce) < 16 {
+		return nil, fmt.Errorf("signed payload has no nonce")
+	}
+	issuedAt, _ := inner["issued_at"].(string)
+	issued, err := time.Parse(time.RFC3339, issuedAt)
+	if err != nil {
+		return nil, fmt.Errorf("signed payload has no issued_at")
+	}
+	if age := time.Since(issued); age > signatureMaxAge || age < -signatureMaxAge {
+		return nil, fmt.Errorf("signed payload issued at %s is outside the %s window", issuedAt, signatureMaxAge)
+	}
+	if !a.commandKey.fresh(nonce) {
+		return nil, fmt.Errorf("nonce was already used")
+	}
+	return inner, nil
+}
+
+// fresh records a nonce and reports whether it was unused. Entries are kept
+// for twice the signature window so a payload cannot outlive its nonce.
+func (ck *commandKey) fresh(nonce string) bool {
+	ck.mutex.Lock()
+	defer ck.mutex.Unlock()
+	now := time.Now()
+	for seen, at := range ck.nonces {
+		if now.Sub(at) > 2*signatureMaxAge {
+			delete(ck.nonces, seen)
+		}
+	}
+	if _, used := ck.nonces[nonce]; used {
+		return false
+	}
+	ck.nonces[nonce] = now
+	return true
+}
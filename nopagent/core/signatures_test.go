@@ -0,0 +1,141 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signCommand wraps payload the way the C2 signs a command
+func signCommand(t *testing.T, key ed25519.PrivateKey, payload map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{
+		"type":           payload["type"],
+		"signed_payload": base64.StdEncoding.EncodeToString(data),
+		"signature":      base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}
+}
+
+func TestVerifySigned(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	key := ed25519.NewKeyFromSeed(seed)
+	seed[0] = 1
+	otherKey := ed25519.NewKeyFromSeed(seed)
+	publicKey := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+
+	nonce := 0
+	payload := func(changes map[string]interface{}) map[string]interface{} {
+		nonce++
+		p := map[string]interface{}{
+			"type":      "terminate",
+			"agent_id":  "test-agent",
+			"nonce":     fmt.Sprintf("nonce-%016d", nonce),
+			"issued_at": time.Now().UTC().Format(time.RFC3339),
+			"message":   "maintenance",
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(p, k)
+			} else {
+				p[k] = v
+			}
+		}
+		return p
+	}
+	tamper := func(msg map[string]interface{}) map[string]interface{} {
+		data, _ := base64.StdEncoding.DecodeString(msg["signed_payload"].(string))
+		msg["signed_payload"] = base64.StdEncoding.EncodeToString([]byte(strings.Replace(string(data), "maintenance", "compromised", 1)))
+		return msg
+	}
+
+	tests := []struct {
+		name      string
+		publicKey string
+		msg       map[string]interface{}
+		replay    bool
+		wantErr   string
+	}{
+		{name: "valid", msg: signCommand(t, key, payload(nil))},
+		{name: "whole fleet", msg: signCommand(t, key, payload(map[string]interface{}{"agent_id": "*"}))},
+		{name: "replayed", msg: signCommand(t, key, payload(nil)), replay: true, wantErr: "nonce was already used"},
+		{name: "tampered payload", msg: tamper(signCommand(t, key, payload(nil))), wantErr: "does not verify"},
+		{name: "signed by another key", msg: signCommand(t, otherKey, payload(nil)), wantErr: "does not verify"},
+		{name: "missing signature", msg: map[string]interface{}{"type": "terminate", "message": "maintenance"}, wantErr: "not signed"},
+		{name: "signature not base64", msg: func() map[string]interface{} {
+			msg := signCommand(t, key, payload(nil))
+			msg["signature"] = "%%"
+			return msg
+		}(), wantErr: "not base64"},
+		{name: "unusable embedded key", publicKey: "c2hvcnQ=", msg: signCommand(t, key, payload(nil)), wantErr: "does not verify"},
+		{name: "outer type differs", msg: func() map[string]interface{} {
+			msg := signCommand(t, key, payload(nil))
+			msg["type"] = "kill"
+			return msg
+		}(), wantErr: "not a kill"},
+		{name: "other agent", msg: signCommand(t, key, payload(map[string]interface{}{"agent_id": "agent-2"})), wantErr: "for agent"},
+		{name: "no nonce", msg: signCommand(t, key, payload(map[string]interface{}{"nonce": nil})), wantErr: "no nonce"},
+		{name: "expired", msg: signCommand(t, key, payload(map[string]interface{}{
+			"issued_at": time.Now().Add(-2 * signatureMaxAge).UTC().Format(time.RFC3339)})), wantErr: "outside"},
+		{name: "from the future", msg: signCommand(t, key, payload(map[string]interface{}{
+			"issued_at": time.Now().Add(2 * signatureMaxAge).UTC().Format(time.RFC3339)})), wantErr: "outside"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t)
+			if tt.publicKey == "" {
+				tt.publicKey = publicKey
+			}
+			a.commandKey = loadCommandKey(tt.publicKey)
+
+			inner, err := a.verifySigned(tt.msg)
+			if tt.replay {
+				if err != nil {
+					t.Fatalf("first delivery: %v", err)
+				}
+				inner, err = a.verifySigned(tt.msg)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifySigned: %v", err)
+				}
+				if inner["message"] != "maintenance" {
+					t.Errorf("payload = %v", inner)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verifySigned = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyMessage(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	a := newTestAgent(t)
+
+	// Without an embedded key nothing is verified
+	unsigned := map[string]interface{}{"type": "terminate"}
+	if msg, ok := a.verifyMessage(unsigned); !ok || msg["type"] != "terminate" {
+		t.Errorf("unsigned terminate refused without an embedded key")
+	}
+
+	a.commandKey = loadCommandKey(base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	if _, ok := a.verifyMessage(unsigned); ok {
+		t.Error("unsigned terminate accepted")
+	}
+	if report := lastReport(t, a); report["type"] != "error" {
+		t.Errorf("refusal reported as %v", report["type"])
+	}
+	if msg, ok := a.verifyMessage(map[string]interface{}{"type": "ping"}); !ok || msg["type"] != "ping" {
+		t.Error("unsigned ping refused")
+	}
+}
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ============================================================================
+// SIMULATION - Synthetic telemetry for server and UI development
+// ============================================================================
+
+// simScenario is the --simulate file. Hosts are either listed in "assets" or
+// generated ("hosts" per agent inside "subnet", shifted by one subnet per
+// agent). Example:
+//
+//	{"agents": 200, "seed": 1, "subnet": "10.20.0.0/24", "hosts": 40,
+//	 "churn": 0.05, "traffic": {"bytes_per_sec": 250000, "jitter": 0.3, "spike_chance": 0.01},
+//	 "host": {"platform_release": "ubuntu", "cpu_percent": 25}}
+type simScenario struct {
+	Agents   int                      `json:"agents"`
+	Seed     int64                    `json:"seed"`
+	Hostname string                   `json:"hostname"`
+	Platform string                   `json:"platform"`
+	Subnet   string                   `json:"subnet"`
+	Hosts    int                      `json:"hosts"`
+	Assets   []map[string]interface{} `json:"assets"`
+	Churn    float64                  `json:"churn"`
+	Traffic  struct {
+		BytesPerSec float64 `json:"bytes_per_sec"`
+		Jitter      float64 `json:"jitter"`
+		SpikeChance float64 `json:"spike_chance"`
+	} `json:"traffic"`
+	Host map[string]interface{} `json:"host"`
+}
+
+// simVendorPrefixes give synthetic hosts MACs that resolve through the OUI table
+var simVendorPrefixes = []string{"00:50:56", "00:0c:29", "b8:27:eb", "f0:9f:c2", "3c:5a:b4", "00:1b:21", "ac:de:48"}
+
+// simHost stands in for the live system of one simulated agent
+type simHost struct {
+	scenario *simScenario
+	index    int
+	hostname string
+	address  string
+	rng      *mathrand.Rand
+	hosts    []map[string]interface{}
+	online   []bool
+	sent     uint64
+	recv     uint64
+	lastAt   time.Time
+	mutex    sync.Mutex
+}
+
+func loadScenario(path string) (*simScenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &simScenario{}
+	if err := json.Unmarshal(data, scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %v", err)
+	}
+	if scenario.Agents <= 0 {
+		scenario.Agents = 1
+	}
+	if scenario.Hostname == "" {
+		scenario.Hostname = "sim"
+	}
+	if scenario.Subnet == "" {
+		scenario.Subnet = "10.200.0.0/24"
+	}
+	if scenario.Hosts <= 0 && len(scenario.Assets) == 0 {
+		scenario.Hosts = 20
+	}
+	if scenario.Traffic.BytesPerSec <= 0 {
+		scenario.Traffic.BytesPerSec = 100000
+	}
+	return scenario, nil
+}
+
+func newSimHost(scenario *simScenario, index int) (*simHost, error) {
+	_, subnet, err := net.ParseCIDR(scenario.Subnet)
+	if err != nil || subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("subnet must be an IPv4 CIDR: %q", scenario.Subnet)
+	}
+	ones, bits := subnet.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	base := binary.BigEndian.Uint32(subnet.IP.To4()) + uint32(index)*size
+	addr := func(offset uint32) string {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+offset)
+		return ip.String()
+	}
+
+	s := &simHost{
+		scenario: scenario,
+		index:    index,
+		hostname: fmt.Sprintf("%s-%03d", scenario.Hostname, index),
+		address:  addr(1),
+		rng:      mathrand.New(mathrand.NewSource(scenario.Seed + int64(index))),
+		lastAt:   time.Now(),
+	}
+
+	if len(scenario.Assets) > 0 {
+		for _, asset := range scenario.Assets {
+			host := make(map[string]interface{}, len(asset))
+			for k, v := range asset {
+				host[k] = v
+			}
+			s.hosts = append(s.hosts, host)
+		}
+	} else {
+		for k := 0; k < scenario.Hosts && uint32(k+2) < size-1; k++ {
+			prefix := simVendorPrefixes[s.rng.Intn(len(simVendorPrefixes))]
+			s.hosts = append(s.hosts, map[string]interface{}{
+				"ip":       addr(uint32(k + 2)),
+				"mac":      fmt.Sprintf("%s:%02x:%02x:%02x", prefix, s.rng.Intn(256), s.rng.Intn(256), s.rng.Intn(256)),
+				"hostname": fmt.Sprintf("%s-host-%02d", s.hostname, k),
+				"family":   "ipv4",
+			})
+		}
+	}
+	s.online = make([]bool, len(s.hosts))
+	for i := range s.online {
+		s.online[i] = true
+	}
+	return s, nil
+}
+
+// assets returns one discovery round; each host flips online/offline with
+// probability "churn" per round
+func (s *simHost) assets() []map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	assets := make([]map[string]interface{}, 0, len(s.hosts))
+	for i, host := range s.hosts {
+		if s.rng.Float64() < s.scenario.Churn {
+			s.online[i] = !s.online[i]
+		}
+		if !s.online[i] {
+			continue
+		}
+		asset := make(map[string]interface{}, len(host)+2)
+		for k, v := range host {
+			asset[k] = v
+		}
+		asset["status"] = "online"
+		asset["discovered_at"] = now
+		assets = append(assets, asset)
+	}
+	return assets
+}
+
+// trafficStats advances monotonic counters at the scenario rate with jitter
+// and occasional tenfold spikes
+func (s *simHost) trafficStats() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	elapsed := time.Since(s.lastAt).Seconds()
+	s.lastAt = time.Now()
+	t := s.scenario.Traffic
+	rate := t.BytesPerSec * (1 + t.Jitter*(2*s.rng.Float64()-1))
+	if s.rng.Float64() < t.SpikeChance {
+		rate *= 10
+	}
+	if rate < 0 {
+		rate = 0
+	}
+	s.recv += uint64(rate * elapsed)
+	s.sent += uint64(rate * elapsed * (0.2 + 0.3*s.rng.Float64()))
+
+	return map[string]interface{}{
+		"bytes_sent":   s.sent,
+		"bytes_recv":   s.recv,
+		"packets_sent": s.sent / 800,
+		"packets_recv": s.recv / 1200,
+		"errors_in":    uint64(0),
+		"errors_out":   uint64(0),
+	}
+}
+
+func (s *simHost) hostInfo() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	platform := s.scenario.Platform
+	if platform == "" {
+		platform = runtime.GOOS
+	}
+	info := map[string]interface{}{
+		"hostname":       s.hostname,
+		"platform":       platform,
+		"architecture":   runtime.GOARCH,
+		"go_version":     runtime.Version(),
+		"cpu_percent":    5 + 40*s.rng.Float64(),
+		"memory_percent": 30 + 30*s.rng.Float64(),
+		"memory_total":   uint64(8 << 30),
+		"disk_percent":   40 + 20*s.rng.Float64(),
+		"disk_total":     uint64(256 << 30),
+		"interfaces": []map[string]interface{}{
+			{"name": "eth0", "ip": s.address, "family": "ipv4", "prefix": s.address + "/" + strings.SplitN(s.scenario.Subnet, "/", 2)[1], "status": "up"},
+		},
+	}
+	for k, v := range s.scenario.Host {
+		info[k] = v
+	}
+	return info
+}
+
+func (s *simHost) overlaySystemInfo(info map[string]interface{}) {
+	info["hostname"] = s.hostname
+	info["ip_address"] = s.address
+	info["ipv4"] = s.address
+	info["ipv6"] = ""
+	if s.scenario.Platform != "" {
+		info["platform"] = s.scenario.Platform
+	}
+}
+
+// runSimulation starts one agent per scenario "agents" against the embedded
+// C2. Each gets its own ID, fingerprint and state directory so the server
+// sees a fleet of distinct agents.
+func runSimulation(identity Identity, path string) error {
+	scenario, err := loadScenario(path)
+	if err != nil {
+		return err
+	}
+	stateRoot, err := os.MkdirTemp("", ServiceName+"-sim-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stateRoot)
+
+	agents := make([]*NOPAgent, 0, scenario.Agents)
+	for i := 0; i < scenario.Agents; i++ {
+		sim, err := newSimHost(scenario, i)
+		if err != nil {
+			return err
+		}
+		agent := NewNOPAgent(identity)
+		if scenario.Agents > 1 {
+			agent.agentID = fmt.Sprintf("%s-sim-%03d", identity.AgentID, i)
+			agent.agentName = fmt.Sprintf("%s (sim %03d)", identity.AgentName, i)
+		}
+		config := make(map[string]interface{}, len(agent.config)+1)
+		for k, v := range agent.config {
+			config[k] = v
+		}
+		config["state_dir"] = filepath.Join(stateRoot, sim.hostname)
+		agent.config = config
+		agent.sim = sim
+		sum := sha256.Sum256([]byte(agent.agentID + "|" + sim.hostname))
+		agent.fingerprint = hex.EncodeToString(sum[:])
+		agent.previousPrint = ""
+		agent.spoolPending = false
+		agents = append(agents, agent)
+	}
+
+	log.Printf("[%s] Simulating %d agents from %s", time.Now().Format(time.RFC3339), len(agents), path)
+	for i, agent := range agents {
+		go agent.Run()
+		// Stagger connects so a large fleet does not arrive in one burst
+		if i < len(agents)-1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Printf("[%s] Simulation stopped by user", time.Now().Format(time.RFC3339))
+	for _, agent := range agents {
+		agent.running = false
+		agent.closeConn()
+	}
+	return nil
+}
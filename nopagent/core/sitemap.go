@@ -0,0 +1,101 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+func (a *NOPAgent) siteMapPath() string {
+	return filepath.Join(a.stateDir(), "site_map.json")
+}
+
+func (a *NOPAgent) loadSiteMap() {
+	var labels []modules.SiteLabel
+	err := a.readState(a.siteMapPath(), "site_map", &labels)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Stored site map unreadable: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+	if labels, err = modules.CompileSiteMap(labels); err != nil {
+		log.Printf("[%s] Stored site map invalid: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+	a.siteMutex.Lock()
+	a.siteMap = labels
+	a.siteMutex.Unlock()
+}
+
+func (a *NOPAgent) annotateSites(assets []map[string]interface{}) {
+	a.siteMutex.RLock()
+	defer a.siteMutex.RUnlock()
+	if len(a.siteMap) == 0 {
+		return
+	}
+	for _, asset := range assets {
+		ipStr, _ := asset["ip"].(string)
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			continue
+		}
+		for _, label := range a.siteMap {
+			if !label.Contains(ip) {
+				continue
+			}
+			if label.Site != "" {
+				asset["site"] = label.Site
+			}
+			if label.Zone != "" {
+				asset["zone"] = label.Zone
+			}
+			if label.Criticality != "" {
+				asset["criticality"] = label.Criticality
+			}
+			break
+		}
+	}
+}
+
+// handleSiteMapUpdate replaces the site map; an empty list clears it
+func (a *NOPAgent) handleSiteMapUpdate(msg map[string]interface{}) {
+	raw, err := json.Marshal(msg["entries"])
+	if err != nil {
+		a.sendError("site_map_update", msg, err)
+		return
+	}
+	var labels []modules.SiteLabel
+	if err := json.Unmarshal(raw, &labels); err != nil {
+		a.sendError("site_map_update", msg, newAgentError(ErrInvalidRequest, "invalid_entries",
+			"entries must be a list of {cidr, site, zone, criticality}"))
+		return
+	}
+	if labels, err = modules.CompileSiteMap(labels); err != nil {
+		a.sendError("site_map_update", msg, newAgentError(ErrInvalidRequest, "invalid_cidr", "%v", err))
+		return
+	}
+
+	if err := a.writeState(a.siteMapPath(), "site_map", labels); err != nil {
+		a.sendError("site_map_update", msg, err)
+		return
+	}
+
+	a.siteMutex.Lock()
+	a.siteMap = labels
+	a.siteMutex.Unlock()
+	log.Printf("[%s] Site map updated (%d subnets)", time.Now().Format(time.RFC3339), len(labels))
+
+	a.writeJSON(map[string]interface{}{
+		"type":      "site_map_result",
+		"agent_id":  a.agentID,
+		"entries":   len(labels),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
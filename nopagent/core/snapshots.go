@@ -0,0 +1,172 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ============================================================================
+// SNAPSHOTS - Process context attached to alerts
+// ============================================================================
+
+// alertTypes get a process snapshot attached before they are relayed
+var alertTypes = map[string]bool{
+	"traffic_anomaly":  true,
+	"beacon_candidate": true,
+}
+
+// attachSnapshot adds the process tree, open sockets and loaded module hashes
+// of the process behind an alert. Alerts without a "pid" get the processes
+// holding the most established connections instead.
+func (a *NOPAgent) attachSnapshot(event interface{}) {
+	alert, ok := event.(map[string]interface{})
+	if !ok || !alertTypes[protocol.Type(alert)] {
+		return
+	}
+	if enabled, ok := a.config["alert_snapshots"].(bool); ok && !enabled {
+		return
+	}
+	if !a.policy.allows("processes") {
+		return
+	}
+	if _, done := alert["snapshot"]; done {
+		return
+	}
+
+	pids := make([]int32, 0)
+	if pid, ok := alert["pid"].(int32); ok && pid > 0 {
+		pids = append(pids, pid)
+	} else {
+		pids = topConnectionOwners(3)
+	}
+
+	snapshots := make([]map[string]interface{}, 0, len(pids))
+	for _, pid := range pids {
+		snapshots = append(snapshots, a.snapshotProcess(pid))
+	}
+	alert["snapshot"] = map[string]interface{}{
+		"captured_at": time.Now().UTC().Format(time.RFC3339),
+		"processes":   snapshots,
+	}
+}
+
+// topConnectionOwners returns the processes with the most established sockets
+func topConnectionOwners(limit int) []int32 {
+	conns, err := psnet.Connections("inet")
+	if err != nil {
+		return nil
+	}
+	counts := make(map[int32]int)
+	for _, c := range conns {
+		if c.Status == "ESTABLISHED" && c.Pid > 0 {
+			counts[c.Pid]++
+		}
+	}
+	pids := make([]int32, 0, len(counts))
+	for pid := range counts {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return counts[pids[i]] > counts[pids[j]] })
+	if len(pids) > limit {
+		pids = pids[:limit]
+	}
+	return pids
+}
+
+func (a *NOPAgent) snapshotProcess(pid int32) map[string]interface{} {
+	snapshot := map[string]interface{}{"pid": pid}
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		snapshot["error"] = err.Error()
+		return snapshot
+	}
+
+	// Ancestry up to init, nearest parent first
+	tree := make([]map[string]interface{}, 0)
+	for p, depth := proc, 0; p != nil && depth < 16; depth++ {
+		entry := map[string]interface{}{"pid": p.Pid}
+		entry["name"], _ = p.Name()
+		entry["exe"], _ = p.Exe()
+		entry["cmdline"], _ = p.Cmdline()
+		entry["user"], _ = p.Username()
+		tree = append(tree, entry)
+
+		ppid, err := p.Ppid()
+		if err != nil || ppid <= 0 || ppid == p.Pid {
+			break
+		}
+		if p, err = process.NewProcess(ppid); err != nil {
+			break
+		}
+	}
+	snapshot["tree"] = tree
+
+	sockets := make([]map[string]interface{}, 0)
+	if conns, err := proc.Connections(); err == nil {
+		for _, c := range conns {
+			sockets = append(sockets, map[string]interface{}{
+				"local":  net.JoinHostPort(c.Laddr.IP, strconv.Itoa(int(c.Laddr.Port))),
+				"remote": net.JoinHostPort(c.Raddr.IP, strconv.Itoa(int(c.Raddr.Port))),
+				"status": c.Status,
+			})
+		}
+	}
+	snapshot["sockets"] = sockets
+	snapshot["modules"] = a.moduleHashList(proc)
+	return snapshot
+}
+
+// moduleHashList hashes the executable and the shared objects mapped by a
+// process (mapped objects on Linux only); at most 64 modules are hashed,
+// results are cached by path
+func (a *NOPAgent) moduleHashList(proc *process.Process) []map[string]interface{} {
+	paths := make([]string, 0)
+	exe, err := proc.Exe()
+	if err == nil && exe != "" {
+		paths = append(paths, exe)
+	}
+	for _, path := range mappedModules(proc) {
+		if path != exe {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) > 64 {
+		paths = paths[:64]
+	}
+
+	modules := make([]map[string]interface{}, 0, len(paths))
+	for _, path := range paths {
+		a.hashMutex.Lock()
+		sum, cached := a.moduleHashes[path]
+		a.hashMutex.Unlock()
+		if !cached {
+			f, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				continue
+			}
+			sum = hex.EncodeToString(h.Sum(nil))
+			a.hashMutex.Lock()
+			a.moduleHashes[path] = sum
+			a.hashMutex.Unlock()
+		}
+		modules = append(modules, map[string]interface{}{"path": path, "sha256": sum})
+	}
+	return modules
+}
@@ -0,0 +1,24 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// mappedModules lists the files mapped into a process, once each
+func mappedModules(proc *process.Process) []string {
+	paths := make([]string, 0)
+	maps, err := proc.MemoryMaps(false)
+	if err != nil {
+		return paths
+	}
+	seen := map[string]bool{}
+	for _, m := range *maps {
+		if strings.HasPrefix(m.Path, "/") && !seen[m.Path] {
+			seen[m.Path] = true
+			paths = append(paths, m.Path)
+		}
+	}
+	return paths
+}
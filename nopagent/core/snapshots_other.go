@@ -0,0 +1,11 @@
+//go:build !linux
+
+package core
+
+import "github.com/shirou/gopsutil/v3/process"
+
+// mappedModules is not available on this platform; only the executable is
+// hashed
+func mappedModules(proc *process.Process) []string {
+	return nil
+}
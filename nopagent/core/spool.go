@@ -0,0 +1,142 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ============================================================================
+// SPOOL - Encrypted on-disk queue for telemetry during outages
+// ============================================================================
+
+func (a *NOPAgent) spoolDir() string {
+	return filepath.Join(a.stateDir(), "spool")
+}
+
+// spoolFiles returns the spooled records oldest first
+func (a *NOPAgent) spoolFiles() []string {
+	files, _ := filepath.Glob(filepath.Join(a.spoolDir(), "*.msg"))
+	sort.Strings(files)
+	return files
+}
+
+func (a *NOPAgent) spoolBacklog() bool {
+	a.spoolMutex.Lock()
+	defer a.spoolMutex.Unlock()
+	return a.spoolPending
+}
+
+// spool writes a report to disk, dropping the oldest records once the spool
+// exceeds "spool_max_bytes" (default 16 MiB)
+func (a *NOPAgent) spool(data interface{}) {
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	sealed, err := a.store.Seal("spool", plaintext)
+	if err != nil {
+		log.Printf("[%s] Spool error: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+
+	limit := int64(16 << 20)
+	if val, ok := a.config["spool_max_bytes"].(float64); ok && val > 0 {
+		limit = int64(val)
+	}
+
+	a.spoolMutex.Lock()
+	defer a.spoolMutex.Unlock()
+
+	if err := os.MkdirAll(a.spoolDir(), 0700); err != nil {
+		log.Printf("[%s] Spool error: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+
+	// Sequence numbers keep file names in send order even within one clock tick
+	seq := time.Now().UnixNano()
+	if seq <= a.spoolSeq {
+		seq = a.spoolSeq + 1
+	}
+	a.spoolSeq = seq
+	name := filepath.Join(a.spoolDir(), fmt.Sprintf("%020d.msg", seq))
+	if err := os.WriteFile(name, sealed, 0600); err != nil {
+		log.Printf("[%s] Spool error: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+	a.spoolPending = true
+
+	files := a.spoolFiles()
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	dropped := 0
+	for i := 0; total > limit && i < len(files)-1; i++ {
+		if os.Remove(files[i]) == nil {
+			total -= sizes[i]
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		log.Printf("[%s] Spool full, dropped %d oldest reports", time.Now().Format(time.RFC3339), dropped)
+	}
+}
+
+// replaySpool delivers spooled reports in order after reconnecting, stopping
+// at the first failure so the remainder waits for the next connection
+func (a *NOPAgent) replaySpool() {
+	replayed := 0
+	for a.running {
+		a.spoolMutex.Lock()
+		files := a.spoolFiles()
+		if len(files) == 0 {
+			a.spoolPending = false
+		}
+		a.spoolMutex.Unlock()
+		if len(files) == 0 {
+			break
+		}
+
+		for _, f := range files {
+			sealed, err := os.ReadFile(f)
+			if err != nil {
+				continue
+			}
+			var msg map[string]interface{}
+			plaintext, err := a.store.Open("spool", sealed)
+			if err == nil {
+				err = json.Unmarshal(plaintext, &msg)
+			}
+			if err != nil {
+				// Unreadable, e.g. sealed under a previous key
+				log.Printf("[%s] Discarding spooled report %s: %v", time.Now().Format(time.RFC3339), filepath.Base(f), err)
+				os.Remove(f)
+				continue
+			}
+
+			msg["spooled"] = true
+			if !a.admitTelemetry(msg) {
+				os.Remove(f)
+				continue
+			}
+			if err := a.deliver(msg); err != nil {
+				log.Printf("[%s] Spool replay interrupted after %d reports: %v", time.Now().Format(time.RFC3339), replayed, err)
+				return
+			}
+			os.Remove(f)
+			replayed++
+		}
+	}
+	if replayed > 0 {
+		log.Printf("[%s] Replayed %d spooled reports", time.Now().Format(time.RFC3339), replayed)
+	}
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// UPNP DISCOVERY - SSDP search, announcements and device descriptions
+// ============================================================================
+
+// ssdpPolicy returns the "ssdp" config block, e.g. {"enabled": true,
+// "search": true, "listen": true, "describe": true, "wait": 3, "timeout": 3}.
+// UPnP discovery is off unless enabled.
+func (a *NOPAgent) ssdpPolicy() map[string]interface{} {
+	if policy, ok := a.config["ssdp"].(map[string]interface{}); ok {
+		return policy
+	}
+	return map[string]interface{}{}
+}
+
+// ssdpEnabled reports whether the "ssdp" block is enabled and, for part,
+// whether that part has not been switched off
+func (a *NOPAgent) ssdpEnabled(part string) bool {
+	policy := a.ssdpPolicy()
+	enabled, _ := policy["enabled"].(bool)
+	on, set := policy[part].(bool)
+	return enabled && (on || !set)
+}
+
+func (a *NOPAgent) ssdpSeconds(key string, def time.Duration) time.Duration {
+	if val, ok := a.ssdpPolicy()[key].(float64); ok && val > 0 {
+		return time.Duration(val * float64(time.Second))
+	}
+	return def
+}
+
+// searchUPnP multicasts an M-SEARCH and returns an asset per device that
+// answered. The search reaches every host on the attached networks, so it is
+// skipped when one of them overlaps a scan exclusion.
+func (a *NOPAgent) searchUPnP() []map[string]interface{} {
+	if !a.ssdpEnabled("search") || a.options.Profile == "passive" {
+		return nil
+	}
+	exclusions, ok := a.scanExclusions()
+	if !ok {
+		return nil
+	}
+	if attachedExcluded(exclusions) {
+		log.Printf("[%s] SSDP search skipped: an attached network overlaps scan_exclusions", time.Now().Format(time.RFC3339))
+		return nil
+	}
+	responses, err := modules.SSDPSearch(context.Background(), a.ssdpSeconds("wait", 3*time.Second))
+	if err != nil {
+		log.Printf("[%s] SSDP search failed: %v", time.Now().Format(time.RFC3339), err)
+		return nil
+	}
+	assets := make([]map[string]interface{}, 0, len(responses))
+	for _, resp := range responses {
+		assets = append(assets, resp.Asset("ssdp_search"))
+	}
+	return assets
+}
+
+// listenSSDP adds devices announcing themselves with NOTIFY to the passive
+// hosts until ctx is cancelled. Listening sends nothing, so passive builds
+// listen too. A device is recorded at most every five minutes.
+func (a *NOPAgent) listenSSDP(ctx context.Context) {
+	seen := make(map[string]time.Time)
+	err := modules.ListenSSDP(ctx, func(resp modules.SSDPResponse) {
+		if last, ok := seen[resp.IP]; resp.ByeBye || ok && time.Since(last) < 5*time.Minute {
+			return
+		}
+		seen[resp.IP] = time.Now()
+
+		a.hostsMutex.Lock()
+		a.passiveHosts = append(a.passiveHosts, resp.Asset("ssdp_notify"))
+		a.hostsMutex.Unlock()
+	})
+	if err != nil {
+		log.Printf("[%s] SSDP listener stopped: %v", time.Now().Format(time.RFC3339), err)
+	}
+}
+
+// describeUPnP adds the upnp_* fields of the device description to assets
+// with an ssdp_location, unless the cached asset was described from the same
+// location within the last day. At most 32 descriptions are fetched a round.
+func (a *NOPAgent) describeUPnP(assets []map[string]interface{}) {
+	if !a.ssdpEnabled("describe") || a.options.Profile == "passive" {
+		return
+	}
+	exclusions, ok := a.scanExclusions()
+	if !ok {
+		return
+	}
+	timeout := a.ssdpSeconds("timeout", 3*time.Second)
+	fetched := 0
+	// A device can be listed by the search and by its announcements
+	round := make(map[string]map[string]interface{})
+	for _, asset := range assets {
+		ip, _ := asset["ip"].(string)
+		location, _ := asset["ssdp_location"].(string)
+		if location == "" || !probeAllowed(net.ParseIP(ip), exclusions) {
+			continue
+		}
+		if fields, ok := round[location]; ok {
+			for k, v := range fields {
+				asset[k] = v
+			}
+			continue
+		}
+		if fetched >= 32 {
+			continue
+		}
+		a.assetMutex.Lock()
+		cached := a.assetCache[ip]
+		described, _ := cached["upnp_described_at"].(string)
+		sameLocation := cached["ssdp_location"] == location
+		a.assetMutex.Unlock()
+		if at, err := time.Parse(time.RFC3339, described); err == nil && sameLocation && time.Since(at) < 24*time.Hour {
+			continue
+		}
+
+		fetched++
+		fields, err := modules.FetchUPnPDescription(context.Background(), ip, location, timeout)
+		if err != nil {
+			log.Printf("[%s] UPnP description of %s unavailable: %v", time.Now().Format(time.RFC3339), ip, err)
+			continue
+		}
+		fields["upnp_described_at"] = time.Now().UTC().Format(time.RFC3339)
+		for k, v := range fields {
+			asset[k] = v
+		}
+		round[location] = fields
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"github.com/goranjovic55/NOP/nopagent/storage"
+)
+
+// ============================================================================
+// LOCAL STORAGE - Sealed state files and logs
+// ============================================================================
+
+// readState opens a state file sealed by writeState. Files written in
+// plaintext by earlier versions are still accepted, if they parse, and are
+// sealed in place on first read.
+func (a *NOPAgent) readState(path, purpose string, v interface{}) error {
+	data, err := a.store.ReadFile(path, purpose)
+	if err == nil {
+		return json.Unmarshal(data, v)
+	}
+	if os.IsNotExist(err) {
+		return err
+	}
+	legacy, readErr := os.ReadFile(path)
+	if readErr != nil || json.Unmarshal(legacy, v) != nil {
+		return err
+	}
+	if err := a.store.WriteFile(path, purpose, legacy); err == nil {
+		log.Printf("[%s] Sealed plaintext state file %s", time.Now().Format(time.RFC3339), path)
+	}
+	return nil
+}
+
+// writeState seals v as JSON to path
+func (a *NOPAgent) writeState(path, purpose string, v interface{}) error {
+	return a.store.WriteJSON(path, purpose, v)
+}
+
+// openLogFile sends the agent's log to "log_file" from the config as well,
+// sealed. Obfuscated builds that discard their log write nothing.
+func (a *NOPAgent) openLogFile() {
+	path, _ := a.config["log_file"].(string)
+	if path == "" || log.Writer() == io.Discard {
+		return
+	}
+	limit := int64(16 << 20)
+	if val, ok := a.config["log_max_bytes"].(float64); ok && val > 0 {
+		limit = int64(val)
+	}
+	file, err := a.store.OpenLog(path, "log", limit)
+	if err != nil {
+		log.Printf("[%s] Log file disabled: %v", time.Now().Format(time.RFC3339), err)
+		return
+	}
+	log.SetOutput(io.MultiWriter(log.Writer(), file))
+}
+
+// sealedLogPurposes are the log files "decrypt" can read
+var sealedLogPurposes = []string{"log", "sink", "recording", "output"}
+
+// runDecrypt prints the records of a sealed log (log file, file sink or
+// session recording) one per line, spooled command output as it was
+// written, or the contents of a sealed state file
+func runDecrypt(identity Identity, path string) error {
+	kdf, _ := crypto.ParseKDF(identity.KDF, identity.KDFMemory, identity.KDFIterations)
+	store, err := storage.New(crypto.MasterKey([]byte(identity.EncryptionKey), kdfSalt(identity.KDFSalt), kdf))
+	if err != nil {
+		return err
+	}
+	for _, purpose := range sealedLogPurposes {
+		records := make([][]byte, 0)
+		err := store.ReadLog(path, purpose, func(record []byte) error {
+			records = append(records, record)
+			return nil
+		})
+		if err == nil && len(records) > 0 {
+			for _, record := range records {
+				os.Stdout.Write(record)
+				if purpose != "output" && len(record) == 0 || record[len(record)-1] != '\n' {
+					os.Stdout.Write([]byte("\n"))
+				}
+			}
+			return nil
+		}
+	}
+	for _, purpose := range []string{"identity", "site_map", "traffic_baseline", "oui", "schedules", "jobs", "replay"} {
+		if data, err := store.ReadFile(path, purpose); err == nil {
+			os.Stdout.Write(data)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a file sealed by this agent", path)
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// PING SWEEP - Active host discovery for the asset module
+// ============================================================================
+
+// sweepPolicy returns the "ping_sweep" config block, e.g. {"enabled": true,
+// "workers": 64, "rate": 200, "timeout": 1, "tcp_ports": [80, 443],
+// "max_hosts": 4096}. Sweeps are off unless enabled.
+func (a *NOPAgent) sweepPolicy() map[string]interface{} {
+	if policy, ok := a.config["ping_sweep"].(map[string]interface{}); ok {
+		return policy
+	}
+	return map[string]interface{}{}
+}
+
+// sweepAssets pings the scan networks, or the attached IPv4 networks when
+// none are configured, and returns the hosts that answered. Passive builds
+// never sweep.
+func (a *NOPAgent) sweepAssets() []map[string]interface{} {
+	policy := a.sweepPolicy()
+	if enabled, _ := policy["enabled"].(bool); !enabled || a.options.Profile == "passive" {
+		return nil
+	}
+	exclusions, ok := a.scanExclusions()
+	if !ok {
+		return nil
+	}
+	number := func(key string, def int) int {
+		if val, ok := policy[key].(float64); ok && val >= 0 {
+			return int(val)
+		}
+		return def
+	}
+
+	opts := modules.SweepOptions{
+		Workers:  number("workers", 64),
+		Rate:     number("rate", 200),
+		Timeout:  time.Second,
+		TCPPorts: []int{80, 443, 22, 445, 3389},
+	}
+	if val, ok := policy["timeout"].(float64); ok && val > 0 {
+		opts.Timeout = time.Duration(val * float64(time.Second))
+	}
+	if ports, ok := policy["tcp_ports"].([]interface{}); ok {
+		opts.TCPPorts = opts.TCPPorts[:0]
+		for _, port := range ports {
+			if p, ok := port.(float64); ok && p > 0 && p < 65536 {
+				opts.TCPPorts = append(opts.TCPPorts, int(p))
+			}
+		}
+	}
+
+	targets := modules.SweepHosts(a.sweepSubnets(), exclusions, number("max_hosts", 4096))
+	if len(targets) == 0 {
+		return nil
+	}
+	started := time.Now()
+	assets := modules.PingSweep(context.Background(), targets, opts)
+	log.Printf("[%s] Ping sweep of %d addresses found %d hosts in %s", time.Now().Format(time.RFC3339),
+		len(targets), len(assets), time.Since(started).Round(time.Millisecond))
+	return assets
+}
+
+// sweepSubnets returns "scan_networks" or, without it, the attached IPv4
+// networks, narrowed to the /24 around the agent's address when larger
+// than /22
+func (a *NOPAgent) sweepSubnets() []*net.IPNet {
+	if _, set := a.config["scan_networks"]; set {
+		return a.scanNetworks()
+	}
+	subnets := make([]*net.IPNet, 0)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return subnets
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil || !modules.UsableAddress(ipnet.IP) || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ones, _ := ipnet.Mask.Size(); ones < 22 {
+			mask := net.CIDRMask(24, 32)
+			ipnet = &net.IPNet{IP: ipnet.IP.Mask(mask), Mask: mask}
+		}
+		subnets = append(subnets, ipnet)
+	}
+	return subnets
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// SCAN TARGETS - Networks active discovery may and may not probe
+// ============================================================================
+
+// scanNetworks parses "scan_networks", the subnets active discovery sweeps
+// instead of the attached networks, e.g. ["10.20.0.0/22", "192.168.7.0/24"].
+// Invalid entries are skipped.
+func (a *NOPAgent) scanNetworks() []*net.IPNet {
+	networks, err := parseNetworks(a.config["scan_networks"])
+	if err != nil {
+		log.Printf("[%s] Ignoring part of scan_networks: %v", time.Now().Format(time.RFC3339), err)
+	}
+	return networks
+}
+
+// scanExclusions parses "scan_exclusions", the CIDRs or addresses no active
+// probe may touch. It fails closed: ok is false when any entry is invalid,
+// and then nothing is probed at all.
+func (a *NOPAgent) scanExclusions() (exclusions []*net.IPNet, ok bool) {
+	exclusions, err := parseNetworks(a.config["scan_exclusions"])
+	if err != nil {
+		log.Printf("[%s] Invalid scan_exclusions, active discovery disabled: %v", time.Now().Format(time.RFC3339), err)
+		return nil, false
+	}
+	return exclusions, true
+}
+
+// parseNetworks reads a list of CIDRs and bare addresses, the latter as
+// single-host networks. The valid entries are returned with an error naming
+// the first invalid one.
+func parseNetworks(v interface{}) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0)
+	if v == nil {
+		return networks, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return networks, fmt.Errorf("expected a list of CIDRs")
+	}
+	var firstErr error
+	for _, entry := range list {
+		text, _ := entry.(string)
+		text = strings.TrimSpace(text)
+		if _, network, err := net.ParseCIDR(text); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		if ip := net.ParseIP(text); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%q is not a CIDR or address", entry)
+		}
+	}
+	return networks, firstErr
+}
+
+// probeAllowed reports whether active discovery may touch ip
+func probeAllowed(ip net.IP, exclusions []*net.IPNet) bool {
+	return !modules.InNetworks(ip, exclusions)
+}
+
+// attachedExcluded reports whether an attached network overlaps an
+// exclusion. Link-local multicast probes reach every host on the attached
+// networks, so they are not sent when one does.
+func attachedExcluded(exclusions []*net.IPNet) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return true
+	}
+	for _, addr := range addrs {
+		attached, ok := addr.(*net.IPNet)
+		if !ok || attached.IP.IsLoopback() {
+			continue
+		}
+		for _, excluded := range exclusions {
+			if attached.Contains(excluded.IP) || excluded.Contains(attached.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dueHosts picks the hosts of a discovery round a probing stage should
+// visit: not the agent's own addresses, not excluded, and not probed within
+// interval according to the stamp field of the cached asset, at most max of
+// them. Each host comes with the round's records for it.
+func (a *NOPAgent) dueHosts(assets []map[string]interface{}, stamp string, interval time.Duration, max int, exclusions []*net.IPNet) ([]net.IP, map[string][]map[string]interface{}) {
+	local := make(map[string]bool)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				local[ipnet.IP.String()] = true
+			}
+		}
+	}
+	hosts := make([]net.IP, 0)
+	byIP := make(map[string][]map[string]interface{})
+	a.assetMutex.Lock()
+	defer a.assetMutex.Unlock()
+	for _, asset := range assets {
+		text, _ := asset["ip"].(string)
+		ip := net.ParseIP(text)
+		// Link-local IPv6 would need the interface zone to connect
+		if ip == nil || local[ip.String()] || ip.To4() == nil && ip.IsLinkLocalUnicast() || !probeAllowed(ip, exclusions) {
+			continue
+		}
+		if _, queued := byIP[ip.String()]; !queued {
+			if last, _ := a.assetCache[ip.String()][stamp].(string); last != "" {
+				if at, err := time.Parse(time.RFC3339, last); err == nil && time.Since(at) < interval {
+					continue
+				}
+			}
+			if len(hosts) >= max {
+				continue
+			}
+			hosts = append(hosts, ip)
+		}
+		byIP[ip.String()] = append(byIP[ip.String()], asset)
+	}
+	return hosts, byIP
+}
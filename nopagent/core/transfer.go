@@ -0,0 +1,170 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// ============================================================================
+// FILE TRANSFER - Chunked, encrypted file streams to the C2
+// ============================================================================
+
+// streamFile sends r in file_chunk messages. category is the data policy
+// category of the content, checked once for the whole transfer.
+func (a *NOPAgent) streamFile(transferID, name, category string, r io.Reader, size int64) error {
+	_, _, err := a.streamFileFrom(transferID, name, category, r, 0, size, sha256.New())
+	return err
+}
+
+// streamFileFrom sends r as encrypted file_chunk messages starting at
+// offset, for resumed transfers, with hash already holding the bytes before
+// it. The final chunk is flagged eof and carries the SHA-256 of the whole
+// stream. size is -1 for streams produced on the fly, which end at EOF. It
+// returns the bytes sent and, once complete, the digest.
+func (a *NOPAgent) streamFileFrom(transferID, name, category string, r io.Reader, offset, size int64, hash hash.Hash) (int64, string, error) {
+	if !a.policy.allows(category) {
+		a.notifyBlocked("file_transfer", category)
+		return 0, "", newAgentError(ErrPermission, "blocked_by_policy", "data category %q is not allowed by this deployment", category)
+	}
+
+	chunkSize := 64 * 1024
+	if val, ok := a.config["file_chunk_size"].(float64); ok && val > 0 {
+		chunkSize = int(val)
+	}
+
+	// Keep large transfers off the control channel: multiplexed transports get
+	// a dedicated stream, others a separate data connection if the C2 offers one
+	send := a.sendEncrypted
+	if stream, ok := a.openStream("bulk", map[string]interface{}{"transfer_id": transferID, "name": name, "size": size}); ok {
+		defer stream.Close()
+		send = func(v interface{}) error { return transport.WriteFrame(transportHost{a}, stream, a.tagConsent(v)) }
+	} else if channel, ok := a.openDataChannel(transferID); ok {
+		defer channel.Close()
+		send = func(v interface{}) error {
+			envelope, err := a.sealEnvelope(v)
+			if err != nil {
+				return err
+			}
+			return channel.Send(envelope)
+		}
+	}
+
+	buf := make([]byte, chunkSize)
+	start := offset
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return offset - start, "", readErr
+		}
+		hash.Write(buf[:n])
+		eof := readErr != nil || size >= 0 && offset+int64(n) >= size
+
+		chunk := map[string]interface{}{
+			"type":        "file_chunk",
+			"agent_id":    a.agentID,
+			"transfer_id": transferID,
+			"name":        name,
+			"offset":      offset,
+			"size":        size,
+			"data":        buf[:n],
+			"eof":         eof,
+		}
+		var digest string
+		if eof {
+			digest = hex.EncodeToString(hash.Sum(nil))
+			chunk["sha256"] = digest
+		}
+		if err := send(chunk); err != nil {
+			return offset - start, "", err
+		}
+
+		offset += int64(n)
+		if eof {
+			return offset - start, digest, nil
+		}
+	}
+}
+
+// openDataChannel asks the C2 for a one-time token and dials a separate
+// connection for one transfer. It gives up after "data_channel_timeout"
+// seconds (default 10) so the caller can fall back to the control channel.
+func (a *NOPAgent) openDataChannel(transferID string) (transport.Transport, bool) {
+	a.grantMutex.Lock()
+	enabled := a.dataChannel
+	grant := make(chan map[string]interface{}, 1)
+	if enabled {
+		a.grants[transferID] = grant
+	}
+	a.grantMutex.Unlock()
+	if !enabled {
+		return nil, false
+	}
+	defer func() {
+		a.grantMutex.Lock()
+		delete(a.grants, transferID)
+		a.grantMutex.Unlock()
+	}()
+
+	if err := a.writeJSON(map[string]interface{}{
+		"type":        "data_channel_request",
+		"agent_id":    a.agentID,
+		"transfer_id": transferID,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		return nil, false
+	}
+
+	var msg map[string]interface{}
+	select {
+	case msg = <-grant:
+	case <-time.After(a.timeout("data_channel_timeout", 10*time.Second)):
+		log.Printf("[%s] No data channel grant for %s, using control channel", time.Now().Format(time.RFC3339), transferID)
+		return nil, false
+	}
+
+	token, _ := msg["token"].(string)
+	target, _ := msg["url"].(string)
+	if target == "" {
+		target = a.serverURL
+	}
+	u, err := url.Parse(target)
+	if err != nil || token == "" {
+		return nil, false
+	}
+	newTransport, ok := transport.Schemes[u.Scheme]
+	if !ok || !a.options.allowsTransport(u.Scheme) {
+		return nil, false
+	}
+
+	header := a.connectionHeaders()
+	header.Set("Authorization", "Bearer "+token)
+	header.Set("X-Agent-ID", a.agentID)
+	header.Set("X-Transfer-ID", transferID)
+	channel := newTransport(transportHost{a})
+	if err := channel.Dial(u, header); err != nil {
+		log.Printf("[%s] Data channel for %s failed: %v", time.Now().Format(time.RFC3339), transferID, err)
+		return nil, false
+	}
+	return channel, true
+}
+
+// handleDataChannelGrant hands a one-time token to the waiting transfer
+func (a *NOPAgent) handleDataChannelGrant(msg map[string]interface{}) {
+	transferID, _ := msg["transfer_id"].(string)
+	a.grantMutex.Lock()
+	grant, ok := a.grants[transferID]
+	a.grantMutex.Unlock()
+	if ok {
+		select {
+		case grant <- msg:
+		default:
+		}
+	}
+}
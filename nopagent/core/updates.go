@@ -0,0 +1,78 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/modules"
+)
+
+// ============================================================================
+// UPDATES - Artifact compatibility checks for fleet updates
+// ============================================================================
+
+// artifactMismatch compares an offered update artifact with this host and
+// returns why it cannot run here, or "" when it matches. Fields the offer
+// leaves empty are not checked, except goos and goarch which are required.
+func artifactMismatch(artifact map[string]interface{}) string {
+	local := modules.PlatformDetail()
+	field := func(m map[string]interface{}, key string) string {
+		s, _ := m[key].(string)
+		return s
+	}
+
+	for _, key := range []string{"goos", "goarch"} {
+		if field(artifact, key) == "" {
+			return key + " not specified"
+		}
+	}
+	for _, key := range []string{"goos", "goarch", "goarm", "goamd64", "libc"} {
+		offered, have := field(artifact, key), field(local, key)
+		if offered == "" || offered == have {
+			continue
+		}
+		// GOARM and GOAMD64 levels are backwards compatible
+		if key == "goarm" || key == "goamd64" {
+			o, _ := strconv.Atoi(strings.TrimPrefix(offered, "v"))
+			h, _ := strconv.Atoi(strings.TrimPrefix(strings.SplitN(have, ",", 2)[0], "v"))
+			if have == "" || o <= h {
+				continue
+			}
+		}
+		// Static binaries run regardless of the host C library
+		if key == "libc" {
+			if static, _ := artifact["static"].(bool); static {
+				continue
+			}
+		}
+		return fmt.Sprintf("%s mismatch: artifact %s, host %s", key, offered, have)
+	}
+	return ""
+}
+
+// handleUpdateOffer verifies an offered artifact matches this host before a
+// fleet updater installs it; installing is left to the updater
+func (a *NOPAgent) handleUpdateOffer(msg map[string]interface{}) {
+	artifact, _ := msg["artifact"].(map[string]interface{})
+	if artifact == nil {
+		a.sendError("update_offer", msg, newAgentError(ErrInvalidRequest, "missing_artifact", "artifact is required"))
+		return
+	}
+
+	reason := artifactMismatch(artifact)
+	if reason != "" {
+		log.Printf("[%s] Rejected update artifact: %s", time.Now().Format(time.RFC3339), reason)
+	}
+	a.writeJSON(map[string]interface{}{
+		"type":      "update_offer_result",
+		"agent_id":  a.agentID,
+		"update_id": msg["update_id"],
+		"accepted":  reason == "",
+		"reason":    reason,
+		"platform":  modules.PlatformDetail(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
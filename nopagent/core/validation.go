@@ -0,0 +1,131 @@
+package core
+
+import (
+	"math"
+
+	"github.com/goranjovic55/NOP/nopagent/transport"
+)
+
+// ============================================================================
+// INPUT VALIDATION - Bounds and schema checks on C2 messages
+// ============================================================================
+
+// validateInbound bounds the shape of a message and checks its fields against
+// the commandCatalog entry for its type. Types without an entry (e.g.
+// "registered") are only shape-checked.
+func validateInbound(msg map[string]interface{}) error {
+	if msg == nil {
+		return transport.Malformed("message is not an object")
+	}
+	nodes := 0
+	if err := checkShape(msg, 0, &nodes); err != nil {
+		return err
+	}
+	msgType, ok := msg["type"].(string)
+	if !ok || msgType == "" {
+		return transport.Malformed("message has no type")
+	}
+
+	spec, ok := catalogSpec(msgType)
+	if !ok {
+		return nil
+	}
+	for _, param := range spec.Params {
+		value, present := msg[param.Name]
+		if !present || value == nil {
+			if param.Required {
+				return transport.Malformed("%s: missing required field %q", msgType, param.Name)
+			}
+			continue
+		}
+		if !paramMatches(param.Type, value) {
+			return transport.Malformed("%s: field %q must be %s", msgType, param.Name, param.Type)
+		}
+	}
+	return nil
+}
+
+func checkShape(v interface{}, depth int, nodes *int) error {
+	*nodes++
+	if *nodes > transport.MaxMessageNodes {
+		return transport.Malformed("message has more than %d values", transport.MaxMessageNodes)
+	}
+	if depth > transport.MaxMessageDepth {
+		return transport.Malformed("message nested deeper than %d levels", transport.MaxMessageDepth)
+	}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for _, field := range value {
+			if err := checkShape(field, depth+1, nodes); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if err := checkShape(item, depth+1, nodes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func catalogSpec(name string) (CommandSpec, bool) {
+	for _, spec := range commandCatalog {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return CommandSpec{}, false
+}
+
+// paramMatches checks a decoded value against a ParamSpec type
+func paramMatches(typ string, v interface{}) bool {
+	each := func(check func(interface{}) bool) bool {
+		items, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if !check(item) {
+				return false
+			}
+		}
+		return true
+	}
+	isString := func(x interface{}) bool { _, ok := x.(string); return ok }
+	isNumber := func(x interface{}) bool { f, ok := x.(float64); return ok && !math.IsNaN(f) && !math.IsInf(f, 0) }
+	isObject := func(x interface{}) bool { _, ok := x.(map[string]interface{}); return ok }
+
+	switch typ {
+	case "string":
+		return isString(v)
+	case "number":
+		return isNumber(v)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		return isObject(v)
+	case "string[]":
+		return each(isString)
+	case "number[]":
+		return each(isNumber)
+	case "object[]":
+		return each(isObject)
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	}
+	return true
+}
+
+// rejectMessage tells the C2 that a message was not processed. msg is nil
+// when the frame itself could not be decoded.
+func (a *NOPAgent) rejectMessage(msg map[string]interface{}, reason error) {
+	requestType, _ := msg["type"].(string)
+	if requestType == "" {
+		requestType = "message"
+	}
+	a.sendError(requestType, msg, newAgentError(ErrInvalidRequest, "malformed_message", "%v", reason))
+}
@@ -0,0 +1,209 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// ============================================================================
+// VISIBLE MODE - Disclosure for consented monitoring deployments
+// ============================================================================
+
+// consentTag marks telemetry from a visible-mode agent
+const consentTag = "consented-monitoring"
+
+// motdNotice is shown to users logging in to Linux hosts in visible mode
+const motdNotice = "/etc/motd.d/" + ServiceName
+
+const defaultNotice = "This device is monitored by your organization's network security team. " +
+	"The NOP agent reports the information listed on its status page."
+
+func (a *NOPAgent) visibleMode() bool {
+	return a.identity.VisibleMode == "true"
+}
+
+func (a *NOPAgent) consentNotice() string {
+	notice := strings.TrimSpace(a.identity.ConsentNotice)
+	if notice == "" || strings.HasPrefix(notice, "{{") {
+		return defaultNotice
+	}
+	return notice
+}
+
+// tagConsent marks an outbound message as consented monitoring. writeJSON
+// and sealEnvelope apply it to every frame, so replies sent directly are
+// tagged like relayed reports.
+func (a *NOPAgent) tagConsent(data interface{}) interface{} {
+	if !a.visibleMode() {
+		return data
+	}
+	switch m := data.(type) {
+	case protocol.Message:
+		m.Monitoring = consentTag
+		return m
+	case protocol.AssetData:
+		m.Monitoring = consentTag
+		return m
+	case protocol.TrafficData:
+		m.Monitoring = consentTag
+		return m
+	case protocol.HostData:
+		m.Monitoring = consentTag
+		return m
+	case protocol.NetworkChangeData:
+		m.Monitoring = consentTag
+		return m
+	case protocol.AggregatedEvent:
+		m.Monitoring = consentTag
+		return m
+	case map[string]interface{}:
+		tagged := make(map[string]interface{}, len(m)+1)
+		for k, v := range m {
+			tagged[k] = v
+		}
+		tagged["monitoring"] = consentTag
+		return tagged
+	}
+	return data
+}
+
+func (a *NOPAgent) statusPageAddr() string {
+	if addr, ok := a.config["status_page_addr"].(string); ok && addr != "" {
+		return addr
+	}
+	return "127.0.0.1:47800"
+}
+
+// announce tells the people using this host that it is monitored: a login
+// banner on Linux, a tray icon on Windows and a notification on macOS.
+// Failures are logged; the status page still discloses the agent.
+func (a *NOPAgent) announce() {
+	notice := a.consentNotice() + " Status: http://" + a.statusPageAddr() + "/"
+	log.Printf("[%s] Visible mode: %s", time.Now().Format(time.RFC3339), notice)
+
+	var err error
+	switch runtime.GOOS {
+	case "linux", "freebsd":
+		if _, statErr := os.Stat(filepath.Dir(motdNotice)); statErr == nil {
+			err = os.WriteFile(motdNotice, []byte(notice+"\n"), 0644)
+		}
+		if os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != "" {
+			exec.Command("notify-send", "NOP monitoring", notice).Run()
+		}
+	case "windows":
+		err = exec.Command("powershell", "-NoProfile", "-WindowStyle", "Hidden", "-Command", trayScript(notice)).Start()
+	case "darwin":
+		err = exec.Command("osascript", "-e",
+			fmt.Sprintf("display notification %q with title \"NOP monitoring\"", notice)).Run()
+	}
+	if err != nil {
+		log.Printf("[%s] Could not display monitoring notice: %v", time.Now().Format(time.RFC3339), err)
+	}
+}
+
+// trayScript keeps a notification-area icon up for as long as the session
+// lasts; clicking it repeats the notice
+func trayScript(notice string) string {
+	quoted := strings.ReplaceAll(notice, "'", "''")
+	return `Add-Type -AssemblyName System.Windows.Forms, System.Drawing
+$icon = New-Object System.Windows.Forms.NotifyIcon
+$icon.Icon = [System.Drawing.SystemIcons]::Information
+$icon.Text = 'NOP monitoring active'
+$icon.BalloonTipTitle = 'NOP monitoring'
+$icon.BalloonTipText = '` + quoted + `'
+$icon.add_Click({ $icon.ShowBalloonTip(10000) })
+$icon.Visible = $true
+$icon.ShowBalloonTip(10000)
+[System.Windows.Forms.Application]::Run()`
+}
+
+// The page uses [[ ]] delimiters so the generator's {{ }} placeholders never
+// collide with it
+var statusPage = template.Must(template.New("status").Delims("[[", "]]").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>NOP monitoring agent</title></head>
+<body>
+<h1>NOP monitoring agent</h1>
+<p>[[.Notice]]</p>
+<table>
+<tr><th>Agent</th><td>[[.Name]] ([[.ID]])</td></tr>
+<tr><th>Reports to</th><td>[[.Server]]</td></tr>
+<tr><th>Last contact</th><td>[[.LastContact]]</td></tr>
+</table>
+<h2>Active modules</h2>
+<ul>[[range .Modules]]<li>[[.]]</li>[[end]]</ul>
+<h2>Data collected</h2>
+<table>[[range .Categories]]<tr><th>[[.Name]]</th><td>[[if .Allowed]]collected[[else]]never sent[[end]]</td></tr>[[end]]</table>
+</body></html>
+`))
+
+type agentStatus struct {
+	Notice      string           `json:"notice"`
+	Name        string           `json:"agent_name"`
+	ID          string           `json:"agent_id"`
+	Server      string           `json:"server"`
+	LastContact string           `json:"last_contact"`
+	Modules     []string         `json:"modules"`
+	Categories  []statusCategory `json:"data_categories"`
+}
+
+type statusCategory struct {
+	Name    string `json:"name"`
+	Allowed bool   `json:"allowed"`
+}
+
+// status describes the agent for the status page
+func (a *NOPAgent) status() agentStatus {
+	modules := make([]string, 0)
+	for module, enabled := range a.capabilities {
+		if enabled {
+			modules = append(modules, module)
+		}
+	}
+	sort.Strings(modules)
+
+	categories := make([]statusCategory, 0, len(policyCategories))
+	for _, category := range policyCategories {
+		categories = append(categories, statusCategory{Name: category, Allowed: a.policy.allows(category)})
+	}
+
+	return agentStatus{
+		Notice:      a.consentNotice(),
+		Name:        a.agentName,
+		ID:          a.agentID,
+		Server:      a.identity.ServerURL,
+		LastContact: a.lastContact.UTC().Format(time.RFC3339),
+		Modules:     modules,
+		Categories:  categories,
+	}
+}
+
+// StatusPage serves a local description of what the agent collects, at / for
+// people and /status.json for scripts
+func (a *NOPAgent) StatusPage() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusPage.Execute(w, a.status())
+	})
+	mux.HandleFunc("/status.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.status())
+	})
+
+	server := &http.Server{Addr: a.statusPageAddr(), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("[%s] Status page error: %v", time.Now().Format(time.RFC3339), err)
+	}
+}
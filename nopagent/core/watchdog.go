@@ -0,0 +1,187 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// startModules launches the collection modules once; they keep running across
+// reconnects and while the agent is in autonomous mode
+func (a *NOPAgent) startModules() {
+	a.modulesOnce.Do(func() {
+		a.superviseModule("asset", a.interval("discovery_interval", 300*time.Second), a.AssetModule)
+		a.superviseModule("network", a.interval("network_check_interval", 15*time.Second), a.NetworkModule)
+		a.superviseModule("power", 60*time.Second, a.PowerModule)
+		a.superviseModule("traffic", a.interval("data_interval", 60*time.Second), a.TrafficModule)
+		a.superviseModule("beacon", a.interval("beacon_poll_interval", 5*time.Second), a.BeaconDetector)
+		a.superviseModule("host", 120*time.Second, a.HostModule)
+		go a.AccessModule()
+		go a.ModuleWatchdog()
+	})
+}
+
+// ============================================================================
+// MODULE WATCHDOG - Restart collectors that stop cycling
+// ============================================================================
+
+// moduleHealth tracks one supervised module. Modules call beat once per
+// cycle; generation changes on every restart so a stuck goroutine that
+// eventually wakes up exits instead of running alongside its replacement.
+type moduleHealth struct {
+	name       string
+	interval   time.Duration
+	run        func(beat func() bool)
+	generation int
+	started    time.Time
+	lastCycle  time.Time
+	restarts   int
+	exited     bool
+}
+
+func (a *NOPAgent) superviseModule(name string, interval time.Duration, run func(beat func() bool)) {
+	m := &moduleHealth{name: name, interval: interval, run: run}
+	a.moduleMutex.Lock()
+	a.modules[name] = m
+	a.moduleMutex.Unlock()
+	a.launchModule(m)
+}
+
+// launchModule starts a new generation of m. A module that returns normally
+// (disabled capability, shutdown) is no longer watched; a panic restarts it.
+func (a *NOPAgent) launchModule(m *moduleHealth) {
+	a.moduleMutex.Lock()
+	m.generation++
+	generation := m.generation
+	m.started = time.Now()
+	m.exited = false
+	a.moduleMutex.Unlock()
+
+	current := func() bool {
+		a.moduleMutex.Lock()
+		defer a.moduleMutex.Unlock()
+		return m.generation == generation
+	}
+	beat := func() bool {
+		a.moduleMutex.Lock()
+		defer a.moduleMutex.Unlock()
+		if m.generation != generation {
+			return false
+		}
+		m.lastCycle = time.Now()
+		return true
+	}
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[%s] Module %s panicked: %v", time.Now().Format(time.RFC3339), m.name, r)
+				if current() && a.running {
+					a.restartModule(m, "panicked", fmt.Sprint(r))
+				}
+				return
+			}
+			a.moduleMutex.Lock()
+			if m.generation == generation {
+				m.exited = true
+			}
+			a.moduleMutex.Unlock()
+		}()
+		m.run(beat)
+	}()
+}
+
+// restartModule reports a failed module to the C2 and starts a replacement,
+// giving up after "module_max_restarts" restarts (default 10)
+func (a *NOPAgent) restartModule(m *moduleHealth, status, detail string) {
+	maxRestarts := 10
+	if val, ok := a.config["module_max_restarts"].(float64); ok && val >= 0 {
+		maxRestarts = int(val)
+	}
+
+	a.moduleMutex.Lock()
+	m.restarts++
+	restarts := m.restarts
+	var lastCycle interface{}
+	if !m.lastCycle.IsZero() {
+		lastCycle = m.lastCycle.UTC().Format(time.RFC3339)
+	}
+	giveUp := restarts > maxRestarts
+	if giveUp {
+		// Retire the current generation and stop watching the module
+		m.generation++
+		m.exited = true
+	}
+	a.moduleMutex.Unlock()
+
+	if giveUp {
+		log.Printf("[%s] Module %s %s, restart limit reached - leaving it stopped", time.Now().Format(time.RFC3339), m.name, status)
+	} else {
+		log.Printf("[%s] Module %s %s (%s), restarting (%d)", time.Now().Format(time.RFC3339), m.name, status, detail, restarts)
+	}
+	a.emitEvent("module_health:"+m.name, map[string]interface{}{
+		"type":       "module_health",
+		"agent_id":   a.agentID,
+		"module":     m.name,
+		"status":     status,
+		"detail":     detail,
+		"last_cycle": lastCycle,
+		"restarts":   restarts,
+		"restarted":  !giveUp,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+	if !giveUp {
+		a.launchModule(m)
+	}
+}
+
+// ModuleWatchdog restarts supervised modules whose last cycle is older than
+// "module_stall_factor" (default 3) times their power-adjusted interval.
+// Checks run every "module_watchdog_interval" seconds (default 30); set
+// "module_watchdog" to false to disable.
+func (a *NOPAgent) ModuleWatchdog() {
+	if enabled, ok := a.config["module_watchdog"].(bool); ok && !enabled {
+		return
+	}
+	check := a.interval("module_watchdog_interval", 30*time.Second)
+	factor := 3.0
+	if val, ok := a.config["module_stall_factor"].(float64); ok && val >= 1 {
+		factor = val
+	}
+
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+
+	for a.running {
+		<-ticker.C
+
+		type stall struct {
+			module *moduleHealth
+			idle   time.Duration
+		}
+		stalled := make([]stall, 0)
+		a.moduleMutex.Lock()
+		for _, m := range a.modules {
+			if m.exited {
+				continue
+			}
+			since := m.lastCycle
+			if since.IsZero() {
+				since = m.started
+			}
+			// Never less than two checks, so short-period modules are not restarted on a slow tick
+			limit := time.Duration(float64(a.powerAdjustedInterval(m.interval)) * factor)
+			if limit < 2*check {
+				limit = 2 * check
+			}
+			if idle := time.Since(since); idle > limit {
+				stalled = append(stalled, stall{m, idle})
+			}
+		}
+		a.moduleMutex.Unlock()
+
+		for _, s := range stalled {
+			a.restartModule(s.module, "stalled", fmt.Sprintf("no cycle completed for %s", s.idle.Round(time.Second)))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// Friday 15 March 2024, 10:07
+	from := time.Date(2024, 3, 15, 10, 7, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(3, 15, 10, 8)},
+		{"*/15 * * * *", at(3, 15, 10, 15)},
+		{"5 * * * *", at(3, 15, 11, 5)},
+		{"0 9-17 * * *", at(3, 15, 11, 0)},
+		{"0 20-23/2 * * *", at(3, 15, 20, 0)},
+		{"0 0,12 * * *", at(3, 15, 12, 0)},
+		{"30 8 1 * *", at(4, 1, 8, 30)},
+		{"0 0 * jun *", at(6, 1, 0, 0)},
+		{"0 6 * * mon-wed", at(3, 18, 6, 0)},
+		{"0 6 * * 7", at(3, 17, 6, 0)},
+		{"0 6 * * sun", at(3, 17, 6, 0)},
+		// a/n steps from a to the end of the field
+		{"50/5 10 * * *", at(3, 15, 10, 50)},
+		// With both day fields restricted either one may match
+		{"0 0 20 * mon", at(3, 18, 0, 0)},
+		{"0 0 16 * mon", at(3, 16, 0, 0)},
+		// With one of them "*" only the other counts
+		{"0 0 20 * *", at(3, 20, 0, 0)},
+		{"0 0 * * 1", at(3, 18, 0, 0)},
+		{"0 0 29 2 *", at(2, 29, 0, 0).AddDate(4, 0, 0)},
+		{"@hourly", at(3, 15, 11, 0)},
+		{"@daily", at(3, 16, 0, 0)},
+		{"@weekly", at(3, 17, 0, 0)},
+		{"@monthly", at(4, 1, 0, 0)},
+		{"@YEARLY", at(1, 1, 0, 0).AddDate(1, 0, 0)},
+		{"@every 90m", from.Add(90 * time.Minute)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * foo *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"1,,2 * * * *",
+		"-5 * * * *",
+		"@every 30s",
+		"@every soon",
+		"@fortnightly",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
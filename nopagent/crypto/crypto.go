@@ -0,0 +1,272 @@
+// Package crypto holds the agent's cryptographic primitives: key derivation,
+// the negotiable AEAD suites, wire framing with optional per-message keys,
+// replay windows and handshake MACs. It keeps no state; the core package
+// decides which keys and options apply to each message.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+	cpufeat "golang.org/x/sys/cpu"
+)
+
+// LegacySalt is the shared salt used by agents generated before per-agent
+// salts existed; it is only used when the template was rendered without one
+const LegacySalt = "nop_c2_salt_2026"
+
+// HKDF info strings bind derived keys to their purpose in this protocol
+const (
+	MessageKeyInfo = "nop-agent message v1"
+	SessionKeyInfo = "nop-agent session v1"
+	RekeyInfo      = "nop-agent rekey v1"
+	ConfigKeyInfo  = "nop-agent sealed config v1"
+	StorageKeyInfo = "nop-agent storage v1"
+)
+
+// Direction labels in the AAD stop a frame being reflected back to its sender
+const (
+	AADAgent = "nop-agent>c2"
+	AADC2    = "nop-c2>agent"
+)
+
+// MessageSaltSize is the length of the HKDF salt prefixed to each message
+// when per-message keys are enabled
+const MessageSaltSize = 16
+
+// DefaultSuite is used until the C2 picks a suite in "registered", and
+// always for local state
+const DefaultSuite = "aes-256-gcm"
+
+// Suites are the AEADs available for wire traffic. All take a 32-byte key
+// and a 12-byte nonce, so framing is identical. ChaCha20-Poly1305 is added
+// outside FIPS builds.
+var Suites = map[string]func(key []byte) (cipher.AEAD, error){
+	"aes-256-gcm": NewGCM,
+}
+
+// Curves for session key agreement, named in key_exchange
+const (
+	CurveX25519 = "x25519"
+	CurveP256   = "p256"
+)
+
+// KeyAgreement returns the curve for session keys and rekeys: X25519, or
+// P-256 in FIPS builds
+func KeyAgreement() (string, ecdh.Curve) {
+	return keyAgreement()
+}
+
+// Salt decodes the hex per-agent KDF salt. ok is false when none usable was
+// embedded and the legacy shared salt is returned instead.
+func Salt(encoded string) (salt []byte, ok bool) {
+	salt, err := hex.DecodeString(encoded)
+	if err != nil || len(salt) < 16 {
+		return []byte(LegacySalt), false
+	}
+	return salt, true
+}
+
+// KDF algorithms for stretching the embedded secret into the master key
+const (
+	PBKDF2   = "pbkdf2-sha256"
+	Argon2id = "argon2id"
+)
+
+// KDFParams select how the master key is derived. The C2 must derive the
+// same key, so the agent reports them at registration.
+type KDFParams struct {
+	Algorithm  string `json:"algorithm"`
+	Iterations uint32 `json:"iterations"`           // PBKDF2 rounds or Argon2 passes
+	MemoryKiB  uint32 `json:"memory_kib,omitempty"` // Argon2 only
+	Threads    uint8  `json:"threads,omitempty"`    // Argon2 only
+}
+
+// DefaultKDF is used by agents generated without a KDF choice
+var DefaultKDF = KDFParams{Algorithm: PBKDF2, Iterations: 100000}
+
+// DefaultArgon2id follows the second recommended option of RFC 9106
+var DefaultArgon2id = KDFParams{Algorithm: Argon2id, Iterations: 3, MemoryKiB: 64 * 1024, Threads: 4}
+
+// ParseKDF builds KDFParams from the rendered template values. Empty or
+// unrendered values take the algorithm's defaults.
+func ParseKDF(algorithm, memoryKiB, iterations string) (KDFParams, error) {
+	rendered := func(v string) bool { return v != "" && !strings.HasPrefix(v, "{{") }
+	var params KDFParams
+	switch {
+	case !rendered(algorithm) || algorithm == PBKDF2:
+		params = DefaultKDF
+	case algorithm == Argon2id && FIPS:
+		return DefaultKDF, fmt.Errorf("argon2id is not FIPS approved, use %s", PBKDF2)
+	case algorithm == Argon2id:
+		params = DefaultArgon2id
+	default:
+		return DefaultKDF, fmt.Errorf("unknown KDF %q", algorithm)
+	}
+
+	if rendered(iterations) {
+		n, err := strconv.ParseUint(iterations, 10, 32)
+		if err != nil || n == 0 {
+			return params, fmt.Errorf("invalid KDF iterations %q", iterations)
+		}
+		params.Iterations = uint32(n)
+	}
+	if rendered(memoryKiB) && params.Algorithm == Argon2id {
+		n, err := strconv.ParseUint(memoryKiB, 10, 32)
+		if err != nil || n < 8*uint64(params.Threads) || n > 4<<20 {
+			return params, fmt.Errorf("invalid Argon2 memory %q KiB", memoryKiB)
+		}
+		params.MemoryKiB = uint32(n)
+	}
+	if params.Algorithm == PBKDF2 && params.Iterations < DefaultKDF.Iterations {
+		return params, fmt.Errorf("PBKDF2 needs at least %d iterations", DefaultKDF.Iterations)
+	}
+	return params, nil
+}
+
+// CheckFIPS refuses key material a FIPS build must not use: SP 800-132
+// wants a random salt of at least 128 bits, so the legacy shared salt is
+// out, and the embedded secret must carry at least 112 bits. It always
+// passes in other builds.
+func CheckFIPS(secret []byte, encodedSalt string) error {
+	if !FIPS {
+		return nil
+	}
+	if _, ok := Salt(encodedSalt); !ok {
+		return fmt.Errorf("FIPS builds need a per-agent KDF salt")
+	}
+	if len(secret) < 14 {
+		return fmt.Errorf("FIPS builds need an encryption key of at least 14 bytes")
+	}
+	return nil
+}
+
+// MasterKey stretches the embedded secret into a 32-byte key
+func MasterKey(secret, salt []byte, params KDFParams) []byte {
+	if params.Algorithm == Argon2id {
+		return argon2.IDKey(secret, salt, params.Iterations, params.MemoryKiB, params.Threads, 32)
+	}
+	return pbkdf2.Key(secret, salt, int(params.Iterations), 32, sha256.New)
+}
+
+// DeriveKey returns a 32-byte key: HKDF-SHA256(secret, salt, info)
+func DeriveKey(secret, salt []byte, info string) ([]byte, error) {
+	return deriveBytes(secret, salt, info, 32)
+}
+
+func deriveBytes(secret, salt []byte, info string, n int) ([]byte, error) {
+	out := make([]byte, n)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func NewGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// HasAESHardware reports whether AES-GCM is hardware accelerated here
+func HasAESHardware() bool {
+	switch runtime.GOARCH {
+	case "amd64", "386":
+		return cpufeat.X86.HasAES && cpufeat.X86.HasPCLMULQDQ
+	case "arm64":
+		return cpufeat.ARM64.HasAES && cpufeat.ARM64.HasPMULL
+	case "s390x":
+		return cpufeat.S390X.HasAES && cpufeat.S390X.HasAESGCM
+	case "ppc64", "ppc64le":
+		return true
+	}
+	return false
+}
+
+// MessageCipher derives a one-off key from a base key and a random message
+// salt: HKDF-SHA256(base, salt, MessageKeyInfo)
+func MessageCipher(suite string, base, salt []byte) (cipher.AEAD, error) {
+	key, err := DeriveKey(base, salt, MessageKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	return Suites[suite](key)
+}
+
+// Seal returns nonce || ciphertext, or salt || nonce || ciphertext with
+// per-message keys derived from base
+func Seal(suite string, aead cipher.AEAD, base, plaintext, aad []byte, perMessage bool) ([]byte, error) {
+	var prefix []byte
+	if perMessage {
+		prefix = make([]byte, MessageSaltSize)
+		if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+			return nil, err
+		}
+		var err error
+		if aead, err = MessageCipher(suite, base, prefix); err != nil {
+			return nil, err
+		}
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(append(prefix, nonce...), nonce, plaintext, aad), nil
+}
+
+// Open reverses Seal
+func Open(suite string, aead cipher.AEAD, base, data, aad []byte, perMessage bool) ([]byte, error) {
+	if perMessage {
+		if len(data) < MessageSaltSize {
+			return nil, fmt.Errorf("ciphertext too short")
+		}
+		var err error
+		if aead, err = MessageCipher(suite, base, data[:MessageSaltSize]); err != nil {
+			return nil, err
+		}
+		data = data[MessageSaltSize:]
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+// ConfigKey derives the key of a sealed configuration from the machine
+// identifiers it is bound to, in order: HKDF-SHA256(id1 "\n" id2 ..., salt,
+// ConfigKeyInfo)
+func ConfigKey(identifiers []string, salt []byte) ([]byte, error) {
+	return DeriveKey([]byte(strings.Join(identifiers, "\n")), salt, ConfigKeyInfo)
+}
+
+// HandshakeMAC is HMAC-SHA256 under key over a direction label and the
+// handshake fields
+func HandshakeMAC(key []byte, label string, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
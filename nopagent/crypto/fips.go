@@ -0,0 +1,15 @@
+//go:build fips
+
+package crypto
+
+import "crypto/ecdh"
+
+// FIPS builds (-tags fips) restrict the agent to FIPS 140 approved
+// algorithms: AES-256-GCM, SHA-2 for HKDF and HMAC, PBKDF2 and P-256 key
+// agreement. Build with GOEXPERIMENT=boringcrypto as well so those
+// algorithms come from the validated module.
+const FIPS = true
+
+func keyAgreement() (string, ecdh.Curve) {
+	return CurveP256, ecdh.P256()
+}
@@ -0,0 +1,17 @@
+//go:build fips && boringcrypto
+
+package crypto
+
+import (
+	"crypto/boring"
+	// Limits TLS to FIPS approved versions, suites and curves
+	_ "crypto/tls/fipsonly"
+)
+
+// FIPSModule names the validated module in use, empty if none
+func FIPSModule() string {
+	if boring.Enabled() {
+		return "boringcrypto"
+	}
+	return ""
+}
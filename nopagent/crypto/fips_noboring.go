@@ -0,0 +1,8 @@
+//go:build !(fips && boringcrypto)
+
+package crypto
+
+// FIPSModule names the validated module in use, empty if none
+func FIPSModule() string {
+	return ""
+}
@@ -0,0 +1,20 @@
+//go:build !fips
+
+package crypto
+
+import (
+	"crypto/ecdh"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// FIPS is false unless built with -tags fips
+const FIPS = false
+
+func init() {
+	Suites["chacha20-poly1305"] = chacha20poly1305.New
+}
+
+func keyAgreement() (string, ecdh.Curve) {
+	return CurveX25519, ecdh.X25519()
+}
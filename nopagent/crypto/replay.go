@@ -0,0 +1,51 @@
+package crypto
+
+import "sync"
+
+// ReplayWindowSize is how far behind the highest counter a late message may be
+const ReplayWindowSize = 64
+
+// ReplayWindow is a sliding bitmap of recently seen counters, as used by
+// IPsec and DTLS: bit i marks highest-i as seen
+type ReplayWindow struct {
+	mutex   sync.Mutex
+	highest uint64
+	seen    uint64
+}
+
+// Accept records counter and reports whether it was new and inside the window
+func (w *ReplayWindow) Accept(counter uint64) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if counter == 0 {
+		return false
+	}
+	if counter > w.highest {
+		if shift := counter - w.highest; shift >= ReplayWindowSize {
+			w.seen = 1
+		} else {
+			w.seen = w.seen<<shift | 1
+		}
+		w.highest = counter
+		return true
+	}
+	offset := w.highest - counter
+	if offset >= ReplayWindowSize || w.seen&(1<<offset) != 0 {
+		return false
+	}
+	w.seen |= 1 << offset
+	return true
+}
+
+// ResumeReplayWindow returns a window that refuses every counter up to
+// highest, to carry on from the highest counter a previous run accepted
+func ResumeReplayWindow(highest uint64) *ReplayWindow {
+	return &ReplayWindow{highest: highest, seen: ^uint64(0)}
+}
+
+// Highest returns the highest counter accepted so far
+func (w *ReplayWindow) Highest() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.highest
+}
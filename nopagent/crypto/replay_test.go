@@ -0,0 +1,49 @@
+package crypto
+
+import "testing"
+
+func TestReplayWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		counters []uint64
+		want     []bool
+	}{
+		{"zero is never valid", []uint64{0, 1, 0}, []bool{false, true, false}},
+		{"in order", []uint64{1, 2, 3}, []bool{true, true, true}},
+		{"duplicate", []uint64{5, 5}, []bool{true, false}},
+		{"late inside window", []uint64{10, 8, 9, 8}, []bool{true, true, true, false}},
+		{"oldest slot of window", []uint64{100, 100 - ReplayWindowSize + 1, 100 - ReplayWindowSize + 1}, []bool{true, true, false}},
+		{"just outside window", []uint64{100, 100 - ReplayWindowSize}, []bool{true, false}},
+		// A shift keeps the bits of counters still inside the window
+		{"shift keeps history", []uint64{1, 2, 40, 2, 1}, []bool{true, true, true, false, false}},
+		{"shift drops expired", []uint64{1, 1 + ReplayWindowSize, 1, 2}, []bool{true, true, false, true}},
+		// A jump of a whole window or more starts a fresh bitmap
+		{"jump clears bitmap", []uint64{3, 3 + ReplayWindowSize, 4, 3 + ReplayWindowSize}, []bool{true, true, true, false}},
+		{"far jump", []uint64{7, 1 << 40, 1<<40 - 1, 7}, []bool{true, true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w ReplayWindow
+			for i, counter := range tt.counters {
+				if got := w.Accept(counter); got != tt.want[i] {
+					t.Errorf("Accept(%d) at step %d = %v, want %v", counter, i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResumeReplayWindow(t *testing.T) {
+	w := ResumeReplayWindow(100)
+	for _, counter := range []uint64{1, 100 - ReplayWindowSize, 99, 100} {
+		if w.Accept(counter) {
+			t.Errorf("resumed window accepted old counter %d", counter)
+		}
+	}
+	if !w.Accept(101) || w.Highest() != 101 {
+		t.Errorf("resumed window refused 101 or did not advance (highest %d)", w.Highest())
+	}
+	if !ResumeReplayWindow(0).Accept(1) {
+		t.Error("window resumed at 0 refused counter 1")
+	}
+}
@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// HKDF info strings of the directional key schedule. Each names the
+// direction it serves, so the agent's send key is the C2's receive key.
+const (
+	AgentKeyInfo = "nop-agent key agent>c2 v1"
+	C2KeyInfo    = "nop-agent key c2>agent v1"
+	AgentIVInfo  = "nop-agent iv agent>c2 v1"
+	C2IVInfo     = "nop-agent iv c2>agent v1"
+)
+
+// IVPrefixSize is the fixed part of a directional nonce; the remaining 8
+// bytes are the message counter, or random without replay protection
+const IVPrefixSize = 4
+
+type direction struct {
+	aead cipher.AEAD
+	base []byte // per-message keys are derived from it
+	iv   []byte // nil under the shared schedule
+}
+
+// WireKey seals and opens wire frames under one key (static, session or
+// rekeyed), as seen from the agent
+type WireKey struct {
+	// Secret is the undivided key, used for handshake MACs and rekey chaining
+	Secret     []byte
+	suite      string
+	send, recv direction
+}
+
+// NewWireKey builds the wire cipher for secret in suite. Without directional
+// both directions use secret as is; with it each gets its own key and nonce
+// prefix from HKDF-SHA256(secret, nil, info), see protocol.KeyScheduleDirectional.
+func NewWireKey(suite string, secret []byte, directional bool) (*WireKey, error) {
+	newAEAD, ok := Suites[suite]
+	if !ok {
+		return nil, fmt.Errorf("unknown cipher suite %q", suite)
+	}
+	k := &WireKey{Secret: secret, suite: suite}
+	if !directional {
+		aead, err := newAEAD(secret)
+		if err != nil {
+			return nil, err
+		}
+		k.send = direction{aead: aead, base: secret}
+		k.recv = k.send
+		return k, nil
+	}
+
+	var err error
+	if k.send, err = deriveDirection(newAEAD, secret, AgentKeyInfo, AgentIVInfo); err != nil {
+		return nil, err
+	}
+	if k.recv, err = deriveDirection(newAEAD, secret, C2KeyInfo, C2IVInfo); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func deriveDirection(newAEAD func([]byte) (cipher.AEAD, error), secret []byte, keyInfo, ivInfo string) (direction, error) {
+	key, err := DeriveKey(secret, nil, keyInfo)
+	if err != nil {
+		return direction{}, err
+	}
+	iv, err := deriveBytes(secret, nil, ivInfo, IVPrefixSize)
+	if err != nil {
+		return direction{}, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return direction{}, err
+	}
+	return direction{aead: aead, base: key, iv: iv}, nil
+}
+
+// Directional reports whether the key was split per direction
+func (k *WireKey) Directional() bool {
+	return k.send.iv != nil
+}
+
+// Seal encrypts an outbound frame like Seal. Under the directional schedule
+// the nonce is the send prefix followed by counter, so it never repeats
+// while the counter increases; a zero counter is replaced by random bytes.
+func (k *WireKey) Seal(plaintext, aad []byte, counter uint64, perMessage bool) ([]byte, error) {
+	if k.send.iv == nil {
+		return Seal(k.suite, k.send.aead, k.send.base, plaintext, aad, perMessage)
+	}
+	aead := k.send.aead
+	var prefix []byte
+	if perMessage {
+		prefix = make([]byte, MessageSaltSize)
+		if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+			return nil, err
+		}
+		var err error
+		if aead, err = MessageCipher(k.suite, k.send.base, prefix); err != nil {
+			return nil, err
+		}
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, k.send.iv)
+	if counter != 0 {
+		binary.BigEndian.PutUint64(nonce[IVPrefixSize:], counter)
+	} else if _, err := io.ReadFull(rand.Reader, nonce[IVPrefixSize:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(append(prefix, nonce...), nonce, plaintext, aad), nil
+}
+
+// Open decrypts an inbound frame. Under the directional schedule a nonce
+// without the C2's prefix is refused before decryption.
+func (k *WireKey) Open(data, aad []byte, perMessage bool) ([]byte, error) {
+	if k.recv.iv != nil {
+		offset := 0
+		if perMessage {
+			offset = MessageSaltSize
+		}
+		if len(data) < offset+IVPrefixSize || !bytes.Equal(data[offset:offset+IVPrefixSize], k.recv.iv) {
+			return nil, fmt.Errorf("nonce does not carry the C2 direction prefix")
+		}
+	}
+	return Open(k.suite, k.recv.aead, k.recv.base, data, aad, perMessage)
+}
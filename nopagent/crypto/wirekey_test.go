@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var testSecret = bytes.Repeat([]byte{0x42}, 32)
+
+// c2View returns the key as the C2 holds it: its send side is the agent's
+// receive side
+func c2View(k *WireKey) *WireKey {
+	return &WireKey{Secret: k.Secret, suite: k.suite, send: k.recv, recv: k.send}
+}
+
+func TestWireKeyRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"type":"heartbeat"}`)
+	for _, directional := range []bool{false, true} {
+		for _, perMessage := range []bool{false, true} {
+			agent, err := NewWireKey(DefaultSuite, testSecret, directional)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c2 := c2View(agent)
+
+			sealed, err := agent.Seal(plaintext, []byte(AADAgent), 7, perMessage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opened, err := c2.Open(sealed, []byte(AADAgent), perMessage)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Errorf("directional=%v perMessage=%v: agent to C2 = %q, %v", directional, perMessage, opened, err)
+			}
+
+			sealed, err = c2.Seal(plaintext, []byte(AADC2), 9, perMessage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opened, err = agent.Open(sealed, []byte(AADC2), perMessage)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Errorf("directional=%v perMessage=%v: C2 to agent = %q, %v", directional, perMessage, opened, err)
+			}
+		}
+	}
+}
+
+func TestWireKeyDirectionLabels(t *testing.T) {
+	for _, directional := range []bool{false, true} {
+		agent, _ := NewWireKey(DefaultSuite, testSecret, directional)
+		sealed, err := agent.Seal([]byte("report"), []byte(AADAgent), 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A frame presented as the other direction must not open
+		if _, err := c2View(agent).Open(sealed, []byte(AADC2), false); err == nil {
+			t.Errorf("directional=%v: frame opened under the wrong direction label", directional)
+		}
+	}
+}
+
+func TestWireKeyDirectionalPrefix(t *testing.T) {
+	agent, _ := NewWireKey(DefaultSuite, testSecret, true)
+	if !agent.Directional() {
+		t.Fatal("directional key reports shared schedule")
+	}
+	if bytes.Equal(agent.send.iv, agent.recv.iv) {
+		t.Fatal("both directions share a nonce prefix")
+	}
+
+	sealed, err := agent.Seal([]byte("report"), []byte(AADAgent), 0x0102030405060708, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sealed[:IVPrefixSize], agent.send.iv) {
+		t.Errorf("nonce prefix = %x, want %x", sealed[:IVPrefixSize], agent.send.iv)
+	}
+	if counter := binary.BigEndian.Uint64(sealed[IVPrefixSize:12]); counter != 0x0102030405060708 {
+		t.Errorf("nonce counter = %#x", counter)
+	}
+	// A frame reflected back to its sender carries the wrong prefix
+	if _, err := agent.Open(sealed, []byte(AADAgent), false); err == nil {
+		t.Error("agent opened its own frame")
+	}
+
+	withSalt, err := agent.Seal([]byte("report"), []byte(AADAgent), 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(withSalt[MessageSaltSize:MessageSaltSize+IVPrefixSize], agent.send.iv) {
+		t.Error("per-message frame does not carry the prefix after its salt")
+	}
+
+	shared, _ := NewWireKey(DefaultSuite, testSecret, false)
+	if shared.Directional() {
+		t.Error("shared key reports directional schedule")
+	}
+}
+
+func TestWireKeyPerMessageKeys(t *testing.T) {
+	for _, directional := range []bool{false, true} {
+		agent, _ := NewWireKey(DefaultSuite, testSecret, directional)
+		c2 := c2View(agent)
+		first, _ := agent.Seal([]byte("same"), []byte(AADAgent), 3, true)
+		second, _ := agent.Seal([]byte("same"), []byte(AADAgent), 3, true)
+		if bytes.Equal(first[:MessageSaltSize], second[:MessageSaltSize]) {
+			t.Errorf("directional=%v: two messages share a key salt", directional)
+		}
+		// Each message is sealed under its own derived key, not the base key
+		if _, err := c2.Open(first, []byte(AADAgent), false); err == nil {
+			t.Errorf("directional=%v: per-message frame opened under the base key", directional)
+		}
+		tampered := append([]byte(nil), first...)
+		tampered[0] ^= 1
+		if _, err := c2.Open(tampered, []byte(AADAgent), true); err == nil {
+			t.Errorf("directional=%v: frame opened with a changed salt", directional)
+		}
+	}
+}
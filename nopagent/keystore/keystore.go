@@ -0,0 +1,32 @@
+// Package keystore keeps secrets in the platform keystore: DPAPI on
+// Windows, the Keychain on macOS and the kernel keyring on Linux. It is only
+// compiled in with the "keystore" build tag; without it Open always returns
+// ErrUnavailable and the agent keeps its secrets in sealed local storage.
+package keystore
+
+import "errors"
+
+var (
+	// ErrUnavailable means this build or platform has no keystore support
+	ErrUnavailable = errors.New("platform keystore not available")
+	// ErrNotFound means no secret is stored under the name
+	ErrNotFound = errors.New("secret not found in keystore")
+)
+
+// Store holds named secrets for one service
+type Store interface {
+	// Name identifies the backend, e.g. "dpapi", for registration reports
+	Name() string
+	// Persistent reports whether secrets survive a reboot; the Linux
+	// kernel keyring does not
+	Persistent() bool
+	Put(name string, secret []byte) error
+	Get(name string) ([]byte, error)
+	Delete(name string) error
+}
+
+// Open returns the platform keystore for service. dir is where backends
+// that only protect data (DPAPI) keep their encrypted blobs.
+func Open(service, dir string) (Store, error) {
+	return open(service, dir)
+}
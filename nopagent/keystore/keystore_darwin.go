@@ -0,0 +1,51 @@
+//go:build keystore && darwin
+
+package keystore
+
+import (
+	"encoding/hex"
+	"os/exec"
+	"strings"
+)
+
+// keychain stores secrets as generic passwords in the login keychain through
+// the security tool, so the agent needs no cgo. Secrets are hex encoded; they
+// are briefly visible in the tool's arguments to processes of the same user.
+type keychain struct {
+	service string
+}
+
+func open(service, dir string) (Store, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, ErrUnavailable
+	}
+	return &keychain{service: service}, nil
+}
+
+func (k *keychain) Name() string     { return "keychain" }
+func (k *keychain) Persistent() bool { return true }
+
+func (k *keychain) Put(name string, secret []byte) error {
+	return exec.Command("security", "add-generic-password", "-U",
+		"-s", k.service, "-a", name, "-w", hex.EncodeToString(secret)).Run()
+}
+
+func (k *keychain) Get(name string) ([]byte, error) {
+	output, err := exec.Command("security", "find-generic-password", "-s", k.service, "-a", name, "-w").Output()
+	if err != nil {
+		// Exit status 44 is errSecItemNotFound
+		if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == 44 {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(output)))
+}
+
+func (k *keychain) Delete(name string) error {
+	err := exec.Command("security", "delete-generic-password", "-s", k.service, "-a", name).Run()
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == 44 {
+		return nil
+	}
+	return err
+}
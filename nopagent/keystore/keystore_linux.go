@@ -0,0 +1,73 @@
+//go:build keystore && linux
+
+package keystore
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// keyring stores secrets as "user" keys in the calling user's keyring, so
+// they live in kernel memory rather than in the agent's heap or on disk
+type keyring struct {
+	service string
+	ring    int
+}
+
+func open(service, dir string) (Store, error) {
+	// The persistent keyring outlives login sessions; fall back to the
+	// user keyring on kernels without it
+	ring, err := unix.KeyctlInt(unix.KEYCTL_GET_PERSISTENT, -1, unix.KEY_SPEC_USER_KEYRING, 0, 0)
+	if err != nil {
+		ring = unix.KEY_SPEC_USER_KEYRING
+		if _, err := unix.KeyctlGetKeyringID(ring, true); err != nil {
+			return nil, ErrUnavailable
+		}
+	}
+	return &keyring{service: service, ring: ring}, nil
+}
+
+func (k *keyring) Name() string     { return "kernel-keyring" }
+func (k *keyring) Persistent() bool { return false }
+
+func (k *keyring) description(name string) string {
+	return k.service + ":" + name
+}
+
+func (k *keyring) Put(name string, secret []byte) error {
+	_, err := unix.AddKey("user", k.description(name), secret, k.ring)
+	return err
+}
+
+func (k *keyring) Get(name string) ([]byte, error) {
+	id, err := unix.KeyctlSearch(k.ring, "user", k.description(name), 0)
+	if errors.Is(err, unix.ENOKEY) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (k *keyring) Delete(name string) error {
+	id, err := unix.KeyctlSearch(k.ring, "user", k.description(name), 0)
+	if errors.Is(err, unix.ENOKEY) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = unix.KeyctlInt(unix.KEYCTL_UNLINK, id, k.ring, 0, 0)
+	return err
+}
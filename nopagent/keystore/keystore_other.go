@@ -0,0 +1,7 @@
+//go:build keystore && !linux && !windows && !darwin
+
+package keystore
+
+func open(service, dir string) (Store, error) {
+	return nil, ErrUnavailable
+}
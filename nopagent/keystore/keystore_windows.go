@@ -0,0 +1,82 @@
+//go:build keystore && windows
+
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapi encrypts each secret with CryptProtectData, which binds it to the
+// Windows account running the agent, and keeps the blob in dir
+type dpapi struct {
+	service string
+	dir     string
+}
+
+func open(service, dir string) (Store, error) {
+	if dir == "" {
+		return nil, ErrUnavailable
+	}
+	return &dpapi{service: service, dir: dir}, nil
+}
+
+func (d *dpapi) Name() string     { return "dpapi" }
+func (d *dpapi) Persistent() bool { return true }
+
+func (d *dpapi) path(name string) string {
+	return filepath.Join(d.dir, name+".dpapi")
+}
+
+func blob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// dpapiOut is a blob allocated by DPAPI, freed once copied out
+type dpapiOut struct{ windows.DataBlob }
+
+func (b *dpapiOut) bytes() []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(b.Data)))
+	return append([]byte(nil), unsafe.Slice(b.Data, b.Size)...)
+}
+
+func (d *dpapi) Put(name string, secret []byte) error {
+	var out dpapiOut
+	entropy := []byte(d.service)
+	if err := windows.CryptProtectData(blob(secret), nil, blob(entropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out.DataBlob); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(d.path(name), out.bytes(), 0600)
+}
+
+func (d *dpapi) Get(name string) ([]byte, error) {
+	protected, err := os.ReadFile(d.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out dpapiOut
+	entropy := []byte(d.service)
+	if err := windows.CryptUnprotectData(blob(protected), nil, blob(entropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out.DataBlob); err != nil {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
+
+func (d *dpapi) Delete(name string) error {
+	if err := os.Remove(d.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
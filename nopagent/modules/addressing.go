@@ -0,0 +1,78 @@
+// Package modules gathers facts about the host and its networks: addresses,
+// neighbor caches, routes, power, platform and MAC vendors. Functions here
+// are stateless; the core package decides when to call them and what to
+// report.
+package modules
+
+import (
+	"net"
+	"net/url"
+	"sort"
+)
+
+// ============================================================================
+// ADDRESSING - IPv4 and IPv6 address selection
+// ============================================================================
+
+// AddressFamily returns "ipv4" or "ipv6"
+func AddressFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// UsableAddress filters out loopback, unspecified and multicast addresses
+func UsableAddress(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// InNetworks reports whether ip lies in any of networks
+func InNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GlobalAddresses lists the host's usable non-link-local addresses, IPv4 first
+func GlobalAddresses() []net.IP {
+	ips := make([]net.IP, 0)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && UsableAddress(ipnet.IP) && !ipnet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		return ips[i].To4() != nil && ips[j].To4() == nil
+	})
+	return ips
+}
+
+// PrimaryAddress returns the local address used to reach serverURL, which works
+// for IPv4-only, IPv6-only and dual-stack hosts alike. No packets are sent:
+// connecting a UDP socket only selects the route.
+func PrimaryAddress(serverURL string) string {
+	if u, err := url.Parse(serverURL); err == nil && u.Hostname() != "" {
+		port := u.Port()
+		if port == "" {
+			port = "443"
+		}
+		if conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port)); err == nil {
+			defer conn.Close()
+			if local, ok := conn.LocalAddr().(*net.UDPAddr); ok && UsableAddress(local.IP) {
+				return local.IP.String()
+			}
+		}
+	}
+	if ips := GlobalAddresses(); len(ips) > 0 {
+		return ips[0].String()
+	}
+	return ""
+}
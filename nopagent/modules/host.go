@@ -0,0 +1,256 @@
+package modules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// HostFingerprint hashes the machine ID and hardware addresses, which differ
+// between clones even when the hostname and agent binary are identical
+func HostFingerprint() string {
+	parts := make([]string, 0)
+	if id, err := host.HostID(); err == nil {
+		parts = append(parts, id)
+	}
+	if interfaces, err := net.Interfaces(); err == nil {
+		macs := make([]string, 0)
+		for _, iface := range interfaces {
+			if iface.Flags&net.FlagLoopback == 0 && len(iface.HardwareAddr) > 0 {
+				macs = append(macs, iface.HardwareAddr.String())
+			}
+		}
+		sort.Strings(macs)
+		parts = append(parts, macs...)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// MachineIdentifiers are the host properties a sealed configuration can be
+// bound to, lower-cased so the generator can reproduce them exactly
+func MachineIdentifiers() map[string]string {
+	ids := make(map[string]string)
+	if id, err := host.HostID(); err == nil && id != "" {
+		ids["machine_id"] = strings.ToLower(strings.TrimSpace(id))
+	}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		ids["hostname"] = strings.ToLower(name)
+	}
+	return ids
+}
+
+// CollectHostInfo reports the hostname, OS, resource usage and interfaces
+func CollectHostInfo() map[string]interface{} {
+	info := make(map[string]interface{})
+
+	// Hostname
+	hostname, _ := os.Hostname()
+	info["hostname"] = hostname
+
+	// Platform info
+	info["platform"] = runtime.GOOS
+	info["architecture"] = runtime.GOARCH
+	info["go_version"] = runtime.Version()
+
+	// Host info from gopsutil
+	hostInfo, err := host.Info()
+	if err == nil {
+		info["platform_release"] = hostInfo.Platform
+		info["platform_version"] = hostInfo.PlatformVersion
+		info["os"] = hostInfo.OS
+		info["kernel_version"] = hostInfo.KernelVersion
+		info["boot_time"] = time.Unix(int64(hostInfo.BootTime), 0).Format(time.RFC3339)
+	}
+
+	// CPU
+	cpuPercent, err := cpu.Percent(time.Second, false)
+	if err == nil && len(cpuPercent) > 0 {
+		info["cpu_percent"] = cpuPercent[0]
+	}
+
+	// Memory
+	memInfo, err := mem.VirtualMemory()
+	if err == nil {
+		info["memory_percent"] = memInfo.UsedPercent
+		info["memory_total"] = memInfo.Total
+		info["memory_used"] = memInfo.Used
+	}
+
+	// Disk
+	diskInfo, err := disk.Usage("/")
+	if err == nil {
+		info["disk_percent"] = diskInfo.UsedPercent
+		info["disk_total"] = diskInfo.Total
+		info["disk_used"] = diskInfo.Used
+	}
+
+	// Network interfaces
+	interfaces := make([]map[string]interface{}, 0)
+	ifaces, err := net.Interfaces()
+	if err == nil {
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp == 0 {
+				continue
+			}
+			addrs, _ := iface.Addrs()
+			for _, addr := range addrs {
+				ipnet, ok := addr.(*net.IPNet)
+				if !ok || !UsableAddress(ipnet.IP) {
+					continue
+				}
+				interfaces = append(interfaces, map[string]interface{}{
+					"name":   iface.Name,
+					"ip":     ipnet.IP.String(),
+					"family": AddressFamily(ipnet.IP),
+					"prefix": ipnet.String(),
+					"status": "up",
+				})
+			}
+		}
+	}
+	info["interfaces"] = interfaces
+
+	return info
+}
+
+// PlatformDetail identifies the exact build target of this binary and the
+// host's C library, which decide which update artifact can run here
+func PlatformDetail() map[string]interface{} {
+	detail := map[string]interface{}{
+		"goos":   runtime.GOOS,
+		"goarch": runtime.GOARCH,
+		"libc":   DetectLibc(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "GOARM", "GOAMD64", "GOARM64", "GO386", "GOMIPS", "GORISCV64":
+				detail[strings.ToLower(setting.Key)] = setting.Value
+			case "CGO_ENABLED":
+				detail["cgo"] = setting.Value == "1"
+			}
+		}
+	}
+	return detail
+}
+
+// DetectLibc returns "glibc", "musl", "bionic" or "" when the platform has
+// no separate C library (e.g. Windows, macOS)
+func DetectLibc() string {
+	if runtime.GOOS == "android" {
+		return "bionic"
+	}
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	if matches, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(matches) > 0 {
+		return "musl"
+	}
+	for _, pattern := range []string{"/lib*/ld-linux*.so.*", "/lib/*/ld-linux*.so.*"} {
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			return "glibc"
+		}
+	}
+	return "unknown"
+}
+
+// DetectVirtualization reports whether the agent runs in a VM, container or
+// WSL, with the evidence found. Kind is "vm", "container", "wsl" or "none".
+func DetectVirtualization() map[string]interface{} {
+	kind, system := "none", ""
+	hints := make([]string, 0)
+	readFile := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return strings.ToLower(strings.TrimSpace(string(data)))
+	}
+
+	// gopsutil covers cgroups, DMI and /proc/xen on Linux and WMI on Windows
+	if system, role, err := host.Virtualization(); err == nil && system != "" {
+		hints = append(hints, fmt.Sprintf("gopsutil: %s (%s)", system, role))
+	}
+
+	if version := readFile("/proc/version"); strings.Contains(version, "microsoft") {
+		kind, system = "wsl", "wsl2"
+		if !strings.Contains(version, "wsl2") {
+			system = "wsl"
+		}
+		hints = append(hints, "/proc/version mentions microsoft")
+	}
+
+	if kind == "none" {
+		markers := map[string]string{"/.dockerenv": "docker", "/run/.containerenv": "podman"}
+		for path, runtimeName := range markers {
+			if _, err := os.Stat(path); err == nil {
+				kind, system = "container", runtimeName
+				hints = append(hints, path+" present")
+			}
+		}
+		cgroup := readFile("/proc/1/cgroup")
+		for _, name := range []string{"kubepods", "docker", "containerd", "lxc", "libpod"} {
+			if strings.Contains(cgroup, name) {
+				hints = append(hints, "cgroup: "+name)
+				if kind == "none" {
+					kind, system = "container", name
+				}
+			}
+		}
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			hints = append(hints, "kubernetes service environment")
+			if kind == "none" {
+				kind, system = "container", "kubernetes"
+			}
+		}
+	}
+
+	// DMI strings and the CPUID hypervisor bit identify full virtual machines
+	vendor := readFile("/sys/class/dmi/id/sys_vendor")
+	product := readFile("/sys/class/dmi/id/product_name")
+	dmi := map[string]string{
+		"vmware": "vmware", "virtualbox": "virtualbox", "innotek": "virtualbox", "qemu": "kvm",
+		"kvm": "kvm", "xen": "xen", "microsoft corporation": "hyperv", "amazon ec2": "aws",
+		"google": "gce", "parallels": "parallels", "bochs": "bochs",
+	}
+	for marker, name := range dmi {
+		if strings.Contains(vendor, marker) || strings.Contains(product, marker) {
+			hints = append(hints, fmt.Sprintf("dmi: %s %s", vendor, product))
+			if kind == "none" {
+				kind, system = "vm", name
+			}
+			break
+		}
+	}
+	if cpuinfo := readFile("/proc/cpuinfo"); strings.Contains(cpuinfo, " hypervisor") {
+		hints = append(hints, "cpuid hypervisor flag")
+		if kind == "none" {
+			kind = "vm"
+		}
+	}
+	if _, err := os.Stat("/proc/xen"); err == nil && kind == "none" {
+		kind, system = "vm", "xen"
+		hints = append(hints, "/proc/xen present")
+	}
+
+	sort.Strings(hints)
+	return map[string]interface{}{
+		"type":   kind,
+		"system": system,
+		"hints":  hints,
+	}
+}
@@ -0,0 +1,132 @@
+package modules
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// NeighborTable6 reads the IPv6 neighbor (NDP) cache
+func NeighborTable6() []map[string]interface{} {
+	assets := make([]map[string]interface{}, 0)
+	record := func(ip, mac, iface string) {
+		parsed := net.ParseIP(ip)
+		if parsed == nil || parsed.To4() != nil || !UsableAddress(parsed) || mac == "" {
+			return
+		}
+		asset := map[string]interface{}{
+			"ip":            parsed.String(),
+			"family":        "ipv6",
+			"mac":           mac,
+			"status":        "online",
+			"discovered_at": time.Now().UTC().Format(time.RFC3339),
+			"method":        "ndp_table",
+		}
+		if iface != "" {
+			asset["interface"] = iface
+		}
+		if parsed.IsLinkLocalUnicast() {
+			asset["scope"] = "link"
+		}
+		assets = append(assets, asset)
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		// fe80::1 dev eth0 lladdr 52:54:00:12:34:56 REACHABLE
+		output, err := exec.Command("ip", "-6", "neigh", "show").Output()
+		if err != nil {
+			return assets
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 5 || strings.Contains(line, "FAILED") || strings.Contains(line, "INCOMPLETE") {
+				continue
+			}
+			var mac, iface string
+			for i := 1; i+1 < len(fields); i++ {
+				switch fields[i] {
+				case "lladdr":
+					mac = fields[i+1]
+				case "dev":
+					iface = fields[i+1]
+				}
+			}
+			record(fields[0], mac, iface)
+		}
+	case "windows":
+		output, err := exec.Command("netsh", "interface", "ipv6", "show", "neighbors").Output()
+		if err != nil {
+			return assets
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && !strings.Contains(line, "Unreachable") {
+				record(fields[0], fields[1], "")
+			}
+		}
+	}
+	return assets
+}
+
+// ArpTable reads the IPv4 ARP cache
+func ArpTable() []map[string]interface{} {
+	assets := make([]map[string]interface{}, 0)
+
+	// Read ARP table from /proc/net/arp on Linux
+	if runtime.GOOS == "linux" {
+		data, err := os.ReadFile("/proc/net/arp")
+		if err != nil {
+			return assets
+		}
+
+		lines := strings.Split(string(data), "\n")
+		for i, line := range lines {
+			if i == 0 { // Skip header
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) >= 4 {
+				ip := fields[0]
+				mac := fields[3]
+				if mac != "00:00:00:00:00:00" && ip != "" {
+					assets = append(assets, map[string]interface{}{
+						"ip":            ip,
+						"mac":           mac,
+						"status":        "online",
+						"discovered_at": time.Now().UTC().Format(time.RFC3339),
+						"method":        "arp_table",
+					})
+				}
+			}
+		}
+	} else if runtime.GOOS == "windows" {
+		// On Windows, use arp -a command
+		cmd := exec.Command("arp", "-a")
+		output, err := cmd.Output()
+		if err == nil {
+			lines := strings.Split(string(output), "\n")
+			for _, line := range lines {
+				fields := strings.Fields(line)
+				if len(fields) >= 2 {
+					ip := fields[0]
+					if net.ParseIP(ip) != nil && len(fields) >= 2 {
+						mac := fields[1]
+						assets = append(assets, map[string]interface{}{
+							"ip":            ip,
+							"mac":           mac,
+							"status":        "online",
+							"discovered_at": time.Now().UTC().Format(time.RFC3339),
+							"method":        "arp_table",
+						})
+					}
+				}
+			}
+		}
+	}
+
+	return assets
+}
@@ -0,0 +1,165 @@
+package modules
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// NETBIOS - Machine names and workgroups of Windows hosts
+// ============================================================================
+
+// NetBIOSInfo is what a node status query reveals about a host
+type NetBIOSInfo struct {
+	Name             string // machine name
+	Domain           string // domain or workgroup
+	DomainController bool   // registers the domain controllers group
+	MAC              string // zero on Samba, which has no unit ID
+}
+
+// NetBIOSOptions tunes a NetBIOS and SMB sweep of known hosts
+type NetBIOSOptions struct {
+	Workers int
+	Timeout time.Duration // per query or SMB exchange
+	SMB     bool          // also negotiate SMB on port 445
+}
+
+// NetBIOSScan queries each host's NetBIOS name table and, with opts.SMB,
+// negotiates an anonymous SMB session, returning the netbios_* and smb_*
+// asset fields of every host that answered either, keyed by IP
+func NetBIOSScan(ctx context.Context, hosts []net.IP, opts NetBIOSOptions) map[string]map[string]interface{} {
+	results := make(map[string]map[string]interface{})
+	var mutex sync.Mutex
+	runProbes(ctx, len(hosts), opts.Workers, 0, func(i int) {
+		fields := make(map[string]interface{})
+		if info, err := NetBIOSStatus(ctx, hosts[i], opts.Timeout); err == nil {
+			fields["netbios_name"] = info.Name
+			if info.Domain != "" {
+				fields["netbios_domain"] = info.Domain
+			}
+			if info.DomainController {
+				fields["netbios_domain_controller"] = true
+			}
+			if info.MAC != "" {
+				fields["netbios_mac"] = info.MAC
+			}
+		}
+		if opts.SMB {
+			if info, err := SMBProbe(ctx, hosts[i], opts.Timeout); err == nil {
+				for k, v := range info.Fields() {
+					fields[k] = v
+				}
+			}
+		}
+		if len(fields) == 0 {
+			return
+		}
+		mutex.Lock()
+		results[hosts[i].String()] = fields
+		mutex.Unlock()
+	})
+	return results
+}
+
+// NetBIOSStatus sends a node status (NBSTAT) query to UDP port 137
+func NetBIOSStatus(ctx context.Context, ip net.IP, timeout time.Duration) (*NetBIOSInfo, error) {
+	dialer := net.Dialer{Timeout: timeoutOrDefault(timeout)}
+	conn, err := dialer.DialContext(ctx, "udp4", net.JoinHostPort(ip.String(), "137"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeoutOrDefault(timeout)))
+
+	query := make([]byte, 12, 50)
+	rand.Read(query[:2])
+	binary.BigEndian.PutUint16(query[4:], 1) // one question
+	// The wildcard name "*", padded with NULs and first-level encoded
+	query = append(query, 32)
+	for i := 0; i < 16; i++ {
+		var c byte
+		if i == 0 {
+			c = '*'
+		}
+		query = append(query, 'A'+c>>4, 'A'+c&0x0f)
+	}
+	query = append(query, 0, 0x00, 0x21, 0x00, 0x01) // NBSTAT, IN
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 12 && buf[0] == query[0] && buf[1] == query[1] {
+			return parseNodeStatus(buf[:n])
+		}
+	}
+}
+
+// parseNodeStatus reads the name table of an NBSTAT response
+func parseNodeStatus(data []byte) (*NetBIOSInfo, error) {
+	errShort := errors.New("truncated node status response")
+	if binary.BigEndian.Uint16(data[6:]) == 0 {
+		return nil, errors.New("node status response has no answer")
+	}
+	off := 12
+	for off < len(data) && data[off] != 0 {
+		if data[off]&0xc0 == 0xc0 {
+			off++
+			break
+		}
+		off += int(data[off]) + 1
+	}
+	off++
+	// TYPE, CLASS, TTL and RDLENGTH
+	if off+10 > len(data) {
+		return nil, errShort
+	}
+	off += 10
+	if off >= len(data) {
+		return nil, errShort
+	}
+	count := int(data[off])
+	off++
+	if off+count*18 > len(data) {
+		return nil, errShort
+	}
+
+	info := &NetBIOSInfo{}
+	for i := 0; i < count; i++ {
+		entry := data[off+i*18 : off+i*18+18]
+		name := strings.TrimRight(string(entry[:15]), " \x00")
+		suffix := entry[15]
+		group := binary.BigEndian.Uint16(entry[16:])&0x8000 != 0
+		switch {
+		case suffix == 0x00 && !group && info.Name == "":
+			info.Name = name
+		case suffix == 0x00 && group && info.Domain == "":
+			info.Domain = name
+		case suffix == 0x1c && group:
+			info.Domain, info.DomainController = name, true
+		}
+	}
+	off += count * 18
+	if off+6 <= len(data) {
+		mac := net.HardwareAddr(data[off : off+6])
+		if mac.String() != "00:00:00:00:00:00" {
+			info.MAC = mac.String()
+		}
+	}
+	if info.Name == "" {
+		return nil, fmt.Errorf("node status lists no machine name")
+	}
+	return info, nil
+}
@@ -0,0 +1,128 @@
+package modules
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+)
+
+// CollectNetworkState snapshots the gateway, SSID and interface addresses
+// whose change signals that the host moved to another network
+func CollectNetworkState() *protocol.NetworkState {
+	state := &protocol.NetworkState{
+		Gateway:   DefaultGateway(),
+		SSID:      WifiSSID(),
+		Addresses: make([]string, 0),
+	}
+
+	// A new DHCP lease shows up as a changed interface address
+	ifaces, err := net.Interfaces()
+	if err == nil {
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+				continue
+			}
+			addrs, _ := iface.Addrs()
+			for _, addr := range addrs {
+				ipnet, ok := addr.(*net.IPNet)
+				if !ok || !UsableAddress(ipnet.IP) || ipnet.IP.IsLinkLocalUnicast() {
+					continue
+				}
+				entry := ipnet.String()
+				if ipnet.IP.To4() == nil {
+					// IPv6 privacy addresses rotate; the prefix identifies the network
+					entry = (&net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask}).String()
+				}
+				state.Addresses = append(state.Addresses, fmt.Sprintf("%s=%s", iface.Name, entry))
+			}
+		}
+	}
+	sort.Strings(state.Addresses)
+
+	return state
+}
+
+// DefaultGateway returns the IPv4 default route's next hop
+func DefaultGateway() string {
+	switch runtime.GOOS {
+	case "linux":
+		// Read default route from /proc/net/route (gateway is little-endian hex)
+		data, err := os.ReadFile("/proc/net/route")
+		if err != nil {
+			return ""
+		}
+		lines := strings.Split(string(data), "\n")
+		for i, line := range lines {
+			if i == 0 { // Skip header
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && fields[1] == "00000000" {
+				gw, err := strconv.ParseUint(fields[2], 16, 32)
+				if err != nil {
+					continue
+				}
+				return net.IPv4(byte(gw), byte(gw>>8), byte(gw>>16), byte(gw>>24)).String()
+			}
+		}
+	case "darwin":
+		output, err := exec.Command("route", "-n", "get", "default").Output()
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "gateway:") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "gateway:"))
+			}
+		}
+	case "windows":
+		output, err := exec.Command("route", "print", "0.0.0.0").Output()
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && fields[0] == "0.0.0.0" && fields[1] == "0.0.0.0" && net.ParseIP(fields[2]) != nil {
+				return fields[2]
+			}
+		}
+	}
+	return ""
+}
+
+// WifiSSID returns the connected wireless network, if any
+func WifiSSID() string {
+	switch runtime.GOOS {
+	case "linux":
+		output, err := exec.Command("iwgetid", "-r").Output()
+		if err == nil {
+			return strings.TrimSpace(string(output))
+		}
+	case "darwin":
+		output, err := exec.Command("networksetup", "-getairportnetwork", "en0").Output()
+		if err == nil {
+			if parts := strings.SplitN(string(output), ":", 2); len(parts) == 2 && strings.Contains(parts[0], "Network") {
+				return strings.TrimSpace(parts[1])
+			}
+		}
+	case "windows":
+		output, err := exec.Command("netsh", "wlan", "show", "interfaces").Output()
+		if err == nil {
+			for _, line := range strings.Split(string(output), "\n") {
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 && strings.TrimSpace(parts[0]) == "SSID" {
+					return strings.TrimSpace(parts[1])
+				}
+			}
+		}
+	}
+	return ""
+}
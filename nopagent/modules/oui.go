@@ -0,0 +1,60 @@
+package modules
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"io"
+	"strings"
+)
+
+// ============================================================================
+// OUI - MAC vendor resolution
+// ============================================================================
+
+// EmbeddedOUI is a gzip-compressed table of "PREFIX<TAB>Vendor" lines, with
+// an optional "# version: X" header; prefixes are 6, 7 or 9 hex digits
+//
+//go:embed oui.txt.gz
+var EmbeddedOUI []byte
+
+// ParseOUI decompresses and parses an OUI table, returning it with its
+// version header
+func ParseOUI(data []byte) (map[string]string, string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	table := make(map[string]string)
+	version := ""
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			if v, ok := strings.CutPrefix(line, "# version:"); ok {
+				version = strings.TrimSpace(v)
+			}
+			continue
+		}
+		prefix, vendor, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		prefix = NormalizeMAC(prefix)
+		if n := len(prefix); n != 6 && n != 7 && n != 9 {
+			continue
+		}
+		table[prefix] = strings.TrimSpace(vendor)
+	}
+	return table, version, nil
+}
+
+// NormalizeMAC strips separators and upper-cases a MAC address or prefix
+func NormalizeMAC(mac string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.TrimSpace(mac)))
+}
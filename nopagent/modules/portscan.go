@@ -0,0 +1,76 @@
+package modules
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// PORT SCAN - TCP connect scanning of discovered hosts
+// ============================================================================
+
+// DefaultScanPorts are scanned when no port list is configured
+var DefaultScanPorts = []int{
+	21, 22, 23, 25, 53, 80, 110, 135, 139, 143, 443, 445, 993, 995,
+	1433, 3306, 3389, 5432, 5900, 6379, 8080, 8443,
+}
+
+// wellKnownServices is the coarse service guess for an open port
+var wellKnownServices = map[int]string{
+	21: "ftp", 22: "ssh", 23: "telnet", 25: "smtp", 53: "dns", 80: "http",
+	88: "kerberos", 110: "pop3", 111: "rpcbind", 135: "msrpc", 139: "netbios-ssn",
+	143: "imap", 389: "ldap", 443: "https", 445: "microsoft-ds", 465: "smtps",
+	502: "modbus", 587: "submission", 636: "ldaps", 873: "rsync", 993: "imaps",
+	995: "pop3s", 1433: "ms-sql", 1521: "oracle", 1883: "mqtt", 2049: "nfs",
+	2375: "docker", 3306: "mysql", 3389: "rdp", 5432: "postgresql", 5900: "vnc",
+	5985: "winrm", 5986: "winrm-https", 6379: "redis", 6443: "kubernetes",
+	8080: "http-alt", 8443: "https-alt", 9200: "elasticsearch", 11211: "memcached",
+	20000: "dnp3", 27017: "mongodb", 44818: "ethernet-ip", 47808: "bacnet",
+}
+
+// ScanOptions tunes a TCP connect scan
+type ScanOptions struct {
+	Ports   []int
+	Workers int           // connects in flight
+	Rate    int           // connects started per second, 0 for no limit
+	Timeout time.Duration // per connect
+}
+
+// PortScan connects to every port of every host and returns the open ports
+// of each host that has any, keyed by IP, in the open_ports form of imported
+// scans: {"port": 22, "protocol": "tcp", "service": "ssh"}
+func PortScan(ctx context.Context, hosts []net.IP, opts ScanOptions) map[string][]map[string]interface{} {
+	dialer := net.Dialer{Timeout: timeoutOrDefault(opts.Timeout)}
+	open := make(map[string][]int)
+	var mutex sync.Mutex
+	runProbes(ctx, len(hosts)*len(opts.Ports), opts.Workers, opts.Rate, func(i int) {
+		host, port := hosts[i/len(opts.Ports)].String(), opts.Ports[i%len(opts.Ports)]
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return
+		}
+		conn.Close()
+		mutex.Lock()
+		open[host] = append(open[host], port)
+		mutex.Unlock()
+	})
+
+	results := make(map[string][]map[string]interface{}, len(open))
+	for host, ports := range open {
+		sort.Ints(ports)
+		entries := make([]map[string]interface{}, 0, len(ports))
+		for _, port := range ports {
+			entry := map[string]interface{}{"port": port, "protocol": "tcp"}
+			if service, ok := wellKnownServices[port]; ok {
+				entry["service"] = service
+			}
+			entries = append(entries, entry)
+		}
+		results[host] = entries
+	}
+	return results
+}
@@ -0,0 +1,54 @@
+package modules
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// OnBattery reports whether the host is running on battery power
+func OnBattery() bool {
+	switch runtime.GOOS {
+	case "linux":
+		// Any online mains adapter means we are plugged in
+		supplies, err := os.ReadDir("/sys/class/power_supply")
+		if err != nil {
+			return false
+		}
+		hasBattery := false
+		for _, supply := range supplies {
+			base := "/sys/class/power_supply/" + supply.Name()
+			typ, _ := os.ReadFile(base + "/type")
+			switch strings.TrimSpace(string(typ)) {
+			case "Mains":
+				online, _ := os.ReadFile(base + "/online")
+				if strings.TrimSpace(string(online)) == "1" {
+					return false
+				}
+			case "Battery":
+				status, _ := os.ReadFile(base + "/status")
+				if strings.TrimSpace(string(status)) == "Discharging" {
+					hasBattery = true
+				}
+			}
+		}
+		return hasBattery
+	case "darwin":
+		output, err := exec.Command("pmset", "-g", "batt").Output()
+		if err == nil {
+			return strings.Contains(string(output), "'Battery Power'")
+		}
+	case "windows":
+		// BatteryStatus 1 = discharging
+		output, err := exec.Command("wmic", "path", "Win32_Battery", "get", "BatteryStatus").Output()
+		if err == nil {
+			for _, line := range strings.Split(string(output), "\n") {
+				if strings.TrimSpace(line) == "1" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+package modules
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scan formats understood by ParseScan
+const (
+	ScanNmap    = "nmap"
+	ScanMasscan = "masscan"
+	ScanArpScan = "arp-scan"
+)
+
+// DetectScanFormat guesses the tool that produced data: Nmap XML, Masscan
+// JSON (-oJ) or arp-scan's tab-separated output
+func DetectScanFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.Contains(trimmed[:min(len(trimmed), 4096)], []byte("<nmaprun")):
+		return ScanNmap
+	case len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{'):
+		return ScanMasscan
+	default:
+		return ScanArpScan
+	}
+}
+
+// ParseScan normalizes the output of a third-party scanner into asset
+// records shaped like those of the asset module. format may be empty to
+// detect it; the format used is returned.
+func ParseScan(format string, data []byte) ([]map[string]interface{}, string, error) {
+	if format == "" || format == "auto" {
+		format = DetectScanFormat(data)
+	}
+	var assets []map[string]interface{}
+	var err error
+	switch format {
+	case ScanNmap:
+		assets, err = parseNmapXML(data)
+	case ScanMasscan:
+		assets, err = parseMasscanJSON(data)
+	case ScanArpScan:
+		assets, err = parseArpScan(data)
+	default:
+		return nil, format, fmt.Errorf("unknown scan format %q", format)
+	}
+	return assets, format, err
+}
+
+// scanAsset starts an imported asset record for ip
+func scanAsset(ip net.IP, format, seen string) map[string]interface{} {
+	if seen == "" {
+		seen = time.Now().UTC().Format(time.RFC3339)
+	}
+	asset := map[string]interface{}{
+		"ip":            ip.String(),
+		"family":        AddressFamily(ip),
+		"status":        "online",
+		"discovered_at": seen,
+		"method":        "import_" + strings.ReplaceAll(format, "-", "_"),
+	}
+	if ip.IsLinkLocalUnicast() {
+		asset["scope"] = "link"
+	}
+	return asset
+}
+
+// unixTime renders a Unix timestamp string as RFC3339, empty if unreadable
+func unixTime(s string) string {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || seconds <= 0 {
+		return ""
+	}
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+// ============================================================================
+// Nmap XML (-oX)
+// ============================================================================
+
+type nmapRun struct {
+	Hosts []nmapHost `xml:"host"`
+}
+
+type nmapHost struct {
+	EndTime string `xml:"endtime,attr"`
+	Status  struct {
+		State string `xml:"state,attr"`
+	} `xml:"status"`
+	Addresses []struct {
+		Addr     string `xml:"addr,attr"`
+		AddrType string `xml:"addrtype,attr"`
+		Vendor   string `xml:"vendor,attr"`
+	} `xml:"address"`
+	Hostnames []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"hostnames>hostname"`
+	Ports []struct {
+		Protocol string `xml:"protocol,attr"`
+		PortID   int    `xml:"portid,attr"`
+		State    struct {
+			State string `xml:"state,attr"`
+		} `xml:"state"`
+		Service struct {
+			Name    string `xml:"name,attr"`
+			Product string `xml:"product,attr"`
+			Version string `xml:"version,attr"`
+		} `xml:"service"`
+	} `xml:"ports>port"`
+	OSMatches []struct {
+		Name     string `xml:"name,attr"`
+		Accuracy int    `xml:"accuracy,attr"`
+	} `xml:"os>osmatch"`
+}
+
+func parseNmapXML(data []byte) ([]map[string]interface{}, error) {
+	var run nmapRun
+	if err := xml.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("invalid Nmap XML: %v", err)
+	}
+
+	assets := make([]map[string]interface{}, 0, len(run.Hosts))
+	for _, host := range run.Hosts {
+		if host.Status.State != "" && host.Status.State != "up" {
+			continue
+		}
+		var ip net.IP
+		var mac, vendor string
+		for _, addr := range host.Addresses {
+			switch addr.AddrType {
+			case "ipv4", "ipv6":
+				if ip == nil {
+					ip = net.ParseIP(addr.Addr)
+				}
+			case "mac":
+				if hw, err := net.ParseMAC(addr.Addr); err == nil {
+					mac, vendor = hw.String(), addr.Vendor
+				}
+			}
+		}
+		if ip == nil {
+			continue
+		}
+
+		asset := scanAsset(ip, ScanNmap, unixTime(host.EndTime))
+		if mac != "" {
+			asset["mac"] = mac
+		}
+		if vendor != "" {
+			asset["vendor"] = vendor
+		}
+		if len(host.Hostnames) > 0 && host.Hostnames[0].Name != "" {
+			asset["hostname"] = host.Hostnames[0].Name
+		}
+
+		ports := make([]map[string]interface{}, 0)
+		for _, port := range host.Ports {
+			if port.State.State != "open" {
+				continue
+			}
+			entry := map[string]interface{}{"port": port.PortID, "protocol": port.Protocol}
+			if port.Service.Name != "" {
+				entry["service"] = port.Service.Name
+			}
+			if product := strings.TrimSpace(port.Service.Product + " " + port.Service.Version); product != "" {
+				entry["product"] = product
+			}
+			ports = append(ports, entry)
+		}
+		if len(ports) > 0 {
+			asset["open_ports"] = ports
+		}
+
+		best := 0
+		for _, match := range host.OSMatches {
+			if match.Accuracy > best {
+				best = match.Accuracy
+				asset["os_guess"] = match.Name
+				asset["os_accuracy"] = match.Accuracy
+			}
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+// ============================================================================
+// Masscan JSON (-oJ)
+// ============================================================================
+
+type masscanRecord struct {
+	IP        string `json:"ip"`
+	Timestamp string `json:"timestamp"`
+	Ports     []struct {
+		Port    int    `json:"port"`
+		Proto   string `json:"proto"`
+		Status  string `json:"status"`
+		Service struct {
+			Name   string `json:"name"`
+			Banner string `json:"banner"`
+		} `json:"service"`
+	} `json:"ports"`
+}
+
+// parseMasscanJSON accepts the -oJ array as well as the one-object-per-line
+// form masscan writes while running, where the array is left unterminated
+// and lines carry trailing commas. Masscan reports one port per record, so
+// records are merged by IP.
+func parseMasscanJSON(data []byte) ([]map[string]interface{}, error) {
+	var records []masscanRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		records = records[:0]
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ",")
+			if !strings.HasPrefix(line, "{") {
+				continue
+			}
+			var record masscanRecord
+			if json.Unmarshal([]byte(line), &record) == nil && record.IP != "" {
+				records = append(records, record)
+			}
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("invalid Masscan JSON: %v", err)
+		}
+	}
+
+	byIP := make(map[string]map[string]interface{})
+	order := make([]string, 0)
+	for _, record := range records {
+		ip := net.ParseIP(record.IP)
+		if ip == nil {
+			continue
+		}
+		asset, ok := byIP[ip.String()]
+		if !ok {
+			asset = scanAsset(ip, ScanMasscan, unixTime(record.Timestamp))
+			asset["open_ports"] = make([]map[string]interface{}, 0)
+			byIP[ip.String()] = asset
+			order = append(order, ip.String())
+		}
+		ports := asset["open_ports"].([]map[string]interface{})
+		for _, port := range record.Ports {
+			if port.Status != "" && port.Status != "open" {
+				continue
+			}
+			entry := map[string]interface{}{"port": port.Port, "protocol": port.Proto}
+			if port.Service.Name != "" {
+				entry["service"] = port.Service.Name
+			}
+			if port.Service.Banner != "" {
+				entry["banner"] = port.Service.Banner
+			}
+			ports = append(ports, entry)
+		}
+		asset["open_ports"] = ports
+	}
+
+	assets := make([]map[string]interface{}, 0, len(order))
+	for _, ip := range order {
+		asset := byIP[ip]
+		ports := asset["open_ports"].([]map[string]interface{})
+		sort.Slice(ports, func(i, j int) bool { return ports[i]["port"].(int) < ports[j]["port"].(int) })
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+// ============================================================================
+// arp-scan
+// ============================================================================
+
+// parseArpScan reads lines of "IP<TAB>MAC<TAB>Vendor"; banner and summary
+// lines do not start with an address and are skipped
+func parseArpScan(data []byte) ([]map[string]interface{}, error) {
+	assets := make([]map[string]interface{}, 0)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "\t", 3)
+		if len(fields) < 2 {
+			fields = strings.Fields(line)
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(strings.TrimSpace(fields[0]))
+		hw, err := net.ParseMAC(strings.TrimSpace(fields[1]))
+		if ip == nil || err != nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+
+		asset := scanAsset(ip, ScanArpScan, "")
+		asset["mac"] = hw.String()
+		if len(fields) == 3 {
+			if vendor := strings.TrimSpace(fields[2]); vendor != "" && !strings.HasPrefix(vendor, "(Unknown") {
+				asset["vendor"] = vendor
+			}
+		}
+		assets = append(assets, asset)
+	}
+	if len(assets) == 0 {
+		return nil, fmt.Errorf("no arp-scan results found")
+	}
+	return assets, nil
+}
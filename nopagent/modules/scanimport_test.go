@@ -0,0 +1,142 @@
+package modules
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const nmapSample = `<?xml version="1.0"?>
+<nmaprun scanner="nmap">
+<host endtime="1710497250"><status state="up"/>
+<address addr="192.168.1.10" addrtype="ipv4"/>
+<address addr="AA:BB:CC:00:11:22" addrtype="mac" vendor="Acme"/>
+<hostnames><hostname name="nas.lan"/></hostnames>
+<ports>
+<port protocol="tcp" portid="22"><state state="open"/><service name="ssh" product="OpenSSH" version="9.6"/></port>
+<port protocol="tcp" portid="23"><state state="closed"/></port>
+<port protocol="udp" portid="161"><state state="open"/><service name="snmp"/></port>
+</ports>
+<os><osmatch name="Linux 5.x" accuracy="90"/><osmatch name="Linux 6.x" accuracy="96"/></os>
+</host>
+<host><status state="down"/><address addr="192.168.1.11" addrtype="ipv4"/></host>
+<host><status state="up"/><address addr="fe80::1" addrtype="ipv6"/></host>
+</nmaprun>`
+
+const masscanSample = `[
+{"ip": "10.0.0.5", "timestamp": "1710497250", "ports": [{"port": 443, "proto": "tcp", "status": "open"}]},
+{"ip": "10.0.0.5", "timestamp": "1710497251", "ports": [{"port": 80, "proto": "tcp", "status": "open", "service": {"name": "http", "banner": "nginx"}}]},
+{"ip": "10.0.0.6", "timestamp": "1710497252", "ports": [{"port": 22, "proto": "tcp", "status": "closed"}]}
+]`
+
+// masscanRunning is the unterminated form masscan writes while scanning
+const masscanRunning = `[
+{"ip": "10.0.0.7", "timestamp": "1710497250", "ports": [{"port": 25, "proto": "tcp", "status": "open"}]},
+{"ip": "10.0.0.7", "timestamp": "1710497251", "ports": [{"port": 21, "proto": "tcp", "status": "open"}]},
+`
+
+const arpScanSample = `Interface: eth0, type: EN10MB, MAC: 00:11:22:33:44:55, IPv4: 192.168.1.2
+Starting arp-scan 1.10.0 with 256 hosts
+192.168.1.1	00:1a:2b:3c:4d:5e	Router Corp
+192.168.1.20	00:1a:2b:3c:4d:5f	(Unknown)
+192.168.1.20	00:1a:2b:3c:4d:5f	(DUP: 2)
+
+3 packets received by filter, 0 packets dropped by kernel
+Ending arp-scan 1.10.0: 256 hosts scanned in 1.9 seconds (134.74 hosts/sec). 2 responded`
+
+// summarize reduces an asset to the fields under test, one string each. A
+// discovery time from the clock rather than the scan reads "now".
+func summarize(asset map[string]interface{}, start time.Time) string {
+	fields := []string{fmt.Sprint(asset["ip"]), fmt.Sprint(asset["method"])}
+	for _, key := range []string{"mac", "vendor", "hostname", "os_guess", "scope", "discovered_at"} {
+		if val, ok := asset[key]; ok {
+			if seen, err := time.Parse(time.RFC3339, fmt.Sprint(val)); err == nil && !seen.Before(start.Truncate(time.Second)) {
+				val = "now"
+			}
+			fields = append(fields, key+"="+fmt.Sprint(val))
+		}
+	}
+	if ports, ok := asset["open_ports"].([]map[string]interface{}); ok {
+		for _, port := range ports {
+			entry := fmt.Sprintf("%v/%v", port["port"], port["protocol"])
+			for _, key := range []string{"service", "product", "banner"} {
+				if val, ok := port[key]; ok {
+					entry += " " + fmt.Sprint(val)
+				}
+			}
+			fields = append(fields, entry)
+		}
+	}
+	return strings.Join(fields, "; ")
+}
+
+func TestParseScan(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		data       string
+		wantFormat string
+		want       []string
+	}{
+		{"nmap", "", nmapSample, ScanNmap, []string{
+			"192.168.1.10; import_nmap; mac=aa:bb:cc:00:11:22; vendor=Acme; hostname=nas.lan; os_guess=Linux 6.x; discovered_at=2024-03-15T10:07:30Z; 22/tcp ssh OpenSSH 9.6; 161/udp snmp",
+			"fe80::1; import_nmap; scope=link; discovered_at=now",
+		}},
+		{"masscan", "auto", masscanSample, ScanMasscan, []string{
+			"10.0.0.5; import_masscan; discovered_at=2024-03-15T10:07:30Z; 80/tcp http nginx; 443/tcp",
+			"10.0.0.6; import_masscan; discovered_at=2024-03-15T10:07:32Z",
+		}},
+		{"masscan while running", ScanMasscan, masscanRunning, ScanMasscan, []string{
+			"10.0.0.7; import_masscan; discovered_at=2024-03-15T10:07:30Z; 21/tcp; 25/tcp",
+		}},
+		{"arp-scan", "", arpScanSample, ScanArpScan, []string{
+			"192.168.1.1; import_arp_scan; mac=00:1a:2b:3c:4d:5e; vendor=Router Corp; discovered_at=now",
+			"192.168.1.20; import_arp_scan; mac=00:1a:2b:3c:4d:5f; discovered_at=now",
+		}},
+		{"arp-scan with spaces", ScanArpScan, "10.1.1.1 00:1a:2b:3c:4d:60", ScanArpScan, []string{
+			"10.1.1.1; import_arp_scan; mac=00:1a:2b:3c:4d:60; discovered_at=now",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			assets, format, err := ParseScan(tt.format, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("ParseScan: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("format = %q, want %q", format, tt.wantFormat)
+			}
+			got := make([]string, 0, len(assets))
+			for _, asset := range assets {
+				got = append(got, summarize(asset, start))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("assets =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseScanInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{"unknown format", "zmap", "10.0.0.1"},
+		{"broken nmap", ScanNmap, "<nmaprun><host>"},
+		{"broken masscan", ScanMasscan, "[{"},
+		{"no arp-scan results", ScanArpScan, "Starting arp-scan\nEnding arp-scan"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ParseScan(tt.format, []byte(tt.data)); err == nil {
+				t.Errorf("ParseScan(%q) succeeded, want an error", tt.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package modules
+
+import (
+	"net"
+	"sort"
+)
+
+// ============================================================================
+// SITE MAP - Organizational labels for discovered assets
+// ============================================================================
+
+// SiteLabel maps a subnet to the site, zone and criticality pushed by the C2
+type SiteLabel struct {
+	CIDR        string `json:"cidr"`
+	Site        string `json:"site,omitempty"`
+	Zone        string `json:"zone,omitempty"`
+	Criticality string `json:"criticality,omitempty"`
+	network     *net.IPNet
+}
+
+// CompileSiteMap parses each CIDR and orders the labels most specific first
+func CompileSiteMap(labels []SiteLabel) ([]SiteLabel, error) {
+	for i := range labels {
+		_, network, err := net.ParseCIDR(labels[i].CIDR)
+		if err != nil {
+			return nil, err
+		}
+		labels[i].network = network
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		oi, _ := labels[i].network.Mask.Size()
+		oj, _ := labels[j].network.Mask.Size()
+		return oi > oj
+	})
+	return labels, nil
+}
+
+// Contains reports whether ip is inside the label's subnet; the label must
+// have been compiled
+func (l SiteLabel) Contains(ip net.IP) bool {
+	return l.network.Contains(ip)
+}
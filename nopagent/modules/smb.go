@@ -0,0 +1,298 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+	"unicode/utf16"
+)
+
+// ============================================================================
+// SMB - Dialect and NTLM identity of SMB servers
+// ============================================================================
+
+// SMBInfo is what an SMB negotiate and the NTLM challenge of an anonymous
+// session setup reveal; no credentials are sent
+type SMBInfo struct {
+	Dialect         string // "1.0" for SMB1-only servers, else "2.0.2" to "3.1.1"
+	SigningRequired bool
+	ComputerName    string
+	Domain          string
+	DNSComputerName string
+	DNSDomain       string
+	OSVersion       string // e.g. "10.0.19041"
+}
+
+// Fields returns the smb_* asset fields of info
+func (info *SMBInfo) Fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"smb_dialect":          info.Dialect,
+		"smb_signing_required": info.SigningRequired,
+	}
+	for name, value := range map[string]string{
+		"smb_computer_name":     info.ComputerName,
+		"smb_domain":            info.Domain,
+		"smb_dns_computer_name": info.DNSComputerName,
+		"smb_dns_domain":        info.DNSDomain,
+		"smb_os_version":        info.OSVersion,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+var smb2Dialects = map[uint16]string{
+	0x0202: "2.0.2", 0x0210: "2.1", 0x0300: "3.0", 0x0302: "3.0.2", 0x0311: "3.1.1",
+}
+
+var errNotSMB2 = errors.New("not an SMB2 response")
+
+// SMBProbe negotiates SMB2/3 on TCP port 445 and reads the server's NTLM
+// challenge. Servers that do not speak SMB2 are asked for SMB1 instead.
+func SMBProbe(ctx context.Context, ip net.IP, timeout time.Duration) (*SMBInfo, error) {
+	info, err := smb2Probe(ctx, ip, timeout)
+	if errors.Is(err, errNotSMB2) || errors.Is(err, io.EOF) {
+		return smb1Probe(ctx, ip, timeout)
+	}
+	return info, err
+}
+
+func dialSMB(ctx context.Context, ip net.IP, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeoutOrDefault(timeout)}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), "445"))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeoutOrDefault(timeout)))
+	return conn, nil
+}
+
+// smbExchange sends one message with its direct TCP transport header and
+// reads the reply
+func smbExchange(conn net.Conn, msg []byte) ([]byte, error) {
+	frame := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	if _, err := conn.Write(append(frame, msg...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, frame[:4]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(frame[:4]) & 0xffffff
+	if size > 64<<10 {
+		return nil, fmt.Errorf("SMB response of %d bytes", size)
+	}
+	reply := make([]byte, size)
+	_, err := io.ReadFull(conn, reply)
+	return reply, err
+}
+
+func smb2Header(command uint16, messageID uint64) []byte {
+	header := make([]byte, 64)
+	copy(header, "\xfeSMB")
+	binary.LittleEndian.PutUint16(header[4:], 64)
+	binary.LittleEndian.PutUint16(header[12:], command)
+	binary.LittleEndian.PutUint16(header[14:], 31) // credits requested
+	binary.LittleEndian.PutUint64(header[24:], messageID)
+	return header
+}
+
+func smb2Probe(ctx context.Context, ip net.IP, timeout time.Duration) (*SMBInfo, error) {
+	conn, err := dialSMB(ctx, ip, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// NEGOTIATE offering every dialect; 3.1.1 requires a preauth
+	// integrity context
+	dialects := []uint16{0x0202, 0x0210, 0x0300, 0x0302, 0x0311}
+	msg := smb2Header(0, 0)
+	body := make([]byte, 36)
+	binary.LittleEndian.PutUint16(body[0:], 36)
+	binary.LittleEndian.PutUint16(body[2:], uint16(len(dialects)))
+	binary.LittleEndian.PutUint16(body[4:], 1) // signing enabled
+	rand.Read(body[12:28])                     // client GUID
+	for _, dialect := range dialects {
+		body = binary.LittleEndian.AppendUint16(body, dialect)
+	}
+	for (len(msg)+len(body))%8 != 0 {
+		body = append(body, 0)
+	}
+	binary.LittleEndian.PutUint32(body[28:], uint32(len(msg)+len(body)))
+	binary.LittleEndian.PutUint16(body[32:], 1)
+	preauth := make([]byte, 8+38)
+	binary.LittleEndian.PutUint16(preauth[0:], 1) // SMB2_PREAUTH_INTEGRITY_CAPABILITIES
+	binary.LittleEndian.PutUint16(preauth[2:], 38)
+	binary.LittleEndian.PutUint16(preauth[8:], 1)   // one hash algorithm
+	binary.LittleEndian.PutUint16(preauth[10:], 32) // salt length
+	binary.LittleEndian.PutUint16(preauth[12:], 1)  // SHA-512
+	rand.Read(preauth[14:])
+	reply, err := smbExchange(conn, append(append(msg, body...), preauth...))
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) < 64+64 || !bytes.HasPrefix(reply, []byte("\xfeSMB")) {
+		return nil, errNotSMB2
+	}
+	if status := binary.LittleEndian.Uint32(reply[8:]); status != 0 {
+		return nil, fmt.Errorf("SMB2 negotiate failed with status 0x%08x", status)
+	}
+	r := reply[64:]
+	info := &SMBInfo{SigningRequired: binary.LittleEndian.Uint16(r[2:])&0x2 != 0}
+	dialect := binary.LittleEndian.Uint16(r[4:])
+	if info.Dialect = smb2Dialects[dialect]; info.Dialect == "" {
+		info.Dialect = fmt.Sprintf("0x%04x", dialect)
+	}
+
+	// SESSION_SETUP with an NTLM NEGOTIATE; the server answers
+	// STATUS_MORE_PROCESSING_REQUIRED with its CHALLENGE
+	token := spnegoInit(ntlmNegotiate())
+	msg = smb2Header(1, 1)
+	body = make([]byte, 24)
+	binary.LittleEndian.PutUint16(body[0:], 25)
+	body[3] = 1 // signing enabled
+	binary.LittleEndian.PutUint16(body[12:], 64+24)
+	binary.LittleEndian.PutUint16(body[14:], uint16(len(token)))
+	reply, err = smbExchange(conn, append(append(msg, body...), token...))
+	if err != nil || len(reply) < 64+8 {
+		// The dialect alone is still worth reporting
+		return info, nil
+	}
+	if status := binary.LittleEndian.Uint32(reply[8:]); status != 0xc0000016 {
+		return info, nil
+	}
+	r = reply[64:]
+	offset, length := int(binary.LittleEndian.Uint16(r[4:])), int(binary.LittleEndian.Uint16(r[6:]))
+	if offset+length <= len(reply) {
+		parseNTLMChallenge(reply[offset:offset+length], info)
+	}
+	return info, nil
+}
+
+// smb1Probe detects servers that only speak SMB1 (NT LM 0.12)
+func smb1Probe(ctx context.Context, ip net.IP, timeout time.Duration) (*SMBInfo, error) {
+	conn, err := dialSMB(ctx, ip, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msg := make([]byte, 32)
+	copy(msg, "\xffSMB")
+	msg[4] = 0x72                                   // SMB_COM_NEGOTIATE
+	msg[9] = 0x18                                   // canonical paths, case insensitive
+	binary.LittleEndian.PutUint16(msg[10:], 0xc001) // unicode, NT status, long names
+	binary.LittleEndian.PutUint16(msg[26:], 0xfeff) // PID
+	dialects := []byte("\x02NT LM 0.12\x00")
+	msg = append(msg, 0) // no parameter words
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(len(dialects)))
+	reply, err := smbExchange(conn, append(msg, dialects...))
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) < 35 || !bytes.HasPrefix(reply, []byte("\xffSMB")) || reply[4] != 0x72 {
+		return nil, errors.New("not an SMB server")
+	}
+	if status := binary.LittleEndian.Uint32(reply[5:]); status != 0 || binary.LittleEndian.Uint16(reply[33:]) == 0xffff {
+		return nil, errors.New("SMB1 dialect refused")
+	}
+	info := &SMBInfo{Dialect: "1.0"}
+	// SecurityMode: bit 3 is signing required
+	if reply[32] >= 1 && len(reply) > 35 {
+		info.SigningRequired = reply[35]&0x08 != 0
+	}
+	return info, nil
+}
+
+// ntlmNegotiate builds an NTLM NEGOTIATE_MESSAGE asking for target info and
+// the server version
+func ntlmNegotiate() []byte {
+	msg := make([]byte, 40)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	// UNICODE, OEM, REQUEST_TARGET, NTLM, ALWAYS_SIGN, EXTENDED_SESSIONSECURITY,
+	// TARGET_INFO, VERSION, 128, 56
+	binary.LittleEndian.PutUint32(msg[12:], 0xa2888207)
+	return msg
+}
+
+// spnegoInit wraps an NTLM token in an SPNEGO NegTokenInit
+func spnegoInit(token []byte) []byte {
+	der := func(tag byte, content ...[]byte) []byte {
+		body := bytes.Join(content, nil)
+		out := []byte{tag}
+		switch n := len(body); {
+		case n < 0x80:
+			out = append(out, byte(n))
+		case n < 0x100:
+			out = append(out, 0x81, byte(n))
+		default:
+			out = append(out, 0x82, byte(n>>8), byte(n))
+		}
+		return append(out, body...)
+	}
+	spnegoOID := []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	ntlmOID := []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+	return der(0x60, spnegoOID,
+		der(0xa0, der(0x30,
+			der(0xa0, der(0x30, ntlmOID)),
+			der(0xa2, der(0x04, token)))))
+}
+
+// parseNTLMChallenge reads names and version from an NTLM CHALLENGE_MESSAGE,
+// found by its signature inside the (usually SPNEGO wrapped) blob
+func parseNTLMChallenge(blob []byte, info *SMBInfo) {
+	start := bytes.Index(blob, []byte("NTLMSSP\x00"))
+	if start < 0 {
+		return
+	}
+	msg := blob[start:]
+	if len(msg) < 48 || binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return
+	}
+	flags := binary.LittleEndian.Uint32(msg[20:])
+	infoLen, infoOff := int(binary.LittleEndian.Uint16(msg[40:])), int(binary.LittleEndian.Uint32(msg[44:]))
+	if flags&0x02000000 != 0 && len(msg) >= 56 && infoOff >= 56 {
+		build := binary.LittleEndian.Uint16(msg[50:])
+		info.OSVersion = fmt.Sprintf("%d.%d.%d", msg[48], msg[49], build)
+	}
+	if infoOff+infoLen > len(msg) {
+		return
+	}
+	pairs := msg[infoOff : infoOff+infoLen]
+	for len(pairs) >= 4 {
+		id, size := binary.LittleEndian.Uint16(pairs), int(binary.LittleEndian.Uint16(pairs[2:]))
+		if id == 0 || 4+size > len(pairs) {
+			break
+		}
+		value := decodeUTF16(pairs[4 : 4+size])
+		switch id {
+		case 1:
+			info.ComputerName = value
+		case 2:
+			info.Domain = value
+		case 3:
+			info.DNSComputerName = value
+		case 4:
+			info.DNSDomain = value
+		}
+		pairs = pairs[4+size:]
+	}
+}
+
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,227 @@
+package modules
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// SSDP - UPnP device discovery
+// ============================================================================
+
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// SSDPResponse is one M-SEARCH answer or NOTIFY announcement
+type SSDPResponse struct {
+	IP       string
+	Location string // URL of the device description
+	Server   string
+	ST       string // search target, or the NT of a NOTIFY
+	USN      string
+	ByeBye   bool // the device is leaving the network
+}
+
+// Asset returns the asset record of a response
+func (r SSDPResponse) Asset(method string) map[string]interface{} {
+	asset := map[string]interface{}{
+		"ip":            r.IP,
+		"family":        "ipv4",
+		"status":        "online",
+		"discovered_at": time.Now().UTC().Format(time.RFC3339),
+		"method":        method,
+	}
+	if r.Location != "" {
+		asset["ssdp_location"] = r.Location
+	}
+	if r.Server != "" {
+		asset["ssdp_server"] = r.Server
+	}
+	return asset
+}
+
+// SSDPSearch multicasts an M-SEARCH for all devices and collects the answers
+// until wait has passed, one per device IP (the root device's if it answered)
+func SSDPSearch(ctx context.Context, wait time.Duration) ([]SSDPResponse, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	mx := int(wait / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
+	request := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\n"+
+		"MAN: \"ssdp:discover\"\r\nMX: %d\r\nST: ssdp:all\r\n\r\n", mx)
+	if _, err := conn.WriteToUDP([]byte(request), ssdpGroup); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+	byIP := make(map[string]SSDPResponse)
+	order := make([]string, 0)
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		resp, ok := parseSSDP(buf[:n], from.IP)
+		if !ok {
+			continue
+		}
+		previous, seen := byIP[resp.IP]
+		if !seen {
+			order = append(order, resp.IP)
+		}
+		if !seen || previous.Location == "" || resp.ST == "upnp:rootdevice" {
+			byIP[resp.IP] = resp
+		}
+	}
+	responses := make([]SSDPResponse, 0, len(order))
+	for _, ip := range order {
+		responses = append(responses, byIP[ip])
+	}
+	return responses, nil
+}
+
+// ListenSSDP joins the SSDP multicast group and passes each NOTIFY to found
+// until ctx is cancelled
+func ListenSSDP(ctx context.Context, found func(SSDPResponse)) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ssdpGroup)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !bytes.HasPrefix(buf[:n], []byte("NOTIFY ")) {
+			continue
+		}
+		if resp, ok := parseSSDP(buf[:n], from.IP); ok {
+			found(resp)
+		}
+	}
+}
+
+// parseSSDP reads the headers of an SSDP message; the first line is either
+// a response status or a NOTIFY request line
+func parseSSDP(data []byte, from net.IP) (SSDPResponse, bool) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	first, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(first, "HTTP/1.1 200") && !strings.HasPrefix(first, "NOTIFY ") {
+		return SSDPResponse{}, false
+	}
+	resp := SSDPResponse{IP: from.String()}
+	for {
+		line, err := reader.ReadString('\n')
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok {
+			value = strings.TrimSpace(value)
+			switch strings.ToUpper(strings.TrimSpace(name)) {
+			case "LOCATION":
+				resp.Location = value
+			case "SERVER":
+				resp.Server = value
+			case "ST", "NT":
+				resp.ST = value
+			case "USN":
+				resp.USN = value
+			case "NTS":
+				resp.ByeBye = value == "ssdp:byebye"
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return resp, true
+}
+
+// upnpDescription is the part of a UPnP device description kept in assets
+type upnpDescription struct {
+	Device struct {
+		DeviceType       string `xml:"deviceType"`
+		FriendlyName     string `xml:"friendlyName"`
+		Manufacturer     string `xml:"manufacturer"`
+		ModelName        string `xml:"modelName"`
+		ModelNumber      string `xml:"modelNumber"`
+		SerialNumber     string `xml:"serialNumber"`
+		UDN              string `xml:"UDN"`
+		PresentationURL  string `xml:"presentationURL"`
+		ModelDescription string `xml:"modelDescription"`
+	} `xml:"device"`
+}
+
+// FetchUPnPDescription fetches the device description at location, which
+// must be served by ip itself, and returns its fields as upnp_* asset
+// fields
+func FetchUPnPDescription(ctx context.Context, ip, location string, timeout time.Duration) (map[string]interface{}, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported description URL %q", location)
+	}
+	if u.Hostname() != ip {
+		return nil, fmt.Errorf("description URL %q is not on %s", location, ip)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeoutOrDefault(timeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("description request answered %s", resp.Status)
+	}
+	var desc upnpDescription
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 256<<10)).Decode(&desc); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	for name, value := range map[string]string{
+		"upnp_device_type":       desc.Device.DeviceType,
+		"upnp_friendly_name":     desc.Device.FriendlyName,
+		"upnp_manufacturer":      desc.Device.Manufacturer,
+		"upnp_model_name":        desc.Device.ModelName,
+		"upnp_model_number":      desc.Device.ModelNumber,
+		"upnp_model_description": desc.Device.ModelDescription,
+		"upnp_serial_number":     desc.Device.SerialNumber,
+		"upnp_udn":               desc.Device.UDN,
+		"upnp_presentation_url":  desc.Device.PresentationURL,
+	} {
+		if value = strings.TrimSpace(value); value != "" {
+			fields[name] = value
+		}
+	}
+	return fields, nil
+}
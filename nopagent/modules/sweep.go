@@ -0,0 +1,304 @@
+package modules
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// ============================================================================
+// PING SWEEP - Active liveness probing of IPv4 subnets
+// ============================================================================
+
+// SweepOptions tunes a ping sweep
+type SweepOptions struct {
+	Workers  int           // probes in flight
+	Rate     int           // probes started per second, 0 for no limit
+	Timeout  time.Duration // wait for each reply
+	TCPPorts []int         // connect probes used when no ICMP socket can be opened
+}
+
+// SweepHosts expands subnets into the IPv4 host addresses to probe, without
+// network and broadcast addresses, addresses in exclude, duplicates or more
+// than max entries
+func SweepHosts(subnets, exclude []*net.IPNet, max int) []net.IP {
+	hosts := make([]net.IP, 0)
+	seen := make(map[uint32]bool)
+	for _, subnet := range subnets {
+		base := subnet.IP.To4()
+		ones, bits := subnet.Mask.Size()
+		if base == nil || bits != 32 {
+			continue
+		}
+		first := binary.BigEndian.Uint32(base.Mask(subnet.Mask))
+		last := first | (1<<(32-ones) - 1)
+		if ones < 31 {
+			first, last = first+1, last-1
+		}
+		for n := first; n <= last && n >= first; n++ {
+			if seen[n] {
+				continue
+			}
+			if end, excluded := excludedUntil(n, exclude); excluded {
+				// Skip the whole excluded range at once
+				n = end
+				continue
+			}
+			seen[n] = true
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, n)
+			if len(hosts) >= max {
+				return hosts
+			}
+			hosts = append(hosts, ip)
+		}
+	}
+	return hosts
+}
+
+// excludedUntil returns the last address of the exclusion holding n
+func excludedUntil(n uint32, exclude []*net.IPNet) (uint32, bool) {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	for _, network := range exclude {
+		if base := network.IP.To4(); base != nil && network.Contains(ip) {
+			ones, _ := network.Mask.Size()
+			return binary.BigEndian.Uint32(base.Mask(network.Mask)) | (1<<(32-ones) - 1), true
+		}
+	}
+	return 0, false
+}
+
+// PingSweep probes targets with ICMP echo and returns an asset for every host
+// that answered. Raw ICMP needs root or CAP_NET_RAW (administrator on
+// Windows); unprivileged agents use datagram ICMP sockets where the kernel
+// allows them, and otherwise TCP connects to opts.TCPPorts, where a refused
+// connection proves the host is up as well as an accepted one.
+func PingSweep(ctx context.Context, targets []net.IP, opts SweepOptions) []map[string]interface{} {
+	probe := func(ctx context.Context, ip net.IP, seq int) (time.Duration, bool) {
+		return tcpPing(ctx, ip, opts.TCPPorts, opts.Timeout)
+	}
+	method := "tcp_sweep"
+	if p, err := openPinger(opts.Timeout); err == nil {
+		defer p.close()
+		probe, method = p.ping, "icmp_sweep"
+	} else if len(opts.TCPPorts) == 0 {
+		return nil
+	}
+
+	var mutex sync.Mutex
+	assets := make([]map[string]interface{}, 0)
+	runProbes(ctx, len(targets), opts.Workers, opts.Rate, func(i int) {
+		rtt, alive := probe(ctx, targets[i], i)
+		if !alive {
+			return
+		}
+		mutex.Lock()
+		assets = append(assets, map[string]interface{}{
+			"ip":            targets[i].String(),
+			"family":        "ipv4",
+			"status":        "online",
+			"discovered_at": time.Now().UTC().Format(time.RFC3339),
+			"method":        method,
+			"rtt_ms":        float64(rtt.Microseconds()) / 1000,
+		})
+		mutex.Unlock()
+	})
+	return assets
+}
+
+// runProbes calls probe for 0..n-1 from a pool of workers, starting at most
+// rate probes per second (0 for no limit), until done or ctx is cancelled
+func runProbes(ctx context.Context, n, workers, rate int, probe func(i int)) {
+	if workers <= 0 {
+		workers = 1
+	}
+	var limit <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		limit = ticker.C
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				probe(i)
+			}
+		}()
+	}
+feed:
+	for i := 0; i < n; i++ {
+		if limit != nil {
+			select {
+			case <-limit:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// pinger sends echo requests over one ICMP socket and hands each reply to
+// the probe waiting for its sequence number
+type pinger struct {
+	conn    *icmp.PacketConn
+	raw     bool
+	id      int
+	timeout time.Duration
+	mutex   sync.Mutex
+	waiting map[int]chan struct{}
+	peers   map[int]string
+}
+
+func openPinger(timeout time.Duration) (*pinger, error) {
+	var err error
+	for _, network := range []string{"ip4:icmp", "udp4"} {
+		var conn *icmp.PacketConn
+		if conn, err = icmp.ListenPacket(network, "0.0.0.0"); err == nil {
+			p := &pinger{
+				conn:    conn,
+				raw:     network == "ip4:icmp",
+				id:      os.Getpid() & 0xffff,
+				timeout: timeoutOrDefault(timeout),
+				waiting: make(map[int]chan struct{}),
+				peers:   make(map[int]string),
+			}
+			go p.receive()
+			return p, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *pinger) close() {
+	p.conn.Close()
+}
+
+// ping sends one echo request; seq only has to be unique among the probes
+// in flight
+func (p *pinger) ping(ctx context.Context, ip net.IP, seq int) (time.Duration, bool) {
+	seq &= 0xffff
+	reply := make(chan struct{}, 1)
+	p.mutex.Lock()
+	p.waiting[seq], p.peers[seq] = reply, ip.String()
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.waiting, seq)
+		delete(p.peers, seq)
+		p.mutex.Unlock()
+	}()
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: p.id, Seq: seq, Data: []byte("NOP sweep")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, false
+	}
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	if p.raw {
+		dst = &net.IPAddr{IP: ip}
+	}
+	started := time.Now()
+	if _, err := p.conn.WriteTo(data, dst); err != nil {
+		return 0, false
+	}
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case <-reply:
+		return time.Since(started), true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return 0, false
+}
+
+// receive dispatches echo replies until the socket is closed. Datagram
+// sockets rewrite the echo ID, so only raw sockets check it.
+func (p *pinger) receive() {
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil || msg.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || p.raw && echo.ID != p.id {
+			continue
+		}
+		host, _, err := net.SplitHostPort(peer.String())
+		if err != nil {
+			host = peer.String()
+		}
+		p.mutex.Lock()
+		if reply, ok := p.waiting[echo.Seq]; ok && p.peers[echo.Seq] == host {
+			select {
+			case reply <- struct{}{}:
+			default:
+			}
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// tcpPing connects to each port in turn until one shows the host is up
+func tcpPing(ctx context.Context, ip net.IP, ports []int, timeout time.Duration) (time.Duration, bool) {
+	dialer := net.Dialer{Timeout: timeoutOrDefault(timeout)}
+	for _, port := range ports {
+		started := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp4", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		if err == nil {
+			conn.Close()
+			return time.Since(started), true
+		}
+		if connRefused(err) {
+			return time.Since(started), true
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return 0, false
+}
+
+// connRefused reports a TCP reset. Windows returns WSAECONNREFUSED (10061)
+// rather than ECONNREFUSED.
+func connRefused(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && (errno == syscall.ECONNREFUSED || errno == 10061)
+}
+
+func timeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return time.Second
+}
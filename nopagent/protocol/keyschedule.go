@@ -0,0 +1,36 @@
+package protocol
+
+// Key schedule shared by the agent and the C2. Info strings are those of
+// the crypto package; all derivations are HKDF-SHA256.
+//
+//	master   = PBKDF2-SHA256 or Argon2id(EncryptionKey, KDF salt), as
+//	           reported in registration "kdf"
+//	session  = HKDF(ECDH(agent, C2), agent nonce, "nop-agent session v1")
+//	           after key_exchange; otherwise the master key is the wire key
+//	rekeyed  = HKDF(ECDH(agent, C2) || current, C2 nonce, "nop-agent rekey v1")
+//	storage  = HKDF(master, nil, "nop-agent storage v1"), never on the wire
+//
+// Under KeyScheduleShared both directions encrypt with the wire key itself
+// and random 12-byte nonces; the "nop-agent>c2" and "nop-c2>agent" AAD
+// labels only stop reflected frames. Under KeyScheduleDirectional each
+// direction gets its own key and 4-byte nonce prefix:
+//
+//	agent>c2 key = HKDF(wire, nil, "nop-agent key agent>c2 v1")
+//	c2>agent key = HKDF(wire, nil, "nop-agent key c2>agent v1")
+//	agent>c2 iv  = HKDF(wire, nil, "nop-agent iv agent>c2 v1")[:4]
+//	c2>agent iv  = HKDF(wire, nil, "nop-agent iv c2>agent v1")[:4]
+//
+// and a frame's nonce is its direction's prefix followed by the 8-byte
+// replay counter of the frame header (random bytes without replay
+// protection). The two sides never share a key, so their nonces cannot
+// collide, and within a direction the counter only increases. Per-message
+// keys are derived from the direction key. Frame layout is the same under
+// both schedules, nonce included, so receivers need not rebuild it.
+//
+// The agent offers its schedules at registration in "key_schedules" and the
+// C2 picks one in "registered"; every wire key (static, session, rekeyed)
+// is split the same way until the next connection.
+const (
+	KeyScheduleShared      = "shared"
+	KeyScheduleDirectional = "directional-v1"
+)
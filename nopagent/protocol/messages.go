@@ -0,0 +1,114 @@
+// Package protocol defines the messages the agent and the C2 exchange and
+// the helpers every layer uses to classify them.
+package protocol
+
+type Message struct {
+	Type       string                 `json:"type"`
+	AgentID    string                 `json:"agent_id,omitempty"`
+	AgentName  string                 `json:"agent_name,omitempty"`
+	Encrypted  bool                   `json:"encrypted,omitempty"`
+	Data       interface{}            `json:"data,omitempty"`
+	Timestamp  string                 `json:"timestamp,omitempty"`
+	Message    string                 `json:"message,omitempty"`
+	SystemInfo map[string]interface{} `json:"system_info,omitempty"`
+	Monitoring string                 `json:"monitoring,omitempty"`
+}
+
+type AssetData struct {
+	Type       string                   `json:"type"`
+	AgentID    string                   `json:"agent_id"`
+	Assets     []map[string]interface{} `json:"assets"`
+	Timestamp  string                   `json:"timestamp"`
+	Seq        uint64                   `json:"seq,omitempty"`
+	Monitoring string                   `json:"monitoring,omitempty"`
+}
+
+type TrafficData struct {
+	Type       string                 `json:"type"`
+	AgentID    string                 `json:"agent_id"`
+	Traffic    map[string]interface{} `json:"traffic"`
+	Timestamp  string                 `json:"timestamp"`
+	Seq        uint64                 `json:"seq,omitempty"`
+	Monitoring string                 `json:"monitoring,omitempty"`
+}
+
+type HostData struct {
+	Type       string                 `json:"type"`
+	AgentID    string                 `json:"agent_id"`
+	Host       map[string]interface{} `json:"host"`
+	Timestamp  string                 `json:"timestamp"`
+	Seq        uint64                 `json:"seq,omitempty"`
+	Monitoring string                 `json:"monitoring,omitempty"`
+}
+
+type NetworkChangeData struct {
+	Type       string        `json:"type"`
+	AgentID    string        `json:"agent_id"`
+	Previous   *NetworkState `json:"previous"`
+	Current    *NetworkState `json:"current"`
+	Changes    []string      `json:"changes"`
+	Timestamp  string        `json:"timestamp"`
+	Seq        uint64        `json:"seq,omitempty"`
+	Monitoring string        `json:"monitoring,omitempty"`
+}
+
+// NetworkState identifies the network an endpoint is attached to
+type NetworkState struct {
+	Gateway   string   `json:"gateway"`
+	SSID      string   `json:"ssid,omitempty"`
+	Addresses []string `json:"addresses"`
+}
+
+type AggregatedEvent struct {
+	Type       string      `json:"type"`
+	AgentID    string      `json:"agent_id"`
+	Event      interface{} `json:"event"`
+	Count      int         `json:"count"`
+	FirstSeen  string      `json:"first_seen"`
+	LastSeen   string      `json:"last_seen"`
+	Timestamp  string      `json:"timestamp"`
+	Seq        uint64      `json:"seq,omitempty"`
+	Monitoring string      `json:"monitoring,omitempty"`
+}
+
+// Type returns the "type" field of any outbound message
+func Type(data interface{}) string {
+	switch m := data.(type) {
+	case AssetData:
+		return m.Type
+	case TrafficData:
+		return m.Type
+	case HostData:
+		return m.Type
+	case NetworkChangeData:
+		return m.Type
+	case AggregatedEvent:
+		return m.Type
+	case Message:
+		return m.Type
+	case map[string]interface{}:
+		t, _ := m["type"].(string)
+		return t
+	}
+	return ""
+}
+
+// Seq returns the sequence number stamped for acknowledgement, if any
+func Seq(data interface{}) uint64 {
+	switch m := data.(type) {
+	case AssetData:
+		return m.Seq
+	case TrafficData:
+		return m.Seq
+	case HostData:
+		return m.Seq
+	case NetworkChangeData:
+		return m.Seq
+	case AggregatedEvent:
+		return m.Seq
+	case map[string]interface{}:
+		seq, _ := m["seq"].(uint64)
+		return seq
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+// Package pty runs interactive shells attached to a pseudo-terminal: a
+// /dev/ptmx pair on Linux and macOS, ConPTY on Windows 10 1809 and later.
+// Where neither is available Start returns ErrUnsupported and callers can
+// fall back to Pipe, which gives line-oriented shells without job control.
+package pty
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// ErrUnsupported means this platform has no pseudo-terminal support
+var ErrUnsupported = errors.New("pseudo-terminals are not supported on this platform")
+
+// Terminal is a running shell
+type Terminal interface {
+	// Read returns the shell's output; it fails once the shell has exited
+	io.Reader
+	// Write sends keystrokes to the shell
+	io.Writer
+	// Resize sets the terminal size in character cells
+	Resize(cols, rows int) error
+	Pid() int
+	// Wait blocks until the shell exits and returns its exit code
+	Wait() (int, error)
+	// Close ends the shell and releases the terminal
+	Close() error
+}
+
+// Start runs argv attached to a new pseudo-terminal of cols x rows. env and
+// dir are as for exec.Cmd.
+func Start(argv, env []string, dir string, cols, rows int) (Terminal, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("no shell given")
+	}
+	return start(argv, env, dir, cols, rows)
+}
+
+// pipeTerminal joins stdout and stderr of a shell on plain pipes
+type pipeTerminal struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	output *io.PipeReader
+	once   sync.Once
+	code   int
+	err    error
+}
+
+// Pipe runs argv with pipes instead of a terminal. Output is read in the
+// order the shell writes it, stdout and stderr interleaved.
+func Pipe(argv, env []string, dir string) (Terminal, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("no shell given")
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env, cmd.Dir = env, dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	cmd.Stdout, cmd.Stderr = writer, writer
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	t := &pipeTerminal{cmd: cmd, stdin: stdin, output: reader}
+	go func() {
+		t.wait()
+		writer.CloseWithError(io.EOF)
+	}()
+	return t, nil
+}
+
+func (t *pipeTerminal) wait() {
+	t.once.Do(func() {
+		t.err = t.cmd.Wait()
+		t.code = t.cmd.ProcessState.ExitCode()
+		if _, exited := t.err.(*exec.ExitError); exited {
+			t.err = nil
+		}
+	})
+}
+
+func (t *pipeTerminal) Read(p []byte) (int, error)  { return t.output.Read(p) }
+func (t *pipeTerminal) Write(p []byte) (int, error) { return t.stdin.Write(p) }
+func (t *pipeTerminal) Resize(cols, rows int) error { return nil }
+func (t *pipeTerminal) Pid() int                    { return t.cmd.Process.Pid }
+
+func (t *pipeTerminal) Wait() (int, error) {
+	t.wait()
+	return t.code, t.err
+}
+
+func (t *pipeTerminal) Close() error {
+	t.stdin.Close()
+	t.cmd.Process.Kill()
+	return nil
+}
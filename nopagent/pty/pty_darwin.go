@@ -0,0 +1,41 @@
+package pty
+
+import (
+	"bytes"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// open allocates a pair from /dev/ptmx: grant and unlock the slave, then
+// ask for its name
+func open() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetInt(fd, unix.TIOCPTYGRANT, 0); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := unix.IoctlSetInt(fd, unix.TIOCPTYUNLK, 0); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	name := make([]byte, 128)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCPTYGNAME), uintptr(unsafe.Pointer(&name[0]))); errno != 0 {
+		master.Close()
+		return nil, nil, errno
+	}
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	slave, err = os.OpenFile(string(name), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package pty
+
+func start(argv, env []string, dir string, cols, rows int) (Terminal, error) {
+	return nil, ErrUnsupported
+}
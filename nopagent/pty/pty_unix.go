@@ -0,0 +1,78 @@
+//go:build linux || darwin
+
+package pty
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// unixTerminal is a shell whose controlling terminal is the slave side of
+// master
+type unixTerminal struct {
+	cmd    *exec.Cmd
+	master *os.File
+	once   sync.Once
+	code   int
+	err    error
+}
+
+func start(argv, env []string, dir string, cols, rows int) (Terminal, error) {
+	master, slave, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer slave.Close()
+
+	t := &unixTerminal{master: master}
+	if err := t.Resize(cols, rows); err != nil {
+		master.Close()
+		return nil, err
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env, cmd.Dir = env, dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	// A new session with the slave as controlling terminal, so the shell
+	// gets job control and Ctrl-C reaches its foreground process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	t.cmd = cmd
+	return t, nil
+}
+
+func (t *unixTerminal) Read(p []byte) (int, error)  { return t.master.Read(p) }
+func (t *unixTerminal) Write(p []byte) (int, error) { return t.master.Write(p) }
+func (t *unixTerminal) Pid() int                    { return t.cmd.Process.Pid }
+
+func (t *unixTerminal) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return nil
+	}
+	return unix.IoctlSetWinsize(int(t.master.Fd()), unix.TIOCSWINSZ,
+		&unix.Winsize{Col: uint16(cols), Row: uint16(rows)})
+}
+
+func (t *unixTerminal) Wait() (int, error) {
+	t.once.Do(func() {
+		t.err = t.cmd.Wait()
+		t.code = t.cmd.ProcessState.ExitCode()
+		if _, exited := t.err.(*exec.ExitError); exited {
+			t.err = nil
+		}
+	})
+	return t.code, t.err
+}
+
+// Close hangs up the whole session, as closing a terminal window would
+func (t *unixTerminal) Close() error {
+	unix.Kill(-t.cmd.Process.Pid, unix.SIGHUP)
+	t.cmd.Process.Kill()
+	return t.master.Close()
+}
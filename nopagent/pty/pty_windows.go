@@ -0,0 +1,164 @@
+package pty
+
+import (
+	"os"
+	"sync"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// conPTY is a shell attached to a Windows pseudo console. The console owns
+// the far ends of two pipes; the agent writes keystrokes to input and reads
+// rendered output from output.
+type conPTY struct {
+	console windows.Handle
+	process windows.Handle
+	pid     int
+	input   *os.File
+	output  *os.File
+	once    sync.Once
+	code    int
+	err     error
+	close   sync.Once
+	closed  sync.Once
+}
+
+func start(argv, env []string, dir string, cols, rows int) (Terminal, error) {
+	if err := windows.NewLazySystemDLL("kernel32.dll").NewProc("CreatePseudoConsole").Find(); err != nil {
+		return nil, ErrUnsupported
+	}
+
+	var inRead, inWrite, outRead, outWrite windows.Handle
+	if err := windows.CreatePipe(&inRead, &inWrite, nil, 0); err != nil {
+		return nil, err
+	}
+	if err := windows.CreatePipe(&outRead, &outWrite, nil, 0); err != nil {
+		windows.CloseHandle(inRead)
+		windows.CloseHandle(inWrite)
+		return nil, err
+	}
+	var console windows.Handle
+	err := windows.CreatePseudoConsole(coord(cols, rows), inRead, outWrite, 0, &console)
+	// The console has its own references to the far ends
+	windows.CloseHandle(inRead)
+	windows.CloseHandle(outWrite)
+	if err != nil {
+		windows.CloseHandle(inWrite)
+		windows.CloseHandle(outRead)
+		return nil, err
+	}
+	t := &conPTY{
+		console: console,
+		input:   os.NewFile(uintptr(inWrite), "conpty-input"),
+		output:  os.NewFile(uintptr(outRead), "conpty-output"),
+	}
+
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		t.release()
+		return nil, err
+	}
+	defer attrs.Delete()
+	// The attribute value is the console handle itself, not a pointer to it
+	if err := attrs.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE,
+		*(*unsafe.Pointer)(unsafe.Pointer(&console)), unsafe.Sizeof(console)); err != nil {
+		t.release()
+		return nil, err
+	}
+
+	si := &windows.StartupInfoEx{ProcThreadAttributeList: attrs.List()}
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	// No standard handles, or the shell would inherit the agent's
+	si.Flags = windows.STARTF_USESTDHANDLES
+
+	cmdline, err := windows.UTF16PtrFromString(windows.ComposeCommandLine(argv))
+	if err != nil {
+		t.release()
+		return nil, err
+	}
+	var dirPtr *uint16
+	if dir != "" {
+		if dirPtr, err = windows.UTF16PtrFromString(dir); err != nil {
+			t.release()
+			return nil, err
+		}
+	}
+	var pi windows.ProcessInformation
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT)
+	if err := windows.CreateProcess(nil, cmdline, nil, nil, false, flags, environmentBlock(env), dirPtr, &si.StartupInfo, &pi); err != nil {
+		t.release()
+		return nil, err
+	}
+	windows.CloseHandle(pi.Thread)
+	t.process, t.pid = pi.Process, int(pi.ProcessId)
+	return t, nil
+}
+
+func coord(cols, rows int) windows.Coord {
+	if cols <= 0 || rows <= 0 {
+		cols, rows = 80, 24
+	}
+	return windows.Coord{X: int16(cols), Y: int16(rows)}
+}
+
+// environmentBlock encodes env as NUL-separated UTF-16 ending in a double
+// NUL; nil inherits the agent's environment
+func environmentBlock(env []string) *uint16 {
+	if env == nil {
+		return nil
+	}
+	block := make([]uint16, 0, 1024)
+	for _, kv := range env {
+		block = append(block, utf16.Encode([]rune(kv))...)
+		block = append(block, 0)
+	}
+	block = append(block, 0)
+	return &block[0]
+}
+
+func (t *conPTY) Read(p []byte) (int, error)  { return t.output.Read(p) }
+func (t *conPTY) Write(p []byte) (int, error) { return t.input.Write(p) }
+func (t *conPTY) Pid() int                    { return t.pid }
+
+func (t *conPTY) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return nil
+	}
+	return windows.ResizePseudoConsole(t.console, coord(cols, rows))
+}
+
+func (t *conPTY) Wait() (int, error) {
+	t.once.Do(func() {
+		if _, err := windows.WaitForSingleObject(t.process, windows.INFINITE); err != nil {
+			t.code, t.err = -1, err
+			return
+		}
+		var code uint32
+		t.err = windows.GetExitCodeProcess(t.process, &code)
+		t.code = int(code)
+		// Closing the console flushes it and ends pending output reads
+		t.release()
+	})
+	return t.code, t.err
+}
+
+// Close ends the shell if it is still running; it must be called once the
+// terminal is no longer used, after Wait too
+func (t *conPTY) Close() error {
+	t.closed.Do(func() {
+		windows.TerminateProcess(t.process, 1)
+		windows.CloseHandle(t.process)
+	})
+	t.release()
+	return nil
+}
+
+func (t *conPTY) release() {
+	t.close.Do(func() {
+		windows.ClosePseudoConsole(t.console)
+		t.input.Close()
+		t.output.Close()
+	})
+}
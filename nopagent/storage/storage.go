@@ -0,0 +1,226 @@
+// Package storage seals everything the agent keeps on disk: state files
+// are written whole, logs and spools as appended records. Data is encrypted
+// with AES-256-GCM under a key derived from the agent's master key, and each
+// file's purpose is bound in as associated data so, e.g., a spooled report
+// cannot be passed off as the stored identity.
+package storage
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+)
+
+// MaxRecordBytes bounds one record of a sealed log
+const MaxRecordBytes = 16 << 20
+
+// ErrLogFull is returned by Append once a log has reached its size limit
+var ErrLogFull = errors.New("sealed log reached its size limit")
+
+// Store seals and opens local files
+type Store struct {
+	aead cipher.AEAD
+}
+
+// New derives the storage key from the agent's master key:
+// HKDF-SHA256(master, nil, crypto.StorageKeyInfo)
+func New(masterKey []byte) (*Store, error) {
+	key, err := crypto.DeriveKey(masterKey, nil, crypto.StorageKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := crypto.NewGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{aead: aead}, nil
+}
+
+func aad(purpose string) []byte {
+	return []byte("nop-agent storage:" + purpose)
+}
+
+// Seal encrypts plaintext for purpose, returning nonce || ciphertext
+func (s *Store) Seal(purpose string, plaintext []byte) ([]byte, error) {
+	return crypto.Seal(crypto.DefaultSuite, s.aead, nil, plaintext, aad(purpose), false)
+}
+
+// Open reverses Seal; it fails if the data was sealed for another purpose
+func (s *Store) Open(purpose string, data []byte) ([]byte, error) {
+	return crypto.Open(crypto.DefaultSuite, s.aead, nil, data, aad(purpose), false)
+}
+
+// WriteFile seals data to path, replacing it atomically so a crash never
+// leaves a truncated file. Missing directories are created private.
+func (s *Store) WriteFile(path, purpose string, data []byte) error {
+	sealed, err := s.Seal(purpose, data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadFile opens a file written by WriteFile
+func (s *Store) ReadFile(path, purpose string) ([]byte, error) {
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.Open(purpose, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%s cannot be decrypted: %v", path, err)
+	}
+	return data, nil
+}
+
+// WriteJSON seals the JSON encoding of v to path
+func (s *Store) WriteJSON(path, purpose string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.WriteFile(path, purpose, data)
+}
+
+// ReadJSON opens a file written by WriteJSON into v
+func (s *Store) ReadJSON(path, purpose string, v interface{}) error {
+	data, err := s.ReadFile(path, purpose)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Log is an append-only file of sealed records, each prefixed with its
+// length as a big-endian uint32. It implements io.Writer so it can back the
+// standard logger; every Write becomes one record.
+type Log struct {
+	store   *Store
+	purpose string
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64
+	limit   int64
+}
+
+// OpenLog opens or creates the log at path. limit caps the file size; zero
+// means no limit.
+func (s *Store) OpenLog(path, purpose string, limit int64) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Log{store: s, purpose: purpose, file: file, size: info.Size(), limit: limit}, nil
+}
+
+// Append seals record and adds it to the log
+func (l *Log) Append(record []byte) error {
+	sealed, err := l.store.Seal(l.purpose, record)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(sealed))
+	binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+	copy(frame[4:], sealed)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	if l.limit > 0 && l.size+int64(len(frame)) > l.limit {
+		return ErrLogFull
+	}
+	n, err := l.file.Write(frame)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Log) Write(p []byte) (int, error) {
+	if err := l.Append(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// ReadLog calls fn with each record of the log at path, in order. A
+// truncated final record, as left by a crash mid-write, ends the log.
+func (s *Store) ReadLog(path, purpose string, fn func(record []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for n := 1; ; n++ {
+		var prefix [4]byte
+		if _, err := io.ReadFull(reader, prefix[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("truncated log after %d records", n-1)
+		}
+		size := binary.BigEndian.Uint32(prefix[:])
+		if size > MaxRecordBytes {
+			return fmt.Errorf("record %d of %d bytes exceeds limit", n, size)
+		}
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(reader, sealed); err != nil {
+			return fmt.Errorf("truncated log after %d records", n-1)
+		}
+		record, err := s.Open(purpose, sealed)
+		if err != nil {
+			return fmt.Errorf("record %d cannot be decrypted: %v", n, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var testMaster = bytes.Repeat([]byte{0x24}, 32)
+
+func newTestStore(t *testing.T, master []byte) *Store {
+	t.Helper()
+	store, err := New(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSealOpen(t *testing.T) {
+	store := newTestStore(t, testMaster)
+	sealed, err := store.Seal("state", []byte("secret state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret state")) {
+		t.Fatal("sealed data contains the plaintext")
+	}
+	again, _ := store.Seal("state", []byte("secret state"))
+	if bytes.Equal(sealed, again) {
+		t.Error("two seals of the same data are identical")
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name    string
+		store   *Store
+		purpose string
+		data    []byte
+		ok      bool
+	}{
+		{"same purpose", store, "state", sealed, true},
+		{"other purpose", store, "spool", sealed, false},
+		{"other master key", newTestStore(t, bytes.Repeat([]byte{0x25}, 32)), "state", sealed, false},
+		{"tampered", store, "state", tampered, false},
+		{"truncated", store, "state", sealed[:8], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := tt.store.Open(tt.purpose, tt.data)
+			if ok := err == nil && string(plaintext) == "secret state"; ok != tt.ok {
+				t.Errorf("Open = %q, %v, want ok %v", plaintext, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	store := newTestStore(t, testMaster)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "agent.json")
+
+	want := map[string]string{"agent_id": "a1"}
+	if err := store.WriteJSON(path, "identity", want); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("a1")) {
+		t.Error("file on disk contains the plaintext")
+	}
+	var got map[string]string
+	if err := store.ReadJSON(path, "identity", &got); err != nil || got["agent_id"] != "a1" {
+		t.Errorf("ReadJSON = %v, %v", got, err)
+	}
+	if err := store.ReadJSON(path, "spool", &got); err == nil {
+		t.Error("file opened under another purpose")
+	}
+
+	// Replacing leaves only the file itself, private, and no temporaries
+	if err := store.WriteFile(path, "identity", []byte(`{"agent_id":"a2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "agent.json" {
+		t.Errorf("directory holds %v, want only agent.json", entries)
+	}
+	if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		t.Errorf("file mode = %v, want private", info.Mode().Perm())
+	}
+}
+
+func TestLog(t *testing.T) {
+	store := newTestStore(t, testMaster)
+	path := filepath.Join(t.TempDir(), "logs", "agent.log")
+	records := []string{"first", "", "third record"}
+
+	log, err := store.OpenLog(path, "log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records[:2] {
+		if err := log.Append([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+	if err := log.Append([]byte("late")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Append after Close = %v", err)
+	}
+
+	// Reopening appends after the existing records
+	log, err = store.OpenLog(path, "log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := log.Write([]byte(records[2])); err != nil || n != len(records[2]) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	log.Close()
+
+	var got []string
+	collect := func(record []byte) error {
+		got = append(got, string(record))
+		return nil
+	}
+	if err := store.ReadLog(path, "log", collect); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != strings.Join(records, "|") {
+		t.Errorf("ReadLog = %q, want %q", got, records)
+	}
+
+	got = nil
+	if err := store.ReadLog(path, "output", collect); err == nil || len(got) != 0 {
+		t.Errorf("log read under another purpose: %q, %v", got, err)
+	}
+
+	// A record cut short by a crash keeps the records before it
+	raw, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, raw[:len(raw)-3], 0600); err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	err = store.ReadLog(path, "log", collect)
+	if err == nil || !strings.Contains(err.Error(), "truncated log after 2 records") {
+		t.Errorf("ReadLog of a truncated log = %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("truncated log gave %d records, want 2", len(got))
+	}
+}
+
+func TestLogLimit(t *testing.T) {
+	store := newTestStore(t, testMaster)
+	path := filepath.Join(t.TempDir(), "spool")
+	log, err := store.OpenLog(path, "spool", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if err := log.Append(make([]byte, 40)); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Append(make([]byte, 40)); !errors.Is(err, ErrLogFull) {
+		t.Errorf("Append past the limit = %v, want ErrLogFull", err)
+	}
+	if info, _ := os.Stat(path); info.Size() > 100 {
+		t.Errorf("log grew to %d bytes past its limit", info.Size())
+	}
+}
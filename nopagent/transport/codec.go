@@ -0,0 +1,202 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ============================================================================
+// CODECS - Message encoding negotiated at registration
+// ============================================================================
+
+// Codec serializes messages on the wire
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Codecs are the codecs the agent can offer, by name
+var Codecs = map[string]Codec{
+	"json":    jsonCodec{},
+	"cbor":    cborCodec{},
+	"msgpack": msgpackCodec{},
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string                               { return "cbor" }
+func (cborCodec) Marshal(v interface{}) ([]byte, error)      { return cbor.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal checks the structure first: the decoder preallocates arrays by
+// their declared length and has no nesting limit
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	if err := checkMsgpack(data); err != nil {
+		return err
+	}
+	return msgpack.Unmarshal(data, v)
+}
+
+// checkMsgpack walks one msgpack value without allocating, rejecting
+// declared lengths the data cannot hold and values nested deeper than
+// MaxMessageDepth
+func checkMsgpack(data []byte) error {
+	pos, nodes := 0, 0
+	remaining := []int{1}
+	length := func(n int) (int, bool) {
+		if pos+n > len(data) {
+			return 0, false
+		}
+		v := 0
+		for _, b := range data[pos : pos+n] {
+			v = v<<8 | int(b)
+		}
+		pos += n
+		return v, v >= 0
+	}
+
+	for len(remaining) > 0 {
+		top := len(remaining) - 1
+		if remaining[top] == 0 {
+			remaining = remaining[:top]
+			continue
+		}
+		remaining[top]--
+		if pos >= len(data) {
+			return Malformed("truncated msgpack value")
+		}
+		if nodes++; nodes > MaxMessageNodes {
+			return Malformed("message has more than %d values", MaxMessageNodes)
+		}
+		c := data[pos]
+		pos++
+
+		var skip, children int
+		var ok = true
+		switch {
+		case c <= 0x7f, c >= 0xe0, c == 0xc0, c == 0xc2, c == 0xc3:
+		case c <= 0x8f:
+			children = 2 * int(c&0x0f)
+		case c <= 0x9f:
+			children = int(c & 0x0f)
+		case c <= 0xbf:
+			skip = int(c & 0x1f)
+		case c == 0xc4, c == 0xd9:
+			skip, ok = length(1)
+		case c == 0xc5, c == 0xda:
+			skip, ok = length(2)
+		case c == 0xc6, c == 0xdb:
+			skip, ok = length(4)
+		case c == 0xc7:
+			skip, ok = length(1)
+			skip++
+		case c == 0xc8:
+			skip, ok = length(2)
+			skip++
+		case c == 0xc9:
+			skip, ok = length(4)
+			skip++
+		case c == 0xcc, c == 0xd0:
+			skip = 1
+		case c == 0xcd, c == 0xd1:
+			skip = 2
+		case c == 0xca, c == 0xce, c == 0xd2:
+			skip = 4
+		case c == 0xcb, c == 0xcf, c == 0xd3:
+			skip = 8
+		case c >= 0xd4 && c <= 0xd8:
+			skip = 1 + 1<<(c-0xd4)
+		case c == 0xdc:
+			children, ok = length(2)
+		case c == 0xdd:
+			children, ok = length(4)
+		case c == 0xde:
+			children, ok = length(2)
+			children *= 2
+		case c == 0xdf:
+			children, ok = length(4)
+			children *= 2
+		default:
+			return Malformed("invalid msgpack code 0x%02x", c)
+		}
+		if !ok || skip > len(data)-pos || children > len(data)-pos {
+			return Malformed("msgpack length exceeds frame")
+		}
+		pos += skip
+		if children > 0 {
+			if len(remaining) > MaxMessageDepth {
+				return Malformed("message nested deeper than %d levels", MaxMessageDepth)
+			}
+			remaining = append(remaining, children)
+		}
+	}
+	return nil
+}
+
+// DecodeFrame decodes a frame into a message map. Binary codecs are
+// normalized through JSON so handlers always see float64 numbers and
+// map[string]interface{} objects, exactly as with JSON frames.
+func DecodeFrame(codec Codec, data []byte, v *map[string]interface{}) error {
+	if codec.Name() == "json" {
+		return codec.Unmarshal(data, v)
+	}
+
+	var raw interface{}
+	if err := codec.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	normalized, err := json.Marshal(normalizeKeys(raw))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(normalized, v)
+}
+
+// normalizeKeys converts map[interface{}]interface{} produced by binary
+// decoders into JSON-compatible maps
+func normalizeKeys(v interface{}) interface{} {
+	switch m := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			out[fmt.Sprint(k)] = normalizeKeys(val)
+		}
+		return out
+	case map[string]interface{}:
+		for k, val := range m {
+			m[k] = normalizeKeys(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range m {
+			m[i] = normalizeKeys(val)
+		}
+		return m
+	}
+	return v
+}
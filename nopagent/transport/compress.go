@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compress encodes data with "gzip" or "zstd"
+func Compress(algorithm string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch algorithm {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "zstd":
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", algorithm)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress reverses Compress, bounded by MaxMessageBytes
+func Decompress(algorithm string, data []byte) ([]byte, error) {
+	switch algorithm {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readBounded(r)
+	case "zstd":
+		r, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderMaxMemory(MaxMessageBytes))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readBounded(r)
+	}
+	return nil, fmt.Errorf("unsupported compression: %s", algorithm)
+}
+
+// readBounded reads at most MaxMessageBytes so a small compressed frame
+// cannot expand without limit
+func readBounded(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxMessageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxMessageBytes {
+		return nil, Malformed("decompressed message exceeds %d bytes", MaxMessageBytes)
+	}
+	return data, nil
+}
@@ -0,0 +1,25 @@
+package transport
+
+import "fmt"
+
+// Limits for a single inbound message, whatever the transport or codec
+const (
+	MaxMessageBytes = 16 << 20
+	MaxMessageDepth = 32
+	MaxMessageNodes = 100000
+)
+
+// MalformedError marks input that was read completely but cannot be used;
+// the message is rejected and the connection stays up
+type MalformedError struct {
+	reason string
+}
+
+func (e *MalformedError) Error() string {
+	return e.reason
+}
+
+// Malformed returns a MalformedError with a formatted reason
+func Malformed(format string, args ...interface{}) error {
+	return &MalformedError{reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,281 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/goranjovic55/NOP/nopagent/protocol"
+	"github.com/goranjovic55/NOP/nopagent/transport/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// grpcTransport speaks the typed protocol in pb/agent_go_protocol.proto
+// over one bidirectional stream (grpc:// plaintext, grpcs:// TLS)
+type grpcTransport struct {
+	host   Host
+	conn   *grpc.ClientConn
+	stream pb.AgentChannel_ConnectClient
+	cancel context.CancelFunc
+}
+
+// ProtocolVersion is sent in every gRPC envelope
+const ProtocolVersion = 1
+
+func NewGRPC(h Host) Transport {
+	return &grpcTransport{host: h}
+}
+
+func (t *grpcTransport) Dial(u *url.URL, header http.Header) error {
+	creds := insecure.NewCredentials()
+	if u.Scheme == "grpcs" {
+		tlsConfig, err := t.host.Credentials().TLSConfig()
+		if err != nil {
+			return err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if ua := header.Get("User-Agent"); ua != "" {
+		opts = append(opts, grpc.WithUserAgent(ua))
+	}
+	conn, err := grpc.NewClient(u.Host, opts...)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	md := metadata.Pairs("x-agent-id", t.host.AgentID())
+	for k, values := range header {
+		// gRPC manages these itself
+		if k == "User-Agent" || k == "Origin" {
+			continue
+		}
+		md.Append(strings.ToLower(k), values...)
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	stream, err := pb.NewAgentChannelClient(conn).Connect(ctx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return err
+	}
+
+	t.conn, t.stream, t.cancel = conn, stream, cancel
+	return nil
+}
+
+func (t *grpcTransport) Send(v interface{}) error {
+	env, err := toEnvelope(v)
+	if err != nil {
+		return err
+	}
+	return t.stream.Send(env)
+}
+
+func (t *grpcTransport) Receive(v *map[string]interface{}) error {
+	env, err := t.stream.Recv()
+	if err != nil {
+		return err
+	}
+	*v = fromEnvelope(env)
+	return nil
+}
+
+func (t *grpcTransport) Close() error {
+	t.stream.CloseSend()
+	t.cancel()
+	return t.conn.Close()
+}
+
+// toEnvelope maps agent messages onto their typed protobuf schema, falling
+// back to a generic Struct for types without one
+func toEnvelope(v interface{}) (*pb.Envelope, error) {
+	env := &pb.Envelope{ProtocolVersion: ProtocolVersion, Type: protocol.Type(v), Seq: protocol.Seq(v)}
+
+	switch m := v.(type) {
+	case protocol.Message:
+		env.AgentId, env.Timestamp = m.AgentID, m.Timestamp
+		switch m.Type {
+		case "register":
+			reg := &pb.Register{AgentName: m.AgentName, Capabilities: map[string]bool{}}
+			extra := make(map[string]interface{})
+			if data, ok := m.Data.(map[string]interface{}); ok {
+				for k, val := range data {
+					switch k {
+					case "capabilities":
+						reg.Capabilities, _ = val.(map[string]bool)
+					case "compression":
+						reg.Compression, _ = val.([]string)
+					case "codecs":
+						reg.Codecs, _ = val.([]string)
+					default:
+						extra[k] = val
+					}
+				}
+			}
+			info := &pb.SystemInfo{}
+			infoExtra := make(map[string]interface{})
+			for k, val := range m.SystemInfo {
+				s, _ := val.(string)
+				switch k {
+				case "hostname":
+					info.Hostname = s
+				case "platform":
+					info.Platform = s
+				case "version":
+					info.Version = s
+				case "ip_address":
+					info.IpAddress = s
+				case "arch":
+					info.Arch = s
+				default:
+					infoExtra[k] = val
+				}
+			}
+			var err error
+			if info.Extra, err = toStruct(infoExtra); err != nil {
+				return nil, err
+			}
+			if reg.Extra, err = toStruct(extra); err != nil {
+				return nil, err
+			}
+			reg.SystemInfo = info
+			env.Body = &pb.Envelope_Register{Register: reg}
+			return env, nil
+		case "heartbeat":
+			// Heartbeats carrying link statistics use the generic Struct
+			if m.Data == nil {
+				env.Body = &pb.Envelope_Heartbeat{Heartbeat: &pb.Heartbeat{}}
+				return env, nil
+			}
+		}
+
+	case protocol.AssetData:
+		env.AgentId, env.Timestamp = m.AgentID, m.Timestamp
+		report := &pb.AssetReport{}
+		for _, asset := range m.Assets {
+			item := &pb.Asset{}
+			attributes := make(map[string]interface{})
+			for k, val := range asset {
+				s, isString := val.(string)
+				switch {
+				case k == "ip" && isString:
+					item.Ip = s
+				case k == "mac" && isString:
+					item.Mac = s
+				case k == "status" && isString:
+					item.Status = s
+				case k == "discovered_at" && isString:
+					item.DiscoveredAt = s
+				case k == "interface" && isString:
+					item.Interface = s
+				case k == "method" && isString:
+					item.Method = s
+				default:
+					attributes[k] = val
+				}
+			}
+			var err error
+			if item.Attributes, err = toStruct(attributes); err != nil {
+				return nil, err
+			}
+			report.Assets = append(report.Assets, item)
+		}
+		env.Body = &pb.Envelope_AssetData{AssetData: report}
+		return env, nil
+
+	case protocol.TrafficData:
+		env.AgentId, env.Timestamp = m.AgentID, m.Timestamp
+		counter := func(key string) uint64 {
+			n, _ := m.Traffic[key].(uint64)
+			return n
+		}
+		env.Body = &pb.Envelope_TrafficData{TrafficData: &pb.TrafficReport{
+			BytesSent:   counter("bytes_sent"),
+			BytesRecv:   counter("bytes_recv"),
+			PacketsSent: counter("packets_sent"),
+			PacketsRecv: counter("packets_recv"),
+			ErrorsIn:    counter("errors_in"),
+			ErrorsOut:   counter("errors_out"),
+		}}
+		return env, nil
+
+	case protocol.HostData:
+		env.AgentId, env.Timestamp = m.AgentID, m.Timestamp
+		host, err := toStruct(m.Host)
+		if err != nil {
+			return nil, err
+		}
+		env.Body = &pb.Envelope_HostData{HostData: &pb.HostReport{Host: host}}
+		return env, nil
+
+	case map[string]interface{}:
+		if encrypted, _ := m["encrypted"].(bool); encrypted {
+			data, _ := m["data"].([]byte)
+			compression, _ := m["compression"].(string)
+			codec, _ := m["codec"].(string)
+			env.Body = &pb.Envelope_Encrypted{Encrypted: &pb.EncryptedPayload{Data: data, Compression: compression, Codec: codec}}
+			return env, nil
+		}
+	}
+
+	generic, err := toStruct(v)
+	if err != nil {
+		return nil, err
+	}
+	env.Body = &pb.Envelope_Generic{Generic: generic}
+	return env, nil
+}
+
+// fromEnvelope converts an inbound envelope into the message map handled by
+// dispatchMessage
+func fromEnvelope(env *pb.Envelope) map[string]interface{} {
+	msg := map[string]interface{}{}
+	switch body := env.Body.(type) {
+	case *pb.Envelope_Command:
+		if body.Command.Options != nil {
+			msg = body.Command.Options.AsMap()
+		}
+		msg["command_id"] = body.Command.CommandId
+		msg["command"] = body.Command.Command
+		msg["class"] = body.Command.Class
+		msg["operator"] = body.Command.Operator
+	case *pb.Envelope_Encrypted:
+		msg["encrypted"] = true
+		msg["data"] = base64.StdEncoding.EncodeToString(body.Encrypted.Data)
+		msg["compression"] = body.Encrypted.Compression
+		msg["codec"] = body.Encrypted.Codec
+	case *pb.Envelope_Generic:
+		msg = body.Generic.AsMap()
+	}
+	if _, ok := msg["type"]; !ok || env.Type != "" {
+		msg["type"] = env.Type
+	}
+	return msg
+}
+
+// toStruct converts any JSON-encodable value into a protobuf Struct
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
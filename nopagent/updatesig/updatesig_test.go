@@ -0,0 +1,162 @@
+package updatesig
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goranjovic55/NOP/nopagent/crypto"
+	"golang.org/x/crypto/blake2b"
+)
+
+var testPayload = []byte("nopagent update payload")
+
+func testKey(t *testing.T, seed byte) (ed25519.PrivateKey, uint64) {
+	t.Helper()
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize)), uint64(seed) << 8
+}
+
+// minisignPub renders a minisign .pub file for priv
+func minisignPub(priv ed25519.PrivateKey, keyID uint64) string {
+	raw := make([]byte, 10, 10+ed25519.PublicKeySize)
+	copy(raw, algPure)
+	binary.LittleEndian.PutUint64(raw[2:], keyID)
+	raw = append(raw, priv.Public().(ed25519.PublicKey)...)
+	return fmt.Sprintf("untrusted comment: minisign public key %X\n%s\n", keyID, base64.StdEncoding.EncodeToString(raw))
+}
+
+// minisign renders a .minisig file signing payload as minisign would
+func minisign(priv ed25519.PrivateKey, keyID uint64, alg string, payload []byte, comment string) []byte {
+	message := payload
+	if alg == algPrehashed {
+		sum := blake2b.Sum512(payload)
+		message = sum[:]
+	}
+	sig := ed25519.Sign(priv, message)
+	raw := make([]byte, 10, 10+ed25519.SignatureSize)
+	copy(raw, alg)
+	binary.LittleEndian.PutUint64(raw[2:], keyID)
+	raw = append(raw, sig...)
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), comment...))
+	return []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(raw), comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestVerify(t *testing.T) {
+	priv, keyID := testKey(t, 1)
+	other, otherID := testKey(t, 2)
+	sum := sha256.Sum256(testPayload)
+	digest := hex.EncodeToString(sum[:])
+
+	minisignKey := minisignPub(priv, keyID)
+	bareKey := base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))
+	bareSig := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, testPayload)) + "\n")
+	prehashedErr := error(nil)
+	if crypto.FIPS {
+		prehashedErr = ErrNotApproved
+	}
+
+	commentTampered := bytes.Replace(minisign(priv, keyID, algPure, testPayload, "file:agent"),
+		[]byte("file:agent"), []byte("file:other"), 1)
+
+	tests := []struct {
+		name    string
+		key     string
+		sig     []byte
+		payload []byte
+		digest  string
+		want    error
+	}{
+		{"minisign", minisignKey, minisign(priv, keyID, algPure, testPayload, "file:agent"), testPayload, digest, nil},
+		{"minisign prehashed", minisignKey, minisign(priv, keyID, algPrehashed, testPayload, "file:agent"), testPayload, digest, prehashedErr},
+		{"bare key and signature", bareKey, bareSig, testPayload, digest, nil},
+		{"bare key accepts any key id", bareKey, minisign(priv, otherID, algPure, testPayload, "c"), testPayload, digest, nil},
+		{"digest with surrounding space", minisignKey, minisign(priv, keyID, algPure, testPayload, "c"), testPayload, " " + hex.EncodeToString(sum[:]) + "\n", nil},
+		{"payload changed", minisignKey, minisign(priv, keyID, algPure, testPayload, "c"), []byte("nopagent update payloaD"), digest, ErrDigest},
+		{"digest and payload changed", minisignKey, minisign(priv, keyID, algPure, testPayload, "c"), []byte("other"), hashOf("other"), ErrSignature},
+		{"trusted comment changed", minisignKey, commentTampered, testPayload, digest, ErrSignature},
+		{"different key id", minisignKey, minisign(other, otherID, algPure, testPayload, "c"), testPayload, digest, ErrKeyID},
+		{"different key", bareKey, minisign(other, otherID, algPure, testPayload, "c"), testPayload, digest, ErrSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePublicKey(tt.key)
+			if err != nil {
+				t.Fatalf("ParsePublicKey: %v", err)
+			}
+			sig, err := ParseSignature(tt.sig)
+			if err != nil {
+				t.Fatalf("ParseSignature: %v", err)
+			}
+			if err := Verify(tt.payload, tt.digest, sig, key); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func hashOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyFile(t *testing.T) {
+	priv, keyID := testKey(t, 3)
+	key, err := ParsePublicKey(minisignPub(priv, keyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseSignature(minisign(priv, keyID, algPure, testPayload, "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "nopagent.new")
+	if err := os.WriteFile(path, testPayload, 0600); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := VerifyFile(path, hashOf(string(testPayload)), sig, key)
+	if err != nil || !bytes.Equal(payload, testPayload) {
+		t.Fatalf("VerifyFile = %q, %v", payload, err)
+	}
+	if err := os.WriteFile(path, []byte("replaced"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := VerifyFile(path, hashOf(string(testPayload)), sig, key); err == nil || payload != nil {
+		t.Errorf("VerifyFile of a replaced payload = %q, %v", payload, err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"not base64!",
+		base64.StdEncoding.EncodeToString(make([]byte, 31)),
+		base64.StdEncoding.EncodeToString(append([]byte("XX"), make([]byte, 40)...)),
+	} {
+		if _, err := ParsePublicKey(key); err == nil {
+			t.Errorf("ParsePublicKey(%q) succeeded, want an error", key)
+		}
+	}
+
+	priv, keyID := testKey(t, 4)
+	valid := minisign(priv, keyID, algPure, testPayload, "c")
+	for name, sig := range map[string][]byte{
+		"empty":             nil,
+		"short bare":        []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))),
+		"missing lines":     valid[:bytes.LastIndexByte(valid[:len(valid)-1], '\n')],
+		"no trusted prefix": bytes.Replace(valid, []byte("trusted comment: "), []byte("comment: "), 1),
+		"unknown algorithm": minisign(priv, keyID, "Xx", testPayload, "c"),
+	} {
+		if _, err := ParseSignature(sig); err == nil {
+			t.Errorf("ParseSignature(%s) succeeded, want an error", name)
+		}
+	}
+}